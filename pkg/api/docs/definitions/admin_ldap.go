@@ -1,5 +1,9 @@
 package definitions
 
+import (
+	"github.com/grafana/grafana/pkg/api/dtos"
+)
+
 // swagger:route POST /admin/ldap/reload admin_ldap reloadLDAP
 //
 // Reloads the LDAP configuration.
@@ -34,6 +38,27 @@ package definitions
 //
 // Finds an user based on a username in LDAP. This helps illustrate how would the particular user be mapped in Grafana when synced.
 //
+// The LDAP servers are searched in order. Servers that cannot be reached or searched are skipped and listed in the response, alongside the server the user was found on.
+//
+// With `allServers=true`, every LDAP server is searched instead of stopping at the first one that has the user. All the users found are returned, each with the server it was found on, and the response is flagged as a conflict when more than one server has a user with that username.
+//
+// If you are running Grafana Enterprise and have Fine-grained access control enabled, you need to have a permission with action `ldap.user:read`.
+//
+// Security:
+// - basic:
+//
+// Responses:
+// 200: okResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+
+// swagger:route GET /admin/ldap/users/preview admin_ldap getLDAPUsersPreview
+//
+// Finds all the users under a base DN in LDAP, and illustrates how they would be mapped in Grafana when synced, one page at a time.
+//
 // If you are running Grafana Enterprise and have Fine-grained access control enabled, you need to have a permission with action `ldap.user:read`.
 //
 // Security:
@@ -41,10 +66,28 @@ package definitions
 //
 // Responses:
 // 200: okResponse
+// 400: badRequestError
 // 401: unauthorisedError
 // 403: forbiddenError
 // 500: internalServerError
 
+// swagger:route GET /admin/ldap/users/preview/attributes/{user_name} admin_ldap getLDAPAttributeSuggestions
+//
+// Finds a sample user in LDAP and returns all the attributes of its entry, with suggestions of the attributes to map to the user's login, email, name, surname and groups.
+//
+// If you are running Grafana Enterprise and have Fine-grained access control enabled, you need to have a permission with action `ldap.user:read`.
+//
+// Security:
+// - basic:
+//
+// Responses:
+// 200: okResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+
 // swagger:route GET /admin/ldap/status admin_ldap getLDAPStatus
 //
 // Attempts to connect to all the configured LDAP servers and returns information on whenever they're available or not.
@@ -60,11 +103,136 @@ package definitions
 // 403: forbiddenError
 // 500: internalServerError
 
+// swagger:route GET /admin/ldap/config-file admin_ldap getLDAPConfigFile
+//
+// Returns the absolute path of the LDAP config file that Grafana reads, and when the file was last modified.
+//
+// If you are running Grafana Enterprise and have Fine-grained access control enabled, you need to have a permission with action `ldap.status:read`.
+//
+// Security:
+// - basic:
+//
+// Responses:
+// 200: okResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 500: internalServerError
+
+// swagger:route GET /admin/ldap/mappings/compare admin_ldap compareLDAPGroupMappings
+//
+// Lists the LDAP groups mapped into two orgs, and the group DNs mapped into only one of them. Only the LDAP configuration is read.
+//
+// If you are running Grafana Enterprise and have Fine-grained access control enabled, you need to have a permission with action `ldap.status:read`.
+//
+// Security:
+// - basic:
+//
+// Responses:
+// 200: okResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+
+// swagger:route GET /admin/ldap/mappings/validate admin_ldap validateLDAPGroupMappings
+//
+// Lists the orgs that LDAP groups are mapped into but that do not exist, and the group mappings with a role that does not exist. The orgs are looked up in a single query, and the LDAP servers are not contacted.
+//
+// If you are running Grafana Enterprise and have Fine-grained access control enabled, you need to have a permission with action `ldap.status:read`.
+//
+// Security:
+// - basic:
+//
+// Responses:
+// 200: okResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 500: internalServerError
+
+// swagger:route POST /admin/ldap/servers/test admin_ldap testLDAPServer
+//
+// Dials one of the configured LDAP servers and binds to it, with the configured bind credentials or with the ones given in the request. The bind DN and password must be given together. The given credentials are only used for the test, so new credentials can be tried out before they are put in the LDAP configuration.
+//
+// If you are running Grafana Enterprise and have Fine-grained access control enabled, you need to have a permission with action `ldap.config:reload`.
+//
+// Security:
+// - basic:
+//
+// Responses:
+// 200: okResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+
+// swagger:route POST /admin/ldap/servers/reload admin_ldap reloadLDAPServer
+//
+// Reloads the settings of one of the configured LDAP servers from the LDAP config file, without reloading the other servers. The new settings are only applied if a bind to the server with them succeeds, otherwise the current ones are kept.
+//
+// If you are running Grafana Enterprise and have Fine-grained access control enabled, you need to have a permission with action `ldap.config:reload`.
+//
+// Security:
+// - basic:
+//
+// Responses:
+// 200: okResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+
+// swagger:route POST /admin/ldap/servers/disable admin_ldap disableLDAPServer
+//
+// Disables one of the configured LDAP servers until the LDAP configuration is reloaded. The server is no longer dialed, its status is reported as `disabled (manual)`, and users are looked up and synced on the other servers. The server is only disabled in the memory of the Grafana instance that receives the request.
+//
+// If you are running Grafana Enterprise and have Fine-grained access control enabled, you need to have a permission with action `ldap.config:reload`.
+//
+// Security:
+// - basic:
+//
+// Responses:
+// 200: okResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+
+// swagger:route POST /admin/ldap/users/disable admin_ldap disableLDAPUsers
+//
+// Disables the given LDAP users and revokes their session tokens, as syncing each of them does when they are no longer found in LDAP. The Grafana admin is never disabled, and neither are users that are not found or not synced with LDAP: they are reported as skipped instead.
+//
+// If you are running Grafana Enterprise and have Fine-grained access control enabled, you need to have a permission with action `ldap.user:sync`.
+//
+// Security:
+// - basic:
+//
+// Responses:
+// 200: okResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 500: internalServerError
+
 // swagger:parameters getLDAPUser
 type GetLDAPUserParams struct {
 	// in:path
 	// required:true
 	UserName string `json:"user_name"`
+	// Search every LDAP server for users with the username.
+	// in:query
+	// required:false
+	// default:false
+	AllServers bool `json:"allServers"`
+	// Scope of the user search for this request only: base, one or sub. The response reports the scope used in searchScope.
+	// in:query
+	// required:false
+	// default:sub
+	Scope string `json:"scope"`
+	// Search filter of the user search for this request only, e.g. (&(uid=%s)(accountStatus=inactive)). It must be a valid LDAP filter with the %s username placeholder. The response reports the filter used in searchFilter.
+	// in:query
+	// required:false
+	Filter string `json:"filter"`
 }
 
 // swagger:parameters syncLDAPUser
@@ -73,3 +241,64 @@ type SyncLDAPUserParams struct {
 	// required:true
 	UserID int64 `json:"user_id"`
 }
+
+// swagger:parameters getLDAPUsersPreview
+type GetLDAPUsersPreviewParams struct {
+	// The base DN to find the users under. It must be one of, or be nested in one of, the search base DNs of an LDAP server.
+	// in:query
+	// required:true
+	BaseDN string `json:"baseDN"`
+	// in:query
+	// required:false
+	// default:1
+	Page int64 `json:"page"`
+	// Number of users per page, at most 100.
+	// in:query
+	// required:false
+	// default:50
+	PerPage int64 `json:"perpage"`
+	// `stable` lists the roles and teams of each user in a deterministic order, and `csv` streams the page as CSV
+	// rows of login, email, name, roles and teams.
+	// in:query
+	// required:false
+	// enum: stable,csv
+	Format string `json:"format"`
+}
+
+// swagger:parameters getLDAPAttributeSuggestions
+type GetLDAPAttributeSuggestionsParams struct {
+	// in:path
+	// required:true
+	UserName string `json:"user_name"`
+}
+
+// swagger:parameters compareLDAPGroupMappings
+type CompareLDAPGroupMappingsParams struct {
+	// in:query
+	// required:true
+	OrgA int64 `json:"orgA"`
+	// in:query
+	// required:true
+	OrgB int64 `json:"orgB"`
+}
+
+// swagger:parameters disableLDAPUsers
+type DisableLDAPUsersParams struct {
+	// in:body
+	// required:true
+	Body dtos.DisableLDAPUsersForm `json:"body"`
+}
+
+// swagger:parameters reloadLDAPServer
+type ReloadLDAPServerParams struct {
+	// in:body
+	// required:true
+	Body dtos.ReloadLDAPServerForm `json:"body"`
+}
+
+// swagger:parameters testLDAPServer
+type TestLDAPServerParams struct {
+	// in:body
+	// required:true
+	Body dtos.TestLDAPServerForm `json:"body"`
+}
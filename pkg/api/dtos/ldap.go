@@ -0,0 +1,28 @@
+package dtos
+
+// TestLDAPServerForm picks the configured LDAP server to test a bind against, and optionally the bind
+// credentials to use instead of the configured ones, whose DN and password go together. The credentials are only used
+// for the test.
+type TestLDAPServerForm struct {
+	Host         string `json:"host" binding:"Required"`
+	Port         int    `json:"port"`
+	BindDN       string `json:"bindDn"`
+	BindPassword string `json:"bindPassword"`
+}
+
+// DisableLDAPServerForm picks the configured LDAP server to disable until the LDAP configuration is reloaded.
+type DisableLDAPServerForm struct {
+	Host string `json:"host" binding:"Required"`
+	Port int    `json:"port" binding:"Required"`
+}
+
+// ReloadLDAPServerForm picks the configured LDAP server to reload the settings of from the LDAP config file.
+type ReloadLDAPServerForm struct {
+	Host string `json:"host" binding:"Required"`
+	Port int    `json:"port" binding:"Required"`
+}
+
+// DisableLDAPUsersForm lists the Grafana users to disable because they are no longer found in LDAP.
+type DisableLDAPUsersForm struct {
+	UserIds []int64 `json:"userIds" binding:"Required"`
+}
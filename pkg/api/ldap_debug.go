@@ -2,37 +2,70 @@ package api
 
 import (
 	"context"
+	"encoding/csv"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/grafana/grafana/pkg/api/dtos"
 	"github.com/grafana/grafana/pkg/api/response"
 	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/infra/tracing"
 	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/services/ldap"
 	"github.com/grafana/grafana/pkg/services/multildap"
 	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
 	"github.com/grafana/grafana/pkg/util"
 	"github.com/grafana/grafana/pkg/web"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
+// Page sizes of the LDAP users preview
+const (
+	ldapPreviewDefaultPerPage = 50
+	ldapPreviewMaxPerPage     = 100
+)
+
+// ldapPreviewFormatStable is the format of the LDAP users preview that lists the roles and teams of each user in a
+// deterministic order, so that the previews of identical inputs are byte-identical.
+const ldapPreviewFormatStable = "stable"
+
+// ldapPreviewFormatCSV is the format of the LDAP users preview that streams the page as CSV rows, with the users'
+// roles and teams in the same order as ldapPreviewFormatStable.
+const ldapPreviewFormatCSV = "csv"
+
 var (
 	getLDAPConfig = multildap.GetConfig
 	newLDAP       = multildap.New
+	newPooledLDAP = multildap.NewWithPool
+	testLDAPBind  = multildap.TestBind
+
+	reloadLDAPServerConfig = ldap.ReloadServerConfig
 
 	ldapLogger = log.New("LDAP.debug")
 
+	ldapRetryWait = waitLDAPRetry
+
 	errOrganizationNotFound = func(orgId int64) error {
 		return fmt.Errorf("unable to find organization with ID '%d'", orgId)
 	}
 )
 
 // LDAPAttribute is a serializer for user attributes mapped from LDAP. Is meant to display both the serialized value and the LDAP key we received it from.
+// If the attribute has transforms configured, the value is the one received from LDAP and the transformed value is the one Grafana uses.
 type LDAPAttribute struct {
 	ConfigAttributeValue string `json:"cfgAttrValue"`
 	LDAPAttributeValue   string `json:"ldapValue"`
+	TransformedValue     string `json:"transformedValue,omitempty"`
 }
 
 // RoleDTO is a serializer for mapped roles from LDAP
@@ -45,30 +78,175 @@ type LDAPRoleDTO struct {
 
 // LDAPUserDTO is a serializer for users mapped from LDAP
 type LDAPUserDTO struct {
-	Name           *LDAPAttribute           `json:"name"`
-	Surname        *LDAPAttribute           `json:"surname"`
-	Email          *LDAPAttribute           `json:"email"`
-	Username       *LDAPAttribute           `json:"login"`
-	IsGrafanaAdmin *bool                    `json:"isGrafanaAdmin"`
-	IsDisabled     bool                     `json:"isDisabled"`
-	OrgRoles       []LDAPRoleDTO            `json:"roles"`
-	Teams          []models.TeamOrgGroupDTO `json:"teams"`
+	Name             *LDAPAttribute           `json:"name"`
+	Surname          *LDAPAttribute           `json:"surname"`
+	Email            *LDAPAttribute           `json:"email"`
+	Username         *LDAPAttribute           `json:"login"`
+	IsGrafanaAdmin   *bool                    `json:"isGrafanaAdmin"`
+	IsDisabled       bool                     `json:"isDisabled"`
+	OrgRoles         []LDAPRoleDTO            `json:"roles"`
+	Teams            []models.TeamOrgGroupDTO `json:"teams"`
+	ReferralFollowed bool                     `json:"referralFollowed"`
+	Referral         string                   `json:"referral,omitempty"`
+	GroupStats       LDAPGroupStatsDTO        `json:"groupStats"`
+	// FoundOn is the LDAP server the user was found on, and FailedServers the ones that could not be searched
+	// before it. Both are only set when looking up a single user, and only FoundOn when looking it up on all servers.
+	FoundOn       string           `json:"foundOn,omitempty"`
+	FailedServers []*LDAPServerDTO `json:"failedServers,omitempty"`
+	// GrafanaAdmin is only set when looking up a single user.
+	GrafanaAdmin *LDAPGrafanaAdminDTO `json:"grafanaAdmin,omitempty"`
+	// SearchScope and SearchFilter are the scope and filter of the search the user was found with. They are only set
+	// when looking up users.
+	SearchScope  string `json:"searchScope,omitempty"`
+	SearchFilter string `json:"searchFilter,omitempty"`
+}
+
+// LDAPGrafanaAdminDTO is a serializer for the Grafana admin status of a user, now and after being synced with LDAP.
+// Sources lists where the status after the sync comes from: "ldap" when a group mapping grants it, and "grafana"
+// when the user is already a Grafana admin and the group mappings do not change it.
+type LDAPGrafanaAdminDTO struct {
+	Current   bool     `json:"current"`
+	Effective bool     `json:"effective"`
+	Sources   []string `json:"sources"`
+}
+
+// LDAPUsersDisabledDTO is a serializer for the result of disabling LDAP users in bulk
+type LDAPUsersDisabledDTO struct {
+	Disabled []int64              `json:"disabled"`
+	Skipped  []LDAPUserSkippedDTO `json:"skipped"`
+}
+
+// LDAPUserSkippedDTO is a serializer for a user that was not disabled, and why
+type LDAPUserSkippedDTO struct {
+	UserId int64  `json:"userId"`
+	Login  string `json:"login,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// LDAPUserMatchesDTO is a serializer for the users found with the same username on all the LDAP servers
+type LDAPUserMatchesDTO struct {
+	Matches []*LDAPUserDTO `json:"matches"`
+	// Conflict is set when more than one LDAP server has a user with the username, as only the first one is synced.
+	Conflict      bool             `json:"conflict"`
+	FailedServers []*LDAPServerDTO `json:"failedServers,omitempty"`
+}
+
+// LDAPGroupStatsDTO is a serializer for the number of LDAP groups of a user, and how many of them are mapped in Grafana
+type LDAPGroupStatsDTO struct {
+	Total       int `json:"total"`
+	MatchedOrg  int `json:"matchedOrg"`
+	MatchedTeam int `json:"matchedTeam"`
+	Unmapped    int `json:"unmapped"`
+}
+
+// LDAPUserPreviewPageDTO is a serializer for a page of users mapped from LDAP
+type LDAPUserPreviewPageDTO struct {
+	TotalCount int            `json:"totalCount"`
+	Page       int            `json:"page"`
+	PerPage    int            `json:"perPage"`
+	Users      []*LDAPUserDTO `json:"users"`
+}
+
+// LDAPAttributeSuggestionsDTO is a serializer for all the attributes of a user in LDAP, along with the attributes
+// suggested for each field of the "attributes" setting
+type LDAPAttributeSuggestionsDTO struct {
+	FoundOn     string                              `json:"foundOn"`
+	Attributes  map[string][]string                 `json:"attributes"`
+	Suggestions map[string]ldap.AttributeSuggestion `json:"suggestions"`
+}
+
+// LDAPGroupMappingDTO is a serializer for an LDAP group mapped into an org
+type LDAPGroupMappingDTO struct {
+	GroupDN string          `json:"groupDN"`
+	OrgRole models.RoleType `json:"orgRole"`
+}
+
+// LDAPOrgGroupMappingsDTO is a serializer for the LDAP groups mapped into an org. OnlyHere lists the group DNs
+// that are mapped into this org but not into the one it is compared with.
+type LDAPOrgGroupMappingsDTO struct {
+	OrgId    int64                 `json:"orgId"`
+	Groups   []LDAPGroupMappingDTO `json:"groups"`
+	OnlyHere []string              `json:"onlyHere"`
+}
+
+// LDAPGroupMappingsComparisonDTO is a serializer for the comparison of the LDAP groups mapped into two orgs
+type LDAPGroupMappingsComparisonDTO struct {
+	OrgA LDAPOrgGroupMappingsDTO `json:"orgA"`
+	OrgB LDAPOrgGroupMappingsDTO `json:"orgB"`
+}
+
+// LDAPMissingOrgDTO is a serializer for an org that LDAP groups are mapped into but that does not exist
+type LDAPMissingOrgDTO struct {
+	OrgId    int64    `json:"orgId"`
+	GroupDNs []string `json:"groupDNs"`
+}
+
+// LDAPInvalidRoleDTO is a serializer for an LDAP group mapped into an org with a role that does not exist
+type LDAPInvalidRoleDTO struct {
+	GroupDN string          `json:"groupDN"`
+	OrgId   int64           `json:"orgId"`
+	OrgRole models.RoleType `json:"orgRole"`
+}
+
+// LDAPMappingTargetsDTO is a serializer for the targets of the LDAP group mappings that cannot be synced to.
+// Valid is true when there are none.
+type LDAPMappingTargetsDTO struct {
+	Valid        bool                 `json:"valid"`
+	MissingOrgs  []LDAPMissingOrgDTO  `json:"missingOrgs"`
+	InvalidRoles []LDAPInvalidRoleDTO `json:"invalidRoles"`
+}
+
+// LDAPConfigFileDTO is a serializer for the LDAP config file Grafana reads
+type LDAPConfigFileDTO struct {
+	Path         string    `json:"path"`
+	LastModified time.Time `json:"lastModified"`
 }
 
 // LDAPServerDTO is a serializer for LDAP server statuses
 type LDAPServerDTO struct {
-	Host      string `json:"host"`
-	Port      int    `json:"port"`
-	Available bool   `json:"available"`
-	Error     string `json:"error"`
+	Host         string `json:"host"`
+	Port         int    `json:"port"`
+	Available    bool   `json:"available"`
+	SecurityMode string `json:"securityMode,omitempty"`
+	Error        string `json:"error"`
+	// ErrorCategory is the kind of failure of the error, such as dns, timeout or tls, only set when there is one.
+	ErrorCategory ldap.ErrorCategory `json:"errorCategory,omitempty"`
+	// AsOf is when the status was polled, only set for statuses polled in the background.
+	AsOf *time.Time `json:"asOf,omitempty"`
+}
+
+// ldapStatusCache keeps the latest statuses of the LDAP servers, polled in the background.
+type ldapStatusCache interface {
+	// Latest returns the latest statuses and when they were polled, which is zero if they have not been yet.
+	Latest() ([]*multildap.ServerStatus, time.Time)
+	// Invalidate drops the latest statuses, so that they are polled again.
+	Invalidate()
+}
+
+// LDAPBindTestDTO is a serializer for the result of testing a bind against an LDAP server
+type LDAPBindTestDTO struct {
+	LDAPServerDTO
+	BindDN string `json:"bindDn"`
+	// OverrideCredentials is true when the bind was tested with credentials given in the request
+	// rather than with the configured ones.
+	OverrideCredentials bool   `json:"overrideCredentials"`
+	Bound               bool   `json:"bound"`
+	BindError           string `json:"bindError,omitempty"`
 }
 
 // FetchOrgs fetches the organization(s) information by executing a single query to the database. Then, populating the DTO with the information retrieved.
 func (user *LDAPUserDTO) FetchOrgs(ctx context.Context, sqlstore sqlstore.Store) error {
+	return fetchLDAPUsersOrgs(ctx, sqlstore, []*LDAPUserDTO{user})
+}
+
+// fetchLDAPUsersOrgs fetches the organization(s) information of all the users by executing a single query to the database.
+func fetchLDAPUsersOrgs(ctx context.Context, sqlstore sqlstore.Store, users []*LDAPUserDTO) error {
 	orgIds := []int64{}
 
-	for _, or := range user.OrgRoles {
-		orgIds = append(orgIds, or.OrgId)
+	for _, user := range users {
+		for _, or := range user.OrgRoles {
+			orgIds = append(orgIds, or.OrgId)
+		}
 	}
 
 	q := &models.SearchOrgsQuery{}
@@ -83,24 +261,26 @@ func (user *LDAPUserDTO) FetchOrgs(ctx context.Context, sqlstore sqlstore.Store)
 		orgNamesById[org.Id] = org.Name
 	}
 
-	for i, orgDTO := range user.OrgRoles {
-		if orgDTO.OrgId < 1 {
-			continue
-		}
+	for _, user := range users {
+		for i, orgDTO := range user.OrgRoles {
+			if orgDTO.OrgId < 1 {
+				continue
+			}
 
-		orgName := orgNamesById[orgDTO.OrgId]
+			orgName := orgNamesById[orgDTO.OrgId]
 
-		if orgName != "" {
-			user.OrgRoles[i].OrgName = orgName
-		} else {
-			return errOrganizationNotFound(orgDTO.OrgId)
+			if orgName != "" {
+				user.OrgRoles[i].OrgName = orgName
+			} else {
+				return errOrganizationNotFound(orgDTO.OrgId)
+			}
 		}
 	}
 
 	return nil
 }
 
-// ReloadLDAPCfg reloads the LDAP configuration
+// ReloadLDAPCfg reloads the LDAP configuration, and enables the LDAP servers disabled with PostDisableLDAPServer again
 func (hs *HTTPServer) ReloadLDAPCfg(c *models.ReqContext) response.Response {
 	if !ldap.IsEnabled() {
 		return response.Error(http.StatusBadRequest, "LDAP is not enabled", nil)
@@ -110,47 +290,234 @@ func (hs *HTTPServer) ReloadLDAPCfg(c *models.ReqContext) response.Response {
 	if err != nil {
 		return response.Error(http.StatusInternalServerError, "Failed to reload LDAP config", err)
 	}
+	multildap.EnableAllServers()
+	if hs.ldapConnectionPool != nil {
+		hs.ldapConnectionPool.Close()
+	}
+	if hs.ldapStatusCache != nil {
+		hs.ldapStatusCache.Invalidate()
+	}
 	return response.Success("LDAP config reloaded")
 }
 
+// PostReloadLDAPServer reloads the settings of one of the configured LDAP servers from the LDAP config file, leaving
+// the other servers untouched so that logins against them are not disrupted. The new settings are only applied if a
+// bind to the server with them succeeds.
+func (hs *HTTPServer) PostReloadLDAPServer(c *models.ReqContext) response.Response {
+	if !ldap.IsEnabled() {
+		return response.Error(http.StatusBadRequest, "LDAP is not enabled", nil)
+	}
+
+	form := dtos.ReloadLDAPServerForm{}
+	if err := web.Bind(c.Req, &form); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+
+	server, err := reloadLDAPServerConfig(form.Host, form.Port, func(config *ldap.ServerConfig) error {
+		_, err := testLDAPBind(config)
+		return err
+	})
+	if errors.Is(err, ldap.ErrServerNotConfigured) {
+		return response.Error(http.StatusNotFound, "No LDAP server is configured with this host and port", nil)
+	}
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "Failed to reload the LDAP server config, the current settings are kept", err)
+	}
+	if hs.ldapConnectionPool != nil {
+		hs.ldapConnectionPool.CloseServer(server.Host, server.Port)
+	}
+	if hs.ldapStatusCache != nil {
+		hs.ldapStatusCache.Invalidate()
+	}
+	ldapLogger.Info("Reloaded LDAP server config", "host", server.Host, "port", server.Port, "userId", c.UserId)
+
+	return response.Success("LDAP server config reloaded")
+}
+
+// GetLDAPConfigFile returns the absolute path of the LDAP config file and when it was last modified, so it can be
+// checked that Grafana reads the edited file, and whether it was modified since it was last loaded.
+func (hs *HTTPServer) GetLDAPConfigFile(c *models.ReqContext) response.Response {
+	if !ldap.IsEnabled() {
+		return response.Error(http.StatusBadRequest, "LDAP is not enabled", nil)
+	}
+
+	path, err := filepath.Abs(setting.LDAPConfigFile)
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to resolve the LDAP config file path", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, fmt.Sprintf("Failed to read the LDAP config file %s", path), err)
+	}
+
+	return response.JSON(http.StatusOK, LDAPConfigFileDTO{Path: path, LastModified: info.ModTime()})
+}
+
 // GetLDAPStatus attempts to connect to all the configured LDAP servers and returns information on whenever they're available or not.
+// When the statuses are polled in the background, the latest polled ones are returned instead, unless fresh ones are asked for
+// with ?fresh=true.
 func (hs *HTTPServer) GetLDAPStatus(c *models.ReqContext) response.Response {
 	if !ldap.IsEnabled() {
 		return response.Error(http.StatusBadRequest, "LDAP is not enabled", nil)
 	}
 
-	ldapConfig, err := getLDAPConfig(hs.Cfg)
+	ctx, span := hs.tracer.Start(c.Req.Context(), "ldap.status")
+	defer span.End()
+
+	if hs.ldapStatusCache != nil && !c.QueryBool("fresh") {
+		if statuses, asOf := hs.ldapStatusCache.Latest(); !asOf.IsZero() {
+			span.SetAttributes("ldap.cached", true, attribute.Bool("ldap.cached", true))
+			return response.JSON(http.StatusOK, newLDAPServerStatusDTOs(statuses, asOf))
+		}
+	}
+
+	ldapConfig, err := hs.loadLDAPConfig(ctx)
 	if err != nil {
 		return response.Error(http.StatusBadRequest, "Failed to obtain the LDAP configuration. Please verify the configuration and try again", err)
 	}
 
+	if len(ldapConfig.Servers) == 0 {
+		return response.Error(http.StatusBadRequest, "LDAP is enabled but no servers are configured", nil)
+	}
+
 	ldap := newLDAP(ldapConfig.Servers)
 
 	if ldap == nil {
 		return response.Error(http.StatusInternalServerError, "Failed to find the LDAP server", nil)
 	}
 
+	_, pingSpan := hs.startLDAPSpan(ctx, "ldap.status.ping", ldapConfig.Servers)
 	statuses, err := ldap.Ping()
+	endLDAPSpan(pingSpan, err)
 	if err != nil {
 		return response.Error(http.StatusBadRequest, "Failed to connect to the LDAP server(s)", err)
 	}
 
+	return response.JSON(http.StatusOK, newLDAPServerDTOs(statuses))
+}
+
+// newLDAPServerStatusDTOs returns the DTOs of the server statuses polled at the given time.
+func newLDAPServerStatusDTOs(statuses []*multildap.ServerStatus, asOf time.Time) []*LDAPServerDTO {
+	serverDTOs := newLDAPServerDTOs(statuses)
+	for _, s := range serverDTOs {
+		s.AsOf = &asOf
+	}
+	return serverDTOs
+}
+
+// PostTestLDAPServer dials one of the configured LDAP servers and binds to it, either with the configured bind
+// credentials or with the ones given in the request, which are never stored. This lets new bind credentials be
+// tried out before they are put in the configuration. The given bind DN and password must come together, so that
+// the configured password is never sent for another DN.
+func (hs *HTTPServer) PostTestLDAPServer(c *models.ReqContext) response.Response {
+	if !ldap.IsEnabled() {
+		return response.Error(http.StatusBadRequest, "LDAP is not enabled", nil)
+	}
+
+	form := dtos.TestLDAPServerForm{}
+	if err := web.Bind(c.Req, &form); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+
+	ldapConfig, err := getLDAPConfig(hs.Cfg)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "Failed to obtain the LDAP configuration. Please verify the configuration and try again", err)
+	}
+
+	var serverConfig *ldap.ServerConfig
+	for _, server := range ldapConfig.Servers {
+		if server.Host == form.Host && (form.Port == 0 || server.Port == form.Port) {
+			serverConfig = server
+			break
+		}
+	}
+	if serverConfig == nil {
+		return response.Error(http.StatusNotFound, "No LDAP server is configured with this host and port", nil)
+	}
+
+	if (form.BindDN == "") != (form.BindPassword == "") {
+		return response.Error(http.StatusBadRequest, "The bind DN and password must be given together", nil)
+	}
+
+	// Test a copy of the server config, so that the given credentials are not kept in the loaded configuration
+	testConfig := *serverConfig
+	override := form.BindDN != ""
+	if override {
+		testConfig.BindDN = form.BindDN
+		testConfig.BindPassword = form.BindPassword
+	}
+
+	status, err := testLDAPBind(&testConfig)
+	result := LDAPBindTestDTO{
+		LDAPServerDTO:       *newLDAPServerDTOs([]*multildap.ServerStatus{status})[0],
+		BindDN:              testConfig.BindDN,
+		OverrideCredentials: override,
+		Bound:               err == nil,
+	}
+	// A dial error is already the error of the server
+	if err != nil && status.Available {
+		result.BindError = err.Error()
+	}
+	ldapLogger.Info("Tested bind to LDAP server", "host", testConfig.Host, "port", testConfig.Port,
+		"bindDn", testConfig.BindDN, "overrideCredentials", override, "bound", result.Bound)
+
+	return response.JSON(http.StatusOK, result)
+}
+
+// PostDisableLDAPServer disables one of the configured LDAP servers until the LDAP configuration is reloaded, so that
+// a server under maintenance is no longer dialed. The status of the server is reported as disabled, and users are
+// looked up and synced on the other servers. The server is only disabled in the memory of this instance.
+func (hs *HTTPServer) PostDisableLDAPServer(c *models.ReqContext) response.Response {
+	if !ldap.IsEnabled() {
+		return response.Error(http.StatusBadRequest, "LDAP is not enabled", nil)
+	}
+
+	form := dtos.DisableLDAPServerForm{}
+	if err := web.Bind(c.Req, &form); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+
+	ldapConfig, err := getLDAPConfig(hs.Cfg)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "Failed to obtain the LDAP configuration. Please verify the configuration and try again", err)
+	}
+
+	configured := false
+	for _, server := range ldapConfig.Servers {
+		if server.Host == form.Host && server.Port == form.Port {
+			configured = true
+			break
+		}
+	}
+	if !configured {
+		return response.Error(http.StatusNotFound, "No LDAP server is configured with this host and port", nil)
+	}
+
+	multildap.DisableServer(form.Host, form.Port)
+	ldapLogger.Info("Disabled LDAP server until the LDAP configuration is reloaded", "host", form.Host, "port", form.Port, "userId", c.UserId)
+
+	return response.Success("LDAP server disabled")
+}
+
+func newLDAPServerDTOs(statuses []*multildap.ServerStatus) []*LDAPServerDTO {
 	serverDTOs := []*LDAPServerDTO{}
 	for _, status := range statuses {
 		s := &LDAPServerDTO{
-			Host:      status.Host,
-			Available: status.Available,
-			Port:      status.Port,
+			Host:         status.Host,
+			Available:    status.Available,
+			Port:         status.Port,
+			SecurityMode: status.SecurityMode,
 		}
 
 		if status.Error != nil {
 			s.Error = status.Error.Error()
+			s.ErrorCategory = ldap.ClassifyError(status.Error)
 		}
 
 		serverDTOs = append(serverDTOs, s)
 	}
-
-	return response.JSON(http.StatusOK, serverDTOs)
+	return serverDTOs
 }
 
 // PostSyncUserWithLDAP enables a single Grafana user to be synchronized against LDAP
@@ -159,19 +526,36 @@ func (hs *HTTPServer) PostSyncUserWithLDAP(c *models.ReqContext) response.Respon
 		return response.Error(http.StatusBadRequest, "LDAP is not enabled", nil)
 	}
 
-	ldapConfig, err := getLDAPConfig(hs.Cfg)
+	ctx, span := hs.tracer.Start(c.Req.Context(), "ldap.sync_user")
+	defer span.End()
+
+	ldapConfig, err := hs.loadLDAPConfig(ctx)
 	if err != nil {
 		return response.Error(http.StatusBadRequest, "Failed to obtain the LDAP configuration. Please verify the configuration and try again", err)
 	}
 
+	if len(ldapConfig.Servers) == 0 {
+		return response.Error(http.StatusBadRequest, "LDAP is enabled but no servers are configured", nil)
+	}
+
 	userId, err := strconv.ParseInt(web.Params(c.Req)[":id"], 10, 64)
 	if err != nil {
 		return response.Error(http.StatusBadRequest, "id is invalid", err)
 	}
 
+	// Revoking the tokens of a user disabled because they left LDAP signs them out right away. Skipping it lets
+	// their current sessions live until they expire, so it has to be asked for explicitly.
+	revokeTokens := true
+	if value := c.Query("revokeTokens"); value != "" {
+		revokeTokens, err = strconv.ParseBool(value)
+		if err != nil {
+			return response.Error(http.StatusBadRequest, "revokeTokens is invalid", err)
+		}
+	}
+
 	query := models.GetUserByIdQuery{Id: userId}
 
-	if err := hs.SQLStore.GetUserById(c.Req.Context(), &query); err != nil { // validate the userId exists
+	if err := hs.SQLStore.GetUserById(ctx, &query); err != nil { // validate the userId exists
 		if errors.Is(err, models.ErrUserNotFound) {
 			return response.Error(404, models.ErrUserNotFound.Error(), nil)
 		}
@@ -180,7 +564,7 @@ func (hs *HTTPServer) PostSyncUserWithLDAP(c *models.ReqContext) response.Respon
 	}
 
 	authModuleQuery := &models.GetAuthInfoQuery{UserId: query.Result.ID, AuthModule: models.AuthModuleLDAP}
-	if err := hs.authInfoService.GetAuthInfo(c.Req.Context(), authModuleQuery); err != nil { // validate the userId comes from LDAP
+	if err := hs.authInfoService.GetAuthInfo(ctx, authModuleQuery); err != nil { // validate the userId comes from LDAP
 		if errors.Is(err, models.ErrUserNotFound) {
 			return response.Error(404, models.ErrUserNotFound.Error(), nil)
 		}
@@ -188,8 +572,11 @@ func (hs *HTTPServer) PostSyncUserWithLDAP(c *models.ReqContext) response.Respon
 		return response.Error(500, "Failed to get user", err)
 	}
 
-	ldapServer := newLDAP(ldapConfig.Servers)
-	user, _, err := ldapServer.User(query.Result.Login)
+	span.SetAttributes("ldap.username", query.Result.Login, attribute.String("ldap.username", query.Result.Login))
+	ldapServer := hs.newSyncLDAP(ldapConfig.Servers)
+	_, lookupSpan := hs.startLDAPSpan(ctx, "ldap.sync_user.lookup_user", ldapConfig.Servers)
+	user, err := hs.lookupLDAPUser(ctx, ldapServer, query.Result.Login)
+	endLDAPSpan(lookupSpan, err)
 	if err != nil {
 		if errors.Is(err, multildap.ErrDidNotFindUser) { // User was not in the LDAP server - we need to take action:
 			if hs.Cfg.AdminUser == query.Result.Login { // User is *the* Grafana Admin. We cannot disable it.
@@ -199,14 +586,18 @@ func (hs *HTTPServer) PostSyncUserWithLDAP(c *models.ReqContext) response.Respon
 			}
 
 			// Since the user was not in the LDAP server. Let's disable it.
-			err := hs.Login.DisableExternalUser(c.Req.Context(), query.Result.Login)
+			err := hs.Login.DisableExternalUser(ctx, query.Result.Login)
 			if err != nil {
 				return response.Error(http.StatusInternalServerError, "Failed to disable the user", err)
 			}
 
-			err = hs.AuthTokenService.RevokeAllUserTokens(c.Req.Context(), userId)
-			if err != nil {
-				return response.Error(http.StatusInternalServerError, "Failed to remove session tokens for the user", err)
+			if revokeTokens {
+				err = hs.AuthTokenService.RevokeAllUserTokens(ctx, userId)
+				if err != nil {
+					return response.Error(http.StatusInternalServerError, "Failed to remove session tokens for the user", err)
+				}
+			} else {
+				ldapLogger.Warn("Disabled user without revoking their session tokens", "user", query.Result.Login)
 			}
 
 			return response.Error(http.StatusBadRequest, "User not found in LDAP. Disabled the user without updating information", nil) // should this be a success?
@@ -217,60 +608,855 @@ func (hs *HTTPServer) PostSyncUserWithLDAP(c *models.ReqContext) response.Respon
 	}
 
 	upsertCmd := &models.UpsertUserCommand{
-		ReqContext:    c,
-		ExternalUser:  user,
-		SignupAllowed: hs.Cfg.LDAPAllowSignup,
+		ReqContext:        c,
+		ExternalUser:      user,
+		SignupAllowed:     hs.Cfg.LDAPAllowSignup,
+		ReportMemberships: true,
+		// Only the teams the admin triggering the sync can read are assigned
+		ActingUser: c.SignedInUser,
 	}
 
-	err = hs.Login.UpsertUser(c.Req.Context(), upsertCmd)
+	upsertCtx, upsertSpan := hs.tracer.Start(ctx, "ldap.sync_user.upsert_user")
+	err = hs.Login.UpsertUser(upsertCtx, upsertCmd)
+	endLDAPSpan(upsertSpan, err)
 	if err != nil {
 		return response.Error(http.StatusInternalServerError, "Failed to update the user", err)
 	}
 
+	if len(upsertCmd.SkippedTeamOrgIds) > 0 {
+		ldapLogger.Warn("Skipped team sync in organizations whose teams the signed-in user cannot read", "user", query.Result.Login,
+			"orgs", upsertCmd.SkippedTeamOrgIds)
+	}
+
+	// The resulting memberships are logged so that every sync leaves a complete record of the user's access.
+	ldapLogger.Info("Synced user with LDAP", "user", query.Result.Login, "memberships", upsertCmd.Memberships)
+
 	return response.Success("User synced successfully")
 }
 
+// PostDisableLDAPUsers disables the given LDAP users and revokes their session tokens, as syncing each of them would
+// do if they are no longer found in LDAP. It is meant to act at once on the users a preview of the sync reports as
+// left out of LDAP. The Grafana admin is never disabled, and neither are users that are not found or not synced with
+// LDAP: they are reported as skipped instead.
+func (hs *HTTPServer) PostDisableLDAPUsers(c *models.ReqContext) response.Response {
+	if !ldap.IsEnabled() {
+		return response.Error(http.StatusBadRequest, "LDAP is not enabled", nil)
+	}
+
+	form := dtos.DisableLDAPUsersForm{}
+	if err := web.Bind(c.Req, &form); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+
+	ctx := c.Req.Context()
+	result := LDAPUsersDisabledDTO{Disabled: []int64{}, Skipped: []LDAPUserSkippedDTO{}}
+	seen := map[int64]bool{}
+	for _, userId := range form.UserIds {
+		if seen[userId] {
+			continue
+		}
+		seen[userId] = true
+
+		query := models.GetUserByIdQuery{Id: userId}
+		if err := hs.SQLStore.GetUserById(ctx, &query); err != nil {
+			if errors.Is(err, models.ErrUserNotFound) {
+				result.Skipped = append(result.Skipped, LDAPUserSkippedDTO{UserId: userId, Reason: "user not found"})
+				continue
+			}
+			return response.Error(http.StatusInternalServerError, "Failed to get user", err)
+		}
+		login := query.Result.Login
+
+		if hs.Cfg.AdminUser == login { // User is *the* Grafana Admin. We cannot disable it.
+			result.Skipped = append(result.Skipped, LDAPUserSkippedDTO{UserId: userId, Login: login, Reason: "the Grafana admin cannot be disabled"})
+			continue
+		}
+
+		authModuleQuery := &models.GetAuthInfoQuery{UserId: userId, AuthModule: models.AuthModuleLDAP}
+		if err := hs.authInfoService.GetAuthInfo(ctx, authModuleQuery); err != nil {
+			if errors.Is(err, models.ErrUserNotFound) {
+				result.Skipped = append(result.Skipped, LDAPUserSkippedDTO{UserId: userId, Login: login, Reason: "user is not synced with LDAP"})
+				continue
+			}
+			return response.Error(http.StatusInternalServerError, "Failed to get user", err)
+		}
+
+		if err := hs.Login.DisableExternalUser(ctx, login); err != nil {
+			return response.Error(http.StatusInternalServerError, fmt.Sprintf("Failed to disable the user %q", login), err)
+		}
+		// Revoke the sessions at once, so that a user disabled before a later failure is logged out anyway
+		if err := hs.AuthTokenService.RevokeAllUserTokens(ctx, userId); err != nil {
+			return response.Error(http.StatusInternalServerError, fmt.Sprintf("Failed to remove session tokens for the user %q", login), err)
+		}
+		result.Disabled = append(result.Disabled, userId)
+	}
+
+	ldapLogger.Info("Disabled LDAP users in bulk", "disabled", result.Disabled, "skipped", len(result.Skipped), "userId", c.UserId)
+
+	return response.JSON(http.StatusOK, result)
+}
+
+// newLDAPConnectionPool creates the pool of connections users are synced with, or returns nil if it is disabled.
+func newLDAPConnectionPool(cfg *setting.Cfg) *multildap.ConnectionPool {
+	if cfg.LDAPConnectionPoolSize <= 0 {
+		return nil
+	}
+	return multildap.NewConnectionPool(cfg.LDAPConnectionPoolSize, cfg.LDAPConnectionPoolIdleTimeout)
+}
+
+// newSyncLDAP returns the LDAP servers to sync users with, which reuse the connections of the pool if it is enabled,
+// so that syncing many users in a row does not dial the servers for each of them. The debug lookups do not use it,
+// so they always dial the servers.
+func (hs *HTTPServer) newSyncLDAP(servers []*ldap.ServerConfig) multildap.IMultiLDAP {
+	if hs.ldapConnectionPool == nil {
+		return newLDAP(servers)
+	}
+	return newPooledLDAP(servers, hs.ldapConnectionPool)
+}
+
+// maxLDAPRetryBackoff is the longest lookupLDAPUser waits between two attempts, however many attempts are configured.
+const maxLDAPRetryBackoff = 30 * time.Second
+
+// lookupLDAPUser finds an user in LDAP. Lookups failing because of a network error are retried with an exponential
+// backoff, up to the configured number of attempts. Any other error, including the user not being found, is returned right away.
+// The retries stop when the context is done, e.g. because the request was canceled.
+func (hs *HTTPServer) lookupLDAPUser(ctx context.Context, ldapServer multildap.IMultiLDAP, login string) (*models.ExternalUserInfo, error) {
+	backoff := hs.Cfg.LDAPSyncRetryBackoff
+	for attempt := 1; ; attempt++ {
+		user, _, err := ldapServer.User(login)
+		if err == nil || attempt >= hs.Cfg.LDAPSyncRetryAttempts || !ldap.IsNetworkError(err) {
+			return user, err
+		}
+
+		if backoff > maxLDAPRetryBackoff {
+			backoff = maxLDAPRetryBackoff
+		}
+		ldapLogger.Warn("Failed to reach LDAP while looking up user, retrying", "login", login, "attempt", attempt, "backoff", backoff, "err", err)
+		if err := ldapRetryWait(ctx, backoff); err != nil {
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
+
+// waitLDAPRetry waits for the backoff to elapse, or returns the error of the context if it is done first.
+func waitLDAPRetry(ctx context.Context, backoff time.Duration) error {
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
 // GetUserFromLDAP finds an user based on a username in LDAP. This helps illustrate how would the particular user be mapped in Grafana when synced.
 func (hs *HTTPServer) GetUserFromLDAP(c *models.ReqContext) response.Response {
 	if !ldap.IsEnabled() {
 		return response.Error(http.StatusBadRequest, "LDAP is not enabled", nil)
 	}
 
-	ldapConfig, err := getLDAPConfig(hs.Cfg)
+	ctx, span := hs.tracer.Start(c.Req.Context(), "ldap.user")
+	defer span.End()
+
+	ldapConfig, err := hs.loadLDAPConfig(ctx)
 	if err != nil {
 		return response.Error(http.StatusBadRequest, "Failed to obtain the LDAP configuration", err)
 	}
 
-	multiLDAP := newLDAP(ldapConfig.Servers)
+	if len(ldapConfig.Servers) == 0 {
+		return response.Error(http.StatusBadRequest, "LDAP is enabled but no servers are configured", nil)
+	}
+
+	servers, resp := ldapServersForOrg(c, ldapConfig)
+	if resp != nil {
+		return resp
+	}
+
+	servers, resp = ldapServersWithSearchScope(servers, c.Query("scope"))
+	if resp != nil {
+		return resp
+	}
+
+	servers, resp = ldapServersWithSearchFilter(servers, c.Query("filter"))
+	if resp != nil {
+		return resp
+	}
+
+	multiLDAP := newLDAP(servers)
 
 	username := web.Params(c.Req)[":username"]
 
 	if len(username) == 0 {
 		return response.Error(http.StatusBadRequest, "Validation error. You must specify an username", nil)
 	}
+	span.SetAttributes("ldap.username", username, attribute.String("ldap.username", username))
 
-	user, serverConfig, err := multiLDAP.User(username)
+	if c.QueryBool("allServers") {
+		return hs.getUserFromAllLDAPServers(ctx, multiLDAP, username, servers)
+	}
+
+	_, lookupSpan := hs.startLDAPSpan(ctx, "ldap.user.lookup_user", servers)
+	user, serverConfig, failedServers, err := multiLDAP.LookupUser(username)
+	endLDAPSpan(lookupSpan, err)
 	if user == nil || err != nil {
-		return response.Error(http.StatusNotFound, "No user was found in the LDAP server(s) with that username", err)
+		return ldapUserLookupError(failedServers, len(servers), err)
 	}
 
 	ldapLogger.Debug("user found", "user", user)
 
-	name, surname := splitName(user.Name)
+	u := newLDAPUserDTO(user, serverConfig)
+	u.FoundOn = formatLDAPServer(serverConfig)
+	u.SearchScope = serverConfig.EffectiveSearchScope()
+	u.SearchFilter = serverConfig.SearchFilter
+	if len(failedServers) > 0 {
+		ldapLogger.Warn("Found the user despite some LDAP servers failing", "user", username, "failedServers", formatLDAPServers(failedServers))
+		u.FailedServers = newLDAPServerDTOs(failedServers)
+	}
+
+	u.GrafanaAdmin, err = hs.resolveGrafanaAdmin(ctx, user)
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to get the user", err)
+	}
+
+	ldapLogger.Debug("mapping org roles", "orgsRoles", u.OrgRoles)
+	orgsCtx, orgsSpan := hs.tracer.Start(ctx, "ldap.user.fetch_orgs")
+	err = u.FetchOrgs(orgsCtx, hs.SQLStore)
+	endLDAPSpan(orgsSpan, err)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "An organization was not found - Please verify your LDAP configuration", err)
+	}
+
+	_, teamsSpan := hs.tracer.Start(ctx, "ldap.user.fetch_teams")
+	err = hs.fetchLDAPUsersTeams([]*models.ExternalUserInfo{user}, []*LDAPUserDTO{u})
+	endLDAPSpan(teamsSpan, err)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "Unable to find the teams for this user", err)
+	}
+
+	return response.JSON(http.StatusOK, u)
+}
+
+// resolveGrafanaAdmin works out whether the user will be a Grafana admin after being synced with LDAP. The sync
+// applies the status given by the group mappings, if any, and otherwise keeps the one the user has in Grafana.
+func (hs *HTTPServer) resolveGrafanaAdmin(ctx context.Context, user *models.ExternalUserInfo) (*LDAPGrafanaAdminDTO, error) {
+	query := &models.GetUserByLoginQuery{LoginOrEmail: user.Login}
+	if err := hs.SQLStore.GetUserByLogin(ctx, query); err != nil && !errors.Is(err, models.ErrUserNotFound) {
+		return nil, err
+	}
+
+	admin := &LDAPGrafanaAdminDTO{Sources: []string{}}
+	if query.Result != nil {
+		admin.Current = query.Result.IsAdmin
+	}
+
+	admin.Effective = admin.Current
+	if user.IsGrafanaAdmin != nil {
+		admin.Effective = *user.IsGrafanaAdmin
+		if admin.Effective {
+			admin.Sources = append(admin.Sources, "ldap")
+		}
+	}
+	if admin.Current && admin.Effective {
+		admin.Sources = append(admin.Sources, "grafana")
+	}
+
+	return admin, nil
+}
+
+// getUserFromAllLDAPServers finds the users with the given username on every LDAP server, rather than only on the
+// first server that has one, and flags the response when more than one server has such a user.
+func (hs *HTTPServer) getUserFromAllLDAPServers(ctx context.Context, multiLDAP multildap.IMultiLDAP, username string, servers []*ldap.ServerConfig) response.Response {
+	_, lookupSpan := hs.startLDAPSpan(ctx, "ldap.user.lookup_user_on_all_servers", servers)
+	matches, failedServers, err := multiLDAP.LookupUserOnAllServers(username)
+	endLDAPSpan(lookupSpan, err)
+	if len(matches) == 0 || err != nil {
+		return ldapUserLookupError(failedServers, len(servers), err)
+	}
+
+	result := LDAPUserMatchesDTO{
+		Matches:  make([]*LDAPUserDTO, 0, len(matches)),
+		Conflict: len(matches) > 1,
+	}
+	users := make([]*models.ExternalUserInfo, 0, len(matches))
+	for _, match := range matches {
+		u := newLDAPUserDTO(match.User, match.Config)
+		u.FoundOn = formatLDAPServer(match.Config)
+		u.SearchScope = match.Config.EffectiveSearchScope()
+		u.SearchFilter = match.Config.SearchFilter
+		result.Matches = append(result.Matches, u)
+		users = append(users, match.User)
+	}
+	if result.Conflict {
+		ldapLogger.Warn("Found a user with the same username on several LDAP servers", "user", username, "servers", len(matches))
+	}
+	if len(failedServers) > 0 {
+		result.FailedServers = newLDAPServerDTOs(failedServers)
+	}
+
+	orgsCtx, orgsSpan := hs.tracer.Start(ctx, "ldap.user.fetch_orgs")
+	err = fetchLDAPUsersOrgs(orgsCtx, hs.SQLStore, result.Matches)
+	endLDAPSpan(orgsSpan, err)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "An organization was not found - Please verify your LDAP configuration", err)
+	}
+
+	_, teamsSpan := hs.tracer.Start(ctx, "ldap.user.fetch_teams")
+	err = hs.fetchLDAPUsersTeams(users, result.Matches)
+	endLDAPSpan(teamsSpan, err)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "Unable to find the teams for this user", err)
+	}
+
+	return response.JSON(http.StatusOK, result)
+}
+
+// ldapServersForOrg returns the servers of the configuration used for the org given by the orgId query parameter,
+// or all the servers if there is no such parameter. It returns an error response if no server is used for that org.
+func ldapServersForOrg(c *models.ReqContext, ldapConfig *ldap.Config) ([]*ldap.ServerConfig, response.Response) {
+	orgID := c.QueryInt64("orgId")
+	if orgID == 0 {
+		return ldapConfig.Servers, nil
+	}
+
+	servers := ldapConfig.ServersForOrg(orgID)
+	if len(servers) == 0 {
+		return nil, response.Error(http.StatusBadRequest, fmt.Sprintf("No LDAP server is configured for organization %d", orgID), nil)
+	}
+	return servers, nil
+}
+
+// ldapServersWithSearchScope returns copies of the servers that search users with the given scope, which is one of
+// base, one or sub. The servers are returned as they are if no scope is given, so the configuration is never changed.
+func ldapServersWithSearchScope(servers []*ldap.ServerConfig, scope string) ([]*ldap.ServerConfig, response.Response) {
+	if scope == "" {
+		return servers, nil
+	}
+	if !ldap.IsValidSearchScope(scope) {
+		return nil, response.Error(http.StatusBadRequest, fmt.Sprintf("Validation error. Unknown search scope %q, must be base, one or sub", scope), nil)
+	}
+
+	scoped := make([]*ldap.ServerConfig, 0, len(servers))
+	for _, server := range servers {
+		server := *server
+		server.SearchScope = scope
+		scoped = append(scoped, &server)
+	}
+	return scoped, nil
+}
+
+// ldapServersWithSearchFilter returns copies of the servers that search users with the given filter, e.g. to also find
+// inactive accounts while debugging. The filter must be a valid LDAP filter with the %s username placeholder. The
+// servers are returned as they are if no filter is given, so the configuration is never changed.
+func ldapServersWithSearchFilter(servers []*ldap.ServerConfig, filter string) ([]*ldap.ServerConfig, response.Response) {
+	if filter == "" {
+		return servers, nil
+	}
+	if err := ldap.ValidateSearchFilter(filter); err != nil {
+		return nil, response.Error(http.StatusBadRequest, fmt.Sprintf("Validation error. %s", err), nil)
+	}
+
+	filtered := make([]*ldap.ServerConfig, 0, len(servers))
+	for _, server := range servers {
+		server := *server
+		server.SearchFilter = filter
+		filtered = append(filtered, &server)
+	}
+	return filtered, nil
+}
+
+// loadLDAPConfig reads the LDAP configuration in a span of its own, as it may have to read the configuration file.
+func (hs *HTTPServer) loadLDAPConfig(ctx context.Context) (*ldap.Config, error) {
+	_, span := hs.tracer.Start(ctx, "ldap.load_config")
+	ldapConfig, err := getLDAPConfig(hs.Cfg)
+	endLDAPSpan(span, err)
+	return ldapConfig, err
+}
+
+// startLDAPSpan starts a span for a call to the given LDAP servers, with their hosts as attribute.
+func (hs *HTTPServer) startLDAPSpan(ctx context.Context, name string, servers []*ldap.ServerConfig) (context.Context, tracing.Span) {
+	ctx, span := hs.tracer.Start(ctx, name)
+	hosts := make([]string, 0, len(servers))
+	for _, server := range servers {
+		hosts = append(hosts, server.Host)
+	}
+	span.SetAttributes("ldap.hosts", hosts, attribute.StringSlice("ldap.hosts", hosts))
+	return ctx, span
+}
+
+// endLDAPSpan ends a span, marking it as failed if err is not nil.
+func endLDAPSpan(span tracing.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// ldapUserLookupError returns the response for a user that was not found, telling apart the LDAP servers that did
+// not have the user from the ones that could not be searched.
+func ldapUserLookupError(failedServers []*multildap.ServerStatus, servers int, err error) response.Response {
+	if len(failedServers) == servers {
+		return response.Error(http.StatusBadRequest, "Failed to search the LDAP server(s)", err)
+	}
+	if len(failedServers) > 0 {
+		msg := fmt.Sprintf("No user was found with that username in the LDAP server(s) that could be searched. The search failed on %s",
+			formatLDAPServers(failedServers))
+		return response.Error(http.StatusNotFound, msg, err)
+	}
+	return response.Error(http.StatusNotFound, "No user was found in the LDAP server(s) with that username", err)
+}
+
+// formatLDAPServer returns the address of the server, e.g. "ldap1:389", or the empty string if it has no host.
+func formatLDAPServer(config ldap.ServerConfig) string {
+	if config.Host == "" {
+		return ""
+	}
+	return net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+}
+
+// formatLDAPServers lists the addresses of the servers, e.g. "ldap1:389, ldap2:636".
+func formatLDAPServers(statuses []*multildap.ServerStatus) string {
+	addresses := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		addresses = append(addresses, net.JoinHostPort(status.Host, strconv.Itoa(status.Port)))
+	}
+	return strings.Join(addresses, ", ")
+}
+
+// GetLDAPUsersPreview finds all the users under a base DN in LDAP, and illustrates how they would be mapped in Grafana when synced, one page at a time.
+func (hs *HTTPServer) GetLDAPUsersPreview(c *models.ReqContext) response.Response {
+	if !ldap.IsEnabled() {
+		return response.Error(http.StatusBadRequest, "LDAP is not enabled", nil)
+	}
+
+	ldapConfig, err := getLDAPConfig(hs.Cfg)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "Failed to obtain the LDAP configuration", err)
+	}
+
+	if len(ldapConfig.Servers) == 0 {
+		return response.Error(http.StatusBadRequest, "LDAP is enabled but no servers are configured", nil)
+	}
+
+	servers, resp := ldapServersForOrg(c, ldapConfig)
+	if resp != nil {
+		return resp
+	}
+
+	baseDN := c.Query("baseDN")
+	if len(baseDN) == 0 {
+		return response.Error(http.StatusBadRequest, "Validation error. You must specify a base DN", nil)
+	}
+
+	perPage := c.QueryInt("perpage")
+	if perPage <= 0 {
+		perPage = ldapPreviewDefaultPerPage
+	}
+	if perPage > ldapPreviewMaxPerPage {
+		return response.Error(http.StatusBadRequest, fmt.Sprintf("Validation error. At most %d users can be previewed per page", ldapPreviewMaxPerPage), nil)
+	}
+	page := c.QueryInt("page")
+	if page < 1 {
+		page = 1
+	}
+	format := c.Query("format")
+	if format != "" && format != ldapPreviewFormatStable && format != ldapPreviewFormatCSV {
+		return response.Error(http.StatusBadRequest, fmt.Sprintf("Validation error. Unknown format %q", format), nil)
+	}
+
+	multiLDAP := newLDAP(servers)
+
+	users, serverConfig, err := multiLDAP.UsersInBaseDN(baseDN)
+	if errors.Is(err, multildap.ErrUnknownBaseDN) {
+		return response.Error(http.StatusBadRequest, "The base DN is not searched by any of the LDAP servers", nil)
+	}
+	if errors.Is(err, ldap.ErrSizeLimitExceeded) {
+		return response.Error(http.StatusBadRequest, "The base DN has more users than the LDAP server's users preview size limit, please choose a narrower one", err)
+	}
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to search the LDAP server(s) for users", err)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Login < users[j].Login
+	})
+
+	result := LDAPUserPreviewPageDTO{
+		TotalCount: len(users),
+		Page:       page,
+		PerPage:    perPage,
+		Users:      []*LDAPUserDTO{},
+	}
+
+	offset := (page - 1) * perPage
+	if offset >= len(users) {
+		if format == ldapPreviewFormatCSV {
+			return ldapUsersCSVResponse(result.Users)
+		}
+		return response.JSON(http.StatusOK, result)
+	}
+	users = users[offset:]
+	if len(users) > perPage {
+		users = users[:perPage]
+	}
+
+	for _, user := range users {
+		result.Users = append(result.Users, newLDAPUserDTO(user, serverConfig))
+	}
+
+	if err := fetchLDAPUsersOrgs(c.Req.Context(), hs.SQLStore, result.Users); err != nil {
+		return response.Error(http.StatusBadRequest, "An organization was not found - Please verify your LDAP configuration", err)
+	}
+
+	if err := hs.fetchLDAPUsersTeams(users, result.Users); err != nil {
+		return response.Error(http.StatusBadRequest, "Unable to find the teams for these users", err)
+	}
+
+	if format == ldapPreviewFormatStable || format == ldapPreviewFormatCSV {
+		for _, u := range result.Users {
+			sortLDAPUserDTO(u)
+		}
+	}
+
+	if format == ldapPreviewFormatCSV {
+		return ldapUsersCSVResponse(result.Users)
+	}
+
+	return response.JSON(http.StatusOK, result)
+}
+
+// GetLDAPAttributeSuggestions finds a sample user in LDAP and returns all the attributes of its entry, with suggestions
+// of the attributes to map to the user's login, email, name, surname and groups. It helps with a first LDAP setup,
+// before the attributes are mapped.
+func (hs *HTTPServer) GetLDAPAttributeSuggestions(c *models.ReqContext) response.Response {
+	if !ldap.IsEnabled() {
+		return response.Error(http.StatusBadRequest, "LDAP is not enabled", nil)
+	}
+
+	ldapConfig, err := getLDAPConfig(hs.Cfg)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "Failed to obtain the LDAP configuration", err)
+	}
+
+	if len(ldapConfig.Servers) == 0 {
+		return response.Error(http.StatusBadRequest, "LDAP is enabled but no servers are configured", nil)
+	}
+
+	servers, resp := ldapServersForOrg(c, ldapConfig)
+	if resp != nil {
+		return resp
+	}
+
+	username := web.Params(c.Req)[":username"]
+	if len(username) == 0 {
+		return response.Error(http.StatusBadRequest, "Validation error. You must specify an username", nil)
+	}
+
+	attributes, serverConfig, err := newLDAP(servers).UserAttributes(username)
+	if errors.Is(err, multildap.ErrDidNotFindUser) {
+		return response.Error(http.StatusNotFound, "No user was found in the LDAP server(s) with that username", err)
+	}
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to search the LDAP server(s) for the user", err)
+	}
+
+	return response.JSON(http.StatusOK, LDAPAttributeSuggestionsDTO{
+		FoundOn:     formatLDAPServer(serverConfig),
+		Attributes:  attributes,
+		Suggestions: ldap.SuggestAttributes(attributes),
+	})
+}
+
+// ldapUsersCSVHeader is the header row of the CSV LDAP users preview. Roles are listed as "org:role" and teams as
+// "org:team", separated with "; ".
+var ldapUsersCSVHeader = []string{"login", "email", "name", "roles", "teams"}
+
+// ldapUsersCSV is a response that streams the LDAP users preview as CSV.
+type ldapUsersCSV struct {
+	users []*LDAPUserDTO
+}
+
+func ldapUsersCSVResponse(users []*LDAPUserDTO) response.Response {
+	return ldapUsersCSV{users: users}
+}
+
+// Status gets the response's status.
+// Required to implement api.Response.
+func (r ldapUsersCSV) Status() int {
+	return http.StatusOK
+}
+
+// Body gets the response's body.
+// Required to implement api.Response.
+func (r ldapUsersCSV) Body() []byte {
+	return nil
+}
+
+// WriteTo writes a row per user to the provided context, as the rows are encoded.
+// Required to implement api.Response.
+func (r ldapUsersCSV) WriteTo(ctx *models.ReqContext) {
+	ctx.Resp.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	ctx.Resp.Header().Set("Content-Disposition", `attachment; filename="ldap-users-preview.csv"`)
+	ctx.Resp.WriteHeader(http.StatusOK)
+
+	w := csv.NewWriter(ctx.Resp)
+	if err := w.Write(ldapUsersCSVHeader); err != nil {
+		ctx.Logger.Error("Error writing to response", "err", err)
+		return
+	}
+	for _, u := range r.users {
+		if err := w.Write(ldapUserCSVRecord(u)); err != nil {
+			ctx.Logger.Error("Error writing to response", "err", err)
+			return
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		ctx.Logger.Error("Error writing to response", "err", err)
+	}
+}
+
+// ldapUserCSVRecord is the CSV row of a user. Unmapped groups are left out of the roles, as they grant none.
+func ldapUserCSVRecord(u *LDAPUserDTO) []string {
+	roles := make([]string, 0, len(u.OrgRoles))
+	for _, role := range u.OrgRoles {
+		if role.OrgId == 0 {
+			continue
+		}
+		roles = append(roles, fmt.Sprintf("%s:%s", role.OrgName, role.OrgRole))
+	}
+	teams := make([]string, 0, len(u.Teams))
+	for _, team := range u.Teams {
+		teams = append(teams, fmt.Sprintf("%s:%s", team.OrgName, team.TeamName))
+	}
+	return []string{
+		ldapAttributeValue(u.Username),
+		ldapAttributeValue(u.Email),
+		strings.TrimSpace(ldapAttributeValue(u.Name) + " " + ldapAttributeValue(u.Surname)),
+		strings.Join(roles, "; "),
+		strings.Join(teams, "; "),
+	}
+}
+
+// ldapAttributeValue is the value synced for the attribute: the transformed value if any, otherwise the LDAP one.
+func ldapAttributeValue(attr *LDAPAttribute) string {
+	if attr == nil {
+		return ""
+	}
+	if attr.TransformedValue != "" {
+		return attr.TransformedValue
+	}
+	return attr.LDAPAttributeValue
+}
+
+// sortLDAPUserDTO sorts the roles of the user by org, with the unmapped groups last, and its teams by org and name.
+// Groups are compared case-insensitively, the same way group memberships are matched.
+func sortLDAPUserDTO(u *LDAPUserDTO) {
+	sort.SliceStable(u.OrgRoles, func(i, j int) bool {
+		a, b := u.OrgRoles[i], u.OrgRoles[j]
+		if (a.OrgId == 0) != (b.OrgId == 0) {
+			return b.OrgId == 0
+		}
+		if a.OrgId != b.OrgId {
+			return a.OrgId < b.OrgId
+		}
+		return strings.ToLower(a.GroupDN) < strings.ToLower(b.GroupDN)
+	})
+	sort.SliceStable(u.Teams, func(i, j int) bool {
+		a, b := u.Teams[i], u.Teams[j]
+		if a.OrgName != b.OrgName {
+			return a.OrgName < b.OrgName
+		}
+		if a.TeamName != b.TeamName {
+			return a.TeamName < b.TeamName
+		}
+		return strings.ToLower(a.GroupDN) < strings.ToLower(b.GroupDN)
+	})
+}
+
+// CompareLDAPGroupMappings lists the LDAP groups mapped into two orgs, and the group DNs mapped into only one of them.
+// Only the configuration is read, the LDAP servers are not contacted.
+func (hs *HTTPServer) CompareLDAPGroupMappings(c *models.ReqContext) response.Response {
+	if !ldap.IsEnabled() {
+		return response.Error(http.StatusBadRequest, "LDAP is not enabled", nil)
+	}
+
+	orgA := c.QueryInt64("orgA")
+	orgB := c.QueryInt64("orgB")
+	if orgA <= 0 || orgB <= 0 {
+		return response.Error(http.StatusBadRequest, "Validation error. You must specify the orgs to compare", nil)
+	}
+	if orgA == orgB {
+		return response.Error(http.StatusBadRequest, "Validation error. You must specify two different orgs", nil)
+	}
+
+	ldapConfig, err := getLDAPConfig(hs.Cfg)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "Failed to obtain the LDAP configuration", err)
+	}
+
+	a := newLDAPOrgGroupMappingsDTO(ldapConfig, orgA)
+	b := newLDAPOrgGroupMappingsDTO(ldapConfig, orgB)
+	a.OnlyHere = groupDNsMissingFrom(a.Groups, b.Groups)
+	b.OnlyHere = groupDNsMissingFrom(b.Groups, a.Groups)
+
+	return response.JSON(http.StatusOK, LDAPGroupMappingsComparisonDTO{OrgA: a, OrgB: b})
+}
+
+// newLDAPOrgGroupMappingsDTO collects the groups mapped into an org by any of the LDAP servers, sorted by DN.
+func newLDAPOrgGroupMappingsDTO(ldapConfig *ldap.Config, orgID int64) LDAPOrgGroupMappingsDTO {
+	result := LDAPOrgGroupMappingsDTO{
+		OrgId:  orgID,
+		Groups: []LDAPGroupMappingDTO{},
+	}
+
+	for _, server := range ldapConfig.Servers {
+		for _, group := range server.Groups {
+			if group.OrgId != orgID {
+				continue
+			}
+			result.Groups = append(result.Groups, LDAPGroupMappingDTO{
+				GroupDN: group.GroupDN,
+				OrgRole: group.OrgRole,
+			})
+		}
+	}
+
+	sort.SliceStable(result.Groups, func(i, j int) bool {
+		return strings.ToLower(result.Groups[i].GroupDN) < strings.ToLower(result.Groups[j].GroupDN)
+	})
+
+	return result
+}
+
+// groupDNsMissingFrom returns the DNs of the groups that are not in other. DNs are compared case-insensitively,
+// the same way group memberships are matched.
+func groupDNsMissingFrom(groups []LDAPGroupMappingDTO, other []LDAPGroupMappingDTO) []string {
+	otherDNs := make(map[string]bool, len(other))
+	for _, group := range other {
+		otherDNs[strings.ToLower(group.GroupDN)] = true
+	}
+
+	missing := []string{}
+	seen := map[string]bool{}
+	for _, group := range groups {
+		dn := strings.ToLower(group.GroupDN)
+		if otherDNs[dn] || seen[dn] {
+			continue
+		}
+		seen[dn] = true
+		missing = append(missing, group.GroupDN)
+	}
+
+	return missing
+}
+
+// ValidateLDAPGroupMappings checks that the orgs and roles the LDAP groups are mapped into exist, which is otherwise
+// only found out when a user of the group logs in. The orgs are looked up in a single query.
+func (hs *HTTPServer) ValidateLDAPGroupMappings(c *models.ReqContext) response.Response {
+	if !ldap.IsEnabled() {
+		return response.Error(http.StatusBadRequest, "LDAP is not enabled", nil)
+	}
+
+	ldapConfig, err := getLDAPConfig(hs.Cfg)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "Failed to obtain the LDAP configuration", err)
+	}
+
+	result, err := validateLDAPMappingTargets(c.Req.Context(), hs.SQLStore, ldapConfig)
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to look up the mapped orgs", err)
+	}
+
+	return response.JSON(http.StatusOK, result)
+}
+
+// validateLDAPMappingTargets collects the orgs that the groups of every LDAP server are mapped into but that do not
+// exist, sorted by ID, and the mappings with a role that does not exist, in configuration order.
+func validateLDAPMappingTargets(ctx context.Context, sqlstore sqlstore.Store, ldapConfig *ldap.Config) (*LDAPMappingTargetsDTO, error) {
+	result := &LDAPMappingTargetsDTO{
+		MissingOrgs:  []LDAPMissingOrgDTO{},
+		InvalidRoles: []LDAPInvalidRoleDTO{},
+	}
+
+	groupDNsByOrg := map[int64][]string{}
+	orgIds := []int64{}
+	for _, server := range ldapConfig.Servers {
+		for _, group := range server.Groups {
+			// Mappings that only grant the Grafana admin flag have no org role
+			if group.OrgRole != "" && !group.OrgRole.IsValid() {
+				result.InvalidRoles = append(result.InvalidRoles, LDAPInvalidRoleDTO{
+					GroupDN: group.GroupDN,
+					OrgId:   group.OrgId,
+					OrgRole: group.OrgRole,
+				})
+			}
+
+			if _, ok := groupDNsByOrg[group.OrgId]; !ok {
+				orgIds = append(orgIds, group.OrgId)
+			}
+			groupDNsByOrg[group.OrgId] = append(groupDNsByOrg[group.OrgId], group.GroupDN)
+		}
+	}
+
+	if len(orgIds) > 0 {
+		q := &models.SearchOrgsQuery{Ids: orgIds}
+		if err := sqlstore.SearchOrgs(ctx, q); err != nil {
+			return nil, err
+		}
+
+		existing := make(map[int64]bool, len(q.Result))
+		for _, org := range q.Result {
+			existing[org.Id] = true
+		}
+
+		for _, orgID := range orgIds {
+			if existing[orgID] {
+				continue
+			}
+			result.MissingOrgs = append(result.MissingOrgs, LDAPMissingOrgDTO{
+				OrgId:    orgID,
+				GroupDNs: groupDNsByOrg[orgID],
+			})
+		}
+		sort.Slice(result.MissingOrgs, func(i, j int) bool {
+			return result.MissingOrgs[i].OrgId < result.MissingOrgs[j].OrgId
+		})
+	}
+
+	result.Valid = len(result.MissingOrgs) == 0 && len(result.InvalidRoles) == 0
+
+	return result, nil
+}
+
+// newLDAPUserDTO maps a user found in LDAP to its attributes and organization roles in Grafana. The names of the
+// organizations and the teams of the user are fetched separately, so that they can be fetched for many users at once.
+func newLDAPUserDTO(user *models.ExternalUserInfo, serverConfig ldap.ServerConfig) *LDAPUserDTO {
+	name, surname := user.GivenName, user.FamilyName
+	if serverConfig.NameSplit != ldap.NameSplitAttributes || (name == "" && surname == "") {
+		name, surname = splitName(user.Name, serverConfig.NameSplit)
+	}
 
 	u := &LDAPUserDTO{
-		Name:           &LDAPAttribute{serverConfig.Attr.Name, name},
-		Surname:        &LDAPAttribute{serverConfig.Attr.Surname, surname},
-		Email:          &LDAPAttribute{serverConfig.Attr.Email, user.Email},
-		Username:       &LDAPAttribute{serverConfig.Attr.Username, user.Login},
-		IsGrafanaAdmin: user.IsGrafanaAdmin,
-		IsDisabled:     user.IsDisabled,
+		Name:             newLDAPAttribute(serverConfig.Attr.Name, name, user.RawAttributes, ldap.AttributeName),
+		Surname:          newLDAPAttribute(serverConfig.Attr.Surname, surname, user.RawAttributes, ldap.AttributeSurname),
+		Email:            newLDAPAttribute(serverConfig.Attr.Email, user.Email, user.RawAttributes, ldap.AttributeEmail),
+		Username:         newLDAPAttribute(serverConfig.Attr.Username, user.Login, user.RawAttributes, ldap.AttributeUsername),
+		IsGrafanaAdmin:   user.IsGrafanaAdmin,
+		IsDisabled:       user.IsDisabled,
+		ReferralFollowed: user.Referral != "",
+		Referral:         user.Referral,
 	}
 
+	userGroups := map[string]struct{}{}
 	unmappedUserGroups := map[string]struct{}{}
 	for _, userGroup := range user.Groups {
+		userGroups[strings.ToLower(userGroup)] = struct{}{}
 		unmappedUserGroups[strings.ToLower(userGroup)] = struct{}{}
 	}
+	u.GroupStats.Total = len(userGroups)
 
 	orgRolesMap := map[int64]models.RoleType{}
 	for _, group := range serverConfig.Groups {
@@ -287,25 +1473,89 @@ func (hs *HTTPServer) GetUserFromLDAP(c *models.ReqContext) response.Response {
 		}
 	}
 
+	u.GroupStats.MatchedOrg = u.GroupStats.Total - len(unmappedUserGroups)
+
 	for userGroup := range unmappedUserGroups {
 		u.OrgRoles = append(u.OrgRoles, LDAPRoleDTO{GroupDN: userGroup})
 	}
 
-	ldapLogger.Debug("mapping org roles", "orgsRoles", u.OrgRoles)
-	if err := u.FetchOrgs(c.Req.Context(), hs.SQLStore); err != nil {
-		return response.Error(http.StatusBadRequest, "An organization was not found - Please verify your LDAP configuration", err)
+	return u
+}
+
+// fetchLDAPUsersTeams fetches the teams of all the users with a single lookup of their groups, and completes
+// the group stats of each user with the groups mapped to its teams.
+func (hs *HTTPServer) fetchLDAPUsersTeams(users []*models.ExternalUserInfo, dtos []*LDAPUserDTO) error {
+	groups := []string{}
+	seen := map[string]struct{}{}
+	for _, user := range users {
+		for _, group := range user.Groups {
+			if _, ok := seen[strings.ToLower(group)]; ok {
+				continue
+			}
+			seen[strings.ToLower(group)] = struct{}{}
+			groups = append(groups, group)
+		}
 	}
 
-	u.Teams, err = hs.ldapGroups.GetTeams(user.Groups)
+	teams, err := hs.ldapGroups.GetTeams(groups)
 	if err != nil {
-		return response.Error(http.StatusBadRequest, "Unable to find the teams for this user", err)
+		return err
 	}
 
-	return response.JSON(http.StatusOK, u)
+	for i, user := range users {
+		u := dtos[i]
+		teamGroups := map[string]struct{}{}
+		for _, team := range teams {
+			if ldap.IsMemberOf(user.Groups, team.GroupDN) {
+				u.Teams = append(u.Teams, team)
+				teamGroups[strings.ToLower(team.GroupDN)] = struct{}{}
+			}
+		}
+
+		unmappedUserGroups := map[string]struct{}{}
+		for _, role := range u.OrgRoles {
+			if role.OrgId == 0 {
+				unmappedUserGroups[strings.ToLower(role.GroupDN)] = struct{}{}
+			}
+		}
+
+		userGroups := map[string]struct{}{}
+		for _, userGroup := range user.Groups {
+			userGroups[strings.ToLower(userGroup)] = struct{}{}
+		}
+		for userGroup := range userGroups {
+			_, matchedTeam := teamGroups[userGroup]
+			_, unmapped := unmappedUserGroups[userGroup]
+			if matchedTeam {
+				u.GroupStats.MatchedTeam++
+			} else if unmapped {
+				u.GroupStats.Unmapped++
+			}
+		}
+	}
+
+	return nil
+}
+
+// newLDAPAttribute returns the serializer for an attribute of a user. If the attribute went through transforms,
+// both the value received from LDAP and the transformed value are shown.
+func newLDAPAttribute(cfgAttr string, value string, raw map[string]string, attribute string) *LDAPAttribute {
+	if rawValue, ok := raw[attribute]; ok {
+		return &LDAPAttribute{ConfigAttributeValue: cfgAttr, LDAPAttributeValue: rawValue, TransformedValue: value}
+	}
+	return &LDAPAttribute{ConfigAttributeValue: cfgAttr, LDAPAttributeValue: value}
 }
 
-// splitName receives the full name of a user and splits it into two parts: A name and a surname.
-func splitName(name string) (string, string) {
+// splitName receives the full name of a user and splits it into two parts: A name and a surname, following the
+// strategy of the LDAP server. Names without a comma are split like ldap.NameSplitFirstRest splits them, whatever the
+// strategy.
+func splitName(name string, strategy string) (string, string) {
+	if strategy == ldap.NameSplitLastFirstComma {
+		if i := strings.Index(name, ","); i >= 0 {
+			return strings.TrimSpace(name[i+1:]), strings.TrimSpace(name[:i])
+		}
+	}
+
 	names := util.SplitString(name)
 
 	switch len(names) {
@@ -314,6 +1564,6 @@ func splitName(name string) (string, string) {
 	case 1:
 		return names[0], ""
 	default:
-		return names[0], names[1]
+		return names[0], strings.Join(names[1:], " ")
 	}
 }
@@ -1,14 +1,22 @@
 package api
 
 import (
+	"context"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/grafana/grafana/pkg/services/accesscontrol"
+	"github.com/grafana/grafana/pkg/services/login"
 	"github.com/grafana/grafana/pkg/services/login/loginservice"
 	"github.com/grafana/grafana/pkg/services/login/logintest"
 	"github.com/grafana/grafana/pkg/services/sqlstore"
@@ -17,6 +25,7 @@ import (
 
 	"github.com/grafana/grafana/pkg/api/response"
 	"github.com/grafana/grafana/pkg/api/routing"
+	"github.com/grafana/grafana/pkg/infra/tracing"
 	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/services/auth"
 	"github.com/grafana/grafana/pkg/services/ldap"
@@ -33,6 +42,12 @@ type LDAPMock struct {
 var userSearchResult *models.ExternalUserInfo
 var userSearchConfig ldap.ServerConfig
 var userSearchError error
+var userSearchFailedServers []*multildap.ServerStatus
+var userMatchesResult []*multildap.UserMatch
+var usersInBaseDNResult []*models.ExternalUserInfo
+var usersInBaseDNError error
+var userAttributesResult map[string][]string
+var userAttributesError error
 var pingResult []*multildap.ServerStatus
 var pingError error
 
@@ -53,6 +68,22 @@ func (m *LDAPMock) User(login string) (*models.ExternalUserInfo, ldap.ServerConf
 	return userSearchResult, userSearchConfig, userSearchError
 }
 
+func (m *LDAPMock) LookupUser(login string) (*models.ExternalUserInfo, ldap.ServerConfig, []*multildap.ServerStatus, error) {
+	return userSearchResult, userSearchConfig, userSearchFailedServers, userSearchError
+}
+
+func (m *LDAPMock) LookupUserOnAllServers(login string) ([]*multildap.UserMatch, []*multildap.ServerStatus, error) {
+	return userMatchesResult, userSearchFailedServers, userSearchError
+}
+
+func (m *LDAPMock) UserAttributes(login string) (map[string][]string, ldap.ServerConfig, error) {
+	return userAttributesResult, userSearchConfig, userAttributesError
+}
+
+func (m *LDAPMock) UsersInBaseDN(baseDN string) ([]*models.ExternalUserInfo, ldap.ServerConfig, error) {
+	return usersInBaseDNResult, userSearchConfig, usersInBaseDNError
+}
+
 // ***
 // GetUserFromLDAP tests
 // ***
@@ -60,13 +91,30 @@ func (m *LDAPMock) User(login string) (*models.ExternalUserInfo, ldap.ServerConf
 func getUserFromLDAPContext(t *testing.T, requestURL string, searchOrgRst []*models.OrgDTO) *scenarioContext {
 	t.Helper()
 
+	return getUserFromLDAPContextWithGroups(t, requestURL, searchOrgRst, ldap.ProvideGroupsService())
+}
+
+func getUserFromLDAPContextWithGroups(t *testing.T, requestURL string, searchOrgRst []*models.OrgDTO, groups ldap.Groups) *scenarioContext {
+	t.Helper()
+
+	return getUserFromLDAPContextWithStore(t, requestURL, &mockstore.SQLStoreMock{ExpectedSearchOrgList: searchOrgRst}, groups)
+}
+
+func getUserFromLDAPContextWithStore(t *testing.T, requestURL string, store sqlstore.Store, groups ldap.Groups) *scenarioContext {
+	t.Helper()
+
 	sc := setupScenarioContext(t, requestURL)
 
 	origLDAP := setting.LDAPEnabled
 	setting.LDAPEnabled = true
 	t.Cleanup(func() { setting.LDAPEnabled = origLDAP })
 
-	hs := &HTTPServer{Cfg: setting.NewCfg(), ldapGroups: ldap.ProvideGroupsService(), SQLStore: &mockstore.SQLStoreMock{ExpectedSearchOrgList: searchOrgRst}}
+	hs := &HTTPServer{
+		Cfg:        setting.NewCfg(),
+		ldapGroups: groups,
+		SQLStore:   store,
+		tracer:     tracing.InitializeTracerForTest(),
+	}
 
 	sc.defaultHandler = routing.Wrap(func(c *models.ReqContext) response.Response {
 		sc.context = c
@@ -85,7 +133,7 @@ func getUserFromLDAPContext(t *testing.T, requestURL string, searchOrgRst []*mod
 
 func TestGetUserFromLDAPAPIEndpoint_UserNotFound(t *testing.T) {
 	getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
-		return &ldap.Config{}, nil
+		return &ldap.Config{Servers: []*ldap.ServerConfig{{}}}, nil
 	}
 
 	newLDAP = func(_ []*ldap.ServerConfig) multildap.IMultiLDAP {
@@ -100,6 +148,199 @@ func TestGetUserFromLDAPAPIEndpoint_UserNotFound(t *testing.T) {
 	assert.JSONEq(t, "{\"message\":\"No user was found in the LDAP server(s) with that username\"}", sc.resp.Body.String())
 }
 
+func TestGetUserFromLDAPAPIEndpoint_FailingServers(t *testing.T) {
+	getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
+		return &ldap.Config{Servers: []*ldap.ServerConfig{{}, {}, {}}}, nil
+	}
+
+	newLDAP = func(_ []*ldap.ServerConfig) multildap.IMultiLDAP {
+		return &LDAPMock{}
+	}
+
+	userSearchFailedServers = []*multildap.ServerStatus{
+		{Host: "ldap2", Port: 389, Error: errors.New("connection refused")},
+		{Host: "ldap3", Port: 636, Error: errors.New("connection timed out")},
+	}
+	t.Cleanup(func() {
+		userSearchResult = nil
+		userSearchConfig = ldap.ServerConfig{}
+		userSearchFailedServers = nil
+		userSearchError = nil
+	})
+
+	t.Run("user found despite failing servers", func(t *testing.T) {
+		userSearchResult = &models.ExternalUserInfo{Login: "johndoe"}
+		userSearchConfig = ldap.ServerConfig{Host: "ldap1", Port: 389}
+		userSearchError = nil
+
+		sc := getUserFromLDAPContext(t, "/api/admin/ldap/johndoe", []*models.OrgDTO{})
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+		var res map[string]interface{}
+		require.NoError(t, json.Unmarshal(sc.resp.Body.Bytes(), &res))
+		assert.Equal(t, "ldap1:389", res["foundOn"])
+		assert.Equal(t, []interface{}{
+			map[string]interface{}{"host": "ldap2", "port": float64(389), "available": false, "error": "connection refused", "errorCategory": "other"},
+			map[string]interface{}{"host": "ldap3", "port": float64(636), "available": false, "error": "connection timed out", "errorCategory": "other"},
+		}, res["failedServers"])
+	})
+
+	t.Run("user not found on the servers that could be searched", func(t *testing.T) {
+		userSearchResult = nil
+		userSearchError = multildap.ErrDidNotFindUser
+
+		sc := getUserFromLDAPContext(t, "/api/admin/ldap/johndoe", []*models.OrgDTO{})
+
+		require.Equal(t, http.StatusNotFound, sc.resp.Code)
+		var res map[string]interface{}
+		require.NoError(t, json.Unmarshal(sc.resp.Body.Bytes(), &res))
+		assert.Equal(t, "No user was found with that username in the LDAP server(s) that could be searched. The search failed on ldap2:389, ldap3:636", res["message"])
+	})
+
+	t.Run("no server could be searched", func(t *testing.T) {
+		userSearchResult = nil
+		userSearchFailedServers = append(userSearchFailedServers, &multildap.ServerStatus{Host: "ldap1", Port: 389, Error: errors.New("connection refused")})
+		userSearchError = errors.New("connection refused")
+
+		sc := getUserFromLDAPContext(t, "/api/admin/ldap/johndoe", []*models.OrgDTO{})
+
+		require.Equal(t, http.StatusBadRequest, sc.resp.Code)
+	})
+}
+
+func TestGetUserFromLDAPAPIEndpoint_AllServers(t *testing.T) {
+	getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
+		return &ldap.Config{Servers: []*ldap.ServerConfig{{}, {}, {}}}, nil
+	}
+
+	newLDAP = func(_ []*ldap.ServerConfig) multildap.IMultiLDAP {
+		return &LDAPMock{}
+	}
+
+	t.Cleanup(func() {
+		userMatchesResult = nil
+		userSearchFailedServers = nil
+		userSearchError = nil
+	})
+
+	t.Run("users found on several servers are flagged as a conflict", func(t *testing.T) {
+		userMatchesResult = []*multildap.UserMatch{
+			{User: &models.ExternalUserInfo{Login: "johndoe", Email: "john@one.org"}, Config: ldap.ServerConfig{Host: "ldap1", Port: 389}},
+			{User: &models.ExternalUserInfo{Login: "johndoe", Email: "john@two.org"}, Config: ldap.ServerConfig{Host: "ldap3", Port: 636}},
+		}
+		userSearchFailedServers = []*multildap.ServerStatus{
+			{Host: "ldap2", Port: 389, Error: errors.New("connection refused")},
+		}
+
+		sc := getUserFromLDAPContext(t, "/api/admin/ldap/johndoe?allServers=true", []*models.OrgDTO{})
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+		var res LDAPUserMatchesDTO
+		require.NoError(t, json.Unmarshal(sc.resp.Body.Bytes(), &res))
+		assert.True(t, res.Conflict)
+		require.Len(t, res.Matches, 2)
+		assert.Equal(t, "ldap1:389", res.Matches[0].FoundOn)
+		assert.Equal(t, "john@one.org", res.Matches[0].Email.LDAPAttributeValue)
+		assert.Equal(t, "ldap3:636", res.Matches[1].FoundOn)
+		assert.Equal(t, "john@two.org", res.Matches[1].Email.LDAPAttributeValue)
+		require.Len(t, res.FailedServers, 1)
+		assert.Equal(t, "ldap2", res.FailedServers[0].Host)
+	})
+
+	t.Run("a user found on a single server is not a conflict", func(t *testing.T) {
+		userMatchesResult = []*multildap.UserMatch{
+			{User: &models.ExternalUserInfo{Login: "johndoe"}, Config: ldap.ServerConfig{Host: "ldap1", Port: 389}},
+		}
+		userSearchFailedServers = nil
+
+		sc := getUserFromLDAPContext(t, "/api/admin/ldap/johndoe?allServers=true", []*models.OrgDTO{})
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+		var res LDAPUserMatchesDTO
+		require.NoError(t, json.Unmarshal(sc.resp.Body.Bytes(), &res))
+		assert.False(t, res.Conflict)
+		require.Len(t, res.Matches, 1)
+		assert.Empty(t, res.FailedServers)
+	})
+
+	t.Run("user not found on any server", func(t *testing.T) {
+		userMatchesResult = nil
+		userSearchFailedServers = nil
+		userSearchError = multildap.ErrDidNotFindUser
+
+		sc := getUserFromLDAPContext(t, "/api/admin/ldap/johndoe?allServers=true", []*models.OrgDTO{})
+
+		require.Equal(t, http.StatusNotFound, sc.resp.Code)
+		var res map[string]interface{}
+		require.NoError(t, json.Unmarshal(sc.resp.Body.Bytes(), &res))
+		assert.Equal(t, "No user was found in the LDAP server(s) with that username", res["message"])
+	})
+}
+
+func TestGetUserFromLDAPAPIEndpoint_NoServers(t *testing.T) {
+	getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
+		return &ldap.Config{}, nil
+	}
+
+	newLDAP = func(_ []*ldap.ServerConfig) multildap.IMultiLDAP {
+		return &LDAPMock{}
+	}
+
+	sc := getUserFromLDAPContext(t, "/api/admin/ldap/john.doe", []*models.OrgDTO{})
+
+	require.Equal(t, http.StatusBadRequest, sc.resp.Code)
+	assert.JSONEq(t, `{"message":"LDAP is enabled but no servers are configured"}`, sc.resp.Body.String())
+}
+
+func TestGetUserFromLDAPAPIEndpoint_OrgScopedServers(t *testing.T) {
+	getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
+		return &ldap.Config{Servers: []*ldap.ServerConfig{
+			{Host: "ldap-shared"},
+			{Host: "ldap-org-1", OrgIds: []int64{1}},
+			{Host: "ldap-org-2", OrgIds: []int64{2, 3}},
+		}}, nil
+	}
+
+	var queried []string
+	newLDAP = func(servers []*ldap.ServerConfig) multildap.IMultiLDAP {
+		queried = nil
+		for _, server := range servers {
+			queried = append(queried, server.Host)
+		}
+		return &LDAPMock{}
+	}
+
+	userSearchResult = &models.ExternalUserInfo{Login: "johndoe"}
+	t.Cleanup(func() { userSearchResult = nil })
+
+	t.Run("only the servers of the org are queried", func(t *testing.T) {
+		sc := getUserFromLDAPContext(t, "/api/admin/ldap/johndoe?orgId=2", []*models.OrgDTO{})
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+		assert.Equal(t, []string{"ldap-shared", "ldap-org-2"}, queried)
+	})
+
+	t.Run("every server is queried without an org", func(t *testing.T) {
+		sc := getUserFromLDAPContext(t, "/api/admin/ldap/johndoe", []*models.OrgDTO{})
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+		assert.Equal(t, []string{"ldap-shared", "ldap-org-1", "ldap-org-2"}, queried)
+	})
+
+	t.Run("an org without servers is rejected", func(t *testing.T) {
+		getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
+			return &ldap.Config{Servers: []*ldap.ServerConfig{{Host: "ldap-org-1", OrgIds: []int64{1}}}}, nil
+		}
+		queried = nil
+
+		sc := getUserFromLDAPContext(t, "/api/admin/ldap/johndoe?orgId=2", []*models.OrgDTO{})
+
+		require.Equal(t, http.StatusBadRequest, sc.resp.Code)
+		assert.JSONEq(t, `{"message":"No LDAP server is configured for organization 2"}`, sc.resp.Body.String())
+		assert.Empty(t, queried)
+	})
+}
+
 func TestGetUserFromLDAPAPIEndpoint_OrgNotfound(t *testing.T) {
 	isAdmin := true
 	userSearchResult = &models.ExternalUserInfo{
@@ -137,7 +378,7 @@ func TestGetUserFromLDAPAPIEndpoint_OrgNotfound(t *testing.T) {
 	}
 
 	getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
-		return &ldap.Config{}, nil
+		return &ldap.Config{Servers: []*ldap.ServerConfig{{}}}, nil
 	}
 
 	newLDAP = func(_ []*ldap.ServerConfig) multildap.IMultiLDAP {
@@ -192,7 +433,7 @@ func TestGetUserFromLDAPAPIEndpoint(t *testing.T) {
 	}
 
 	getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
-		return &ldap.Config{}, nil
+		return &ldap.Config{Servers: []*ldap.ServerConfig{{}}}, nil
 	}
 
 	newLDAP = func(_ []*ldap.ServerConfig) multildap.IMultiLDAP {
@@ -223,13 +464,73 @@ func TestGetUserFromLDAPAPIEndpoint(t *testing.T) {
 				{ "orgId": 1, "orgRole": "Admin", "orgName": "Main Org.", "groupDN": "cn=admins,ou=groups,dc=grafana,dc=org" },
 				{ "orgId": 0, "orgRole": "", "orgName": "", "groupDN": "another-group-not-matched" }
 			],
-			"teams": null
+			"teams": null,
+			"referralFollowed": false,
+			"groupStats": { "total": 2, "matchedOrg": 1, "matchedTeam": 0, "unmapped": 1 },
+			"grafanaAdmin": { "current": false, "effective": true, "sources": ["ldap"] },
+			"searchScope": "sub"
 		}
 	`
 
 	assert.JSONEq(t, expected, sc.resp.Body.String())
 }
 
+func TestGetUserFromLDAPAPIEndpoint_GrafanaAdmin(t *testing.T) {
+	userSearchConfig = ldap.ServerConfig{}
+	getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
+		return &ldap.Config{Servers: []*ldap.ServerConfig{{}}}, nil
+	}
+	newLDAP = func(_ []*ldap.ServerConfig) multildap.IMultiLDAP {
+		return &LDAPMock{}
+	}
+
+	grantedByLDAP, revokedByLDAP := true, false
+	testCases := []struct {
+		desc           string
+		isGrafanaAdmin *bool
+		existing       *user.User
+		expected       string
+	}{
+		{
+			desc:           "granted by LDAP",
+			isGrafanaAdmin: &grantedByLDAP,
+			expected:       `{ "current": false, "effective": true, "sources": ["ldap"] }`,
+		},
+		{
+			desc:     "granted in Grafana",
+			existing: &user.User{Login: "johndoe", IsAdmin: true},
+			expected: `{ "current": true, "effective": true, "sources": ["grafana"] }`,
+		},
+		{
+			desc:           "granted by both",
+			isGrafanaAdmin: &grantedByLDAP,
+			existing:       &user.User{Login: "johndoe", IsAdmin: true},
+			expected:       `{ "current": true, "effective": true, "sources": ["ldap", "grafana"] }`,
+		},
+		{
+			desc:           "granted in Grafana and revoked by LDAP",
+			isGrafanaAdmin: &revokedByLDAP,
+			existing:       &user.User{Login: "johndoe", IsAdmin: true},
+			expected:       `{ "current": true, "effective": false, "sources": [] }`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			userSearchResult = &models.ExternalUserInfo{Login: "johndoe", IsGrafanaAdmin: tc.isGrafanaAdmin}
+			store := &mockstore.SQLStoreMock{ExpectedUser: tc.existing}
+
+			sc := getUserFromLDAPContextWithStore(t, "/api/admin/ldap/johndoe", store, ldap.ProvideGroupsService())
+
+			require.Equal(t, http.StatusOK, sc.resp.Code)
+			var result struct {
+				GrafanaAdmin json.RawMessage `json:"grafanaAdmin"`
+			}
+			require.NoError(t, json.Unmarshal(sc.resp.Body.Bytes(), &result))
+			assert.JSONEq(t, tc.expected, string(result.GrafanaAdmin))
+		})
+	}
+}
+
 func TestGetUserFromLDAPAPIEndpoint_WithTeamHandler(t *testing.T) {
 	isAdmin := true
 	userSearchResult = &models.ExternalUserInfo{
@@ -262,7 +563,7 @@ func TestGetUserFromLDAPAPIEndpoint_WithTeamHandler(t *testing.T) {
 	}
 
 	getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
-		return &ldap.Config{}, nil
+		return &ldap.Config{Servers: []*ldap.ServerConfig{{}}}, nil
 	}
 
 	newLDAP = func(_ []*ldap.ServerConfig) multildap.IMultiLDAP {
@@ -292,129 +593,1293 @@ func TestGetUserFromLDAPAPIEndpoint_WithTeamHandler(t *testing.T) {
 			"roles": [
 				{ "orgId": 1, "orgRole": "Admin", "orgName": "Main Org.", "groupDN": "cn=admins,ou=groups,dc=grafana,dc=org" }
 			],
-			"teams": null
+			"teams": null,
+			"referralFollowed": false,
+			"groupStats": { "total": 1, "matchedOrg": 1, "matchedTeam": 0, "unmapped": 0 },
+			"grafanaAdmin": { "current": false, "effective": true, "sources": ["ldap"] },
+			"searchScope": "sub"
 		}
 	`
 
 	assert.JSONEq(t, expected, sc.resp.Body.String())
 }
 
-// ***
-// GetLDAPStatus tests
-// ***
+func TestGetUserFromLDAPAPIEndpoint_SearchScope(t *testing.T) {
+	configured := &ldap.ServerConfig{Host: "ldap1", Port: 389}
+	getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
+		return &ldap.Config{Servers: []*ldap.ServerConfig{configured}}, nil
+	}
 
-func getLDAPStatusContext(t *testing.T) *scenarioContext {
-	t.Helper()
+	var searched []*ldap.ServerConfig
+	newLDAP = func(servers []*ldap.ServerConfig) multildap.IMultiLDAP {
+		searched = servers
+		userSearchConfig = *servers[0]
+		return &LDAPMock{}
+	}
 
-	requestURL := "/api/admin/ldap/status"
-	sc := setupScenarioContext(t, requestURL)
+	userSearchResult = &models.ExternalUserInfo{Login: "johndoe"}
+	userSearchError = nil
+	t.Cleanup(func() { userSearchConfig = ldap.ServerConfig{} })
 
-	ldap := setting.LDAPEnabled
-	setting.LDAPEnabled = true
-	t.Cleanup(func() { setting.LDAPEnabled = ldap })
+	t.Run("the configured scope is used by default", func(t *testing.T) {
+		sc := getUserFromLDAPContext(t, "/api/admin/ldap/johndoe", []*models.OrgDTO{})
 
-	hs := &HTTPServer{Cfg: setting.NewCfg()}
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+		var res LDAPUserDTO
+		require.NoError(t, json.Unmarshal(sc.resp.Body.Bytes(), &res))
+		assert.Equal(t, ldap.SearchScopeSub, res.SearchScope)
+		assert.Same(t, configured, searched[0])
+	})
 
-	sc.defaultHandler = routing.Wrap(func(c *models.ReqContext) response.Response {
-		sc.context = c
-		return hs.GetLDAPStatus(c)
+	t.Run("the scope is overridden for the request only", func(t *testing.T) {
+		sc := getUserFromLDAPContext(t, "/api/admin/ldap/johndoe?scope=one", []*models.OrgDTO{})
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+		var res LDAPUserDTO
+		require.NoError(t, json.Unmarshal(sc.resp.Body.Bytes(), &res))
+		assert.Equal(t, ldap.SearchScopeOne, res.SearchScope)
+		require.Len(t, searched, 1)
+		assert.Equal(t, ldap.SearchScopeOne, searched[0].SearchScope)
+		assert.Equal(t, "ldap1", searched[0].Host)
+		assert.Empty(t, configured.SearchScope)
 	})
 
-	sc.m.Get("/api/admin/ldap/status", sc.defaultHandler)
+	t.Run("an unknown scope is rejected", func(t *testing.T) {
+		sc := getUserFromLDAPContext(t, "/api/admin/ldap/johndoe?scope=tree", []*models.OrgDTO{})
 
-	sc.resp = httptest.NewRecorder()
-	req, _ := http.NewRequest(http.MethodGet, requestURL, nil)
-	sc.req = req
-	sc.exec()
+		require.Equal(t, http.StatusBadRequest, sc.resp.Code)
+		assert.JSONEq(t, `{"message":"Validation error. Unknown search scope \"tree\", must be base, one or sub"}`, sc.resp.Body.String())
+	})
+}
 
-	return sc
+func TestGetUserFromLDAPAPIEndpoint_SearchFilter(t *testing.T) {
+	configured := &ldap.ServerConfig{Host: "ldap1", Port: 389, SearchFilter: "(&(uid=%s)(accountStatus=active))"}
+	getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
+		return &ldap.Config{Servers: []*ldap.ServerConfig{configured}}, nil
+	}
+
+	var searched []*ldap.ServerConfig
+	newLDAP = func(servers []*ldap.ServerConfig) multildap.IMultiLDAP {
+		searched = servers
+		userSearchConfig = *servers[0]
+		return &LDAPMock{}
+	}
+
+	userSearchResult = &models.ExternalUserInfo{Login: "johndoe"}
+	userSearchError = nil
+	t.Cleanup(func() { userSearchConfig = ldap.ServerConfig{} })
+
+	t.Run("the configured filter is reported by default", func(t *testing.T) {
+		sc := getUserFromLDAPContext(t, "/api/admin/ldap/johndoe", []*models.OrgDTO{})
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+		var res LDAPUserDTO
+		require.NoError(t, json.Unmarshal(sc.resp.Body.Bytes(), &res))
+		assert.Equal(t, "(&(uid=%s)(accountStatus=active))", res.SearchFilter)
+		assert.Same(t, configured, searched[0])
+	})
+
+	t.Run("a valid filter is used for the request only", func(t *testing.T) {
+		filter := url.QueryEscape("(&(uid=%s)(|(accountStatus=active)(accountStatus=inactive)))")
+		sc := getUserFromLDAPContext(t, "/api/admin/ldap/johndoe?filter="+filter, []*models.OrgDTO{})
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+		var res LDAPUserDTO
+		require.NoError(t, json.Unmarshal(sc.resp.Body.Bytes(), &res))
+		assert.Equal(t, "(&(uid=%s)(|(accountStatus=active)(accountStatus=inactive)))", res.SearchFilter)
+		require.Len(t, searched, 1)
+		assert.Equal(t, "(&(uid=%s)(|(accountStatus=active)(accountStatus=inactive)))", searched[0].SearchFilter)
+		assert.Equal(t, "ldap1", searched[0].Host)
+		assert.Equal(t, "(&(uid=%s)(accountStatus=active))", configured.SearchFilter)
+	})
+
+	t.Run("an invalid filter is rejected", func(t *testing.T) {
+		searched = nil
+		sc := getUserFromLDAPContext(t, "/api/admin/ldap/johndoe?filter="+url.QueryEscape("(&(uid=%s)"), []*models.OrgDTO{})
+
+		require.Equal(t, http.StatusBadRequest, sc.resp.Code)
+		var res map[string]interface{}
+		require.NoError(t, json.Unmarshal(sc.resp.Body.Bytes(), &res))
+		assert.Contains(t, res["message"], "Validation error. invalid search filter")
+		assert.Nil(t, searched)
+	})
 }
 
-func TestGetLDAPStatusAPIEndpoint(t *testing.T) {
-	pingResult = []*multildap.ServerStatus{
-		{Host: "10.0.0.3", Port: 361, Available: true, Error: nil},
-		{Host: "10.0.0.3", Port: 362, Available: true, Error: nil},
-		{Host: "10.0.0.5", Port: 361, Available: false, Error: errors.New("something is awfully wrong")},
+type fakeLDAPGroups struct {
+	teams []models.TeamOrgGroupDTO
+	calls int
+}
+
+func (f *fakeLDAPGroups) GetTeams(_ []string) ([]models.TeamOrgGroupDTO, error) {
+	f.calls++
+	return f.teams, nil
+}
+
+func TestGetUserFromLDAPAPIEndpoint_GroupStats(t *testing.T) {
+	userSearchResult = &models.ExternalUserInfo{
+		Name:  "John Doe",
+		Email: "john.doe@example.com",
+		Login: "johndoe",
+		Groups: []string{
+			"cn=admins,ou=groups,dc=grafana,dc=org",
+			"cn=editors,ou=groups,dc=grafana,dc=org",
+			"cn=devs,ou=groups,dc=grafana,dc=org",
+			"cn=unknown,ou=groups,dc=grafana,dc=org",
+		},
+	}
+
+	userSearchConfig = ldap.ServerConfig{
+		Groups: []*ldap.GroupToOrgRole{
+			{GroupDN: "cn=admins,ou=groups,dc=grafana,dc=org", OrgId: 1, OrgRole: models.ROLE_ADMIN},
+			{GroupDN: "cn=editors,ou=groups,dc=grafana,dc=org", OrgId: 2, OrgRole: models.ROLE_EDITOR},
+			{GroupDN: "cn=viewers,ou=groups,dc=grafana,dc=org", OrgId: 2, OrgRole: models.ROLE_VIEWER},
+		},
+	}
+
+	mockOrgSearchResult := []*models.OrgDTO{
+		{Id: 1, Name: "Main Org."},
+		{Id: 2, Name: "Second Org."},
 	}
 
 	getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
-		return &ldap.Config{}, nil
+		return &ldap.Config{Servers: []*ldap.ServerConfig{{}}}, nil
 	}
 
 	newLDAP = func(_ []*ldap.ServerConfig) multildap.IMultiLDAP {
 		return &LDAPMock{}
 	}
 
-	sc := getLDAPStatusContext(t)
+	groups := &fakeLDAPGroups{teams: []models.TeamOrgGroupDTO{
+		{TeamName: "Editors", OrgName: "Second Org.", GroupDN: "cn=editors,ou=groups,dc=grafana,dc=org"},
+		{TeamName: "Devs", OrgName: "Main Org.", GroupDN: "cn=devs,ou=groups,dc=grafana,dc=org"},
+	}}
+
+	sc := getUserFromLDAPContextWithGroups(t, "/api/admin/ldap/johndoe", mockOrgSearchResult, groups)
 
 	require.Equal(t, http.StatusOK, sc.resp.Code)
 
-	expected := `
-	[
-		{ "host": "10.0.0.3", "port": 361, "available": true, "error": "" },
-		{ "host": "10.0.0.3", "port": 362, "available": true, "error": "" },
-		{ "host": "10.0.0.5", "port": 361, "available": false, "error": "something is awfully wrong" }
-	]
-	`
-	assert.JSONEq(t, expected, sc.resp.Body.String())
+	var res LDAPUserDTO
+	require.NoError(t, json.Unmarshal(sc.resp.Body.Bytes(), &res))
+	assert.Equal(t, LDAPGroupStatsDTO{Total: 4, MatchedOrg: 2, MatchedTeam: 2, Unmapped: 1}, res.GroupStats)
+}
+
+func TestGetUserFromLDAPAPIEndpoint_TransformedAttributes(t *testing.T) {
+	userSearchResult = &models.ExternalUserInfo{
+		Name:  "John Doe",
+		Email: "john.doe@example.com",
+		Login: "johndoe",
+		RawAttributes: map[string]string{
+			ldap.AttributeName:     "John",
+			ldap.AttributeSurname:  "Doe",
+			ldap.AttributeEmail:    "John.Doe@EXAMPLE.COM",
+			ldap.AttributeUsername: "johndoe@EXAMPLE.COM",
+		},
+	}
+
+	userSearchConfig = ldap.ServerConfig{
+		Attr: ldap.AttributeMap{
+			Name:     "givenName",
+			Surname:  "sn",
+			Email:    "mail",
+			Username: "userPrincipalName",
+		},
+	}
+
+	getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
+		return &ldap.Config{Servers: []*ldap.ServerConfig{{}}}, nil
+	}
+
+	newLDAP = func(_ []*ldap.ServerConfig) multildap.IMultiLDAP {
+		return &LDAPMock{}
+	}
+
+	sc := getUserFromLDAPContext(t, "/api/admin/ldap/johndoe", []*models.OrgDTO{})
+
+	require.Equal(t, http.StatusOK, sc.resp.Code)
+
+	var res LDAPUserDTO
+	require.NoError(t, json.Unmarshal(sc.resp.Body.Bytes(), &res))
+	assert.Equal(t, &LDAPAttribute{ConfigAttributeValue: "mail", LDAPAttributeValue: "John.Doe@EXAMPLE.COM", TransformedValue: "john.doe@example.com"}, res.Email)
+	assert.Equal(t, &LDAPAttribute{ConfigAttributeValue: "userPrincipalName", LDAPAttributeValue: "johndoe@EXAMPLE.COM", TransformedValue: "johndoe"}, res.Username)
+	assert.Equal(t, &LDAPAttribute{ConfigAttributeValue: "givenName", LDAPAttributeValue: "John", TransformedValue: "John"}, res.Name)
 }
 
 // ***
-// PostSyncUserWithLDAP tests
+// GetLDAPUsersPreview tests
 // ***
 
-func postSyncUserWithLDAPContext(t *testing.T, requestURL string, preHook func(*testing.T, *scenarioContext), sqlstoremock sqlstore.Store) *scenarioContext {
+func getLDAPUsersPreviewContext(t *testing.T, requestURL string, store sqlstore.Store, groups ldap.Groups) *scenarioContext {
 	t.Helper()
 
 	sc := setupScenarioContext(t, requestURL)
-	sc.authInfoService = &logintest.AuthInfoServiceFake{}
 
-	ldap := setting.LDAPEnabled
-	t.Cleanup(func() {
-		setting.LDAPEnabled = ldap
-	})
+	origLDAP := setting.LDAPEnabled
 	setting.LDAPEnabled = true
+	t.Cleanup(func() { setting.LDAPEnabled = origLDAP })
 
-	hs := &HTTPServer{
-		Cfg:              sc.cfg,
-		AuthTokenService: auth.NewFakeUserAuthTokenService(),
-		SQLStore:         sqlstoremock,
-		Login:            loginservice.LoginServiceMock{},
-		authInfoService:  sc.authInfoService,
+	getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
+		return &ldap.Config{Servers: []*ldap.ServerConfig{{}}}, nil
+	}
+
+	newLDAP = func(_ []*ldap.ServerConfig) multildap.IMultiLDAP {
+		return &LDAPMock{}
 	}
 
+	hs := &HTTPServer{Cfg: setting.NewCfg(), ldapGroups: groups, SQLStore: store}
+
 	sc.defaultHandler = routing.Wrap(func(c *models.ReqContext) response.Response {
 		sc.context = c
-		return hs.PostSyncUserWithLDAP(c)
+		return hs.GetLDAPUsersPreview(c)
 	})
 
-	sc.m.Post("/api/admin/ldap/sync/:id", sc.defaultHandler)
+	sc.m.Get("/api/admin/ldap/users/preview", sc.defaultHandler)
 
 	sc.resp = httptest.NewRecorder()
-	req, err := http.NewRequest(http.MethodPost, requestURL, nil)
-	require.NoError(t, err)
-
-	preHook(t, sc)
-
+	req, _ := http.NewRequest(http.MethodGet, requestURL, nil)
 	sc.req = req
 	sc.exec()
 
 	return sc
 }
 
-func TestPostSyncUserWithLDAPAPIEndpoint_Success(t *testing.T) {
-	sqlstoremock := mockstore.SQLStoreMock{}
-	sqlstoremock.ExpectedUser = &user.User{Login: "ldap-daniel", ID: 34}
-	sc := postSyncUserWithLDAPContext(t, "/api/admin/ldap/sync/34", func(t *testing.T, sc *scenarioContext) {
-		getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
-			return &ldap.Config{}, nil
-		}
-
-		newLDAP = func(_ []*ldap.ServerConfig) multildap.IMultiLDAP {
-			return &LDAPMock{}
-		}
+func TestGetLDAPUsersPreviewAPIEndpoint(t *testing.T) {
+	t.Cleanup(func() {
+		usersInBaseDNResult = nil
+		usersInBaseDNError = nil
+		userSearchConfig = ldap.ServerConfig{}
+	})
 
-		userSearchResult = &models.ExternalUserInfo{
+	usersInBaseDNResult = []*models.ExternalUserInfo{
+		{Login: "carol", Groups: []string{"cn=editors,ou=groups,dc=grafana,dc=org"}},
+		{Login: "alice", Groups: []string{"cn=admins,ou=groups,dc=grafana,dc=org"}},
+		{Login: "bob", Groups: []string{"cn=editors,ou=groups,dc=grafana,dc=org", "cn=devs,ou=groups,dc=grafana,dc=org"}},
+	}
+	userSearchConfig = ldap.ServerConfig{
+		Groups: []*ldap.GroupToOrgRole{
+			{GroupDN: "cn=admins,ou=groups,dc=grafana,dc=org", OrgId: 1, OrgRole: models.ROLE_ADMIN},
+			{GroupDN: "cn=editors,ou=groups,dc=grafana,dc=org", OrgId: 2, OrgRole: models.ROLE_EDITOR},
+		},
+	}
+	orgs := []*models.OrgDTO{{Id: 1, Name: "Main Org."}, {Id: 2, Name: "Second Org."}}
+
+	t.Run("returns a page of previews sorted by login, resolving orgs and teams once", func(t *testing.T) {
+		store := &mockstore.SyncStoreMock{SQLStoreMock: &mockstore.SQLStoreMock{ExpectedSearchOrgList: orgs}}
+		groups := &fakeLDAPGroups{teams: []models.TeamOrgGroupDTO{
+			{TeamName: "Devs", OrgName: "Main Org.", GroupDN: "cn=devs,ou=groups,dc=grafana,dc=org"},
+		}}
+
+		sc := getLDAPUsersPreviewContext(t, "/api/admin/ldap/users/preview?baseDN=ou=people,dc=grafana,dc=org&perpage=2", store, groups)
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+
+		var res LDAPUserPreviewPageDTO
+		require.NoError(t, json.Unmarshal(sc.resp.Body.Bytes(), &res))
+		assert.Equal(t, 3, res.TotalCount)
+		assert.Equal(t, 1, res.Page)
+		assert.Equal(t, 2, res.PerPage)
+		require.Len(t, res.Users, 2)
+		assert.Equal(t, "alice", res.Users[0].Username.LDAPAttributeValue)
+		assert.Equal(t, "Main Org.", res.Users[0].OrgRoles[0].OrgName)
+		assert.Empty(t, res.Users[0].Teams)
+		assert.Equal(t, "bob", res.Users[1].Username.LDAPAttributeValue)
+		assert.Equal(t, "Second Org.", res.Users[1].OrgRoles[0].OrgName)
+		require.Len(t, res.Users[1].Teams, 1)
+		assert.Equal(t, "Devs", res.Users[1].Teams[0].TeamName)
+		assert.Equal(t, 1, store.OrgSearches)
+		assert.Equal(t, 1, groups.calls)
+	})
+
+	t.Run("returns byte-identical previews in the stable format", func(t *testing.T) {
+		previous := usersInBaseDNResult
+		t.Cleanup(func() { usersInBaseDNResult = previous })
+		usersInBaseDNResult = []*models.ExternalUserInfo{{Login: "dave", Groups: []string{
+			"cn=ops,ou=groups,dc=grafana,dc=org",
+			"cn=editors,ou=groups,dc=grafana,dc=org",
+			"cn=devs,ou=groups,dc=grafana,dc=org",
+			"cn=admins,ou=groups,dc=grafana,dc=org",
+			"cn=sre,ou=groups,dc=grafana,dc=org",
+		}}}
+		groups := &fakeLDAPGroups{teams: []models.TeamOrgGroupDTO{
+			{TeamName: "Ops", OrgName: "Second Org.", GroupDN: "cn=ops,ou=groups,dc=grafana,dc=org"},
+			{TeamName: "Devs", OrgName: "Main Org.", GroupDN: "cn=devs,ou=groups,dc=grafana,dc=org"},
+		}}
+		preview := func() string {
+			store := &mockstore.SQLStoreMock{ExpectedSearchOrgList: orgs}
+			sc := getLDAPUsersPreviewContext(t, "/api/admin/ldap/users/preview?baseDN=ou=people,dc=grafana,dc=org&format=stable", store, groups)
+			require.Equal(t, http.StatusOK, sc.resp.Code)
+			return sc.resp.Body.String()
+		}
+
+		first := preview()
+		for i := 0; i < 5; i++ {
+			require.Equal(t, first, preview())
+		}
+
+		var res LDAPUserPreviewPageDTO
+		require.NoError(t, json.Unmarshal([]byte(first), &res))
+		require.Len(t, res.Users, 1)
+		dns := make([]string, 0, len(res.Users[0].OrgRoles))
+		for _, role := range res.Users[0].OrgRoles {
+			dns = append(dns, role.GroupDN)
+		}
+		assert.Equal(t, []string{
+			"cn=admins,ou=groups,dc=grafana,dc=org",
+			"cn=editors,ou=groups,dc=grafana,dc=org",
+			"cn=devs,ou=groups,dc=grafana,dc=org",
+			"cn=ops,ou=groups,dc=grafana,dc=org",
+			"cn=sre,ou=groups,dc=grafana,dc=org",
+		}, dns)
+		require.Len(t, res.Users[0].Teams, 2)
+		assert.Equal(t, "Devs", res.Users[0].Teams[0].TeamName)
+		assert.Equal(t, "Ops", res.Users[0].Teams[1].TeamName)
+	})
+
+	t.Run("streams the preview as CSV, quoting the fields containing commas", func(t *testing.T) {
+		previous := usersInBaseDNResult
+		t.Cleanup(func() { usersInBaseDNResult = previous })
+		usersInBaseDNResult = []*models.ExternalUserInfo{
+			{Login: "erin", Email: "erin@grafana.org", Name: "Erin Smith", Groups: []string{
+				"cn=editors,ou=groups,dc=grafana,dc=org",
+				"cn=admins,ou=groups,dc=grafana,dc=org",
+				"cn=devs,ou=groups,dc=grafana,dc=org",
+			}},
+			{Login: "frank", Groups: []string{"cn=unmapped,ou=groups,dc=grafana,dc=org"}},
+		}
+		store := &mockstore.SQLStoreMock{ExpectedSearchOrgList: []*models.OrgDTO{
+			{Id: 1, Name: "Main Org."},
+			{Id: 2, Name: "Acme, Inc."},
+		}}
+		groups := &fakeLDAPGroups{teams: []models.TeamOrgGroupDTO{
+			{TeamName: "Devs, Backend", OrgName: "Main Org.", GroupDN: "cn=devs,ou=groups,dc=grafana,dc=org"},
+		}}
+
+		sc := getLDAPUsersPreviewContext(t, "/api/admin/ldap/users/preview?baseDN=ou=people,dc=grafana,dc=org&format=csv", store, groups)
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+		assert.Equal(t, "text/csv; charset=utf-8", sc.resp.Header().Get("Content-Type"))
+		assert.Contains(t, sc.resp.Body.String(), `"Main Org.:Admin; Acme, Inc.:Editor"`)
+
+		records, err := csv.NewReader(strings.NewReader(sc.resp.Body.String())).ReadAll()
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{
+			{"login", "email", "name", "roles", "teams"},
+			{"erin", "erin@grafana.org", "Erin Smith", "Main Org.:Admin; Acme, Inc.:Editor", "Main Org.:Devs, Backend"},
+			{"frank", "", "", "", ""},
+		}, records)
+	})
+
+	t.Run("streams only the header as CSV past the last page", func(t *testing.T) {
+		sc := getLDAPUsersPreviewContext(t, "/api/admin/ldap/users/preview?baseDN=ou=people,dc=grafana,dc=org&format=csv&page=5", &mockstore.SQLStoreMock{}, &fakeLDAPGroups{})
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+		assert.Equal(t, "login,email,name,roles,teams\n", sc.resp.Body.String())
+	})
+
+	t.Run("rejects an unknown format", func(t *testing.T) {
+		sc := getLDAPUsersPreviewContext(t, "/api/admin/ldap/users/preview?baseDN=ou=people,dc=grafana,dc=org&format=yaml", &mockstore.SQLStoreMock{}, &fakeLDAPGroups{})
+
+		require.Equal(t, http.StatusBadRequest, sc.resp.Code)
+		assert.JSONEq(t, `{"message": "Validation error. Unknown format \"yaml\""}`, sc.resp.Body.String())
+	})
+
+	t.Run("returns the requested page", func(t *testing.T) {
+		store := &mockstore.SQLStoreMock{ExpectedSearchOrgList: orgs}
+
+		sc := getLDAPUsersPreviewContext(t, "/api/admin/ldap/users/preview?baseDN=ou=people,dc=grafana,dc=org&perpage=2&page=2", store, &fakeLDAPGroups{})
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+
+		var res LDAPUserPreviewPageDTO
+		require.NoError(t, json.Unmarshal(sc.resp.Body.Bytes(), &res))
+		assert.Equal(t, 3, res.TotalCount)
+		require.Len(t, res.Users, 1)
+		assert.Equal(t, "carol", res.Users[0].Username.LDAPAttributeValue)
+	})
+
+	t.Run("returns an empty page past the last user", func(t *testing.T) {
+		store := &mockstore.SQLStoreMock{ExpectedSearchOrgList: orgs}
+
+		sc := getLDAPUsersPreviewContext(t, "/api/admin/ldap/users/preview?baseDN=ou=people,dc=grafana,dc=org&page=5", store, &fakeLDAPGroups{})
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+		assert.JSONEq(t, `{"totalCount": 3, "page": 5, "perPage": 50, "users": []}`, sc.resp.Body.String())
+	})
+
+	t.Run("rejects pages larger than the maximum", func(t *testing.T) {
+		sc := getLDAPUsersPreviewContext(t, "/api/admin/ldap/users/preview?baseDN=ou=people,dc=grafana,dc=org&perpage=101", &mockstore.SQLStoreMock{}, &fakeLDAPGroups{})
+
+		require.Equal(t, http.StatusBadRequest, sc.resp.Code)
+		assert.JSONEq(t, `{"message": "Validation error. At most 100 users can be previewed per page"}`, sc.resp.Body.String())
+	})
+
+	t.Run("requires a base DN", func(t *testing.T) {
+		sc := getLDAPUsersPreviewContext(t, "/api/admin/ldap/users/preview", &mockstore.SQLStoreMock{}, &fakeLDAPGroups{})
+
+		require.Equal(t, http.StatusBadRequest, sc.resp.Code)
+	})
+
+	t.Run("rejects a base DN no server searches", func(t *testing.T) {
+		usersInBaseDNError = multildap.ErrUnknownBaseDN
+		t.Cleanup(func() { usersInBaseDNError = nil })
+
+		sc := getLDAPUsersPreviewContext(t, "/api/admin/ldap/users/preview?baseDN=ou=people,dc=example,dc=org", &mockstore.SQLStoreMock{}, &fakeLDAPGroups{})
+
+		require.Equal(t, http.StatusBadRequest, sc.resp.Code)
+		assert.JSONEq(t, `{"message": "The base DN is not searched by any of the LDAP servers"}`, sc.resp.Body.String())
+	})
+
+	t.Run("rejects a base DN with more users than the size limit", func(t *testing.T) {
+		usersInBaseDNError = ldap.ErrSizeLimitExceeded
+		t.Cleanup(func() { usersInBaseDNError = nil })
+
+		sc := getLDAPUsersPreviewContext(t, "/api/admin/ldap/users/preview?baseDN=dc=grafana,dc=org", &mockstore.SQLStoreMock{}, &fakeLDAPGroups{})
+
+		require.Equal(t, http.StatusBadRequest, sc.resp.Code)
+		assert.Contains(t, sc.resp.Body.String(), "more users than the LDAP server's users preview size limit")
+	})
+}
+
+// ***
+// GetLDAPAttributeSuggestions tests
+// ***
+
+func getLDAPAttributeSuggestionsContext(t *testing.T, requestURL string) *scenarioContext {
+	t.Helper()
+
+	sc := setupScenarioContext(t, requestURL)
+
+	origLDAP := setting.LDAPEnabled
+	setting.LDAPEnabled = true
+	t.Cleanup(func() { setting.LDAPEnabled = origLDAP })
+
+	getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
+		return &ldap.Config{Servers: []*ldap.ServerConfig{{}}}, nil
+	}
+
+	newLDAP = func(_ []*ldap.ServerConfig) multildap.IMultiLDAP {
+		return &LDAPMock{}
+	}
+
+	hs := &HTTPServer{Cfg: setting.NewCfg()}
+
+	sc.defaultHandler = routing.Wrap(func(c *models.ReqContext) response.Response {
+		sc.context = c
+		return hs.GetLDAPAttributeSuggestions(c)
+	})
+
+	sc.m.Get("/api/admin/ldap/users/preview/attributes/:username", sc.defaultHandler)
+
+	sc.resp = httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, requestURL, nil)
+	sc.req = req
+	sc.exec()
+
+	return sc
+}
+
+func TestGetLDAPAttributeSuggestionsAPIEndpoint(t *testing.T) {
+	t.Cleanup(func() {
+		userAttributesResult = nil
+		userAttributesError = nil
+		userSearchConfig = ldap.ServerConfig{}
+	})
+
+	t.Run("returns the attributes of the user with suggestions", func(t *testing.T) {
+		userAttributesResult = map[string][]string{
+			"sAMAccountName": {"alice"},
+			"mail":           {"alice@grafana.org"},
+			"givenName":      {"Alice"},
+			"sn":             {"Smith"},
+		}
+		userAttributesError = nil
+		userSearchConfig = ldap.ServerConfig{Host: "ad.grafana.org", Port: 389}
+
+		sc := getLDAPAttributeSuggestionsContext(t, "/api/admin/ldap/users/preview/attributes/alice")
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+
+		var res LDAPAttributeSuggestionsDTO
+		require.NoError(t, json.Unmarshal(sc.resp.Body.Bytes(), &res))
+		assert.Equal(t, userAttributesResult, res.Attributes)
+		assert.Equal(t, map[string]ldap.AttributeSuggestion{
+			ldap.AttributeUsername: {Attribute: "sAMAccountName", Value: "alice"},
+			ldap.AttributeEmail:    {Attribute: "mail", Value: "alice@grafana.org"},
+			ldap.AttributeName:     {Attribute: "givenName", Value: "Alice"},
+			ldap.AttributeSurname:  {Attribute: "sn", Value: "Smith"},
+		}, res.Suggestions)
+		assert.Equal(t, "ad.grafana.org:389", res.FoundOn)
+	})
+
+	t.Run("returns 404 when the user is not found", func(t *testing.T) {
+		userAttributesResult = nil
+		userAttributesError = multildap.ErrDidNotFindUser
+
+		sc := getLDAPAttributeSuggestionsContext(t, "/api/admin/ldap/users/preview/attributes/alice")
+
+		assert.Equal(t, http.StatusNotFound, sc.resp.Code)
+	})
+}
+
+// ***
+// GetLDAPConfigFile tests
+// ***
+
+func getLDAPConfigFileContext(t *testing.T, configFile string) *scenarioContext {
+	t.Helper()
+
+	requestURL := "/api/admin/ldap/config-file"
+	sc := setupScenarioContext(t, requestURL)
+
+	ldap := setting.LDAPEnabled
+	file := setting.LDAPConfigFile
+	setting.LDAPEnabled = true
+	setting.LDAPConfigFile = configFile
+	t.Cleanup(func() {
+		setting.LDAPEnabled = ldap
+		setting.LDAPConfigFile = file
+	})
+
+	hs := &HTTPServer{Cfg: setting.NewCfg()}
+
+	sc.defaultHandler = routing.Wrap(func(c *models.ReqContext) response.Response {
+		sc.context = c
+		return hs.GetLDAPConfigFile(c)
+	})
+
+	sc.m.Get(requestURL, sc.defaultHandler)
+
+	sc.resp = httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, requestURL, nil)
+	sc.req = req
+	sc.exec()
+
+	return sc
+}
+
+func TestGetLDAPConfigFileAPIEndpoint(t *testing.T) {
+	t.Run("returns the path and the last modification time", func(t *testing.T) {
+		configFile := filepath.Join(t.TempDir(), "ldap.toml")
+		require.NoError(t, os.WriteFile(configFile, []byte("[[servers]]\n"), 0600))
+		modified := time.Date(2022, time.March, 14, 9, 30, 0, 0, time.UTC)
+		require.NoError(t, os.Chtimes(configFile, modified, modified))
+
+		sc := getLDAPConfigFileContext(t, configFile)
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+
+		var result LDAPConfigFileDTO
+		require.NoError(t, json.Unmarshal(sc.resp.Body.Bytes(), &result))
+		assert.Equal(t, configFile, result.Path)
+		assert.True(t, modified.Equal(result.LastModified))
+	})
+
+	t.Run("fails when the file does not exist", func(t *testing.T) {
+		configFile := filepath.Join(t.TempDir(), "missing.toml")
+
+		sc := getLDAPConfigFileContext(t, configFile)
+
+		require.Equal(t, http.StatusInternalServerError, sc.resp.Code)
+	})
+}
+
+// ***
+// GetLDAPStatus tests
+// ***
+
+func getLDAPStatusContext(t *testing.T) *scenarioContext {
+	t.Helper()
+
+	return getLDAPStatusContextWithCache(t, "/api/admin/ldap/status", nil)
+}
+
+func getLDAPStatusContextWithCache(t *testing.T, requestURL string, cache ldapStatusCache) *scenarioContext {
+	t.Helper()
+
+	sc := setupScenarioContext(t, requestURL)
+
+	ldap := setting.LDAPEnabled
+	setting.LDAPEnabled = true
+	t.Cleanup(func() { setting.LDAPEnabled = ldap })
+
+	hs := &HTTPServer{Cfg: setting.NewCfg(), tracer: tracing.InitializeTracerForTest(), ldapStatusCache: cache}
+
+	sc.defaultHandler = routing.Wrap(func(c *models.ReqContext) response.Response {
+		sc.context = c
+		return hs.GetLDAPStatus(c)
+	})
+
+	sc.m.Get("/api/admin/ldap/status", sc.defaultHandler)
+
+	sc.resp = httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, requestURL, nil)
+	sc.req = req
+	sc.exec()
+
+	return sc
+}
+
+func TestGetLDAPStatusAPIEndpoint(t *testing.T) {
+	pingResult = []*multildap.ServerStatus{
+		{Host: "10.0.0.3", Port: 361, Available: true, SecurityMode: "starttls", Error: nil},
+		{Host: "10.0.0.3", Port: 362, Available: true, SecurityMode: "none", Error: nil},
+		{Host: "10.0.0.5", Port: 361, Available: false, Error: errors.New("something is awfully wrong")},
+		{Host: "10.0.0.6", Port: 636, Available: false, Error: &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}},
+	}
+
+	getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
+		return &ldap.Config{Servers: []*ldap.ServerConfig{{}}}, nil
+	}
+
+	newLDAP = func(_ []*ldap.ServerConfig) multildap.IMultiLDAP {
+		return &LDAPMock{}
+	}
+
+	sc := getLDAPStatusContext(t)
+
+	require.Equal(t, http.StatusOK, sc.resp.Code)
+
+	expected := `
+	[
+		{ "host": "10.0.0.3", "port": 361, "available": true, "securityMode": "starttls", "error": "" },
+		{ "host": "10.0.0.3", "port": 362, "available": true, "securityMode": "none", "error": "" },
+		{ "host": "10.0.0.5", "port": 361, "available": false, "error": "something is awfully wrong", "errorCategory": "other" },
+		{ "host": "10.0.0.6", "port": 636, "available": false, "error": "dial tcp: i/o timeout", "errorCategory": "timeout" }
+	]
+	`
+	assert.JSONEq(t, expected, sc.resp.Body.String())
+}
+
+type fakeLDAPStatusCache struct {
+	statuses []*multildap.ServerStatus
+	asOf     time.Time
+}
+
+func (f *fakeLDAPStatusCache) Latest() ([]*multildap.ServerStatus, time.Time) {
+	return f.statuses, f.asOf
+}
+
+func (f *fakeLDAPStatusCache) Invalidate() {
+	f.statuses = nil
+	f.asOf = time.Time{}
+}
+
+func TestGetLDAPStatusAPIEndpoint_PolledStatuses(t *testing.T) {
+	pingResult = []*multildap.ServerStatus{{Host: "10.0.0.3", Port: 361, Available: true}}
+	t.Cleanup(func() { pingResult = nil })
+
+	getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
+		return &ldap.Config{Servers: []*ldap.ServerConfig{{}}}, nil
+	}
+
+	pinged := false
+	newLDAP = func(_ []*ldap.ServerConfig) multildap.IMultiLDAP {
+		pinged = true
+		return &LDAPMock{}
+	}
+
+	cache := &fakeLDAPStatusCache{
+		statuses: []*multildap.ServerStatus{{Host: "10.0.0.3", Port: 361, Available: false, Error: errors.New("connection refused")}},
+		asOf:     time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	t.Run("the polled statuses are served", func(t *testing.T) {
+		pinged = false
+
+		sc := getLDAPStatusContextWithCache(t, "/api/admin/ldap/status", cache)
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+		assert.False(t, pinged)
+		assert.JSONEq(t, `[{ "host": "10.0.0.3", "port": 361, "available": false, "error": "connection refused", "errorCategory": "other", "asOf": "2022-08-01T12:00:00Z" }]`,
+			sc.resp.Body.String())
+	})
+
+	t.Run("the servers are pinged when asked for fresh statuses", func(t *testing.T) {
+		pinged = false
+
+		sc := getLDAPStatusContextWithCache(t, "/api/admin/ldap/status?fresh=true", cache)
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+		assert.True(t, pinged)
+		assert.JSONEq(t, `[{ "host": "10.0.0.3", "port": 361, "available": true, "error": "" }]`, sc.resp.Body.String())
+	})
+
+	t.Run("the servers are pinged when they have not been polled yet", func(t *testing.T) {
+		pinged = false
+
+		sc := getLDAPStatusContextWithCache(t, "/api/admin/ldap/status", &fakeLDAPStatusCache{})
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+		assert.True(t, pinged)
+	})
+}
+
+func TestGetLDAPStatusAPIEndpoint_NoServers(t *testing.T) {
+	getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
+		return &ldap.Config{}, nil
+	}
+
+	newLDAP = func(_ []*ldap.ServerConfig) multildap.IMultiLDAP {
+		return &LDAPMock{}
+	}
+
+	sc := getLDAPStatusContext(t)
+
+	require.Equal(t, http.StatusBadRequest, sc.resp.Code)
+	assert.JSONEq(t, `{"message":"LDAP is enabled but no servers are configured"}`, sc.resp.Body.String())
+}
+
+// ***
+// PostTestLDAPServer tests
+// ***
+
+func postTestLDAPServerContext(t *testing.T, body string) *scenarioContext {
+	t.Helper()
+
+	requestURL := "/api/admin/ldap/servers/test"
+	sc := setupScenarioContext(t, requestURL)
+
+	ldap := setting.LDAPEnabled
+	setting.LDAPEnabled = true
+	t.Cleanup(func() { setting.LDAPEnabled = ldap })
+
+	hs := &HTTPServer{Cfg: setting.NewCfg()}
+
+	sc.defaultHandler = routing.Wrap(func(c *models.ReqContext) response.Response {
+		sc.context = c
+		return hs.PostTestLDAPServer(c)
+	})
+
+	sc.m.Post(requestURL, sc.defaultHandler)
+
+	sc.resp = httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, requestURL, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	sc.req = req
+	sc.exec()
+
+	return sc
+}
+
+func TestPostTestLDAPServerAPIEndpoint(t *testing.T) {
+	getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
+		return &ldap.Config{Servers: []*ldap.ServerConfig{
+			{Host: "10.0.0.3", Port: 389, BindDN: "cn=grafana,dc=grafana,dc=org", BindPassword: "current"},
+			{Host: "10.0.0.3", Port: 636, BindDN: "cn=grafana,dc=grafana,dc=org", BindPassword: "current"},
+		}}, nil
+	}
+
+	var tested *ldap.ServerConfig
+	var bindErr error
+	origTestLDAPBind := testLDAPBind
+	testLDAPBind = func(config *ldap.ServerConfig) (*multildap.ServerStatus, error) {
+		tested = config
+		return &multildap.ServerStatus{Host: config.Host, Port: config.Port, Available: true, SecurityMode: "ldaps"}, bindErr
+	}
+	t.Cleanup(func() { testLDAPBind = origTestLDAPBind })
+
+	t.Run("binds with the configured credentials", func(t *testing.T) {
+		sc := postTestLDAPServerContext(t, `{"host": "10.0.0.3", "port": 636}`)
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+		assert.JSONEq(t, `{
+			"host": "10.0.0.3", "port": 636, "available": true, "securityMode": "ldaps", "error": "",
+			"bindDn": "cn=grafana,dc=grafana,dc=org", "overrideCredentials": false, "bound": true
+		}`, sc.resp.Body.String())
+		assert.Equal(t, "current", tested.BindPassword)
+	})
+
+	t.Run("binds with the given credentials without keeping them", func(t *testing.T) {
+		sc := postTestLDAPServerContext(t, `{"host": "10.0.0.3", "bindDn": "cn=grafana-new,dc=grafana,dc=org", "bindPassword": "rotated"}`)
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+		assert.JSONEq(t, `{
+			"host": "10.0.0.3", "port": 389, "available": true, "securityMode": "ldaps", "error": "",
+			"bindDn": "cn=grafana-new,dc=grafana,dc=org", "overrideCredentials": true, "bound": true
+		}`, sc.resp.Body.String())
+		assert.Equal(t, "rotated", tested.BindPassword)
+		assert.NotContains(t, sc.resp.Body.String(), "rotated")
+
+		config, err := getLDAPConfig(nil)
+		require.NoError(t, err)
+		assert.Equal(t, "current", config.Servers[0].BindPassword)
+	})
+
+	t.Run("reports a failed bind", func(t *testing.T) {
+		bindErr = ldap.ErrInvalidCredentials
+		t.Cleanup(func() { bindErr = nil })
+
+		sc := postTestLDAPServerContext(t, `{"host": "10.0.0.3", "bindDn": "cn=grafana,dc=grafana,dc=org", "bindPassword": "wrong"}`)
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+		result := LDAPBindTestDTO{}
+		require.NoError(t, json.Unmarshal(sc.resp.Body.Bytes(), &result))
+		assert.False(t, result.Bound)
+		assert.Equal(t, ldap.ErrInvalidCredentials.Error(), result.BindError)
+	})
+
+	t.Run("requires the bind DN and password together", func(t *testing.T) {
+		tested = nil
+		for _, body := range []string{
+			`{"host": "10.0.0.3", "bindDn": "cn=someone-else,dc=grafana,dc=org"}`,
+			`{"host": "10.0.0.3", "bindPassword": "rotated"}`,
+		} {
+			sc := postTestLDAPServerContext(t, body)
+
+			require.Equal(t, http.StatusBadRequest, sc.resp.Code)
+		}
+		assert.Nil(t, tested)
+	})
+
+	t.Run("unknown server", func(t *testing.T) {
+		sc := postTestLDAPServerContext(t, `{"host": "10.0.0.4"}`)
+
+		require.Equal(t, http.StatusNotFound, sc.resp.Code)
+	})
+
+	t.Run("missing host", func(t *testing.T) {
+		sc := postTestLDAPServerContext(t, `{"bindPassword": "rotated"}`)
+
+		require.Equal(t, http.StatusBadRequest, sc.resp.Code)
+	})
+}
+
+// ***
+// PostDisableLDAPServer tests
+// ***
+
+func postLDAPServerContext(t *testing.T, requestURL string, body string, handler func(hs *HTTPServer, c *models.ReqContext) response.Response) *scenarioContext {
+	t.Helper()
+
+	sc := setupScenarioContext(t, requestURL)
+
+	ldap := setting.LDAPEnabled
+	setting.LDAPEnabled = true
+	t.Cleanup(func() { setting.LDAPEnabled = ldap })
+
+	hs := &HTTPServer{Cfg: setting.NewCfg()}
+
+	sc.defaultHandler = routing.Wrap(func(c *models.ReqContext) response.Response {
+		sc.context = c
+		return handler(hs, c)
+	})
+
+	sc.m.Post(requestURL, sc.defaultHandler)
+
+	sc.resp = httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, requestURL, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	sc.req = req
+	sc.exec()
+
+	return sc
+}
+
+func TestPostDisableLDAPServerAPIEndpoint(t *testing.T) {
+	getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
+		return &ldap.Config{Servers: []*ldap.ServerConfig{
+			{Host: "10.0.0.3", Port: 389},
+			{Host: "10.0.0.3", Port: 636},
+		}}, nil
+	}
+	t.Cleanup(multildap.EnableAllServers)
+
+	disable := func(t *testing.T, body string) *scenarioContext {
+		return postLDAPServerContext(t, "/api/admin/ldap/servers/disable", body, (*HTTPServer).PostDisableLDAPServer)
+	}
+
+	t.Run("disables the server until the configuration is reloaded", func(t *testing.T) {
+		t.Cleanup(multildap.EnableAllServers)
+
+		sc := disable(t, `{"host": "10.0.0.3", "port": 636}`)
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+		assert.True(t, multildap.IsServerDisabled(&ldap.ServerConfig{Host: "10.0.0.3", Port: 636}))
+		assert.False(t, multildap.IsServerDisabled(&ldap.ServerConfig{Host: "10.0.0.3", Port: 389}))
+
+		configFile := setting.LDAPConfigFile
+		t.Cleanup(func() { setting.LDAPConfigFile = configFile })
+		path, err := filepath.Abs("../../conf/ldap.toml")
+		require.NoError(t, err)
+		setting.LDAPConfigFile = path
+
+		sc = postLDAPServerContext(t, "/api/admin/ldap/reload", "", (*HTTPServer).ReloadLDAPCfg)
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+		assert.False(t, multildap.IsServerDisabled(&ldap.ServerConfig{Host: "10.0.0.3", Port: 636}))
+	})
+
+	t.Run("unknown server", func(t *testing.T) {
+		sc := disable(t, `{"host": "10.0.0.3", "port": 3269}`)
+
+		require.Equal(t, http.StatusNotFound, sc.resp.Code)
+		assert.False(t, multildap.IsServerDisabled(&ldap.ServerConfig{Host: "10.0.0.3", Port: 3269}))
+	})
+
+	t.Run("missing port", func(t *testing.T) {
+		sc := disable(t, `{"host": "10.0.0.3"}`)
+
+		require.Equal(t, http.StatusBadRequest, sc.resp.Code)
+	})
+}
+
+// ***
+// PostReloadLDAPServer tests
+// ***
+
+func TestPostReloadLDAPServerAPIEndpoint(t *testing.T) {
+	reloaded := &ldap.ServerConfig{Host: "10.0.0.3", Port: 636, BindDN: "cn=grafana-new,dc=grafana,dc=org"}
+	var applied *ldap.ServerConfig
+	origReload := reloadLDAPServerConfig
+	reloadLDAPServerConfig = func(host string, port int, test func(*ldap.ServerConfig) error) (*ldap.ServerConfig, error) {
+		if host != reloaded.Host || port != reloaded.Port {
+			return nil, ldap.ErrServerNotConfigured
+		}
+		if err := test(reloaded); err != nil {
+			return nil, err
+		}
+		applied = reloaded
+		return reloaded, nil
+	}
+	t.Cleanup(func() { reloadLDAPServerConfig = origReload })
+
+	var tested *ldap.ServerConfig
+	var bindErr error
+	origTestLDAPBind := testLDAPBind
+	testLDAPBind = func(config *ldap.ServerConfig) (*multildap.ServerStatus, error) {
+		tested = config
+		return &multildap.ServerStatus{Host: config.Host, Port: config.Port, Available: true}, bindErr
+	}
+	t.Cleanup(func() { testLDAPBind = origTestLDAPBind })
+
+	reload := func(t *testing.T, body string) *scenarioContext {
+		return postLDAPServerContext(t, "/api/admin/ldap/servers/reload", body, (*HTTPServer).PostReloadLDAPServer)
+	}
+
+	t.Run("keeps the current settings when the bind with the new ones fails", func(t *testing.T) {
+		bindErr = ldap.ErrInvalidCredentials
+		t.Cleanup(func() { bindErr = nil })
+
+		sc := reload(t, `{"host": "10.0.0.3", "port": 636}`)
+
+		require.Equal(t, http.StatusBadRequest, sc.resp.Code)
+		assert.Same(t, reloaded, tested)
+		assert.Nil(t, applied)
+	})
+
+	t.Run("applies the new settings once the bind with them succeeds", func(t *testing.T) {
+		sc := reload(t, `{"host": "10.0.0.3", "port": 636}`)
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+		assert.Same(t, reloaded, applied)
+	})
+
+	t.Run("drops the cached server statuses", func(t *testing.T) {
+		cache := &fakeLDAPStatusCache{
+			statuses: []*multildap.ServerStatus{{Host: "10.0.0.3", Port: 636, Available: true}},
+			asOf:     time.Now(),
+		}
+
+		sc := postLDAPServerContext(t, "/api/admin/ldap/servers/reload", `{"host": "10.0.0.3", "port": 636}`, func(hs *HTTPServer, c *models.ReqContext) response.Response {
+			hs.ldapStatusCache = cache
+			return hs.PostReloadLDAPServer(c)
+		})
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+		statuses, asOf := cache.Latest()
+		assert.Empty(t, statuses)
+		assert.True(t, asOf.IsZero())
+	})
+
+	t.Run("unknown server", func(t *testing.T) {
+		sc := reload(t, `{"host": "10.0.0.3", "port": 389}`)
+
+		require.Equal(t, http.StatusNotFound, sc.resp.Code)
+	})
+
+	t.Run("missing port", func(t *testing.T) {
+		sc := reload(t, `{"host": "10.0.0.3"}`)
+
+		require.Equal(t, http.StatusBadRequest, sc.resp.Code)
+	})
+}
+
+// ***
+// CompareLDAPGroupMappings tests
+// ***
+
+func compareLDAPGroupMappingsContext(t *testing.T, requestURL string) *scenarioContext {
+	t.Helper()
+
+	sc := setupScenarioContext(t, requestURL)
+
+	ldap := setting.LDAPEnabled
+	setting.LDAPEnabled = true
+	t.Cleanup(func() { setting.LDAPEnabled = ldap })
+
+	hs := &HTTPServer{Cfg: setting.NewCfg()}
+
+	sc.defaultHandler = routing.Wrap(func(c *models.ReqContext) response.Response {
+		sc.context = c
+		return hs.CompareLDAPGroupMappings(c)
+	})
+
+	sc.m.Get("/api/admin/ldap/mappings/compare", sc.defaultHandler)
+
+	sc.resp = httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, requestURL, nil)
+	sc.req = req
+	sc.exec()
+
+	return sc
+}
+
+func TestCompareLDAPGroupMappingsAPIEndpoint(t *testing.T) {
+	getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
+		return &ldap.Config{Servers: []*ldap.ServerConfig{
+			{
+				Groups: []*ldap.GroupToOrgRole{
+					{GroupDN: "cn=admins,ou=groups,dc=grafana,dc=org", OrgId: 1, OrgRole: models.ROLE_ADMIN},
+					{GroupDN: "cn=admins,ou=groups,dc=grafana,dc=org", OrgId: 2, OrgRole: models.ROLE_ADMIN},
+					{GroupDN: "cn=contractors,ou=groups,dc=grafana,dc=org", OrgId: 1, OrgRole: models.ROLE_VIEWER},
+					{GroupDN: "cn=ops,ou=groups,dc=grafana,dc=org", OrgId: 3, OrgRole: models.ROLE_EDITOR},
+				},
+			},
+			{
+				Groups: []*ldap.GroupToOrgRole{
+					{GroupDN: "CN=Editors,OU=Groups,DC=grafana,DC=org", OrgId: 1, OrgRole: models.ROLE_EDITOR},
+					{GroupDN: "cn=editors,ou=groups,dc=grafana,dc=org", OrgId: 2, OrgRole: models.ROLE_VIEWER},
+					{GroupDN: "cn=auditors,ou=groups,dc=grafana,dc=org", OrgId: 2, OrgRole: models.ROLE_VIEWER},
+				},
+			},
+		}}, nil
+	}
+
+	t.Run("lists the groups mapped into only one of the orgs", func(t *testing.T) {
+		sc := compareLDAPGroupMappingsContext(t, "/api/admin/ldap/mappings/compare?orgA=1&orgB=2")
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+
+		expected := `
+		{
+			"orgA": {
+				"orgId": 1,
+				"groups": [
+					{ "groupDN": "cn=admins,ou=groups,dc=grafana,dc=org", "orgRole": "Admin" },
+					{ "groupDN": "cn=contractors,ou=groups,dc=grafana,dc=org", "orgRole": "Viewer" },
+					{ "groupDN": "CN=Editors,OU=Groups,DC=grafana,DC=org", "orgRole": "Editor" }
+				],
+				"onlyHere": ["cn=contractors,ou=groups,dc=grafana,dc=org"]
+			},
+			"orgB": {
+				"orgId": 2,
+				"groups": [
+					{ "groupDN": "cn=admins,ou=groups,dc=grafana,dc=org", "orgRole": "Admin" },
+					{ "groupDN": "cn=auditors,ou=groups,dc=grafana,dc=org", "orgRole": "Viewer" },
+					{ "groupDN": "cn=editors,ou=groups,dc=grafana,dc=org", "orgRole": "Viewer" }
+				],
+				"onlyHere": ["cn=auditors,ou=groups,dc=grafana,dc=org"]
+			}
+		}
+		`
+		assert.JSONEq(t, expected, sc.resp.Body.String())
+	})
+
+	t.Run("an org without mapped groups has every group of the other org missing", func(t *testing.T) {
+		sc := compareLDAPGroupMappingsContext(t, "/api/admin/ldap/mappings/compare?orgA=3&orgB=4")
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+
+		expected := `
+		{
+			"orgA": {
+				"orgId": 3,
+				"groups": [{ "groupDN": "cn=ops,ou=groups,dc=grafana,dc=org", "orgRole": "Editor" }],
+				"onlyHere": ["cn=ops,ou=groups,dc=grafana,dc=org"]
+			},
+			"orgB": { "orgId": 4, "groups": [], "onlyHere": [] }
+		}
+		`
+		assert.JSONEq(t, expected, sc.resp.Body.String())
+	})
+
+	t.Run("both orgs are required", func(t *testing.T) {
+		sc := compareLDAPGroupMappingsContext(t, "/api/admin/ldap/mappings/compare?orgA=1")
+
+		require.Equal(t, http.StatusBadRequest, sc.resp.Code)
+		assert.JSONEq(t, `{"message":"Validation error. You must specify the orgs to compare"}`, sc.resp.Body.String())
+	})
+
+	t.Run("the orgs must differ", func(t *testing.T) {
+		sc := compareLDAPGroupMappingsContext(t, "/api/admin/ldap/mappings/compare?orgA=1&orgB=1")
+
+		require.Equal(t, http.StatusBadRequest, sc.resp.Code)
+		assert.JSONEq(t, `{"message":"Validation error. You must specify two different orgs"}`, sc.resp.Body.String())
+	})
+}
+
+// ***
+// ValidateLDAPGroupMappings tests
+// ***
+
+func validateLDAPGroupMappingsContext(t *testing.T, store sqlstore.Store) *scenarioContext {
+	t.Helper()
+
+	requestURL := "/api/admin/ldap/mappings/validate"
+	sc := setupScenarioContext(t, requestURL)
+
+	ldap := setting.LDAPEnabled
+	setting.LDAPEnabled = true
+	t.Cleanup(func() { setting.LDAPEnabled = ldap })
+
+	hs := &HTTPServer{Cfg: setting.NewCfg(), SQLStore: store}
+
+	sc.defaultHandler = routing.Wrap(func(c *models.ReqContext) response.Response {
+		sc.context = c
+		return hs.ValidateLDAPGroupMappings(c)
+	})
+
+	sc.m.Get(requestURL, sc.defaultHandler)
+
+	sc.resp = httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, requestURL, nil)
+	sc.req = req
+	sc.exec()
+
+	return sc
+}
+
+func TestValidateLDAPGroupMappingsAPIEndpoint(t *testing.T) {
+	isAdmin := true
+	getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
+		return &ldap.Config{Servers: []*ldap.ServerConfig{
+			{
+				Groups: []*ldap.GroupToOrgRole{
+					{GroupDN: "cn=admins,ou=groups,dc=grafana,dc=org", OrgId: 1, OrgRole: models.ROLE_ADMIN},
+					{GroupDN: "cn=ops,ou=groups,dc=grafana,dc=org", OrgId: 3, OrgRole: models.ROLE_EDITOR},
+					{GroupDN: "cn=superadmins,ou=groups,dc=grafana,dc=org", OrgId: 1, IsGrafanaAdmin: &isAdmin},
+				},
+			},
+			{
+				Groups: []*ldap.GroupToOrgRole{
+					{GroupDN: "cn=auditors,ou=groups,dc=grafana,dc=org", OrgId: 2, OrgRole: "Auditor"},
+					{GroupDN: "cn=oncall,ou=groups,dc=grafana,dc=org", OrgId: 3, OrgRole: models.ROLE_VIEWER},
+				},
+			},
+		}}, nil
+	}
+
+	t.Run("reports the missing orgs and the invalid roles", func(t *testing.T) {
+		store := &mockstore.SQLStoreMock{ExpectedSearchOrgList: []*models.OrgDTO{
+			{Id: 1, Name: "Main Org."},
+			{Id: 2, Name: "Auditing"},
+		}}
+		sc := validateLDAPGroupMappingsContext(t, store)
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+
+		expected := `
+		{
+			"valid": false,
+			"missingOrgs": [
+				{
+					"orgId": 3,
+					"groupDNs": ["cn=ops,ou=groups,dc=grafana,dc=org", "cn=oncall,ou=groups,dc=grafana,dc=org"]
+				}
+			],
+			"invalidRoles": [
+				{ "groupDN": "cn=auditors,ou=groups,dc=grafana,dc=org", "orgId": 2, "orgRole": "Auditor" }
+			]
+		}
+		`
+		assert.JSONEq(t, expected, sc.resp.Body.String())
+	})
+
+	t.Run("is valid when every target exists", func(t *testing.T) {
+		getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
+			return &ldap.Config{Servers: []*ldap.ServerConfig{{
+				Groups: []*ldap.GroupToOrgRole{
+					{GroupDN: "cn=admins,ou=groups,dc=grafana,dc=org", OrgId: 1, OrgRole: models.ROLE_ADMIN},
+				},
+			}}}, nil
+		}
+		store := &mockstore.SQLStoreMock{ExpectedSearchOrgList: []*models.OrgDTO{{Id: 1, Name: "Main Org."}}}
+		sc := validateLDAPGroupMappingsContext(t, store)
+
+		require.Equal(t, http.StatusOK, sc.resp.Code)
+		assert.JSONEq(t, `{"valid": true, "missingOrgs": [], "invalidRoles": []}`, sc.resp.Body.String())
+	})
+
+	t.Run("fails when the orgs cannot be looked up", func(t *testing.T) {
+		store := &mockstore.SQLStoreMock{ExpectedError: errors.New("database is locked")}
+		sc := validateLDAPGroupMappingsContext(t, store)
+
+		require.Equal(t, http.StatusInternalServerError, sc.resp.Code)
+	})
+}
+
+// ***
+// PostSyncUserWithLDAP tests
+// ***
+
+func postSyncUserWithLDAPContext(t *testing.T, requestURL string, preHook func(*testing.T, *scenarioContext), sqlstoremock sqlstore.Store) *scenarioContext {
+	t.Helper()
+	return postSyncUserWithLDAPContextWithLogin(t, requestURL, preHook, sqlstoremock, loginservice.LoginServiceMock{})
+}
+
+func postSyncUserWithLDAPContextWithLogin(t *testing.T, requestURL string, preHook func(*testing.T, *scenarioContext), sqlstoremock sqlstore.Store, loginService login.Service) *scenarioContext {
+	t.Helper()
+
+	sc := setupScenarioContext(t, requestURL)
+	sc.authInfoService = &logintest.AuthInfoServiceFake{}
+	sc.userAuthTokenService = auth.NewFakeUserAuthTokenService()
+
+	ldap := setting.LDAPEnabled
+	t.Cleanup(func() {
+		setting.LDAPEnabled = ldap
+	})
+	setting.LDAPEnabled = true
+
+	hs := &HTTPServer{
+		Cfg:              sc.cfg,
+		AuthTokenService: sc.userAuthTokenService,
+		SQLStore:         sqlstoremock,
+		Login:            loginService,
+		authInfoService:  sc.authInfoService,
+		tracer:           tracing.InitializeTracerForTest(),
+	}
+
+	sc.defaultHandler = routing.Wrap(func(c *models.ReqContext) response.Response {
+		sc.context = c
+		return hs.PostSyncUserWithLDAP(c)
+	})
+
+	sc.m.Post("/api/admin/ldap/sync/:id", sc.defaultHandler)
+
+	sc.resp = httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodPost, requestURL, nil)
+	require.NoError(t, err)
+
+	preHook(t, sc)
+
+	sc.req = req
+	sc.exec()
+
+	return sc
+}
+
+func TestPostSyncUserWithLDAPAPIEndpoint_Success(t *testing.T) {
+	sqlstoremock := mockstore.SQLStoreMock{}
+	sqlstoremock.ExpectedUser = &user.User{Login: "ldap-daniel", ID: 34}
+	sc := postSyncUserWithLDAPContext(t, "/api/admin/ldap/sync/34", func(t *testing.T, sc *scenarioContext) {
+		getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
+			return &ldap.Config{Servers: []*ldap.ServerConfig{{}}}, nil
+		}
+
+		newLDAP = func(_ []*ldap.ServerConfig) multildap.IMultiLDAP {
+			return &LDAPMock{}
+		}
+
+		userSearchResult = &models.ExternalUserInfo{
 			Login: "ldap-daniel",
 		}
 	}, &sqlstoremock)
@@ -430,11 +1895,39 @@ func TestPostSyncUserWithLDAPAPIEndpoint_Success(t *testing.T) {
 	assert.JSONEq(t, expected, sc.resp.Body.String())
 }
 
+func TestPostSyncUserWithLDAPAPIEndpoint_ActsAsSignedInUser(t *testing.T) {
+	sqlstoremock := mockstore.SQLStoreMock{}
+	sqlstoremock.ExpectedUser = &user.User{Login: "ldap-daniel", ID: 34}
+	var upsertCmd *models.UpsertUserCommand
+	loginService := loginservice.LoginServiceMock{ExpectedUserFunc: func(cmd *models.UpsertUserCommand) *user.User {
+		upsertCmd = cmd
+		return &user.User{Login: "ldap-daniel", ID: 34}
+	}}
+	sc := postSyncUserWithLDAPContextWithLogin(t, "/api/admin/ldap/sync/34", func(t *testing.T, sc *scenarioContext) {
+		getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
+			return &ldap.Config{Servers: []*ldap.ServerConfig{{}}}, nil
+		}
+
+		newLDAP = func(_ []*ldap.ServerConfig) multildap.IMultiLDAP {
+			return &LDAPMock{}
+		}
+
+		userSearchResult = &models.ExternalUserInfo{
+			Login: "ldap-daniel",
+		}
+	}, &sqlstoremock, loginService)
+
+	assert.Equal(t, http.StatusOK, sc.resp.Code)
+	require.NotNil(t, upsertCmd)
+	require.NotNil(t, upsertCmd.ActingUser)
+	assert.Same(t, sc.context.SignedInUser, upsertCmd.ActingUser)
+}
+
 func TestPostSyncUserWithLDAPAPIEndpoint_WhenUserNotFound(t *testing.T) {
 	sqlstoremock := mockstore.SQLStoreMock{ExpectedError: models.ErrUserNotFound}
 	sc := postSyncUserWithLDAPContext(t, "/api/admin/ldap/sync/34", func(t *testing.T, sc *scenarioContext) {
 		getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
-			return &ldap.Config{}, nil
+			return &ldap.Config{Servers: []*ldap.ServerConfig{{}}}, nil
 		}
 
 		newLDAP = func(_ []*ldap.ServerConfig) multildap.IMultiLDAP {
@@ -457,7 +1950,7 @@ func TestPostSyncUserWithLDAPAPIEndpoint_WhenGrafanaAdmin(t *testing.T) {
 	sqlstoremock := mockstore.SQLStoreMock{ExpectedUser: &user.User{Login: "ldap-daniel", ID: 34}}
 	sc := postSyncUserWithLDAPContext(t, "/api/admin/ldap/sync/34", func(t *testing.T, sc *scenarioContext) {
 		getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
-			return &ldap.Config{}, nil
+			return &ldap.Config{Servers: []*ldap.ServerConfig{{}}}, nil
 		}
 
 		newLDAP = func(_ []*ldap.ServerConfig) multildap.IMultiLDAP {
@@ -477,12 +1970,89 @@ func TestPostSyncUserWithLDAPAPIEndpoint_WhenGrafanaAdmin(t *testing.T) {
 	assert.Equal(t, "Refusing to sync grafana super admin \"ldap-daniel\" - it would be disabled", res["message"])
 }
 
+// externalUserDisableRecorder records the external users disabled by login
+type externalUserDisableRecorder struct {
+	logintest.LoginServiceFake
+	disabled []string
+	failOn   string
+}
+
+func (r *externalUserDisableRecorder) DisableExternalUser(ctx context.Context, username string) error {
+	if username == r.failOn {
+		return errors.New("failed to disable user")
+	}
+	r.disabled = append(r.disabled, username)
+	return nil
+}
+
+func TestPostDisableLDAPUsersAPIEndpoint(t *testing.T) {
+	store := &mockstore.SyncStoreMock{SQLStoreMock: &mockstore.SQLStoreMock{}, Users: []*user.User{
+		{ID: 1, Login: "admin"},
+		{ID: 34, Login: "ldap-daniel"},
+		{ID: 35, Login: "ldap-leonard"},
+	}}
+	loginService := &externalUserDisableRecorder{}
+	tokenService := auth.NewFakeUserAuthTokenService()
+	var revoked []int64
+	tokenService.RevokeAllUserTokensProvider = func(ctx context.Context, userId int64) error {
+		revoked = append(revoked, userId)
+		return nil
+	}
+
+	sc := postLDAPServerContext(t, "/api/admin/ldap/users/disable", `{"userIds": [34, 1, 35, 34, 99]}`, func(hs *HTTPServer, c *models.ReqContext) response.Response {
+		hs.Cfg.AdminUser = "admin"
+		hs.SQLStore = store
+		hs.Login = loginService
+		hs.AuthTokenService = tokenService
+		hs.authInfoService = &logintest.AuthInfoServiceFake{}
+		return hs.PostDisableLDAPUsers(c)
+	})
+
+	require.Equal(t, http.StatusOK, sc.resp.Code)
+
+	var res LDAPUsersDisabledDTO
+	require.NoError(t, json.Unmarshal(sc.resp.Body.Bytes(), &res))
+	assert.Equal(t, []int64{34, 35}, res.Disabled)
+	assert.Equal(t, []LDAPUserSkippedDTO{
+		{UserId: 1, Login: "admin", Reason: "the Grafana admin cannot be disabled"},
+		{UserId: 99, Reason: "user not found"},
+	}, res.Skipped)
+	assert.Equal(t, []string{"ldap-daniel", "ldap-leonard"}, loginService.disabled)
+	assert.Equal(t, []int64{34, 35}, revoked)
+}
+
+func TestPostDisableLDAPUsersAPIEndpoint_RevokesTokensOfUsersDisabledBeforeAFailure(t *testing.T) {
+	store := &mockstore.SyncStoreMock{SQLStoreMock: &mockstore.SQLStoreMock{}, Users: []*user.User{
+		{ID: 34, Login: "ldap-daniel"},
+		{ID: 35, Login: "ldap-leonard"},
+	}}
+	loginService := &externalUserDisableRecorder{failOn: "ldap-leonard"}
+	tokenService := auth.NewFakeUserAuthTokenService()
+	var revoked []int64
+	tokenService.RevokeAllUserTokensProvider = func(ctx context.Context, userId int64) error {
+		revoked = append(revoked, userId)
+		return nil
+	}
+
+	sc := postLDAPServerContext(t, "/api/admin/ldap/users/disable", `{"userIds": [34, 35]}`, func(hs *HTTPServer, c *models.ReqContext) response.Response {
+		hs.SQLStore = store
+		hs.Login = loginService
+		hs.AuthTokenService = tokenService
+		hs.authInfoService = &logintest.AuthInfoServiceFake{}
+		return hs.PostDisableLDAPUsers(c)
+	})
+
+	require.Equal(t, http.StatusInternalServerError, sc.resp.Code)
+	assert.Equal(t, []string{"ldap-daniel"}, loginService.disabled)
+	assert.Equal(t, []int64{34}, revoked)
+}
+
 func TestPostSyncUserWithLDAPAPIEndpoint_WhenUserNotInLDAP(t *testing.T) {
 	sqlstoremock := mockstore.SQLStoreMock{ExpectedUser: &user.User{Login: "ldap-daniel", ID: 34}}
 	sc := postSyncUserWithLDAPContext(t, "/api/admin/ldap/sync/34", func(t *testing.T, sc *scenarioContext) {
 		sc.authInfoService.ExpectedExternalUser = &models.ExternalUserInfo{IsDisabled: true, UserId: 34}
 		getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
-			return &ldap.Config{}, nil
+			return &ldap.Config{Servers: []*ldap.ServerConfig{{}}}, nil
 		}
 
 		newLDAP = func(_ []*ldap.ServerConfig) multildap.IMultiLDAP {
@@ -504,10 +2074,232 @@ func TestPostSyncUserWithLDAPAPIEndpoint_WhenUserNotInLDAP(t *testing.T) {
 	assert.JSONEq(t, expected, sc.resp.Body.String())
 }
 
+func TestPostSyncUserWithLDAPAPIEndpoint_WhenUserNotInLDAP_RevokeTokens(t *testing.T) {
+	tests := []struct {
+		name          string
+		url           string
+		expectRevoked bool
+	}{
+		{name: "revokes tokens by default", url: "/api/admin/ldap/sync/34", expectRevoked: true},
+		{name: "revokes tokens when asked to", url: "/api/admin/ldap/sync/34?revokeTokens=true", expectRevoked: true},
+		{name: "keeps tokens when asked to", url: "/api/admin/ldap/sync/34?revokeTokens=false", expectRevoked: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			revoked := false
+			sqlstoremock := mockstore.SQLStoreMock{ExpectedUser: &user.User{Login: "ldap-daniel", ID: 34}}
+			sc := postSyncUserWithLDAPContext(t, tt.url, func(t *testing.T, sc *scenarioContext) {
+				sc.userAuthTokenService.RevokeAllUserTokensProvider = func(ctx context.Context, userId int64) error {
+					revoked = true
+					return nil
+				}
+				getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
+					return &ldap.Config{Servers: []*ldap.ServerConfig{{}}}, nil
+				}
+
+				newLDAP = func(_ []*ldap.ServerConfig) multildap.IMultiLDAP {
+					return &LDAPMock{}
+				}
+
+				userSearchResult = nil
+				userSearchError = multildap.ErrDidNotFindUser
+			}, &sqlstoremock)
+
+			assert.Equal(t, http.StatusBadRequest, sc.resp.Code)
+			assert.JSONEq(t, `{"message": "User not found in LDAP. Disabled the user without updating information"}`, sc.resp.Body.String())
+			assert.Equal(t, tt.expectRevoked, revoked)
+		})
+	}
+}
+
+func TestPostSyncUserWithLDAPAPIEndpoint_InvalidRevokeTokens(t *testing.T) {
+	sqlstoremock := mockstore.SQLStoreMock{ExpectedUser: &user.User{Login: "ldap-daniel", ID: 34}}
+	sc := postSyncUserWithLDAPContext(t, "/api/admin/ldap/sync/34?revokeTokens=maybe", func(t *testing.T, sc *scenarioContext) {
+		getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
+			return &ldap.Config{Servers: []*ldap.ServerConfig{{}}}, nil
+		}
+	}, &sqlstoremock)
+
+	assert.Equal(t, http.StatusBadRequest, sc.resp.Code)
+}
+
 // ***
 // Access control tests for ldap endpoints
 // ***
 
+// flakyLDAPMock fails the first user lookups with the given error before finding the user.
+type flakyLDAPMock struct {
+	LDAPMock
+	failures int
+	err      error
+	calls    int
+}
+
+func (m *flakyLDAPMock) User(login string) (*models.ExternalUserInfo, ldap.ServerConfig, error) {
+	m.calls++
+	if m.calls <= m.failures {
+		return nil, ldap.ServerConfig{}, m.err
+	}
+	return &models.ExternalUserInfo{Login: login}, ldap.ServerConfig{}, nil
+}
+
+func TestPostSyncUserWithLDAPAPIEndpoint_RetriesNetworkErrors(t *testing.T) {
+	origWait := ldapRetryWait
+	t.Cleanup(func() { ldapRetryWait = origWait })
+	var backoffs []time.Duration
+	ldapRetryWait = func(_ context.Context, d time.Duration) error {
+		backoffs = append(backoffs, d)
+		return nil
+	}
+
+	flaky := &flakyLDAPMock{failures: 1, err: &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}}
+
+	sqlstoremock := mockstore.SQLStoreMock{ExpectedUser: &user.User{Login: "ldap-daniel", ID: 34}}
+	sc := postSyncUserWithLDAPContext(t, "/api/admin/ldap/sync/34", func(t *testing.T, sc *scenarioContext) {
+		sc.cfg.LDAPSyncRetryAttempts = 3
+		sc.cfg.LDAPSyncRetryBackoff = time.Second
+
+		getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
+			return &ldap.Config{Servers: []*ldap.ServerConfig{{}}}, nil
+		}
+
+		newLDAP = func(_ []*ldap.ServerConfig) multildap.IMultiLDAP {
+			return flaky
+		}
+	}, &sqlstoremock)
+
+	assert.Equal(t, http.StatusOK, sc.resp.Code)
+	assert.JSONEq(t, `{"message": "User synced successfully"}`, sc.resp.Body.String())
+	assert.Equal(t, 2, flaky.calls)
+	assert.Equal(t, []time.Duration{time.Second}, backoffs)
+}
+
+func TestPostSyncUserWithLDAPAPIEndpoint_DoesNotRetryUserNotFound(t *testing.T) {
+	origWait := ldapRetryWait
+	t.Cleanup(func() { ldapRetryWait = origWait })
+	ldapRetryWait = func(context.Context, time.Duration) error { return nil }
+
+	flaky := &flakyLDAPMock{failures: 3, err: multildap.ErrDidNotFindUser}
+
+	sqlstoremock := mockstore.SQLStoreMock{ExpectedUser: &user.User{Login: "ldap-daniel", ID: 34}}
+	sc := postSyncUserWithLDAPContext(t, "/api/admin/ldap/sync/34", func(t *testing.T, sc *scenarioContext) {
+		sc.cfg.LDAPSyncRetryAttempts = 3
+		sc.authInfoService.ExpectedExternalUser = &models.ExternalUserInfo{IsDisabled: true, UserId: 34}
+
+		getLDAPConfig = func(*setting.Cfg) (*ldap.Config, error) {
+			return &ldap.Config{Servers: []*ldap.ServerConfig{{}}}, nil
+		}
+
+		newLDAP = func(_ []*ldap.ServerConfig) multildap.IMultiLDAP {
+			return flaky
+		}
+	}, &sqlstoremock)
+
+	assert.Equal(t, http.StatusBadRequest, sc.resp.Code)
+	assert.Equal(t, 1, flaky.calls)
+}
+
+func TestLookupLDAPUser_Backoff(t *testing.T) {
+	networkErr := &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}
+
+	t.Run("the backoff is capped", func(t *testing.T) {
+		origWait := ldapRetryWait
+		t.Cleanup(func() { ldapRetryWait = origWait })
+		var backoffs []time.Duration
+		ldapRetryWait = func(_ context.Context, d time.Duration) error {
+			backoffs = append(backoffs, d)
+			return nil
+		}
+		cfg := setting.NewCfg()
+		cfg.LDAPSyncRetryAttempts = 4
+		cfg.LDAPSyncRetryBackoff = 10 * time.Second
+		hs := &HTTPServer{Cfg: cfg}
+		flaky := &flakyLDAPMock{failures: 3, err: networkErr}
+
+		_, err := hs.lookupLDAPUser(context.Background(), flaky, "johndoe")
+
+		require.NoError(t, err)
+		assert.Equal(t, []time.Duration{10 * time.Second, 20 * time.Second, maxLDAPRetryBackoff}, backoffs)
+	})
+
+	t.Run("retries stop when the context is done", func(t *testing.T) {
+		cfg := setting.NewCfg()
+		cfg.LDAPSyncRetryAttempts = 3
+		cfg.LDAPSyncRetryBackoff = time.Hour
+		hs := &HTTPServer{Cfg: cfg}
+		flaky := &flakyLDAPMock{failures: 3, err: networkErr}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := hs.lookupLDAPUser(ctx, flaky, "johndoe")
+
+		require.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, 1, flaky.calls)
+	})
+}
+
+func TestNewLDAPUserDTO_NameSplit(t *testing.T) {
+	tests := []struct {
+		desc            string
+		strategy        string
+		user            *models.ExternalUserInfo
+		expectedName    string
+		expectedSurname string
+	}{
+		{
+			desc:            "the first word is the name by default",
+			user:            &models.ExternalUserInfo{Name: "Mary Ann Smith"},
+			expectedName:    "Mary",
+			expectedSurname: "Ann Smith",
+		},
+		{
+			desc:            "the first word is the name",
+			strategy:        ldap.NameSplitFirstRest,
+			user:            &models.ExternalUserInfo{Name: "Mary Ann Smith"},
+			expectedName:    "Mary",
+			expectedSurname: "Ann Smith",
+		},
+		{
+			desc:            "the surname comes first before a comma",
+			strategy:        ldap.NameSplitLastFirstComma,
+			user:            &models.ExternalUserInfo{Name: "Smith, Mary Ann"},
+			expectedName:    "Mary Ann",
+			expectedSurname: "Smith",
+		},
+		{
+			desc:            "a name without a comma is split at the first word",
+			strategy:        ldap.NameSplitLastFirstComma,
+			user:            &models.ExternalUserInfo{Name: "Mary Smith"},
+			expectedName:    "Mary",
+			expectedSurname: "Smith",
+		},
+		{
+			desc:            "the attributes are used as they are",
+			strategy:        ldap.NameSplitAttributes,
+			user:            &models.ExternalUserInfo{Name: "Mary Ann Smith", GivenName: "Mary Ann", FamilyName: "Smith"},
+			expectedName:    "Mary Ann",
+			expectedSurname: "Smith",
+		},
+		{
+			desc:            "the name is split when the attributes are missing",
+			strategy:        ldap.NameSplitAttributes,
+			user:            &models.ExternalUserInfo{Name: "Mary Smith"},
+			expectedName:    "Mary",
+			expectedSurname: "Smith",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			u := newLDAPUserDTO(tc.user, ldap.ServerConfig{NameSplit: tc.strategy})
+
+			assert.Equal(t, tc.expectedName, u.Name.LDAPAttributeValue)
+			assert.Equal(t, tc.expectedSurname, u.Surname.LDAPAttributeValue)
+		})
+	}
+}
+
 func TestLDAP_AccessControl(t *testing.T) {
 	tests := []accessControlTestCase{
 		{
@@ -546,6 +2338,96 @@ func TestLDAP_AccessControl(t *testing.T) {
 				{Action: "wrong"},
 			},
 		},
+		{
+			url:          "/api/admin/ldap/mappings/compare?orgA=1&orgB=2",
+			method:       http.MethodGet,
+			desc:         "CompareLDAPGroupMappings should return 200 for user with required permissions",
+			expectedCode: http.StatusOK,
+			permissions: []accesscontrol.Permission{
+				{Action: accesscontrol.ActionLDAPStatusRead},
+			},
+		},
+		{
+			url:          "/api/admin/ldap/servers/test",
+			method:       http.MethodPost,
+			desc:         "PostTestLDAPServer should return 403 for user without required permissions",
+			expectedCode: http.StatusForbidden,
+			permissions: []accesscontrol.Permission{
+				{Action: "wrong"},
+			},
+		},
+		{
+			url:          "/api/admin/ldap/servers/test",
+			method:       http.MethodPost,
+			desc:         "PostTestLDAPServer should return 403 for user who can only read the LDAP status",
+			expectedCode: http.StatusForbidden,
+			permissions: []accesscontrol.Permission{
+				{Action: accesscontrol.ActionLDAPStatusRead},
+			},
+		},
+		{
+			url:          "/api/admin/ldap/servers/disable",
+			method:       http.MethodPost,
+			desc:         "PostDisableLDAPServer should return 403 for user without required permissions",
+			expectedCode: http.StatusForbidden,
+			permissions: []accesscontrol.Permission{
+				{Action: accesscontrol.ActionLDAPStatusRead},
+			},
+		},
+		{
+			url:          "/api/admin/ldap/users/disable",
+			method:       http.MethodPost,
+			desc:         "PostDisableLDAPUsers should return 403 for user without required permissions",
+			expectedCode: http.StatusForbidden,
+			permissions: []accesscontrol.Permission{
+				{Action: accesscontrol.ActionLDAPUsersRead},
+			},
+		},
+		{
+			url:          "/api/admin/ldap/mappings/compare?orgA=1&orgB=2",
+			method:       http.MethodGet,
+			desc:         "CompareLDAPGroupMappings should return 403 for user without required permissions",
+			expectedCode: http.StatusForbidden,
+			permissions: []accesscontrol.Permission{
+				{Action: "wrong"},
+			},
+		},
+		{
+			url:          "/api/admin/ldap/config-file",
+			method:       http.MethodGet,
+			desc:         "GetLDAPConfigFile should return 200 for user with required permissions",
+			expectedCode: http.StatusOK,
+			permissions: []accesscontrol.Permission{
+				{Action: accesscontrol.ActionLDAPStatusRead},
+			},
+		},
+		{
+			url:          "/api/admin/ldap/config-file",
+			method:       http.MethodGet,
+			desc:         "GetLDAPConfigFile should return 403 for user without required permissions",
+			expectedCode: http.StatusForbidden,
+			permissions: []accesscontrol.Permission{
+				{Action: "wrong"},
+			},
+		},
+		{
+			url:          "/api/admin/ldap/mappings/validate",
+			method:       http.MethodGet,
+			desc:         "ValidateLDAPGroupMappings should return 200 for user with required permissions",
+			expectedCode: http.StatusOK,
+			permissions: []accesscontrol.Permission{
+				{Action: accesscontrol.ActionLDAPStatusRead},
+			},
+		},
+		{
+			url:          "/api/admin/ldap/mappings/validate",
+			method:       http.MethodGet,
+			desc:         "ValidateLDAPGroupMappings should return 403 for user without required permissions",
+			expectedCode: http.StatusForbidden,
+			permissions: []accesscontrol.Permission{
+				{Action: "wrong"},
+			},
+		},
 		{
 			url:          "/api/admin/ldap/test",
 			method:       http.MethodGet,
@@ -605,6 +2487,7 @@ func TestLDAP_AccessControl(t *testing.T) {
 			hs.SQLStore = &mockstore.SQLStoreMock{ExpectedUser: &user.User{}}
 			hs.authInfoService = &logintest.AuthInfoServiceFake{}
 			hs.Login = &loginservice.LoginServiceMock{}
+			hs.tracer = tracing.InitializeTracerForTest()
 			sc.resp = httptest.NewRecorder()
 			sc.req, err = http.NewRequest(test.method, test.url, nil)
 			assert.NoError(t, err)
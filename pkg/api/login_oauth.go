@@ -229,7 +229,11 @@ func (hs *HTTPServer) OAuthLogin(ctx *models.ReqContext) {
 	}
 
 	loginInfo.ExternalUser = *hs.buildExternalUserInfo(token, userInfo, name)
-	loginInfo.User, err = hs.SyncUser(ctx, &loginInfo.ExternalUser, connect)
+	profileSync := models.ProfileSyncAlways
+	if hs.Cfg.OAuthKeepEditedProfile {
+		profileSync = models.ProfileSyncUnlessEdited
+	}
+	loginInfo.User, err = hs.SyncUser(ctx, &loginInfo.ExternalUser, connect, profileSync)
 	if err != nil {
 		hs.handleOAuthLoginErrorWithRedirect(ctx, loginInfo, err)
 		return
@@ -293,11 +297,13 @@ func (hs *HTTPServer) buildExternalUserInfo(token *oauth2.Token, userInfo *socia
 	return extUser
 }
 
-// SyncUser syncs a Grafana user profile with the corresponding OAuth profile.
+// SyncUser syncs a Grafana user profile with the corresponding OAuth profile. profileSync controls whether the
+// name and email of an existing user are updated from the OAuth profile.
 func (hs *HTTPServer) SyncUser(
 	ctx *models.ReqContext,
 	extUser *models.ExternalUserInfo,
 	connect social.SocialConnector,
+	profileSync models.ProfileSyncMode,
 ) (*user.User, error) {
 	oauthLogger.Debug("Syncing Grafana user with corresponding OAuth profile")
 	// add/update user in Grafana
@@ -305,6 +311,7 @@ func (hs *HTTPServer) SyncUser(
 		ReqContext:    ctx,
 		ExternalUser:  extUser,
 		SignupAllowed: connect.IsSignupAllowed(),
+		ProfileSync:   profileSync,
 	}
 
 	if err := hs.Login.UpsertUser(ctx.Req.Context(), cmd); err != nil {
@@ -90,6 +90,39 @@ func (auth *mockAuth) User(login string) (
 	return nil, ldap.ServerConfig{}, nil
 }
 
+func (auth *mockAuth) UsersInBaseDN(baseDN string) (
+	[]*models.ExternalUserInfo,
+	ldap.ServerConfig,
+	error,
+) {
+	return nil, ldap.ServerConfig{}, nil
+}
+
+func (auth *mockAuth) UserAttributes(login string) (
+	map[string][]string,
+	ldap.ServerConfig,
+	error,
+) {
+	return nil, ldap.ServerConfig{}, nil
+}
+
+func (auth *mockAuth) LookupUser(login string) (
+	*models.ExternalUserInfo,
+	ldap.ServerConfig,
+	[]*multildap.ServerStatus,
+	error,
+) {
+	return nil, ldap.ServerConfig{}, nil, nil
+}
+
+func (auth *mockAuth) LookupUserOnAllServers(login string) (
+	[]*multildap.UserMatch,
+	[]*multildap.ServerStatus,
+	error,
+) {
+	return nil, nil, nil
+}
+
 func (auth *mockAuth) Add(dn string, values map[string][]string) error {
 	return nil
 }
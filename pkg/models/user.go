@@ -66,8 +66,10 @@ type GetUserByLoginQuery struct {
 }
 
 type GetUserByEmailQuery struct {
-	Email  string
-	Result *user.User
+	Email string
+	// CaseInsensitive matches the email regardless of its casing, even when logins are case sensitive.
+	CaseInsensitive bool
+	Result          *user.User
 }
 
 type GetUserByIdQuery struct {
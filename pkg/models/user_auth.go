@@ -24,6 +24,8 @@ type UserAuth struct {
 	OAuthIdToken      string
 	OAuthTokenType    string
 	OAuthExpiry       time.Time
+	// LastSynced is when the user was last synced successfully from the auth module, zero if never.
+	LastSynced time.Time
 }
 
 type ExternalUserInfo struct {
@@ -38,6 +40,32 @@ type ExternalUserInfo struct {
 	OrgRoles       map[int64]RoleType
 	IsGrafanaAdmin *bool // This is a pointer to know if we should sync this or not (nil = ignore sync)
 	IsDisabled     bool
+	Referral       string // The LDAP referral that was followed to find the user, if any
+	// The LDAP attribute values from before the configured transforms were applied, keyed by attribute.
+	// Only set if the LDAP server has attribute transforms.
+	RawAttributes map[string]string
+	// GivenName and FamilyName are the parts Name is made of, for auth modules that read them separately.
+	GivenName  string
+	FamilyName string
+	// The preferences the user starts with in the orgs they are added to, keyed by org.
+	OrgPreferences map[int64]ExternalOrgPreferences
+	// The permission the user's role in an org implies on every team of the org, keyed by org.
+	TeamPermissions map[int64]PermissionType
+	// The orgs where the user's role implies the default team permission of the login service on every team.
+	DefaultTeamPermissionOrgs map[int64]bool
+}
+
+// ExternalOrgPreferences are preferences applied to a user when an external auth provider adds them to an org.
+// They only fill in preferences the user has not set themselves.
+type ExternalOrgPreferences struct {
+	HomeDashboardID int64
+	Timezone        string
+	Theme           string
+}
+
+// IsEmpty returns true if no preference is set.
+func (p ExternalOrgPreferences) IsEmpty() bool {
+	return p.HomeDashboardID == 0 && p.Timezone == "" && p.Theme == ""
 }
 
 type LoginInfo struct {
@@ -56,12 +84,117 @@ type RequestURIKey struct{}
 // ---------------------
 // COMMANDS
 
+// ProfileSyncMode controls whether the name and email of a user are synced from their external user info.
+type ProfileSyncMode int
+
+const (
+	// ProfileSyncAlways overwrites the name and email with the external ones.
+	ProfileSyncAlways ProfileSyncMode = iota
+	// ProfileSyncUnlessEdited overwrites the name and email unless the user's profile was updated in Grafana
+	// after their last login with the auth module.
+	ProfileSyncUnlessEdited
+	// ProfileSyncNever leaves the name and email untouched.
+	ProfileSyncNever
+)
+
 type UpsertUserCommand struct {
 	ReqContext    *ReqContext
 	ExternalUser  *ExternalUserInfo
 	SignupAllowed bool
+	// NoDowngrade makes the org role sync additive: roles are only updated when the
+	// new role is equal or higher than the current one, and memberships are never removed.
+	NoDowngrade bool
+	// OrgFilter limits the org role sync to the orgs with these names. The user's roles and memberships
+	// in other orgs are left untouched. An empty filter syncs all orgs.
+	OrgFilter []string
+	// SkipInvalidRoles makes the org role sync go on when the external user has an invalid role in an org.
+	// The invalid roles are returned in InvalidRoles instead of failing the upsert.
+	SkipInvalidRoles bool
+	// InvalidRoleFallback is applied in place of an invalid role when SkipInvalidRoles is set. If empty,
+	// the orgs with an invalid role are left out of the sync.
+	InvalidRoleFallback RoleType
+	// ProfileSync controls whether the name and email of an existing user are updated from the external user.
+	ProfileSync ProfileSyncMode
+	// ReportMemberships makes the upsert read back the orgs, roles and team memberships of the user once all the
+	// changes are written, and return them in Memberships.
+	ReportMemberships bool
+	// ConstrainToOrg restricts the org role and team permission sync to the org with this ID, for automation that
+	// acts on behalf of a single tenant. The roles of the external user in other orgs are rejected and returned in
+	// RejectedOrgIds, the user's memberships of other orgs are left untouched, and the Grafana admin flag is not
+	// synced. Unlike OrgFilter, it is not meant to narrow down a sync, but to guarantee that other orgs are never
+	// written to. Zero means no constraint.
+	ConstrainToOrg int64
+	// ActingUser is the identity the sync acts on behalf of, such as the admin syncing a user through the LDAP API or
+	// a scoped service account. When set, the user is only given the team permissions implied by their org role on
+	// the teams the acting user can read, and in the orgs where the acting user cannot read teams at all no team is
+	// assigned, and the orgs are returned in SkippedTeamOrgIds. When nil, as for logins, the sync reads every team of
+	// the org.
+	ActingUser *SignedInUser
+
+	Result            *user.User
+	SkippedDowngrades []SkippedRoleDowngrade
+	// FilteredOrgIds are the orgs the external user has a role in that were not synced because of OrgFilter.
+	FilteredOrgIds []int64
+	// RejectedOrgIds are the orgs the external user has a role in that were rejected because of ConstrainToOrg,
+	// ordered by ID.
+	RejectedOrgIds []int64
+	// SkippedTeamOrgIds are the orgs where no team was assigned because ActingUser cannot read their teams, ordered
+	// by ID.
+	SkippedTeamOrgIds []int64
+	InvalidRoles      []InvalidOrgRole
+	// OrgMappingConflicts are the orgs the org mapping provider mapped the user into with different roles, of which
+	// the highest was applied, ordered by org ID.
+	OrgMappingConflicts []OrgMappingConflict
+	// KeptLastAdmins are the orgs where the user was left an Admin because they are the last Admin of the org.
+	KeptLastAdmins []KeptLastOrgAdmin
+	// Memberships is the state of the user's orgs after the upsert, ordered by org ID. It is only set when
+	// ReportMemberships is.
+	Memberships []OrgMembership
+}
+
+// SkippedRoleDowngrade is an org role that was not applied to a user because
+// it is lower than the role the user already has in that org.
+type SkippedRoleDowngrade struct {
+	OrgId       int64
+	CurrentRole RoleType
+	SkippedRole RoleType
+}
+
+// InvalidOrgRole is an org role of an external user that is not a valid role,
+// and the role that was applied in its place, if any.
+type InvalidOrgRole struct {
+	OrgId       int64
+	Role        RoleType
+	AppliedRole RoleType
+}
 
-	Result *user.User
+// OrgMappingConflict is an org several org mappings of an external user map it into with different roles. Roles
+// are in the order of the mappings, and AppliedRole is the highest of them.
+type OrgMappingConflict struct {
+	OrgId       int64
+	Roles       []RoleType
+	AppliedRole RoleType
+}
+
+// OrgMembership is an org a user belongs to, with their role and team memberships in it.
+type OrgMembership struct {
+	OrgId   int64
+	OrgName string
+	Role    RoleType
+	Teams   []TeamMembership
+}
+
+// TeamMembership is a team a user is a member of, with their permission on it.
+type TeamMembership struct {
+	TeamId     int64
+	Permission PermissionType
+}
+
+// KeptLastOrgAdmin is an org where a user was left an Admin instead of being demoted or removed, because they
+// are the last Admin of the org. SkippedRole is the role that was not applied, which is empty for a removal.
+type KeptLastOrgAdmin struct {
+	OrgId       int64
+	SkippedRole RoleType
 }
 
 type SetAuthInfoCommand struct {
@@ -78,6 +211,12 @@ type UpdateAuthInfoCommand struct {
 	OAuthToken *oauth2.Token
 }
 
+// UpdateAuthInfoLastSyncedCommand records that the user was just synced successfully from the auth module.
+type UpdateAuthInfoLastSyncedCommand struct {
+	UserId     int64
+	AuthModule string
+}
+
 type DeleteAuthInfoCommand struct {
 	UserAuth *UserAuth
 }
@@ -117,6 +256,16 @@ type GetAuthInfoQuery struct {
 	Result *UserAuth
 }
 
+// GetAuthInfoNotSyncedSinceQuery finds the auth info of the users that have not been synced successfully since a
+// given time, including those never synced. An empty AuthModule matches every auth module. The OAuth tokens of the
+// results are not read.
+type GetAuthInfoNotSyncedSinceQuery struct {
+	AuthModule string
+	Since      time.Time
+
+	Result []*UserAuth
+}
+
 type TeamOrgGroupDTO struct {
 	TeamName string `json:"teamName"`
 	OrgName  string `json:"orgName"`
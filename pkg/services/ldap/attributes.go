@@ -0,0 +1,59 @@
+package ldap
+
+import (
+	"strings"
+)
+
+// AttributeMemberOf is the field of the group memberships in the "attributes" setting
+const AttributeMemberOf = "member_of"
+
+// AttributeSuggestion is an attribute of a directory entry that likely holds one of the fields Grafana maps
+type AttributeSuggestion struct {
+	Attribute string `json:"attribute"`
+	Value     string `json:"value"`
+}
+
+// attributeCandidates lists, by field of the "attributes" setting, the attributes commonly used for it in Active
+// Directory and OpenLDAP, in order of preference.
+var attributeCandidates = []struct {
+	field      string
+	attributes []string
+}{
+	{AttributeUsername, []string{"sAMAccountName", "uid", "userPrincipalName", "cn"}},
+	{AttributeEmail, []string{"mail", "email", "emailAddress", "userPrincipalName"}},
+	{AttributeName, []string{"givenName", "displayName", "cn"}},
+	{AttributeSurname, []string{"sn", "surname"}},
+	{AttributeMemberOf, []string{"memberOf", "isMemberOf"}},
+}
+
+// SuggestAttributes suggests which of the attributes of a directory entry to map to each field of the "attributes"
+// setting. Attribute names are matched case-insensitively, and an email is only suggested if its value contains an @.
+// Fields with no likely attribute are left out.
+func SuggestAttributes(attributes map[string][]string) map[string]AttributeSuggestion {
+	byName := make(map[string]string, len(attributes))
+	for name, values := range attributes {
+		if len(values) > 0 && values[0] != "" {
+			byName[strings.ToLower(name)] = name
+		}
+	}
+
+	suggestions := map[string]AttributeSuggestion{}
+	for _, candidate := range attributeCandidates {
+		for _, attribute := range candidate.attributes {
+			name, ok := byName[strings.ToLower(attribute)]
+			if !ok {
+				continue
+			}
+
+			value := attributes[name][0]
+			if candidate.field == AttributeEmail && !strings.Contains(value, "@") {
+				continue
+			}
+
+			suggestions[candidate.field] = AttributeSuggestion{Attribute: name, Value: value}
+			break
+		}
+	}
+
+	return suggestions
+}
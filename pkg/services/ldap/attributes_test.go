@@ -0,0 +1,56 @@
+package ldap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuggestAttributes(t *testing.T) {
+	t.Run("Active Directory entry", func(t *testing.T) {
+		suggestions := SuggestAttributes(map[string][]string{
+			"cn":                {"Roel Gerrits"},
+			"sn":                {"Gerrits"},
+			"givenName":         {"Roel"},
+			"displayName":       {"Roel Gerrits"},
+			"sAMAccountName":    {"roelgerrits"},
+			"userPrincipalName": {"roelgerrits@corp.grafana.org"},
+			"mail":              {"roel@grafana.org"},
+			"memberOf":          {"CN=Admins,OU=Groups,DC=corp,DC=grafana,DC=org", "CN=Editors,OU=Groups,DC=corp,DC=grafana,DC=org"},
+			"objectClass":       {"top", "person", "organizationalPerson", "user"},
+			"whenCreated":       {"20220101000000.0Z"},
+		})
+
+		assert.Equal(t, map[string]AttributeSuggestion{
+			AttributeUsername: {Attribute: "sAMAccountName", Value: "roelgerrits"},
+			AttributeEmail:    {Attribute: "mail", Value: "roel@grafana.org"},
+			AttributeName:     {Attribute: "givenName", Value: "Roel"},
+			AttributeSurname:  {Attribute: "sn", Value: "Gerrits"},
+			AttributeMemberOf: {Attribute: "memberOf", Value: "CN=Admins,OU=Groups,DC=corp,DC=grafana,DC=org"},
+		}, suggestions)
+	})
+
+	t.Run("Active Directory entry without mail", func(t *testing.T) {
+		suggestions := SuggestAttributes(map[string][]string{
+			"samaccountname":    {"roelgerrits"},
+			"userPrincipalName": {"roelgerrits@corp.grafana.org"},
+			"mail":              {""},
+		})
+
+		assert.Equal(t, map[string]AttributeSuggestion{
+			AttributeUsername: {Attribute: "samaccountname", Value: "roelgerrits"},
+			AttributeEmail:    {Attribute: "userPrincipalName", Value: "roelgerrits@corp.grafana.org"},
+		}, suggestions)
+	})
+
+	t.Run("email without an @ is not suggested", func(t *testing.T) {
+		suggestions := SuggestAttributes(map[string][]string{
+			"uid":  {"roelgerrits"},
+			"mail": {"roelgerrits"},
+		})
+
+		assert.Equal(t, map[string]AttributeSuggestion{
+			AttributeUsername: {Attribute: "uid", Value: "roelgerrits"},
+		}, suggestions)
+	})
+}
@@ -1,11 +1,105 @@
 package ldap
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
 	"strings"
+	"syscall"
 
 	"gopkg.in/ldap.v3"
 )
 
+// IsNetworkError returns true if the error was caused by the network rather than by the LDAP server,
+// such as a failed dial or a reset connection. These errors are usually transient.
+func IsNetworkError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var ldapErr *ldap.Error
+	return errors.As(err, &ldapErr) && ldapErr.ResultCode == ldap.ErrorNetwork
+}
+
+// ErrorCategory is the kind of failure an error of an LDAP server is, for telling failures apart without parsing
+// their messages.
+type ErrorCategory string
+
+const (
+	ErrorCategoryDNS               ErrorCategory = "dns"
+	ErrorCategoryConnectionRefused ErrorCategory = "connection_refused"
+	ErrorCategoryTimeout           ErrorCategory = "timeout"
+	ErrorCategoryTLS               ErrorCategory = "tls"
+	ErrorCategoryBind              ErrorCategory = "bind"
+	ErrorCategoryNetwork           ErrorCategory = "network"
+	ErrorCategoryOther             ErrorCategory = "other"
+)
+
+// ClassifyError returns the category of an error of an LDAP server, or an empty category if there is no error.
+// The error is inspected through the LDAP error wrapping it, if any, so that a failed dial is classified by its cause.
+func ClassifyError(err error) ErrorCategory {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, ErrInvalidCredentials) {
+		return ErrorCategoryBind
+	}
+
+	cause := err
+	var ldapErr *ldap.Error
+	if errors.As(err, &ldapErr) {
+		if ldapErr.ResultCode == ldap.LDAPResultInvalidCredentials {
+			return ErrorCategoryBind
+		}
+		if ldapErr.Err != nil {
+			cause = ldapErr.Err
+		}
+	}
+
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.As(cause, &dnsErr):
+		return ErrorCategoryDNS
+	case errors.As(cause, &netErr) && netErr.Timeout():
+		return ErrorCategoryTimeout
+	case errors.Is(cause, syscall.ECONNREFUSED):
+		return ErrorCategoryConnectionRefused
+	case isTLSError(cause):
+		return ErrorCategoryTLS
+	case IsNetworkError(err):
+		return ErrorCategoryNetwork
+	}
+	return ErrorCategoryOther
+}
+
+// isTLSError returns true if the error comes from the TLS handshake or the verification of the server certificate.
+// The alerts the server sends during the handshake are not exported, so they are recognized by their message.
+func isTLSError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var recordHeader tls.RecordHeaderError
+	return errors.As(err, &unknownAuthority) || errors.As(err, &hostname) || errors.As(err, &invalid) ||
+		errors.As(err, &recordHeader) || strings.Contains(err.Error(), "tls: ")
+}
+
+// ValidateSearchFilter returns an error if the user search filter is not a valid LDAP filter once its username
+// placeholder (%s) is replaced, or if it has no placeholder, as it would then find the same users for any username.
+func ValidateSearchFilter(filter string) error {
+	if !strings.Contains(filter, "%s") {
+		return errors.New("the search filter must contain the %s placeholder for the username")
+	}
+	if _, err := ldap.CompileFilter(strings.ReplaceAll(filter, "%s", "username")); err != nil {
+		return fmt.Errorf("invalid search filter: %w", err)
+	}
+	return nil
+}
+
 func IsMemberOf(memberOf []string, group string) bool {
 	if group == "*" {
 		return true
@@ -19,6 +113,63 @@ func IsMemberOf(memberOf []string, group string) bool {
 	return false
 }
 
+// apply returns the value transformed according to the type of the transform.
+func (t *AttributeTransform) apply(value string) (string, error) {
+	switch t.Type {
+	case TransformLowercase:
+		return strings.ToLower(value), nil
+	case TransformTrim:
+		return strings.TrimSpace(value), nil
+	case TransformRegexReplace:
+		re, err := regexp.Compile(t.Pattern)
+		if err != nil {
+			return "", fmt.Errorf("LDAP attribute transform: invalid pattern for attribute %q: %w", t.Attribute, err)
+		}
+		return re.ReplaceAllString(value, t.Replacement), nil
+	default:
+		return "", fmt.Errorf("LDAP attribute transform: unknown type %q for attribute %q", t.Type, t.Attribute)
+	}
+}
+
+// transformAttributes applies the transforms, in order, to the attribute values they are configured for.
+// It returns the values from before the transformation, or nil if there are no transforms.
+func transformAttributes(transforms []*AttributeTransform, values map[string]string) (map[string]string, error) {
+	if len(transforms) == 0 {
+		return nil, nil
+	}
+
+	raw := make(map[string]string, len(values))
+	for attribute, value := range values {
+		raw[attribute] = value
+	}
+
+	for _, transform := range transforms {
+		value, ok := values[transform.Attribute]
+		if !ok {
+			continue
+		}
+		transformed, err := transform.apply(value)
+		if err != nil {
+			return nil, err
+		}
+		values[transform.Attribute] = transformed
+	}
+
+	return raw, nil
+}
+
+// IsInBaseDNs returns true if the DN is one of the base DNs, or is nested in one of them
+func IsInBaseDNs(dn string, baseDNs []string) bool {
+	dn = strings.ToLower(strings.TrimSpace(dn))
+	for _, base := range baseDNs {
+		base = strings.ToLower(strings.TrimSpace(base))
+		if dn == base || strings.HasSuffix(dn, ","+base) {
+			return true
+		}
+	}
+	return false
+}
+
 func appendIfNotEmpty(slice []string, values ...string) []string {
 	for _, v := range values {
 		if v != "" {
@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"math"
 	"net"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -26,6 +27,7 @@ type IConnection interface {
 	Add(*ldap.AddRequest) error
 	Del(*ldap.DelRequest) error
 	Search(*ldap.SearchRequest) (*ldap.SearchResult, error)
+	SearchWithPaging(*ldap.SearchRequest, uint32) (*ldap.SearchResult, error)
 	StartTLS(*tls.Config) error
 	Close()
 }
@@ -34,17 +36,33 @@ type IConnection interface {
 type IServer interface {
 	Login(*models.LoginUserQuery) (*models.ExternalUserInfo, error)
 	Users([]string) ([]*models.ExternalUserInfo, error)
+	UsersInBaseDN(string) ([]*models.ExternalUserInfo, error)
+	UserAttributes(string) (map[string][]string, error)
 	Bind() error
 	UserBind(string, string) error
 	Dial() error
 	Close()
+	SecurityMode() string
 }
 
+// Security modes of an LDAP connection
+const (
+	SecurityModeNone     = "none"
+	SecurityModeStartTLS = "starttls"
+	SecurityModeLDAPS    = "ldaps"
+)
+
 // Server is basic struct of LDAP authorization
 type Server struct {
 	Config     *ServerConfig
 	Connection IConnection
 	log        log.Logger
+
+	// referrals maps the DN of the entries found by following a referral to that referral
+	referrals map[string]string
+
+	// securityMode is the security mode of the established connection
+	securityMode string
 }
 
 // Bind authenticates the connection with the LDAP server
@@ -79,6 +97,14 @@ var (
 
 	// ErrCouldNotFindUser is returned when username hasn't been found (not username+password)
 	ErrCouldNotFindUser = errors.New("can't find user in LDAP")
+
+	// ErrSizeLimitExceeded is returned when a search under a base DN matches more users than the size limit
+	ErrSizeLimitExceeded = errors.New("the search matched more users than the size limit")
+
+	// dialReferral dials the server a referral points to
+	dialReferral = func(server *Server) error {
+		return server.Dial()
+	}
 )
 
 // New creates the new LDAP connection
@@ -135,23 +161,35 @@ func (server *Server) Dial() error {
 				server.Connection, err = dialWithTimeout("tcp", address, timeout)
 				if err == nil {
 					if err = server.Connection.StartTLS(tlsCfg); err == nil {
+						server.securityMode = SecurityModeStartTLS
 						return nil
 					}
 				}
 			} else {
 				server.Connection, err = dialTLSWithTimeout("tcp", address, tlsCfg, timeout)
+				server.securityMode = SecurityModeLDAPS
 			}
 		} else {
 			server.Connection, err = dialWithTimeout("tcp", address, timeout)
+			server.securityMode = SecurityModeNone
 		}
 
 		if err == nil {
 			return nil
 		}
 	}
+	server.securityMode = ""
 	return err
 }
 
+// SecurityMode returns the security mode of the connection established by Dial, one of SecurityModeNone,
+// SecurityModeStartTLS and SecurityModeLDAPS. It is empty if there is no connection.
+// Note that start_tls is only honored together with use_ssl, so a connection configured with start_tls alone is
+// reported as SecurityModeNone.
+func (server *Server) SecurityMode() string {
+	return server.securityMode
+}
+
 // dialWithTimeout applies the specified timeout
 // and connects to the given address on the given network using net.Dial
 func dialWithTimeout(network, addr string, timeout time.Duration) (*ldap.Conn, error) {
@@ -307,6 +345,54 @@ func (server *Server) Users(logins []string) (
 	return serializedUsers, nil
 }
 
+// UsersInBaseDN returns all the users matching the search filter under the given base DN. The entries are fetched
+// UsersMaxRequest at a time, and ErrSizeLimitExceeded is returned if there are more than the users preview size limit.
+func (server *Server) UsersInBaseDN(baseDN string) (
+	[]*models.ExternalUserInfo,
+	error,
+) {
+	result, err := server.Connection.SearchWithPaging(server.getBaseDNSearchRequest(baseDN), UsersMaxRequest)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("%w of %d", ErrSizeLimitExceeded, server.Config.UsersPreviewSizeLimit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Entries) == 0 {
+		return []*models.ExternalUserInfo{}, nil
+	}
+
+	return server.serializeUsers([][]*ldap.Entry{result.Entries})
+}
+
+// UserAttributes returns all the attributes of the entry matching the login, regardless of the attributes mapped in
+// the configuration. It returns nil if no entry matches.
+func (server *Server) UserAttributes(login string) (map[string][]string, error) {
+	for _, base := range server.Config.SearchBaseDNs {
+		request := server.getSearchRequest(base, []string{login})
+		request.Attributes = []string{"*"}
+
+		result, err := server.Connection.Search(request)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(result.Entries) == 0 {
+			continue
+		}
+
+		entry := result.Entries[0]
+		attributes := make(map[string][]string, len(entry.Attributes))
+		for _, attr := range entry.Attributes {
+			attributes[attr.Name] = attr.Values
+		}
+		return attributes, nil
+	}
+
+	return nil, nil
+}
+
 // getUsersIteration is a helper function for Users() method.
 // It divides the users by equal parts for the anticipated requests
 func getUsersIteration(logins []string, fn func(int, int) error) error {
@@ -349,14 +435,128 @@ func (server *Server) users(logins []string) (
 			return nil, err
 		}
 
-		if len(result.Entries) > 0 {
-			entries = append(entries, result.Entries)
+		found := result.Entries
+		if Config.FollowReferrals && len(result.Referrals) > 0 {
+			found = append(found, server.followReferrals(result.Referrals, base, logins)...)
+		}
+
+		if len(found) > 0 {
+			entries = append(entries, found)
 		}
 	}
 
 	return entries, nil
 }
 
+// followReferrals searches for the users on the servers the referrals point to.
+// Referrals that cannot be followed are logged and skipped.
+func (server *Server) followReferrals(referrals []string, base string, logins []string) []*ldap.Entry {
+	var entries []*ldap.Entry
+	for _, referral := range referrals {
+		found, err := server.followReferral(referral, base, logins)
+		if err != nil {
+			server.log.Warn("Failed to follow LDAP referral", "referral", referral, "error", err)
+			continue
+		}
+
+		if server.referrals == nil {
+			server.referrals = map[string]string{}
+		}
+		for _, entry := range found {
+			server.referrals[entry.DN] = referral
+		}
+		entries = append(entries, found...)
+	}
+	return entries
+}
+
+// followReferral dials the server a referral points to, using the settings and credentials of this server,
+// and searches for the users under the DN of the referral.
+func (server *Server) followReferral(referral string, base string, logins []string) ([]*ldap.Entry, error) {
+	config, base, err := referralConfig(server.Config, referral, base)
+	if err != nil {
+		return nil, err
+	}
+
+	referred := &Server{
+		Config: config,
+		log:    server.log,
+	}
+	if err := dialReferral(referred); err != nil {
+		return nil, err
+	}
+	defer referred.Close()
+
+	if err := referred.Bind(); err != nil {
+		return nil, err
+	}
+
+	result, err := referred.Connection.Search(referred.getSearchRequest(base, logins))
+	if err != nil {
+		return nil, err
+	}
+	return result.Entries, nil
+}
+
+// referralConfig returns a copy of the server config pointing to the server of an LDAP referral URL,
+// alongside the base DN to search from. The base DN defaults to the one of the original search.
+func referralConfig(config *ServerConfig, referral string, base string) (*ServerConfig, string, error) {
+	u, err := url.Parse(referral)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid LDAP referral %q: %w", referral, err)
+	}
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		return nil, "", fmt.Errorf("unsupported LDAP referral scheme %q", u.Scheme)
+	}
+
+	if !referralHostAllowed(config, u.Hostname()) {
+		return nil, "", fmt.Errorf("LDAP referral host %q is neither a host of the server nor in its referral hosts", u.Hostname())
+	}
+
+	// The credentials of the server are sent to the referred server, so the connection to it is never less secure
+	// than the one to the server: an ldap:// referral of a server using TLS is upgraded with StartTLS.
+	referred := *config
+	referred.Host = u.Hostname()
+	if u.Scheme == "ldaps" {
+		referred.UseSSL = true
+		referred.StartTLS = false
+	} else {
+		referred.UseSSL = config.UseSSL
+		referred.StartTLS = config.UseSSL
+	}
+
+	switch {
+	case u.Port() != "":
+		referred.Port, err = strconv.Atoi(u.Port())
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid LDAP referral port %q: %w", u.Port(), err)
+		}
+	case u.Scheme == "ldaps":
+		referred.Port = 636
+	default:
+		referred.Port = 389
+	}
+
+	if dn := strings.TrimPrefix(u.Path, "/"); dn != "" {
+		base = dn
+	}
+
+	return &referred, base, nil
+}
+
+// referralHostAllowed returns true if a referral may be followed to the host, which must be one of the hosts of the
+// server or of its referral hosts.
+func referralHostAllowed(config *ServerConfig, host string) bool {
+	allowed := append(strings.Split(config.Host, " "), config.ReferralHosts...)
+	for _, h := range allowed {
+		h = strings.TrimSuffix(strings.TrimPrefix(h, "["), "]")
+		if h != "" && strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	return false
+}
+
 // validateGrafanaUser validates user access.
 // If there are no ldap group mappings access is true
 // otherwise a single group must match
@@ -373,25 +573,19 @@ func (server *Server) validateGrafanaUser(user *models.ExternalUserInfo) error {
 	return nil
 }
 
+// searchScopes maps the user search scopes to the ones of the LDAP protocol
+var searchScopes = map[string]int{
+	SearchScopeBase: ldap.ScopeBaseObject,
+	SearchScopeOne:  ldap.ScopeSingleLevel,
+	SearchScopeSub:  ldap.ScopeWholeSubtree,
+}
+
 // getSearchRequest returns LDAP search request for users
 func (server *Server) getSearchRequest(
 	base string,
 	logins []string,
 ) *ldap.SearchRequest {
-	attributes := []string{}
-
-	inputs := server.Config.Attr
-	attributes = appendIfNotEmpty(
-		attributes,
-		inputs.Username,
-		inputs.Surname,
-		inputs.Email,
-		inputs.Name,
-		inputs.MemberOf,
-
-		// In case for the POSIX LDAP schema server
-		server.Config.GroupSearchFilterUserAttribute,
-	)
+	attributes := server.getSearchAttributes()
 
 	search := ""
 	for _, login := range logins {
@@ -407,7 +601,7 @@ func (server *Server) getSearchRequest(
 
 	searchRequest := &ldap.SearchRequest{
 		BaseDN:       base,
-		Scope:        ldap.ScopeWholeSubtree,
+		Scope:        searchScopes[server.Config.EffectiveSearchScope()],
 		DerefAliases: ldap.NeverDerefAliases,
 		Attributes:   attributes,
 		Filter:       filter,
@@ -420,6 +614,40 @@ func (server *Server) getSearchRequest(
 	return searchRequest
 }
 
+// getBaseDNSearchRequest returns a request for all the entries under the base DN matching the search filter
+func (server *Server) getBaseDNSearchRequest(base string) *ldap.SearchRequest {
+	searchRequest := &ldap.SearchRequest{
+		BaseDN:       base,
+		Scope:        ldap.ScopeWholeSubtree,
+		DerefAliases: ldap.NeverDerefAliases,
+		SizeLimit:    server.Config.UsersPreviewSizeLimit,
+		Attributes:   server.getSearchAttributes(),
+		Filter:       strings.ReplaceAll(server.Config.SearchFilter, "%s", "*"),
+	}
+
+	server.log.Debug(
+		"LDAP SearchRequest", "searchRequest", fmt.Sprintf("%+v\n", searchRequest),
+	)
+
+	return searchRequest
+}
+
+// getSearchAttributes returns the attributes to request for users
+func (server *Server) getSearchAttributes() []string {
+	inputs := server.Config.Attr
+	return appendIfNotEmpty(
+		[]string{},
+		inputs.Username,
+		inputs.Surname,
+		inputs.Email,
+		inputs.Name,
+		inputs.MemberOf,
+
+		// In case for the POSIX LDAP schema server
+		server.Config.GroupSearchFilterUserAttribute,
+	)
+}
+
 // buildGrafanaUser extracts info from UserInfo model to ExternalUserInfo
 func (server *Server) buildGrafanaUser(user *ldap.Entry) (*models.ExternalUserInfo, error) {
 	memberOf, err := server.getMemberOf(user)
@@ -428,20 +656,35 @@ func (server *Server) buildGrafanaUser(user *ldap.Entry) (*models.ExternalUserIn
 	}
 
 	attrs := server.Config.Attr
+	values := map[string]string{
+		AttributeUsername: getAttribute(attrs.Username, user),
+		AttributeName:     getAttribute(attrs.Name, user),
+		AttributeSurname:  getAttribute(attrs.Surname, user),
+		AttributeEmail:    getAttribute(attrs.Email, user),
+	}
+	raw, err := transformAttributes(server.Config.AttributeTransforms, values)
+	if err != nil {
+		return nil, err
+	}
+
 	extUser := &models.ExternalUserInfo{
 		AuthModule: models.AuthModuleLDAP,
 		AuthId:     user.DN,
 		Name: strings.TrimSpace(
 			fmt.Sprintf(
 				"%s %s",
-				getAttribute(attrs.Name, user),
-				getAttribute(attrs.Surname, user),
+				values[AttributeName],
+				values[AttributeSurname],
 			),
 		),
-		Login:    getAttribute(attrs.Username, user),
-		Email:    getAttribute(attrs.Email, user),
-		Groups:   memberOf,
-		OrgRoles: map[int64]models.RoleType{},
+		GivenName:     values[AttributeName],
+		FamilyName:    values[AttributeSurname],
+		Login:         values[AttributeUsername],
+		Email:         values[AttributeEmail],
+		Groups:        memberOf,
+		OrgRoles:      map[int64]models.RoleType{},
+		Referral:      server.referrals[user.DN],
+		RawAttributes: raw,
 	}
 
 	for _, group := range server.Config.Groups {
@@ -453,6 +696,18 @@ func (server *Server) buildGrafanaUser(user *ldap.Entry) (*models.ExternalUserIn
 		if IsMemberOf(memberOf, group.GroupDN) {
 			if group.OrgRole != "" {
 				extUser.OrgRoles[group.OrgId] = group.OrgRole
+
+				prefs := models.ExternalOrgPreferences{
+					HomeDashboardID: group.HomeDashboardID,
+					Timezone:        group.Timezone,
+					Theme:           group.Theme,
+				}
+				if !prefs.IsEmpty() {
+					if extUser.OrgPreferences == nil {
+						extUser.OrgPreferences = map[int64]models.ExternalOrgPreferences{}
+					}
+					extUser.OrgPreferences[group.OrgId] = prefs
+				}
 			}
 
 			if extUser.IsGrafanaAdmin == nil || !*extUser.IsGrafanaAdmin {
@@ -461,6 +716,8 @@ func (server *Server) buildGrafanaUser(user *ldap.Entry) (*models.ExternalUserIn
 		}
 	}
 
+	extUser.TeamPermissions, extUser.DefaultTeamPermissionOrgs = server.Config.teamPermissions(extUser.OrgRoles)
+
 	// If there are group org mappings configured, but no matching mappings,
 	// the user will not be able to login and will be disabled
 	if len(server.Config.Groups) > 0 && (len(extUser.OrgRoles) == 0 && (extUser.IsGrafanaAdmin == nil || !*extUser.IsGrafanaAdmin)) {
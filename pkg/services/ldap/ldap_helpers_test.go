@@ -1,10 +1,16 @@
 package ldap
 
 import (
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"net"
+	"os"
+	"syscall"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gopkg.in/ldap.v3"
 )
 
@@ -26,6 +32,53 @@ func TestIsMemberOf(t *testing.T) {
 	}
 }
 
+func TestIsNetworkError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, expected: true},
+		{err: fmt.Errorf("search failed: %w", ldap.NewError(ldap.ErrorNetwork, errors.New("connection reset"))), expected: true},
+		{err: ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials")), expected: false},
+		{err: ErrCouldNotFindUser, expected: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(fmt.Sprintf("IsNetworkError(%v)", tc.err), func(t *testing.T) {
+			assert.Equal(t, tc.expected, IsNetworkError(tc.err))
+		})
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	dial := func(err error) error {
+		return ldap.NewError(ldap.ErrorNetwork, &net.OpError{Op: "dial", Net: "tcp", Err: err})
+	}
+
+	tests := []struct {
+		name     string
+		err      error
+		expected ErrorCategory
+	}{
+		{name: "no error", err: nil, expected: ""},
+		{name: "unknown host", err: dial(&net.DNSError{Err: "no such host", Name: "ldap.invalid", IsNotFound: true}), expected: ErrorCategoryDNS},
+		{name: "connection refused", err: dial(os.NewSyscallError("connect", syscall.ECONNREFUSED)), expected: ErrorCategoryConnectionRefused},
+		{name: "timeout", err: dial(os.ErrDeadlineExceeded), expected: ErrorCategoryTimeout},
+		{name: "untrusted certificate", err: ldap.NewError(ldap.ErrorNetwork, x509.UnknownAuthorityError{}), expected: ErrorCategoryTLS},
+		{name: "handshake alert", err: ldap.NewError(ldap.ErrorNetwork, errors.New("remote error: tls: bad certificate")), expected: ErrorCategoryTLS},
+		{name: "invalid bind credentials", err: ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials")), expected: ErrorCategoryBind},
+		{name: "invalid user credentials", err: fmt.Errorf("bind failed: %w", ErrInvalidCredentials), expected: ErrorCategoryBind},
+		{name: "reset connection", err: ldap.NewError(ldap.ErrorNetwork, errors.New("connection reset")), expected: ErrorCategoryNetwork},
+		{name: "anything else", err: errors.New("something is awfully wrong"), expected: ErrorCategoryOther},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, ClassifyError(tc.err))
+		})
+	}
+}
+
 func TestGetUsersIteration(t *testing.T) {
 	const pageSize = UsersMaxRequest
 	iterations := map[int]int{
@@ -139,3 +192,141 @@ func TestGetArrayAttribute(t *testing.T) {
 		assert.Empty(t, result)
 	})
 }
+
+func TestAttributeTransform_apply(t *testing.T) {
+	tests := []struct {
+		name      string
+		transform AttributeTransform
+		value     string
+		expected  string
+	}{
+		{
+			name:      "lowercase",
+			transform: AttributeTransform{Attribute: AttributeEmail, Type: TransformLowercase},
+			value:     "John.Doe@EXAMPLE.COM",
+			expected:  "john.doe@example.com",
+		},
+		{
+			name:      "trim",
+			transform: AttributeTransform{Attribute: AttributeName, Type: TransformTrim},
+			value:     "  John \t",
+			expected:  "John",
+		},
+		{
+			name:      "regex replace",
+			transform: AttributeTransform{Attribute: AttributeUsername, Type: TransformRegexReplace, Pattern: `@EXAMPLE\.COM$`},
+			value:     "johndoe@EXAMPLE.COM",
+			expected:  "johndoe",
+		},
+		{
+			name:      "regex replace with capture groups",
+			transform: AttributeTransform{Attribute: AttributeUsername, Type: TransformRegexReplace, Pattern: `^CORP\\(.+)$`, Replacement: "$1"},
+			value:     `CORP\johndoe`,
+			expected:  "johndoe",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := tc.transform.apply(tc.value)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, result)
+		})
+	}
+}
+
+func TestAttributeTransform_validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		transform AttributeTransform
+		valid     bool
+	}{
+		{name: "valid transform", transform: AttributeTransform{Attribute: AttributeEmail, Type: TransformLowercase}, valid: true},
+		{name: "unknown attribute", transform: AttributeTransform{Attribute: "member_of", Type: TransformLowercase}, valid: false},
+		{name: "unknown type", transform: AttributeTransform{Attribute: AttributeEmail, Type: "uppercase"}, valid: false},
+		{name: "invalid pattern", transform: AttributeTransform{Attribute: AttributeEmail, Type: TransformRegexReplace, Pattern: "("}, valid: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.transform.validate()
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestTransformAttributes(t *testing.T) {
+	t.Run("applies transforms in order and returns the raw values", func(t *testing.T) {
+		transforms := []*AttributeTransform{
+			{Attribute: AttributeUsername, Type: TransformTrim},
+			{Attribute: AttributeUsername, Type: TransformRegexReplace, Pattern: `@.*$`},
+			{Attribute: AttributeUsername, Type: TransformLowercase},
+		}
+		values := map[string]string{AttributeUsername: " JohnDoe@EXAMPLE.COM ", AttributeEmail: "john@example.com"}
+
+		raw, err := transformAttributes(transforms, values)
+
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{AttributeUsername: "johndoe", AttributeEmail: "john@example.com"}, values)
+		assert.Equal(t, map[string]string{AttributeUsername: " JohnDoe@EXAMPLE.COM ", AttributeEmail: "john@example.com"}, raw)
+	})
+
+	t.Run("returns no raw values without transforms", func(t *testing.T) {
+		values := map[string]string{AttributeUsername: "johndoe"}
+
+		raw, err := transformAttributes(nil, values)
+
+		require.NoError(t, err)
+		assert.Nil(t, raw)
+		assert.Equal(t, "johndoe", values[AttributeUsername])
+	})
+}
+
+func TestIsInBaseDNs(t *testing.T) {
+	baseDNs := []string{"ou=people,dc=grafana,dc=org", "dc=example,dc=org"}
+	tests := []struct {
+		dn       string
+		expected bool
+	}{
+		{dn: "ou=people,dc=grafana,dc=org", expected: true},
+		{dn: "ou=Engineering,OU=People,dc=grafana,dc=org", expected: true},
+		{dn: "ou=groups,dc=grafana,dc=org", expected: false},
+		{dn: "dc=grafana,dc=org", expected: false},
+		{dn: "ou=sales,dc=example,dc=org", expected: true},
+		{dn: "ou=sales,dc=notexample,dc=org", expected: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.dn, func(t *testing.T) {
+			assert.Equal(t, tc.expected, IsInBaseDNs(tc.dn, baseDNs))
+		})
+	}
+}
+
+func TestValidateSearchFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		valid  bool
+	}{
+		{filter: "(cn=%s)", valid: true},
+		{filter: "(&(uid=%s)(|(accountStatus=active)(accountStatus=inactive)))", valid: true},
+		{filter: "(cn=admin)", valid: false},
+		{filter: "(&(uid=%s)", valid: false},
+		{filter: "uid=%s)", valid: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.filter, func(t *testing.T) {
+			err := ValidateSearchFilter(tc.filter)
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
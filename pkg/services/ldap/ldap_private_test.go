@@ -51,6 +51,24 @@ func TestServer_getSearchRequest(t *testing.T) {
 	assert.EqualValues(t, expected, result)
 }
 
+func TestServer_getSearchRequest_scope(t *testing.T) {
+	for scope, expected := range map[string]int{
+		"":              ldap.ScopeWholeSubtree,
+		SearchScopeBase: ldap.ScopeBaseObject,
+		SearchScopeOne:  ldap.ScopeSingleLevel,
+		SearchScopeSub:  ldap.ScopeWholeSubtree,
+	} {
+		server := &Server{
+			Config: &ServerConfig{SearchScope: scope},
+			log:    log.New("test-logger"),
+		}
+
+		result := server.getSearchRequest("ou=users,dc=grafana,dc=org", []string{"johndoe"})
+
+		assert.Equal(t, expected, result.Scope, "scope %q", scope)
+	}
+}
+
 func TestSerializeUsers(t *testing.T) {
 	t.Run("simple case", func(t *testing.T) {
 		server := &Server{
@@ -120,6 +138,45 @@ func TestSerializeUsers(t *testing.T) {
 		assert.Equal(t, "Roel", result[0].Name)
 	})
 
+	t.Run("with attribute transforms", func(t *testing.T) {
+		server := &Server{
+			Config: &ServerConfig{
+				Attr: AttributeMap{
+					Username: "username",
+					Name:     "name",
+					MemberOf: "memberof",
+					Email:    "email",
+				},
+				AttributeTransforms: []*AttributeTransform{
+					{Attribute: AttributeUsername, Type: TransformRegexReplace, Pattern: `@TEST\.COM$`},
+					{Attribute: AttributeEmail, Type: TransformLowercase},
+				},
+				SearchBaseDNs: []string{"BaseDNHere"},
+			},
+			Connection: &MockConnection{},
+			log:        log.New("test-logger"),
+		}
+
+		entry := ldap.Entry{
+			DN: "dn",
+			Attributes: []*ldap.EntryAttribute{
+				{Name: "username", Values: []string{"roelgerrits@TEST.COM"}},
+				{Name: "email", Values: []string{"Roel@TEST.com"}},
+				{Name: "name", Values: []string{"Roel"}},
+				{Name: "memberof", Values: []string{"admins"}},
+			},
+		}
+		users := [][]*ldap.Entry{{&entry}}
+
+		result, err := server.serializeUsers(users)
+		require.NoError(t, err)
+
+		assert.Equal(t, "roelgerrits", result[0].Login)
+		assert.Equal(t, "roel@test.com", result[0].Email)
+		assert.Equal(t, "roelgerrits@TEST.COM", result[0].RawAttributes[AttributeUsername])
+		assert.Equal(t, "Roel@TEST.com", result[0].RawAttributes[AttributeEmail])
+	})
+
 	t.Run("mark user without matching group as disabled", func(t *testing.T) {
 		server := &Server{
 			Config: &ServerConfig{
@@ -147,6 +204,42 @@ func TestSerializeUsers(t *testing.T) {
 		assert.Len(t, result, 1)
 		assert.True(t, result[0].IsDisabled)
 	})
+
+	t.Run("team permissions implied by org roles", func(t *testing.T) {
+		server := &Server{
+			Config: &ServerConfig{
+				Attr: AttributeMap{
+					MemberOf: "memberof",
+				},
+				Groups: []*GroupToOrgRole{
+					{GroupDN: "admins", OrgId: 1, OrgRole: models.ROLE_ADMIN},
+					{GroupDN: "admins", OrgId: 2, OrgRole: models.ROLE_EDITOR},
+					{GroupDN: "admins", OrgId: 3, OrgRole: models.ROLE_VIEWER},
+				},
+				TeamRoleInheritance: []*OrgRoleToTeamPermission{
+					{OrgRole: models.ROLE_ADMIN, TeamPermission: TeamPermissionAdmin},
+					{OrgRole: models.ROLE_EDITOR, TeamPermission: TeamPermissionMember},
+					{OrgRole: models.ROLE_VIEWER},
+				},
+			},
+			Connection: &MockConnection{},
+			log:        log.New("test-logger"),
+		}
+
+		entry := ldap.Entry{
+			DN: "dn",
+			Attributes: []*ldap.EntryAttribute{
+				{Name: "memberof", Values: []string{"admins"}},
+			},
+		}
+		users := [][]*ldap.Entry{{&entry}}
+
+		result, err := server.serializeUsers(users)
+		require.NoError(t, err)
+
+		assert.Equal(t, map[int64]models.PermissionType{1: models.PERMISSION_ADMIN, 2: 0}, result[0].TeamPermissions)
+		assert.Equal(t, map[int64]bool{3: true}, result[0].DefaultTeamPermissionOrgs)
+	})
 }
 
 func TestServer_validateGrafanaUser(t *testing.T) {
@@ -3,6 +3,7 @@ package ldap
 import (
 	"errors"
 	"fmt"
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -50,6 +51,35 @@ func TestServer_Close(t *testing.T) {
 	})
 }
 
+func TestServer_Dial(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	t.Run("report plaintext connection", func(t *testing.T) {
+		server := &Server{
+			Config: &ServerConfig{Host: "127.0.0.1", Port: port, Timeout: 1},
+		}
+
+		require.NoError(t, server.Dial())
+		t.Cleanup(server.Close)
+
+		assert.Equal(t, SecurityModeNone, server.SecurityMode())
+	})
+
+	t.Run("report plaintext connection when only start_tls is set", func(t *testing.T) {
+		server := &Server{
+			Config: &ServerConfig{Host: "127.0.0.1", Port: port, Timeout: 1, StartTLS: true},
+		}
+
+		require.NoError(t, server.Dial())
+		t.Cleanup(server.Close)
+
+		assert.Equal(t, SecurityModeNone, server.SecurityMode())
+	})
+}
+
 func TestServer_Users(t *testing.T) {
 	t.Run("one user", func(t *testing.T) {
 		conn := &MockConnection{}
@@ -221,6 +251,268 @@ func TestServer_Users(t *testing.T) {
 		require.Len(t, res, 1)
 		assert.Equal(t, "Grot the First", res[0].Name)
 	})
+
+	t.Run("follow referrals", func(t *testing.T) {
+		referral := "ldap://dc2.example.org/dc=sub,dc=example,dc=org"
+		conn := &MockConnection{}
+		conn.setSearchResult(&ldap.SearchResult{Referrals: []string{referral}})
+
+		referredConn := &MockConnection{}
+		entry := ldap.Entry{
+			DN: "cn=grot,dc=sub,dc=example,dc=org", Attributes: []*ldap.EntryAttribute{
+				{Name: "username", Values: []string{"grot"}},
+				{Name: "name", Values: []string{"Grot"}},
+			}}
+		referredConn.setSearchFunc(func(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+			if request.BaseDN != "dc=sub,dc=example,dc=org" {
+				return nil, fmt.Errorf("test case not defined for baseDN: '%s'", request.BaseDN)
+			}
+			return &ldap.SearchResult{Entries: []*ldap.Entry{&entry}}, nil
+		})
+
+		var dialed *ServerConfig
+		origDialReferral := dialReferral
+		t.Cleanup(func() { dialReferral = origDialReferral })
+		dialReferral = func(server *Server) error {
+			dialed = server.Config
+			server.Connection = referredConn
+			return nil
+		}
+
+		server := &Server{
+			Config: &ServerConfig{
+				Host: "dc1.example.org",
+				Port: 389,
+				Attr: AttributeMap{
+					Username: "username",
+					Name:     "name",
+				},
+				SearchBaseDNs:   []string{"dc=example,dc=org"},
+				FollowReferrals: true,
+				ReferralHosts:   []string{"dc2.example.org"},
+			},
+			Connection: conn,
+			log:        log.New("test-logger"),
+		}
+
+		res, err := server.Users([]string{"grot"})
+		require.NoError(t, err)
+		require.Len(t, res, 1)
+		assert.Equal(t, "Grot", res[0].Name)
+		assert.Equal(t, referral, res[0].Referral)
+
+		require.NotNil(t, dialed)
+		assert.Equal(t, "dc2.example.org", dialed.Host)
+		assert.Equal(t, 389, dialed.Port)
+		assert.True(t, referredConn.CloseCalled)
+	})
+
+	t.Run("ignore referrals when not enabled", func(t *testing.T) {
+		conn := &MockConnection{}
+		conn.setSearchResult(&ldap.SearchResult{Referrals: []string{"ldap://dc2.example.org/dc=sub,dc=example,dc=org"}})
+
+		origDialReferral := dialReferral
+		t.Cleanup(func() { dialReferral = origDialReferral })
+		dialReferral = func(server *Server) error {
+			t.Fatal("referral should not be followed")
+			return nil
+		}
+
+		server := &Server{
+			Config: &ServerConfig{
+				Attr:          AttributeMap{Username: "username"},
+				SearchBaseDNs: []string{"dc=example,dc=org"},
+			},
+			Connection: conn,
+			log:        log.New("test-logger"),
+		}
+
+		res, err := server.Users([]string{"grot"})
+		require.NoError(t, err)
+		assert.Empty(t, res)
+	})
+}
+
+func TestReferralConfig(t *testing.T) {
+	t.Run("an ldap:// referral of an LDAPS server is upgraded with StartTLS", func(t *testing.T) {
+		config := &ServerConfig{
+			Host:          "dc1.example.org",
+			Port:          636,
+			UseSSL:        true,
+			ReferralHosts: []string{"dc2.example.org"},
+		}
+
+		referred, base, err := referralConfig(config, "ldap://dc2.example.org/dc=sub,dc=example,dc=org", "dc=example,dc=org")
+
+		require.NoError(t, err)
+		assert.Equal(t, "dc2.example.org", referred.Host)
+		assert.Equal(t, 389, referred.Port)
+		assert.True(t, referred.UseSSL)
+		assert.True(t, referred.StartTLS)
+		assert.Equal(t, "dc=sub,dc=example,dc=org", base)
+	})
+
+	t.Run("an ldaps:// referral uses LDAPS", func(t *testing.T) {
+		config := &ServerConfig{Host: "dc1.example.org dc2.example.org", Port: 389}
+
+		referred, _, err := referralConfig(config, "ldaps://dc2.example.org", "dc=example,dc=org")
+
+		require.NoError(t, err)
+		assert.Equal(t, 636, referred.Port)
+		assert.True(t, referred.UseSSL)
+		assert.False(t, referred.StartTLS)
+	})
+
+	t.Run("a referral to a host that is not allowed is refused", func(t *testing.T) {
+		config := &ServerConfig{
+			Host:          "dc1.example.org",
+			UseSSL:        true,
+			ReferralHosts: []string{"dc2.example.org"},
+		}
+
+		_, _, err := referralConfig(config, "ldap://attacker.example.com/dc=example,dc=org", "dc=example,dc=org")
+
+		require.Error(t, err)
+	})
+}
+
+func TestServer_UsersInBaseDN(t *testing.T) {
+	t.Run("all users under the base DN", func(t *testing.T) {
+		conn := &MockConnection{}
+		var request *ldap.SearchRequest
+		conn.SearchFunc = func(sr *ldap.SearchRequest) (*ldap.SearchResult, error) {
+			request = sr
+			return &ldap.SearchResult{Entries: []*ldap.Entry{
+				{DN: "uid=roel,ou=people,dc=grafana,dc=org", Attributes: []*ldap.EntryAttribute{
+					{Name: "username", Values: []string{"roelgerrits"}},
+				}},
+				{DN: "uid=grot,ou=people,dc=grafana,dc=org", Attributes: []*ldap.EntryAttribute{
+					{Name: "username", Values: []string{"grot"}},
+				}},
+			}}, nil
+		}
+
+		server := &Server{
+			Config: &ServerConfig{
+				Attr: AttributeMap{
+					Username: "username",
+				},
+				SearchFilter:  "(uid=%s)",
+				SearchBaseDNs: []string{"dc=grafana,dc=org"},
+			},
+			Connection: conn,
+			log:        log.New("test-logger"),
+		}
+
+		users, err := server.UsersInBaseDN("ou=people,dc=grafana,dc=org")
+
+		require.NoError(t, err)
+		require.Len(t, users, 2)
+		assert.Equal(t, "roelgerrits", users[0].Login)
+		assert.Equal(t, "grot", users[1].Login)
+		assert.Equal(t, "ou=people,dc=grafana,dc=org", request.BaseDN)
+		assert.Equal(t, "(uid=*)", request.Filter)
+		assert.Equal(t, uint32(UsersMaxRequest), conn.PagingSize)
+	})
+
+	t.Run("more users than the size limit", func(t *testing.T) {
+		conn := &MockConnection{}
+		var request *ldap.SearchRequest
+		conn.SearchFunc = func(sr *ldap.SearchRequest) (*ldap.SearchResult, error) {
+			request = sr
+			return &ldap.SearchResult{}, ldap.NewError(ldap.LDAPResultSizeLimitExceeded, errors.New("size limit exceeded"))
+		}
+
+		server := &Server{
+			Config: &ServerConfig{
+				SearchFilter:          "(uid=%s)",
+				SearchBaseDNs:         []string{"dc=grafana,dc=org"},
+				UsersPreviewSizeLimit: 2,
+			},
+			Connection: conn,
+			log:        log.New("test-logger"),
+		}
+
+		_, err := server.UsersInBaseDN("ou=people,dc=grafana,dc=org")
+
+		assert.ErrorIs(t, err, ErrSizeLimitExceeded)
+		assert.Equal(t, 2, request.SizeLimit)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		expected := errors.New("Killa-gorilla")
+		conn := &MockConnection{}
+		conn.setSearchError(expected)
+
+		server := &Server{
+			Config: &ServerConfig{
+				SearchBaseDNs: []string{"dc=grafana,dc=org"},
+			},
+			Connection: conn,
+			log:        log.New("test-logger"),
+		}
+
+		_, err := server.UsersInBaseDN("ou=people,dc=grafana,dc=org")
+
+		assert.ErrorIs(t, err, expected)
+	})
+}
+
+func TestServer_UserAttributes(t *testing.T) {
+	t.Run("all the attributes of the entry", func(t *testing.T) {
+		conn := &MockConnection{}
+		var request *ldap.SearchRequest
+		conn.SearchFunc = func(sr *ldap.SearchRequest) (*ldap.SearchResult, error) {
+			request = sr
+			return &ldap.SearchResult{Entries: []*ldap.Entry{
+				{DN: "CN=Roel Gerrits,OU=Users,DC=grafana,DC=org", Attributes: []*ldap.EntryAttribute{
+					{Name: "sAMAccountName", Values: []string{"roelgerrits"}},
+					{Name: "mail", Values: []string{"roel@grafana.org"}},
+				}},
+			}}, nil
+		}
+
+		server := &Server{
+			Config: &ServerConfig{
+				Attr: AttributeMap{
+					Username: "sAMAccountName",
+				},
+				SearchFilter:  "(sAMAccountName=%s)",
+				SearchBaseDNs: []string{"DC=grafana,DC=org"},
+			},
+			Connection: conn,
+			log:        log.New("test-logger"),
+		}
+
+		attributes, err := server.UserAttributes("roelgerrits")
+
+		require.NoError(t, err)
+		assert.Equal(t, map[string][]string{
+			"sAMAccountName": {"roelgerrits"},
+			"mail":           {"roel@grafana.org"},
+		}, attributes)
+		assert.Equal(t, []string{"*"}, request.Attributes)
+		assert.Equal(t, "(|(sAMAccountName=roelgerrits))", request.Filter)
+	})
+
+	t.Run("no entry", func(t *testing.T) {
+		conn := &MockConnection{}
+		conn.setSearchResult(&ldap.SearchResult{})
+
+		server := &Server{
+			Config: &ServerConfig{
+				SearchFilter:  "(uid=%s)",
+				SearchBaseDNs: []string{"dc=grafana,dc=org"},
+			},
+			Connection: conn,
+			log:        log.New("test-logger"),
+		}
+
+		attributes, err := server.UserAttributes("roelgerrits")
+
+		require.NoError(t, err)
+		assert.Nil(t, attributes)
+	})
 }
 
 func TestServer_UserBind(t *testing.T) {
@@ -1,6 +1,7 @@
 package ldap
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"sync"
@@ -14,6 +15,8 @@ import (
 
 const defaultTimeout = 10
 
+const defaultUsersPreviewSizeLimit = 10000
+
 // Config holds list of connections to LDAP
 type Config struct {
 	Servers []*ServerConfig `toml:"servers"`
@@ -34,14 +37,92 @@ type ServerConfig struct {
 	Timeout       int          `toml:"timeout"`
 	Attr          AttributeMap `toml:"attributes"`
 
-	SearchFilter  string   `toml:"search_filter"`
-	SearchBaseDNs []string `toml:"search_base_dns"`
+	// NameSplit is how the LDAP debug view splits the full name of a user into a name and a surname. It is one of
+	// the NameSplit constants, and NameSplitFirstRest if empty.
+	NameSplit string `toml:"name_split"`
+
+	AttributeTransforms []*AttributeTransform `toml:"attribute_transforms"`
+
+	SearchFilter    string   `toml:"search_filter"`
+	SearchBaseDNs   []string `toml:"search_base_dns"`
+	FollowReferrals bool     `toml:"follow_referrals"`
+
+	// ReferralHosts are the hosts, besides the hosts of the server, that referrals may be followed to. The server
+	// credentials are sent to the referred server, so referrals to any other host are refused.
+	ReferralHosts []string `toml:"referral_hosts"`
 
 	GroupSearchFilter              string   `toml:"group_search_filter"`
 	GroupSearchFilterUserAttribute string   `toml:"group_search_filter_user_attribute"`
 	GroupSearchBaseDNs             []string `toml:"group_search_base_dns"`
 
 	Groups []*GroupToOrgRole `toml:"group_mappings"`
+
+	TeamRoleInheritance []*OrgRoleToTeamPermission `toml:"team_role_inheritance"`
+
+	// UsersPreviewSizeLimit is the most users a search under a base DN, such as the one of the LDAP users preview,
+	// may match. It is 10000 if not set.
+	UsersPreviewSizeLimit int `toml:"users_preview_size_limit"`
+
+	// OrgIds limits the server to the users of these orgs. A server without org IDs serves every org.
+	OrgIds []int64 `toml:"org_ids"`
+
+	// SearchScope overrides the scope of the user searches, which is the whole subtree of the base DNs otherwise.
+	// It is not read from the config file, and is only set for debug lookups.
+	SearchScope string `toml:"-"`
+}
+
+// Strategies of splitting the full name of a user into a name and a surname
+const (
+	// NameSplitFirstRest takes the first word as the name and the rest as the surname.
+	NameSplitFirstRest = "first_rest"
+	// NameSplitLastFirstComma reads names written as "Surname, Name".
+	NameSplitLastFirstComma = "last_first_comma"
+	// NameSplitAttributes uses the values of the name and surname attributes as they are.
+	NameSplitAttributes = "attributes"
+)
+
+// Scopes of the user searches
+const (
+	SearchScopeBase = "base"
+	SearchScopeOne  = "one"
+	SearchScopeSub  = "sub"
+)
+
+// IsValidSearchScope returns true if the scope is one of the user search scopes.
+func IsValidSearchScope(scope string) bool {
+	return scope == SearchScopeBase || scope == SearchScopeOne || scope == SearchScopeSub
+}
+
+// EffectiveSearchScope returns the scope the user searches of the server use.
+func (c *ServerConfig) EffectiveSearchScope() string {
+	if c.SearchScope == "" {
+		return SearchScopeSub
+	}
+	return c.SearchScope
+}
+
+// ServesOrg returns true if the server is used for the users of the org.
+func (c *ServerConfig) ServesOrg(orgID int64) bool {
+	if len(c.OrgIds) == 0 {
+		return true
+	}
+	for _, id := range c.OrgIds {
+		if id == orgID {
+			return true
+		}
+	}
+	return false
+}
+
+// ServersForOrg returns the servers used for the users of the org.
+func (c *Config) ServersForOrg(orgID int64) []*ServerConfig {
+	var servers []*ServerConfig
+	for _, server := range c.Servers {
+		if server.ServesOrg(orgID) {
+			servers = append(servers, server)
+		}
+	}
+	return servers
 }
 
 // AttributeMap is a struct representation for LDAP "attributes" setting
@@ -53,6 +134,30 @@ type AttributeMap struct {
 	MemberOf string `toml:"member_of"`
 }
 
+// Attributes of a user that can be transformed, named after their "attributes" setting
+const (
+	AttributeUsername = "username"
+	AttributeName     = "name"
+	AttributeSurname  = "surname"
+	AttributeEmail    = "email"
+)
+
+// Types of attribute transforms
+const (
+	TransformLowercase    = "lowercase"
+	TransformTrim         = "trim"
+	TransformRegexReplace = "regex_replace"
+)
+
+// AttributeTransform is a struct representation of LDAP
+// config "attribute_transforms" setting
+type AttributeTransform struct {
+	Attribute   string `toml:"attribute"`
+	Type        string `toml:"type"`
+	Pattern     string `toml:"pattern"`
+	Replacement string `toml:"replacement"`
+}
+
 // GroupToOrgRole is a struct representation of LDAP
 // config "group_mappings" setting
 type GroupToOrgRole struct {
@@ -63,6 +168,61 @@ type GroupToOrgRole struct {
 	IsGrafanaAdmin *bool `toml:"grafana_admin"`
 
 	OrgRole models.RoleType `toml:"org_role"`
+
+	// The preferences a user starts with when this mapping adds them to the org.
+	// Preferences the user already has are not replaced.
+	HomeDashboardID int64  `toml:"home_dashboard_id"`
+	Timezone        string `toml:"timezone"`
+	Theme           string `toml:"theme"`
+}
+
+// Team permissions an org role can imply
+const (
+	TeamPermissionMember = "Member"
+	TeamPermissionAdmin  = "Admin"
+)
+
+// OrgRoleToTeamPermission is a struct representation of LDAP
+// config "team_role_inheritance" setting. Users with the org role
+// get the team permission on every team of the org, or the default
+// team permission of the login service if none is set.
+type OrgRoleToTeamPermission struct {
+	OrgRole        models.RoleType `toml:"org_role"`
+	TeamPermission string          `toml:"team_permission"`
+}
+
+func (r *OrgRoleToTeamPermission) permission() models.PermissionType {
+	if r.TeamPermission == TeamPermissionAdmin {
+		return models.PERMISSION_ADMIN
+	}
+	return 0
+}
+
+// teamPermissions returns, for each org, the permission on all of its teams that the
+// user's role in the org implies, and the orgs where the role implies the default team
+// permission. Orgs whose role implies no team permission are left out.
+func (c *ServerConfig) teamPermissions(orgRoles map[int64]models.RoleType) (map[int64]models.PermissionType, map[int64]bool) {
+	if len(c.TeamRoleInheritance) == 0 {
+		return nil, nil
+	}
+
+	result := map[int64]models.PermissionType{}
+	defaults := map[int64]bool{}
+	for orgID, role := range orgRoles {
+		for _, rule := range c.TeamRoleInheritance {
+			if rule.OrgRole != role {
+				continue
+			}
+			if rule.TeamPermission == "" {
+				defaults[orgID] = true
+				continue
+			}
+			if current, ok := result[orgID]; !ok || rule.permission() > current {
+				result[orgID] = rule.permission()
+			}
+		}
+	}
+	return result, defaults
 }
 
 // logger for all LDAP stuff
@@ -94,6 +254,64 @@ func ReloadConfig() error {
 // could be defined as singleton
 var config *Config
 
+// ErrServerNotConfigured is returned when no LDAP server is configured with a host and port
+var ErrServerNotConfigured = errors.New("no LDAP server is configured with this host and port")
+
+// ReloadServerConfig reads the settings of the server with the given host and port from the config file, and swaps
+// them into the loaded configuration in place of the current ones once test accepts them. The other servers keep
+// the settings they were loaded with, so that logins against them are not disrupted. ErrServerNotConfigured is
+// returned if the server is not in both the loaded configuration and the config file.
+func ReloadServerConfig(host string, port int, test func(*ServerConfig) error) (*ServerConfig, error) {
+	if !IsEnabled() {
+		return nil, nil
+	}
+
+	fileConfig, err := readConfig(setting.LDAPConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	index := serverIndex(fileConfig.Servers, host, port)
+	if index < 0 {
+		return nil, ErrServerNotConfigured
+	}
+	server := fileConfig.Servers[index]
+
+	if err := test(server); err != nil {
+		return nil, err
+	}
+
+	loadingMutex.Lock()
+	defer loadingMutex.Unlock()
+
+	// Until the whole configuration is loaded once, every lookup reads the config file.
+	current := config
+	if current == nil {
+		current = fileConfig
+	}
+	index = serverIndex(current.Servers, host, port)
+	if index < 0 {
+		return nil, ErrServerNotConfigured
+	}
+
+	// The loaded configuration is replaced rather than modified, as it may be in use
+	updated := *current
+	updated.Servers = append([]*ServerConfig{}, current.Servers...)
+	updated.Servers[index] = server
+	config = &updated
+
+	return server, nil
+}
+
+// serverIndex returns the index of the server with the given host and port, or -1 if there is none.
+func serverIndex(servers []*ServerConfig, host string, port int) int {
+	for i, server := range servers {
+		if server.Host == host && server.Port == port {
+			return i
+		}
+	}
+	return -1
+}
+
 // GetConfig returns the LDAP config if LDAP is enabled otherwise it returns nil. It returns either cached value of
 // the config or it reads it and caches it first.
 func GetConfig(cfg *setting.Cfg) (*Config, error) {
@@ -162,12 +380,51 @@ func readConfig(configFile string) (*Config, error) {
 			if groupMap.OrgId == 0 {
 				groupMap.OrgId = 1
 			}
+
+			if groupMap.Theme != "" && groupMap.Theme != "light" && groupMap.Theme != "dark" {
+				return nil, fmt.Errorf("LDAP group mapping: invalid theme %q, must be light or dark", groupMap.Theme)
+			}
+		}
+
+		for _, rule := range server.TeamRoleInheritance {
+			if !rule.OrgRole.IsValid() {
+				return nil, fmt.Errorf("LDAP team role inheritance: invalid organization role %q", rule.OrgRole)
+			}
+			if rule.TeamPermission != "" && rule.TeamPermission != TeamPermissionMember && rule.TeamPermission != TeamPermissionAdmin {
+				return nil, fmt.Errorf("LDAP team role inheritance: invalid team permission %q, must be Member or Admin", rule.TeamPermission)
+			}
+		}
+
+		for _, orgID := range server.OrgIds {
+			if orgID <= 0 {
+				return nil, fmt.Errorf("LDAP server org_ids: invalid organization ID %d", orgID)
+			}
+		}
+
+		switch server.NameSplit {
+		case "", NameSplitFirstRest, NameSplitLastFirstComma, NameSplitAttributes:
+		default:
+			return nil, fmt.Errorf("LDAP name_split: invalid strategy %q, must be %s, %s or %s", server.NameSplit,
+				NameSplitFirstRest, NameSplitLastFirstComma, NameSplitAttributes)
+		}
+
+		for _, transform := range server.AttributeTransforms {
+			if err := transform.validate(); err != nil {
+				return nil, fmt.Errorf("%v: %w", "Failed to validate attribute transforms", err)
+			}
 		}
 
 		// set default timeout if unspecified
 		if server.Timeout == 0 {
 			server.Timeout = defaultTimeout
 		}
+
+		if server.UsersPreviewSizeLimit < 0 {
+			return nil, fmt.Errorf("LDAP users_preview_size_limit: invalid limit %d, must not be negative", server.UsersPreviewSizeLimit)
+		}
+		if server.UsersPreviewSizeLimit == 0 {
+			server.UsersPreviewSizeLimit = defaultUsersPreviewSizeLimit
+		}
 	}
 
 	return result, nil
@@ -188,3 +445,14 @@ func assertNotEmptyCfg(val interface{}, propName string) error {
 	}
 	return nil
 }
+
+func (t *AttributeTransform) validate() error {
+	switch t.Attribute {
+	case AttributeUsername, AttributeName, AttributeSurname, AttributeEmail:
+	default:
+		return fmt.Errorf("LDAP attribute transform: unknown attribute %q", t.Attribute)
+	}
+
+	_, err := t.apply("")
+	return err
+}
@@ -1,11 +1,16 @@
 package ldap
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/setting"
 )
 
 func TestReadingLDAPSettings(t *testing.T) {
@@ -22,3 +27,82 @@ func TestReadingLDAPSettingsWithEnvVariable(t *testing.T) {
 	require.NoError(t, err)
 	assert.EqualValues(t, "MySecret", config.Servers[0].BindPassword)
 }
+
+func TestServersForOrg(t *testing.T) {
+	shared := &ServerConfig{Host: "shared"}
+	org1 := &ServerConfig{Host: "org1", OrgIds: []int64{1}}
+	org2 := &ServerConfig{Host: "org2", OrgIds: []int64{2, 3}}
+	config := &Config{Servers: []*ServerConfig{shared, org1, org2}}
+
+	assert.Equal(t, []*ServerConfig{shared, org1}, config.ServersForOrg(1))
+	assert.Equal(t, []*ServerConfig{shared, org2}, config.ServersForOrg(3))
+	assert.Equal(t, []*ServerConfig{shared}, config.ServersForOrg(4))
+}
+
+func TestReloadServerConfig(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "ldap.toml")
+	writeConfig := func(bindDNA, bindDNB string) {
+		t.Helper()
+		content := fmt.Sprintf(`
+[[servers]]
+host = "a.example.org"
+port = 389
+bind_dn = "%s"
+search_filter = "(uid=%%s)"
+search_base_dns = ["dc=example,dc=org"]
+
+[[servers]]
+host = "b.example.org"
+port = 389
+bind_dn = "%s"
+search_filter = "(uid=%%s)"
+search_base_dns = ["dc=example,dc=org"]
+`, bindDNA, bindDNB)
+		require.NoError(t, os.WriteFile(configFile, []byte(content), 0600))
+	}
+
+	origEnabled, origFile := setting.LDAPEnabled, setting.LDAPConfigFile
+	t.Cleanup(func() {
+		setting.LDAPEnabled, setting.LDAPConfigFile = origEnabled, origFile
+		config = nil
+	})
+	setting.LDAPEnabled, setting.LDAPConfigFile = true, configFile
+
+	writeConfig("cn=a,dc=example,dc=org", "cn=b,dc=example,dc=org")
+	require.NoError(t, ReloadConfig())
+	loaded := config
+	writeConfig("cn=a-new,dc=example,dc=org", "cn=b-new,dc=example,dc=org")
+
+	t.Run("settings failing the test are not swapped in", func(t *testing.T) {
+		_, err := ReloadServerConfig("a.example.org", 389, func(*ServerConfig) error {
+			return errors.New("invalid credentials")
+		})
+
+		require.EqualError(t, err, "invalid credentials")
+		assert.Same(t, loaded, config)
+	})
+
+	t.Run("an unknown server is not reloaded", func(t *testing.T) {
+		_, err := ReloadServerConfig("a.example.org", 636, func(*ServerConfig) error { return nil })
+
+		require.ErrorIs(t, err, ErrServerNotConfigured)
+		assert.Same(t, loaded, config)
+	})
+
+	t.Run("only the settings of the server are reloaded", func(t *testing.T) {
+		var tested *ServerConfig
+		server, err := ReloadServerConfig("a.example.org", 389, func(s *ServerConfig) error {
+			tested = s
+			return nil
+		})
+
+		require.NoError(t, err)
+		assert.Same(t, tested, server)
+		require.Len(t, config.Servers, 2)
+		assert.Same(t, server, config.Servers[0])
+		assert.Equal(t, "cn=a-new,dc=example,dc=org", config.Servers[0].BindDN)
+		assert.Same(t, loaded.Servers[1], config.Servers[1])
+		assert.Equal(t, "cn=b,dc=example,dc=org", config.Servers[1].BindDN)
+		assert.Equal(t, "cn=a,dc=example,dc=org", loaded.Servers[0].BindDN, "the previous configuration should be left as it was")
+	})
+}
@@ -16,6 +16,8 @@ type MockConnection struct {
 	SearchFunc       searchFunc
 	SearchCalled     bool
 	SearchAttributes []string
+	// PagingSize is the paging size of the last paged search, or zero if there was none
+	PagingSize uint32
 
 	AddParams *ldap.AddRequest
 	AddCalled bool
@@ -83,6 +85,12 @@ func (c *MockConnection) Search(sr *ldap.SearchRequest) (*ldap.SearchResult, err
 	return c.SearchFunc(sr)
 }
 
+// SearchWithPaging mocks SearchWithPaging connection function, returning all the entries at once
+func (c *MockConnection) SearchWithPaging(sr *ldap.SearchRequest, pagingSize uint32) (*ldap.SearchResult, error) {
+	c.PagingSize = pagingSize
+	return c.Search(sr)
+}
+
 // Add mocks Add connection function
 func (c *MockConnection) Add(request *ldap.AddRequest) error {
 	c.AddCalled = true
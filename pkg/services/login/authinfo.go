@@ -13,4 +13,6 @@ type AuthInfoService interface {
 	GetExternalUserInfoByLogin(ctx context.Context, query *models.GetExternalUserInfoByLoginQuery) error
 	SetAuthInfo(ctx context.Context, cmd *models.SetAuthInfoCommand) error
 	UpdateAuthInfo(ctx context.Context, cmd *models.UpdateAuthInfoCommand) error
+	UpdateAuthInfoLastSynced(ctx context.Context, cmd *models.UpdateAuthInfoLastSyncedCommand) error
+	GetAuthInfoNotSyncedSince(ctx context.Context, query *models.GetAuthInfoNotSyncedSinceQuery) error
 }
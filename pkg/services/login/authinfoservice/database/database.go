@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"encoding/base64"
+	"strings"
 	"time"
 
 	"github.com/grafana/grafana/pkg/infra/log"
@@ -212,6 +213,34 @@ func (s *AuthInfoStore) UpdateAuthInfo(ctx context.Context, cmd *models.UpdateAu
 	})
 }
 
+// UpdateAuthInfoLastSynced sets the time the user was last synced from the auth module to now.
+func (s *AuthInfoStore) UpdateAuthInfoLastSynced(ctx context.Context, cmd *models.UpdateAuthInfoLastSyncedCommand) error {
+	authInfo := &models.UserAuth{LastSynced: GetTime()}
+
+	cond := &models.UserAuth{
+		UserId:     cmd.UserId,
+		AuthModule: cmd.AuthModule,
+	}
+	return s.sqlStore.WithTransactionalDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		_, err := sess.Cols("last_synced").Update(authInfo, cond)
+		return err
+	})
+}
+
+// GetAuthInfoNotSyncedSince finds the auth info of the users not synced since the given time, ordered by user ID.
+func (s *AuthInfoStore) GetAuthInfoNotSyncedSince(ctx context.Context, query *models.GetAuthInfoNotSyncedSinceQuery) error {
+	return s.sqlStore.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		query.Result = make([]*models.UserAuth, 0)
+
+		sess.Cols("id", "user_id", "auth_module", "auth_id", "created", "last_synced").
+			Where("(last_synced IS NULL OR last_synced < ?)", query.Since)
+		if query.AuthModule != "" {
+			sess.And("auth_module = ?", query.AuthModule)
+		}
+		return sess.Asc("user_id").Find(&query.Result)
+	})
+}
+
 func (s *AuthInfoStore) DeleteAuthInfo(ctx context.Context, cmd *models.DeleteAuthInfoCommand) error {
 	return s.sqlStore.WithTransactionalDbSession(ctx, func(sess *sqlstore.DBSession) error {
 		_, err := sess.Delete(cmd.UserAuth)
@@ -237,8 +266,11 @@ func (s *AuthInfoStore) GetUserByLogin(ctx context.Context, login string) (*user
 	return query.Result, nil
 }
 
+// GetUserByEmail finds the user with the given email. Upstream identity providers do not preserve the casing of the
+// emails Grafana stored, so the email is trimmed and matched regardless of its casing. If several users have the email
+// with different casings, none of them is returned, so that an external identity is never linked to the wrong user.
 func (s *AuthInfoStore) GetUserByEmail(ctx context.Context, email string) (*user.User, error) {
-	query := models.GetUserByEmailQuery{Email: email}
+	query := models.GetUserByEmailQuery{Email: strings.ToLower(strings.TrimSpace(email)), CaseInsensitive: true}
 	if err := s.sqlStore.GetUserByEmail(ctx, &query); err != nil {
 		return nil, err
 	}
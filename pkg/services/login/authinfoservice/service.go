@@ -188,6 +188,14 @@ func (s *Implementation) UpdateAuthInfo(ctx context.Context, cmd *models.UpdateA
 	return s.authInfoStore.UpdateAuthInfo(ctx, cmd)
 }
 
+func (s *Implementation) UpdateAuthInfoLastSynced(ctx context.Context, cmd *models.UpdateAuthInfoLastSyncedCommand) error {
+	return s.authInfoStore.UpdateAuthInfoLastSynced(ctx, cmd)
+}
+
+func (s *Implementation) GetAuthInfoNotSyncedSince(ctx context.Context, query *models.GetAuthInfoNotSyncedSinceQuery) error {
+	return s.authInfoStore.GetAuthInfoNotSyncedSince(ctx, query)
+}
+
 func (s *Implementation) SetAuthInfo(ctx context.Context, cmd *models.SetAuthInfoCommand) error {
 	return s.authInfoStore.SetAuthInfo(ctx, cmd)
 }
@@ -65,6 +65,12 @@ func TestUserAuth(t *testing.T) {
 			require.Nil(t, err)
 			require.Equal(t, user.Email, email)
 
+			// By differently cased email
+			user, err = srv.LookupByOneOf(context.Background(), 0, " User1@Test.COM ", "")
+
+			require.Nil(t, err)
+			require.Equal(t, user.Email, email)
+
 			// Don't find nonexistent user
 			email = "nonexistent@test.com"
 
@@ -74,6 +80,20 @@ func TestUserAuth(t *testing.T) {
 			require.Nil(t, user)
 		})
 
+		t.Run("Sync finds the existing user by a differently cased email", func(t *testing.T) {
+			usr, err := sqlStore.CreateUser(context.Background(), user.CreateUserCommand{
+				Email: "Mixed.Case@test.com",
+				Login: "mixedcase",
+			})
+			require.NoError(t, err)
+
+			query := &models.GetUserByAuthInfoQuery{AuthModule: "ldap", AuthId: "uid=mixedcase", Email: "mixed.case@TEST.com"}
+			found, err := srv.LookupAndUpdate(context.Background(), query)
+
+			require.NoError(t, err)
+			require.Equal(t, usr.ID, found.ID)
+		})
+
 		t.Run("Can set & locate by AuthModule and AuthId", func(t *testing.T) {
 			// get nonexistent user_auth entry
 			query := &models.GetUserByAuthInfoQuery{AuthModule: "test", AuthId: "test"}
@@ -392,3 +412,101 @@ func TestUserAuth(t *testing.T) {
 		})
 	})
 }
+
+func TestUserAuth_EmailsDifferingByCase(t *testing.T) {
+	sqlStore := sqlstore.InitTestDB(t)
+	if sqlStore.GetDialect().DriverName() == "mysql" {
+		t.Skip("mysql makes the unique constraint on emails case insensitive")
+	}
+	secretsService := secretsManager.SetupTestService(t, secretstore.ProvideSecretsStore(sqlStore))
+	authInfoStore := database.ProvideAuthInfoStore(sqlStore, secretsService)
+	srv := ProvideAuthInfoService(
+		&OSSUserProtectionImpl{},
+		authInfoStore,
+		&usagestats.UsageStatsMock{},
+	)
+
+	for _, cmd := range []user.CreateUserCommand{
+		{Email: "Bob@test.com", Login: "bob-upper"},
+		{Email: "bob@test.com", Login: "bob-lower"},
+	} {
+		_, err := sqlStore.CreateUser(context.Background(), cmd)
+		require.NoError(t, err)
+	}
+
+	query := &models.GetUserByAuthInfoQuery{AuthModule: "ldap", AuthId: "uid=bob", Email: "BOB@test.com"}
+	found, err := srv.LookupAndUpdate(context.Background(), query)
+
+	require.ErrorIs(t, err, models.ErrCaseInsensitive)
+	require.Nil(t, found)
+}
+
+func TestUserAuth_LastSynced(t *testing.T) {
+	sqlStore := sqlstore.InitTestDB(t)
+	secretsService := secretsManager.SetupTestService(t, secretstore.ProvideSecretsStore(sqlStore))
+	authInfoStore := database.ProvideAuthInfoStore(sqlStore, secretsService)
+	srv := ProvideAuthInfoService(
+		&OSSUserProtectionImpl{},
+		authInfoStore,
+		&usagestats.UsageStatsMock{},
+	)
+	t.Cleanup(func() { database.GetTime = time.Now })
+
+	users := make([]*user.User, 0, 2)
+	for i := 0; i < 2; i++ {
+		usr, err := sqlStore.CreateUser(context.Background(), user.CreateUserCommand{
+			Email: fmt.Sprint("synced", i, "@test.com"),
+			Login: fmt.Sprint("synced", i),
+		})
+		require.NoError(t, err)
+		_, err = srv.LookupAndUpdate(context.Background(), &models.GetUserByAuthInfoQuery{
+			UserId: usr.ID, AuthModule: "ldap", AuthId: fmt.Sprint("uid=synced", i),
+		})
+		require.NoError(t, err)
+		users = append(users, usr)
+	}
+
+	fixedTime := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("users never synced are not synced since any time", func(t *testing.T) {
+		query := &models.GetAuthInfoNotSyncedSinceQuery{AuthModule: "ldap", Since: fixedTime}
+		require.NoError(t, srv.GetAuthInfoNotSyncedSince(context.Background(), query))
+
+		require.Len(t, query.Result, 2)
+	})
+
+	t.Run("a sync updates the timestamp", func(t *testing.T) {
+		database.GetTime = func() time.Time { return fixedTime.AddDate(0, 0, -10) }
+		require.NoError(t, srv.UpdateAuthInfoLastSynced(context.Background(), &models.UpdateAuthInfoLastSyncedCommand{
+			UserId: users[0].ID, AuthModule: "ldap",
+		}))
+		require.NoError(t, srv.UpdateAuthInfoLastSynced(context.Background(), &models.UpdateAuthInfoLastSyncedCommand{
+			UserId: users[1].ID, AuthModule: "ldap",
+		}))
+
+		database.GetTime = func() time.Time { return fixedTime }
+		require.NoError(t, srv.UpdateAuthInfoLastSynced(context.Background(), &models.UpdateAuthInfoLastSyncedCommand{
+			UserId: users[0].ID, AuthModule: "ldap",
+		}))
+
+		query := &models.GetAuthInfoQuery{UserId: users[0].ID, AuthModule: "ldap"}
+		require.NoError(t, srv.GetAuthInfo(context.Background(), query))
+		require.True(t, fixedTime.Equal(query.Result.LastSynced), "got %s", query.Result.LastSynced)
+	})
+
+	t.Run("users not synced since a time are found", func(t *testing.T) {
+		query := &models.GetAuthInfoNotSyncedSinceQuery{AuthModule: "ldap", Since: fixedTime.AddDate(0, 0, -7)}
+		require.NoError(t, srv.GetAuthInfoNotSyncedSince(context.Background(), query))
+
+		require.Len(t, query.Result, 1)
+		require.Equal(t, users[1].ID, query.Result[0].UserId)
+		require.Empty(t, query.Result[0].OAuthAccessToken)
+	})
+
+	t.Run("other auth modules are left out", func(t *testing.T) {
+		query := &models.GetAuthInfoNotSyncedSinceQuery{AuthModule: "oauth_okta", Since: fixedTime}
+		require.NoError(t, srv.GetAuthInfoNotSyncedSince(context.Background(), query))
+
+		require.Empty(t, query.Result)
+	})
+}
@@ -3,6 +3,7 @@ package login
 import (
 	"context"
 	"errors"
+	"sort"
 
 	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/services/user"
@@ -13,13 +14,158 @@ var (
 	ErrUsersQuotaReached  = errors.New("users quota reached")
 	ErrGettingUserQuota   = errors.New("error getting user quota")
 	ErrSignupNotAllowed   = errors.New("system administrator has disabled signup")
+	ErrTooManyOrgs        = errors.New("too many organizations for a single sync")
+	ErrSyncPlanOutdated   = errors.New("the organization memberships of the user changed since the sync was planned")
 )
 
 type TeamSyncFunc func(user *user.User, externalUser *models.ExternalUserInfo) error
 
+// OrgMapping is a role an external user is given in an org.
+type OrgMapping struct {
+	OrgID int64
+	Role  models.RoleType
+}
+
+// OrgMappingProvider decides the org roles of external users when they are synced, so that they can come from
+// another source than the authentication module, such as an HR system.
+type OrgMappingProvider interface {
+	// MappingsFor returns the roles of the external user in each org. If several mappings are for the same org,
+	// the highest role applies.
+	MappingsFor(ctx context.Context, user *models.ExternalUserInfo) ([]OrgMapping, error)
+}
+
+// ExternalUserOrgMappings is the default OrgMappingProvider, which gives external users the org roles their
+// authentication module maps them to. Other providers can use it to add to these roles rather than replace them.
+type ExternalUserOrgMappings struct{}
+
+func (ExternalUserOrgMappings) MappingsFor(_ context.Context, user *models.ExternalUserInfo) ([]OrgMapping, error) {
+	mappings := make([]OrgMapping, 0, len(user.OrgRoles))
+	for orgID, role := range user.OrgRoles {
+		mappings = append(mappings, OrgMapping{OrgID: orgID, Role: role})
+	}
+	sort.Slice(mappings, func(i, j int) bool { return mappings[i].OrgID < mappings[j].OrgID })
+	return mappings, nil
+}
+
+// SyncPlanAction is what syncing a user would do to its membership of an org.
+type SyncPlanAction string
+
+const (
+	SyncPlanAdd    SyncPlanAction = "add"
+	SyncPlanUpdate SyncPlanAction = "update"
+	SyncPlanRemove SyncPlanAction = "remove"
+	SyncPlanKeep   SyncPlanAction = "keep"
+)
+
+// OrgRoleChange is what syncing a user would do to its membership of an org.
+type OrgRoleChange struct {
+	OrgID       int64
+	OrgName     string
+	Action      SyncPlanAction
+	CurrentRole models.RoleType
+	Role        models.RoleType
+	// Reason tells why a membership the mappings would change or remove is kept instead.
+	Reason string
+}
+
+// SyncPlan is what syncing a user with a set of org mappings would change, without changing anything.
+type SyncPlan struct {
+	UserID    int64
+	UserLogin string
+	// Changes has an entry for each org the user is a member of or mapped into, sorted by org ID.
+	Changes []OrgRoleChange
+	// MissingOrgIDs are the orgs the mappings map into that do not exist, which the sync skips.
+	MissingOrgIDs []int64
+}
+
+// PlannedSync is a SyncPlan that can be applied. Token hashes the memberships of the user the plan was made from
+// along with the planned changes, so that applying the plan fails if either changed since.
+type PlannedSync struct {
+	SyncPlan
+	Token string
+}
+
+// PlannedRemoval is a membership of an org that syncing a user would remove, along with the teams of the org the
+// user would be removed from with it.
+type PlannedRemoval struct {
+	OrgID   int64
+	OrgName string
+	Role    models.RoleType
+	TeamIDs []int64
+}
+
+// UserRemovalPlan is what syncing a user with its org mappings would take away from them, without changing anything.
+type UserRemovalPlan struct {
+	UserID    int64
+	UserLogin string
+	Email     string
+	// Removals are sorted by org ID.
+	Removals []PlannedRemoval
+}
+
+// SyncEventType is the kind of change the sync of an external user made.
+type SyncEventType string
+
+const (
+	SyncEventUserCreated           SyncEventType = "user_created"
+	SyncEventUserUpdated           SyncEventType = "user_updated"
+	SyncEventUserEnabled           SyncEventType = "user_enabled"
+	SyncEventUserDisabled          SyncEventType = "user_disabled"
+	SyncEventGrafanaAdminUpdated   SyncEventType = "grafana_admin_updated"
+	SyncEventOrgUserAdded          SyncEventType = "org_user_added"
+	SyncEventOrgRoleUpdated        SyncEventType = "org_role_updated"
+	SyncEventOrgUserRemoved        SyncEventType = "org_user_removed"
+	SyncEventTeamMemberAdded       SyncEventType = "team_member_added"
+	SyncEventTeamPermissionUpdated SyncEventType = "team_permission_updated"
+)
+
+// SyncEvent is a change the sync of an external user made, once it is written.
+type SyncEvent struct {
+	Type      SyncEventType
+	UserID    int64
+	UserLogin string
+	// OrgID is set for the changes to org and team memberships.
+	OrgID int64
+	// Role is the org role the user was given, and PreviousRole the one it had before, if any.
+	Role         models.RoleType
+	PreviousRole models.RoleType
+	// TeamID and Permission are set for the changes to team memberships.
+	TeamID     int64
+	Permission models.PermissionType
+	// IsGrafanaAdmin is the Grafana admin permission the user was given.
+	IsGrafanaAdmin bool
+}
+
+// SyncEventEmitter is told about the changes the sync of external users makes, so that they can be forwarded to an
+// audit pipeline or a message bus. Emit is called synchronously after each change, so it should not block. The
+// changes made by the team sync function are not emitted.
+type SyncEventEmitter interface {
+	Emit(event SyncEvent)
+}
+
+// NopSyncEventEmitter is the default SyncEventEmitter, which drops the events.
+type NopSyncEventEmitter struct{}
+
+func (NopSyncEventEmitter) Emit(SyncEvent) {}
+
 type Service interface {
 	CreateUser(cmd user.CreateUserCommand) (*user.User, error)
 	UpsertUser(ctx context.Context, cmd *models.UpsertUserCommand) error
 	DisableExternalUser(ctx context.Context, username string) error
 	SetTeamSyncFunc(TeamSyncFunc)
+	SetOrgMappingProvider(OrgMappingProvider)
+	SetSyncEventEmitter(SyncEventEmitter)
+	// OrphanedOrgMappings returns the mappings that map into an org that does not exist, e.g. because it was
+	// deleted since the mappings were written.
+	OrphanedOrgMappings(ctx context.Context, mappings []OrgMapping) ([]OrgMapping, error)
+	// PlanMappings returns what syncing the user with the given email with the mappings would change.
+	PlanMappings(ctx context.Context, email string, mappings []OrgMapping) (*SyncPlan, error)
+	// PlanRemovals returns the memberships syncing each user with their mappings would remove.
+	PlanRemovals(ctx context.Context, mappings map[string][]OrgMapping) ([]UserRemovalPlan, error)
+	// PlanSync returns what syncing the user with the given email with the mappings would change, as a plan that
+	// ApplySync can apply.
+	PlanSync(ctx context.Context, email string, mappings []OrgMapping) (*PlannedSync, error)
+	// ApplySync makes exactly the changes of the plan, or returns ErrSyncPlanOutdated if the memberships of the user
+	// changed since the plan was made.
+	ApplySync(ctx context.Context, plan *PlannedSync) error
 }
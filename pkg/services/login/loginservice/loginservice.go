@@ -2,41 +2,113 @@ package loginservice
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/grafana/grafana/pkg/infra/log"
 	"github.com/grafana/grafana/pkg/models"
+	ac "github.com/grafana/grafana/pkg/services/accesscontrol"
 	"github.com/grafana/grafana/pkg/services/login"
+	pref "github.com/grafana/grafana/pkg/services/preference"
 	"github.com/grafana/grafana/pkg/services/quota"
 	"github.com/grafana/grafana/pkg/services/sqlstore"
 	"github.com/grafana/grafana/pkg/services/user"
+	"github.com/grafana/grafana/pkg/setting"
 )
 
+// The lines logged while syncing a user carry the user_id, user_login, user_email and auth_module of the user, and
+// use snake_case keys such as org_id, org_name, team_id, team_name, role and error for the rest of their context.
 var (
 	logger = log.New("login.ext_user")
 )
 
 func ProvideService(
+	cfg *setting.Cfg,
 	sqlStore sqlstore.Store,
 	userService user.Service,
 	quotaService *quota.QuotaService,
 	authInfoService login.AuthInfoService,
-) *Implementation {
+	preferenceService pref.Service,
+	accessControl ac.AccessControl,
+) (*Implementation, error) {
+	defaultTeamPermission, err := parseTeamPermission(cfg.TeamSyncDefaultPermission)
+	if err != nil {
+		return nil, fmt.Errorf("invalid team_sync_default_permission: %w", err)
+	}
+
+	minOrgRoles := make(map[int64]models.RoleType, len(cfg.SyncMinOrgRoles))
+	for orgID, role := range cfg.SyncMinOrgRoles {
+		if !models.RoleType(role).IsValid() {
+			return nil, fmt.Errorf("invalid sync_min_org_roles: %q is not a valid role", role)
+		}
+		minOrgRoles[orgID] = models.RoleType(role)
+	}
+
 	s := &Implementation{
-		SQLStore:        sqlStore,
-		userService:     userService,
-		QuotaService:    quotaService,
-		AuthInfoService: authInfoService,
+		SQLStore:              sqlStore,
+		userService:           userService,
+		QuotaService:          quotaService,
+		AuthInfoService:       authInfoService,
+		PreferenceService:     preferenceService,
+		AccessControl:         accessControl,
+		defaultTeamPermission: defaultTeamPermission,
+		excludedOrgs:          cfg.SyncExcludedOrgs,
+		orgNameAliases:        cfg.OrgNameAliases,
+		minOrgRoles:           minOrgRoles,
+		maxOrgs:               cfg.SyncMaxOrgs,
+		defaultTeams:          cfg.SyncDefaultTeams,
+		editorsCanAdmin:       cfg.EditorsCanAdmin,
+		SyncEvents:            login.NopSyncEventEmitter{},
 	}
-	return s
+	return s, nil
 }
 
 type Implementation struct {
-	SQLStore        sqlstore.Store
-	userService     user.Service
-	AuthInfoService login.AuthInfoService
-	QuotaService    *quota.QuotaService
-	TeamSync        login.TeamSyncFunc
+	SQLStore          sqlstore.Store
+	userService       user.Service
+	AuthInfoService   login.AuthInfoService
+	QuotaService      *quota.QuotaService
+	TeamSync          login.TeamSyncFunc
+	PreferenceService pref.Service
+	// OrgMappings decides the org roles of external users. If nil, they get the roles of the external user.
+	OrgMappings login.OrgMappingProvider
+	// SyncEvents is told about the changes the sync makes. If nil, they are not emitted.
+	SyncEvents login.SyncEventEmitter
+	// AccessControl resolves the permissions of the user a sync acts on behalf of. If nil, or if access control is
+	// disabled, the org roles of the user decide what it can do.
+	AccessControl ac.AccessControl
+
+	// defaultTeamPermission is the permission synced team memberships that do not specify one give.
+	defaultTeamPermission models.PermissionType
+	// excludedOrgs are the IDs or names of the orgs the org role sync never removes users from.
+	excludedOrgs []string
+	// orgNameAliases maps other names orgs are known by to their names.
+	orgNameAliases map[string]string
+	// minOrgRoles are the lowest roles synced users get in these orgs, keyed by org ID.
+	minOrgRoles map[int64]models.RoleType
+	// maxOrgs is the most orgs a single sync may map a user into, or 0 for no limit.
+	maxOrgs int
+	// defaultTeams are the teams users join when the sync adds them to these orgs, keyed by org ID.
+	defaultTeams map[int64]int64
+	// editorsCanAdmin lets Editors administer teams when access control is disabled.
+	editorsCanAdmin bool
+}
+
+// parseTeamPermission returns the team permission with the given name, Member or Admin.
+func parseTeamPermission(name string) (models.PermissionType, error) {
+	switch name {
+	case "Member":
+		return 0, nil
+	case "Admin":
+		return models.PERMISSION_ADMIN, nil
+	default:
+		return 0, fmt.Errorf("unknown team permission %q, must be Member or Admin", name)
+	}
 }
 
 // CreateUser creates inserts a new one.
@@ -47,6 +119,26 @@ func (ls *Implementation) CreateUser(cmd user.CreateUserCommand) (*user.User, er
 // UpsertUser updates an existing user, or if it doesn't exist, inserts a new one.
 func (ls *Implementation) UpsertUser(ctx context.Context, cmd *models.UpsertUserCommand) error {
 	extUser := cmd.ExternalUser
+	syncLog := logger.New("auth_module", extUser.AuthModule, "user_login", extUser.Login, "user_email", extUser.Email)
+
+	// The org roles are mapped first, so that a sync exceeding the org limit changes nothing.
+	mapped, conflicts, err := ls.mapOrgRoles(ctx, extUser)
+	if err != nil {
+		return err
+	}
+	if ls.maxOrgs > 0 && cmd.ConstrainToOrg == 0 && len(mapped.OrgRoles) > ls.maxOrgs {
+		syncLog.Warn("Not syncing a user mapped into too many organizations", "org_count", len(mapped.OrgRoles), "max_orgs", ls.maxOrgs)
+		return fmt.Errorf("%w: the user is mapped into %d organizations, more than the limit of %d set by sync_max_orgs",
+			login.ErrTooManyOrgs, len(mapped.OrgRoles), ls.maxOrgs)
+	}
+
+	// The lookup below records the current login, so the previous one has to be read first.
+	var lastLogin *models.UserAuth
+	if cmd.ProfileSync == models.ProfileSyncUnlessEdited {
+		if lastLogin, err = ls.lastExternalLogin(ctx, extUser); err != nil {
+			return err
+		}
+	}
 
 	usr, err := ls.AuthInfoService.LookupAndUpdate(ctx, &models.GetUserByAuthInfoQuery{
 		AuthModule: extUser.AuthModule,
@@ -60,13 +152,14 @@ func (ls *Implementation) UpsertUser(ctx context.Context, cmd *models.UpsertUser
 			return err
 		}
 		if !cmd.SignupAllowed {
-			cmd.ReqContext.Logger.Warn("Not allowing login, user not found in internal user database and allow signup = false", "authmode", extUser.AuthModule)
+			cmd.ReqContext.Logger.Warn("Not allowing login, user not found in internal user database and allow signup = false",
+				"auth_module", extUser.AuthModule, "user_login", extUser.Login)
 			return login.ErrSignupNotAllowed
 		}
 
 		limitReached, err := ls.QuotaService.QuotaReached(cmd.ReqContext, "user")
 		if err != nil {
-			cmd.ReqContext.Logger.Warn("Error getting user quota.", "error", err)
+			cmd.ReqContext.Logger.Warn("Error getting user quota.", "user_login", extUser.Login, "error", err)
 			return login.ErrGettingUserQuota
 		}
 		if limitReached {
@@ -77,6 +170,8 @@ func (ls *Implementation) UpsertUser(ctx context.Context, cmd *models.UpsertUser
 		if err != nil {
 			return err
 		}
+		syncLog = syncLog.New("user_id", result.ID)
+		ls.emit(result, login.SyncEvent{Type: login.SyncEventUserCreated})
 
 		cmd.Result = &user.User{
 			ID:               result.ID,
@@ -113,15 +208,16 @@ func (ls *Implementation) UpsertUser(ctx context.Context, cmd *models.UpsertUser
 		}
 	} else {
 		cmd.Result = usr
+		syncLog = syncLog.New("user_id", usr.ID)
 
-		err = ls.updateUser(ctx, cmd.Result, extUser)
+		err = ls.updateUser(ctx, syncLog, cmd.Result, extUser, shouldSyncProfile(cmd.ProfileSync, cmd.Result, lastLogin))
 		if err != nil {
 			return err
 		}
 
 		// Always persist the latest token at log-in
 		if extUser.AuthModule != "" && extUser.OAuthToken != nil {
-			err = ls.updateUserAuth(ctx, cmd.Result, extUser)
+			err = ls.updateUserAuth(ctx, syncLog, cmd.Result, extUser)
 			if err != nil {
 				return err
 			}
@@ -132,17 +228,56 @@ func (ls *Implementation) UpsertUser(ctx context.Context, cmd *models.UpsertUser
 			if err := ls.SQLStore.DisableUser(ctx, &models.DisableUserCommand{UserId: cmd.Result.ID, IsDisabled: false}); err != nil {
 				return err
 			}
+			ls.emit(cmd.Result, login.SyncEvent{Type: login.SyncEventUserEnabled})
+		}
+	}
+
+	extUser, cmd.OrgMappingConflicts = mapped, conflicts
+	for _, conflict := range cmd.OrgMappingConflicts {
+		syncLog.Warn("Organization mappings give the user different roles in the same organization, applying the highest",
+			"org_id", conflict.OrgId, "roles", conflict.Roles, "applied_role", conflict.AppliedRole)
+	}
+
+	if cmd.ConstrainToOrg != 0 {
+		extUser, cmd.RejectedOrgIds = constrainOrgRoles(extUser, cmd.ConstrainToOrg)
+		if len(cmd.RejectedOrgIds) > 0 {
+			syncLog.Warn("Rejecting organization roles outside of the organization the sync is constrained to",
+				"constrain_to_org", cmd.ConstrainToOrg, "org_ids", cmd.RejectedOrgIds)
 		}
 	}
 
-	if err := ls.syncOrgRoles(ctx, cmd.Result, extUser); err != nil {
+	extUser, cmd.InvalidRoles, err = checkOrgRoles(syncLog, extUser, cmd.SkipInvalidRoles, cmd.InvalidRoleFallback)
+	if err != nil {
+		return err
+	}
+
+	extUser = applyMinOrgRoles(syncLog, extUser, ls.minOrgRoles, cmd.ConstrainToOrg)
+
+	allowedOrgIds, err := ls.resolveOrgFilter(ctx, syncLog, cmd.OrgFilter)
+	if err != nil {
+		return err
+	}
+	if cmd.ConstrainToOrg != 0 {
+		allowedOrgIds = map[int64]bool{cmd.ConstrainToOrg: allowedOrgIds == nil || allowedOrgIds[cmd.ConstrainToOrg]}
+	}
+
+	skipped, filtered, keptAdmins, err := ls.syncOrgRoles(ctx, syncLog, cmd.Result, extUser, cmd.NoDowngrade, allowedOrgIds)
+	if err != nil {
 		return err
 	}
+	cmd.SkippedDowngrades = skipped
+	cmd.FilteredOrgIds = filtered
+	cmd.KeptLastAdmins = keptAdmins
 
-	// Sync isGrafanaAdmin permission
+	// Sync isGrafanaAdmin permission. It applies to every org, so a sync constrained to an org leaves it alone.
 	if extUser.IsGrafanaAdmin != nil && *extUser.IsGrafanaAdmin != cmd.Result.IsAdmin {
-		if err := ls.SQLStore.UpdateUserPermissions(cmd.Result.ID, *extUser.IsGrafanaAdmin); err != nil {
+		if cmd.ConstrainToOrg != 0 {
+			syncLog.Warn("Not syncing the Grafana admin permission of a sync constrained to an organization",
+				"constrain_to_org", cmd.ConstrainToOrg)
+		} else if err := ls.SQLStore.UpdateUserPermissions(cmd.Result.ID, *extUser.IsGrafanaAdmin); err != nil {
 			return err
+		} else {
+			ls.emit(cmd.Result, login.SyncEvent{Type: login.SyncEventGrafanaAdminUpdated, IsGrafanaAdmin: *extUser.IsGrafanaAdmin})
 		}
 	}
 
@@ -153,9 +288,63 @@ func (ls *Implementation) UpsertUser(ctx context.Context, cmd *models.UpsertUser
 		}
 	}
 
+	skippedTeamOrgs, err := ls.syncInheritedTeamPermissions(ctx, syncLog, cmd.Result, extUser, allowedOrgIds, cmd.ActingUser)
+	if err != nil {
+		return err
+	}
+	cmd.SkippedTeamOrgIds = skippedTeamOrgs
+
+	// Recording the sync once everything is written lets users whose syncs stopped be told apart.
+	if cmd.ExternalUser.AuthModule != "" {
+		if err := ls.AuthInfoService.UpdateAuthInfoLastSynced(ctx, &models.UpdateAuthInfoLastSyncedCommand{
+			UserId:     cmd.Result.ID,
+			AuthModule: cmd.ExternalUser.AuthModule,
+		}); err != nil {
+			return err
+		}
+	}
+
+	if cmd.ReportMemberships {
+		memberships, err := ls.orgMemberships(ctx, cmd.Result)
+		if err != nil {
+			return err
+		}
+		cmd.Memberships = memberships
+	}
+
 	return nil
 }
 
+// orgMemberships reads the orgs the user belongs to, with their role and team memberships in each of them.
+func (ls *Implementation) orgMemberships(ctx context.Context, usr *user.User) ([]models.OrgMembership, error) {
+	orgsQuery := &models.GetUserOrgListQuery{UserId: usr.ID}
+	if err := ls.SQLStore.GetUserOrgList(ctx, orgsQuery); err != nil {
+		return nil, err
+	}
+
+	memberships := make([]models.OrgMembership, 0, len(orgsQuery.Result))
+	for _, org := range orgsQuery.Result {
+		teams, err := ls.SQLStore.GetUserTeamMemberships(ctx, org.OrgId, usr.ID, false)
+		if err != nil {
+			return nil, err
+		}
+		membership := models.OrgMembership{
+			OrgId:   org.OrgId,
+			OrgName: org.Name,
+			Role:    org.Role,
+			Teams:   make([]models.TeamMembership, 0, len(teams)),
+		}
+		for _, team := range teams {
+			membership.Teams = append(membership.Teams, models.TeamMembership{TeamId: team.TeamId, Permission: team.Permission})
+		}
+		sort.Slice(membership.Teams, func(i, j int) bool { return membership.Teams[i].TeamId < membership.Teams[j].TeamId })
+		memberships = append(memberships, membership)
+	}
+	sort.Slice(memberships, func(i, j int) bool { return memberships[i].OrgId < memberships[j].OrgId })
+
+	return memberships, nil
+}
+
 func (ls *Implementation) DisableExternalUser(ctx context.Context, username string) error {
 	// Check if external user exist in Grafana
 	userQuery := &models.GetExternalUserInfoByLoginQuery{
@@ -171,11 +360,7 @@ func (ls *Implementation) DisableExternalUser(ctx context.Context, username stri
 		return nil
 	}
 
-	logger.Debug(
-		"Disabling external user",
-		"user",
-		userQuery.Result.Login,
-	)
+	logger.Debug("Disabling external user", "user_id", userInfo.UserId, "user_login", userInfo.Login)
 
 	// Mark user as disabled in grafana db
 	disableUserCmd := &models.DisableUserCommand{
@@ -184,23 +369,507 @@ func (ls *Implementation) DisableExternalUser(ctx context.Context, username stri
 	}
 
 	if err := ls.SQLStore.DisableUser(ctx, disableUserCmd); err != nil {
-		logger.Debug(
-			"Error disabling external user",
-			"user",
-			userQuery.Result.Login,
-			"message",
-			err.Error(),
-		)
+		logger.Debug("Error disabling external user", "user_id", userInfo.UserId, "user_login", userInfo.Login, "error", err)
 		return err
 	}
+	ls.emit(&user.User{ID: userInfo.UserId, Login: userInfo.Login}, login.SyncEvent{Type: login.SyncEventUserDisabled})
 	return nil
 }
 
+// syncInheritedTeamPermissions gives the user the permission their org role implies on every team of the org.
+// It runs after the team sync, so that it also applies to the teams the user was explicitly added to. The membership
+// is only written when the user is not a member of the team yet, or has a lower permission on it. A permission the
+// user already has on a team is never lowered, so syncing the same user again changes nothing.
+//
+// Without an acting user, every team of the org is read. With one, only the teams the acting user can read are, and
+// the orgs where it cannot read teams are skipped and returned, ordered by ID.
+func (ls *Implementation) syncInheritedTeamPermissions(ctx context.Context, syncLog log.Logger, usr *user.User, extUser *models.ExternalUserInfo, allowedOrgIds map[int64]bool, actingUser *models.SignedInUser) ([]int64, error) {
+	permissions := make(map[int64]models.PermissionType, len(extUser.TeamPermissions))
+	for orgID, permission := range extUser.TeamPermissions {
+		permissions[orgID] = permission
+	}
+	for orgID := range extUser.DefaultTeamPermissionOrgs {
+		if current, ok := permissions[orgID]; !ok || ls.defaultTeamPermission > current {
+			permissions[orgID] = ls.defaultTeamPermission
+		}
+	}
+
+	orgIDs := make([]int64, 0, len(permissions))
+	for orgID := range permissions {
+		if allowedOrgIds == nil || allowedOrgIds[orgID] {
+			orgIDs = append(orgIDs, orgID)
+		}
+	}
+	sort.Slice(orgIDs, func(i, j int) bool { return orgIDs[i] < orgIDs[j] })
+
+	var skipped []int64
+	for _, orgID := range orgIDs {
+		permission := permissions[orgID]
+
+		teamReader, err := ls.teamReaderFor(ctx, actingUser, orgID)
+		if err != nil {
+			return nil, err
+		}
+		if teamReader == nil {
+			syncLog.Warn("Not assigning teams in an organization whose teams the acting user cannot read", "org_id", orgID,
+				"acting_user", actingUser.Login)
+			skipped = append(skipped, orgID)
+			continue
+		}
+		teamsQuery := &models.SearchTeamsQuery{
+			OrgId:        orgID,
+			UserIdFilter: models.FilterIgnoreUser,
+			SignedInUser: teamReader,
+		}
+		if err := ls.SQLStore.SearchTeams(ctx, teamsQuery); err != nil {
+			return nil, err
+		}
+
+		memberships, err := ls.SQLStore.GetUserTeamMemberships(ctx, orgID, usr.ID, false)
+		if err != nil {
+			return nil, err
+		}
+		current := make(map[int64]models.PermissionType, len(memberships))
+		for _, membership := range memberships {
+			current[membership.TeamId] = membership.Permission
+		}
+
+		for _, team := range teamsQuery.Result.Teams {
+			existing, isMember := current[team.Id]
+			switch {
+			case !isMember:
+				syncLog.Debug("Adding user to team implied by org role", "org_id", orgID, "team_id", team.Id, "team_name", team.Name)
+				if err := ls.SQLStore.AddTeamMember(usr.ID, orgID, team.Id, true, permission); err != nil {
+					return nil, err
+				}
+				ls.emit(usr, login.SyncEvent{Type: login.SyncEventTeamMemberAdded, OrgID: orgID, TeamID: team.Id, Permission: permission})
+			case existing < permission:
+				syncLog.Debug("Raising team permission implied by org role", "org_id", orgID, "team_id", team.Id, "team_name", team.Name)
+				cmd := &models.UpdateTeamMemberCommand{UserId: usr.ID, OrgId: orgID, TeamId: team.Id, Permission: permission}
+				if err := ls.SQLStore.UpdateTeamMember(ctx, cmd); err != nil {
+					return nil, err
+				}
+				ls.emit(usr, login.SyncEvent{Type: login.SyncEventTeamPermissionUpdated, OrgID: orgID, TeamID: team.Id, Permission: permission})
+			default:
+				syncLog.Debug("Team permission implied by org role unchanged", "org_id", orgID, "team_id", team.Id, "team_name", team.Name,
+					"permission", existing)
+			}
+		}
+	}
+
+	return skipped, nil
+}
+
+// teamReaderFor returns the identity to list the teams of the org with, or nil if the acting user cannot read them.
+// Without an acting user, it can read every team of the org. The permissions the acting user signed in with are only
+// those of its current org, so it is given its role in the org, and its permissions are resolved for it. With access
+// control disabled, it can read the teams of the orgs it is an Admin of, or an Editor of if Editors can administer
+// teams, and of every org if it is a Grafana Admin.
+func (ls *Implementation) teamReaderFor(ctx context.Context, actingUser *models.SignedInUser, orgID int64) (*models.SignedInUser, error) {
+	if actingUser == nil {
+		return &models.SignedInUser{
+			OrgId: orgID,
+			Permissions: map[int64]map[string][]string{
+				orgID: {
+					ac.ActionTeamsRead: {ac.ScopeTeamsAll},
+				},
+			},
+		}, nil
+	}
+
+	reader := *actingUser
+	if orgID != actingUser.OrgId {
+		orgsQuery := &models.GetUserOrgListQuery{UserId: actingUser.UserId}
+		if err := ls.SQLStore.GetUserOrgList(ctx, orgsQuery); err != nil {
+			return nil, err
+		}
+		reader.OrgId = orgID
+		reader.OrgName = ""
+		reader.OrgRole = ""
+		for _, org := range orgsQuery.Result {
+			if org.OrgId == orgID {
+				reader.OrgName = org.Name
+				reader.OrgRole = org.Role
+			}
+		}
+	}
+
+	if ls.AccessControl == nil || ls.AccessControl.IsDisabled() {
+		if reader.IsGrafanaAdmin || reader.OrgRole == models.ROLE_ADMIN || (ls.editorsCanAdmin && reader.OrgRole == models.ROLE_EDITOR) {
+			return &reader, nil
+		}
+		return nil, nil
+	}
+
+	permissions, err := ls.AccessControl.GetUserPermissions(ctx, &reader, ac.Options{ReloadCache: true})
+	if err != nil {
+		return nil, err
+	}
+	reader.Permissions = map[int64]map[string][]string{orgID: ac.GroupScopesByAction(permissions)}
+	if len(reader.Permissions[orgID][ac.ActionTeamsRead]) == 0 {
+		return nil, nil
+	}
+	return &reader, nil
+}
+
 // SetTeamSyncFunc sets the function received through args as the team sync function.
 func (ls *Implementation) SetTeamSyncFunc(teamSyncFunc login.TeamSyncFunc) {
 	ls.TeamSync = teamSyncFunc
 }
 
+// SetOrgMappingProvider sets the provider that decides the org roles of external users.
+func (ls *Implementation) SetOrgMappingProvider(provider login.OrgMappingProvider) {
+	ls.OrgMappings = provider
+}
+
+// SetSyncEventEmitter sets the emitter told about the changes the sync makes.
+func (ls *Implementation) SetSyncEventEmitter(emitter login.SyncEventEmitter) {
+	ls.SyncEvents = emitter
+}
+
+// emit tells the sync event emitter about a change made to the user.
+func (ls *Implementation) emit(usr *user.User, event login.SyncEvent) {
+	if ls.SyncEvents == nil {
+		return
+	}
+	event.UserID = usr.ID
+	event.UserLogin = usr.Login
+	ls.SyncEvents.Emit(event)
+}
+
+// OrphanedOrgMappings returns the mappings that map into an org that does not exist, in the order they are given, so
+// that mapping sets can be cleaned up after orgs are deleted. The orgs are looked up in a single query, and nothing
+// is written.
+func (ls *Implementation) OrphanedOrgMappings(ctx context.Context, mappings []login.OrgMapping) ([]login.OrgMapping, error) {
+	if len(mappings) == 0 {
+		return nil, nil
+	}
+
+	orgIds := make([]int64, 0, len(mappings))
+	seen := make(map[int64]bool, len(mappings))
+	for _, mapping := range mappings {
+		if !seen[mapping.OrgID] {
+			seen[mapping.OrgID] = true
+			orgIds = append(orgIds, mapping.OrgID)
+		}
+	}
+
+	query := &models.SearchOrgsQuery{Ids: orgIds}
+	if err := ls.SQLStore.SearchOrgs(ctx, query); err != nil {
+		return nil, fmt.Errorf("failed to look up the mapped organizations: %w", err)
+	}
+	existing := make(map[int64]bool, len(query.Result))
+	for _, org := range query.Result {
+		existing[org.Id] = true
+	}
+
+	var orphaned []login.OrgMapping
+	for _, mapping := range mappings {
+		if !existing[mapping.OrgID] {
+			orphaned = append(orphaned, mapping)
+		}
+	}
+	return orphaned, nil
+}
+
+// PlanMappings returns what syncing the user with the given email with the org mappings would change, as UpsertUser
+// does with the roles an OrgMappingProvider gives and no other options, without changing anything. The highest role
+// applies when several mappings are for the same org. Memberships of excluded orgs, and the Admin role of the last
+// Admin of an org, are kept like the sync keeps them. Without any mapping the org roles are not synced at all, so
+// every membership is kept.
+func (ls *Implementation) PlanMappings(ctx context.Context, email string, mappings []login.OrgMapping) (*login.SyncPlan, error) {
+	// The sync matches emails regardless of their casing, and so does the plan
+	userQuery := &models.GetUserByEmailQuery{Email: strings.ToLower(strings.TrimSpace(email)), CaseInsensitive: true}
+	if err := ls.SQLStore.GetUserByEmail(ctx, userQuery); err != nil {
+		return nil, err
+	}
+	usr := userQuery.Result
+
+	roles := make(map[int64]models.RoleType, len(mappings))
+	for _, mapping := range mappings {
+		if current, ok := roles[mapping.OrgID]; ok && current.Includes(mapping.Role) {
+			continue
+		}
+		roles[mapping.OrgID] = mapping.Role
+	}
+
+	orgsQuery := &models.GetUserOrgListQuery{UserId: usr.ID}
+	if err := ls.SQLStore.GetUserOrgList(ctx, orgsQuery); err != nil {
+		return nil, err
+	}
+
+	plan := &login.SyncPlan{UserID: usr.ID, UserLogin: usr.Login, Changes: []login.OrgRoleChange{}}
+	members := make(map[int64]bool, len(orgsQuery.Result))
+	removals := 0
+	for _, org := range orgsQuery.Result {
+		members[org.OrgId] = true
+		change := login.OrgRoleChange{OrgID: org.OrgId, OrgName: org.Name, Action: login.SyncPlanKeep, CurrentRole: org.Role, Role: org.Role}
+		if role, mapped := roles[org.OrgId]; len(roles) > 0 && role != org.Role {
+			change.Action, change.Role = login.SyncPlanUpdate, role
+			if !mapped {
+				change.Action = login.SyncPlanRemove
+			}
+			if org.Role == models.ROLE_ADMIN {
+				isLast, err := ls.isLastOrgAdmin(ctx, org.OrgId, usr.ID)
+				if err != nil {
+					return nil, err
+				}
+				if isLast {
+					change.Action, change.Role, change.Reason = login.SyncPlanKeep, org.Role, models.ErrLastOrgAdmin.Error()
+				}
+			}
+		}
+		if change.Action == login.SyncPlanRemove {
+			removals++
+		}
+		plan.Changes = append(plan.Changes, change)
+	}
+
+	excludedOrgIds, err := ls.resolveExcludedOrgs(ctx, logger, removals)
+	if err != nil {
+		return nil, err
+	}
+	for i, change := range plan.Changes {
+		if change.Action == login.SyncPlanRemove && excludedOrgIds[change.OrgID] {
+			plan.Changes[i].Action, plan.Changes[i].Role = login.SyncPlanKeep, change.CurrentRole
+			plan.Changes[i].Reason = "the organization is excluded from the sync"
+		}
+	}
+
+	var added []int64
+	for orgID := range roles {
+		if !members[orgID] {
+			added = append(added, orgID)
+		}
+	}
+	if len(added) > 0 {
+		query := &models.SearchOrgsQuery{Ids: added}
+		if err := ls.SQLStore.SearchOrgs(ctx, query); err != nil {
+			return nil, fmt.Errorf("failed to look up the mapped organizations: %w", err)
+		}
+		names := make(map[int64]string, len(query.Result))
+		for _, org := range query.Result {
+			names[org.Id] = org.Name
+		}
+		for _, orgID := range added {
+			name, ok := names[orgID]
+			if !ok {
+				plan.MissingOrgIDs = append(plan.MissingOrgIDs, orgID)
+				continue
+			}
+			plan.Changes = append(plan.Changes, login.OrgRoleChange{OrgID: orgID, OrgName: name, Action: login.SyncPlanAdd, Role: roles[orgID]})
+		}
+	}
+
+	sort.Slice(plan.Changes, func(i, j int) bool { return plan.Changes[i].OrgID < plan.Changes[j].OrgID })
+	sort.Slice(plan.MissingOrgIDs, func(i, j int) bool { return plan.MissingOrgIDs[i] < plan.MissingOrgIDs[j] })
+	return plan, nil
+}
+
+// PlanRemovals returns the memberships syncing each user with their org mappings would remove, as PlanMappings plans
+// them, without changing anything. The mappings are keyed by the email of the user. Since removing a user from an
+// org also removes them from its teams, the teams of each removed org are listed as well. Only the users who would
+// lose a membership are returned, sorted by email, and the users who do not exist yet are left out, as the sync would
+// create them.
+func (ls *Implementation) PlanRemovals(ctx context.Context, mappings map[string][]login.OrgMapping) ([]login.UserRemovalPlan, error) {
+	emails := make([]string, 0, len(mappings))
+	for email := range mappings {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+
+	plans := []login.UserRemovalPlan{}
+	for _, email := range emails {
+		plan, err := ls.PlanMappings(ctx, email, mappings[email])
+		if errors.Is(err, models.ErrUserNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to plan the sync of %s: %w", email, err)
+		}
+
+		var removals []login.PlannedRemoval
+		for _, change := range plan.Changes {
+			if change.Action != login.SyncPlanRemove {
+				continue
+			}
+			memberships, err := ls.SQLStore.GetUserTeamMemberships(ctx, change.OrgID, plan.UserID, false)
+			if err != nil {
+				return nil, err
+			}
+			removal := login.PlannedRemoval{OrgID: change.OrgID, OrgName: change.OrgName, Role: change.CurrentRole}
+			for _, membership := range memberships {
+				removal.TeamIDs = append(removal.TeamIDs, membership.TeamId)
+			}
+			sort.Slice(removal.TeamIDs, func(i, j int) bool { return removal.TeamIDs[i] < removal.TeamIDs[j] })
+			removals = append(removals, removal)
+		}
+		if len(removals) > 0 {
+			plans = append(plans, login.UserRemovalPlan{UserID: plan.UserID, UserLogin: plan.UserLogin, Email: email, Removals: removals})
+		}
+	}
+	return plans, nil
+}
+
+// PlanSync returns the plan of PlanMappings along with a token hashing the current memberships of the user and the
+// planned changes, for ApplySync to apply.
+func (ls *Implementation) PlanSync(ctx context.Context, email string, mappings []login.OrgMapping) (*login.PlannedSync, error) {
+	plan, err := ls.PlanMappings(ctx, email, mappings)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every current membership of the user has a change in the plan
+	memberships := make(map[int64]models.RoleType, len(plan.Changes))
+	for _, change := range plan.Changes {
+		if change.Action != login.SyncPlanAdd {
+			memberships[change.OrgID] = change.CurrentRole
+		}
+	}
+	return &login.PlannedSync{SyncPlan: *plan, Token: syncPlanToken(plan.UserID, memberships, plan.Changes)}, nil
+}
+
+// ApplySync makes exactly the changes of a plan returned by PlanSync. It returns login.ErrSyncPlanOutdated, without
+// changing anything, if the memberships of the user changed since the plan was made or if the plan was altered.
+func (ls *Implementation) ApplySync(ctx context.Context, plan *login.PlannedSync) error {
+	query := &models.GetUserOrgListQuery{UserId: plan.UserID}
+	if err := ls.SQLStore.GetUserOrgList(ctx, query); err != nil {
+		return err
+	}
+	memberships := make(map[int64]models.RoleType, len(query.Result))
+	for _, org := range query.Result {
+		memberships[org.OrgId] = org.Role
+	}
+	if syncPlanToken(plan.UserID, memberships, plan.Changes) != plan.Token {
+		return login.ErrSyncPlanOutdated
+	}
+
+	usr := &user.User{ID: plan.UserID, Login: plan.UserLogin}
+	for _, change := range plan.Changes {
+		switch change.Action {
+		case login.SyncPlanAdd:
+			cmd := &models.AddOrgUserCommand{UserId: usr.ID, Role: change.Role, OrgId: change.OrgID}
+			if err := ls.SQLStore.AddOrgUser(ctx, cmd); err != nil {
+				return err
+			}
+			ls.emit(usr, login.SyncEvent{Type: login.SyncEventOrgUserAdded, OrgID: change.OrgID, Role: change.Role})
+		case login.SyncPlanUpdate:
+			cmd := &models.UpdateOrgUserCommand{OrgId: change.OrgID, UserId: usr.ID, Role: change.Role}
+			if err := ls.SQLStore.UpdateOrgUser(ctx, cmd); err != nil {
+				return err
+			}
+			ls.emit(usr, login.SyncEvent{Type: login.SyncEventOrgRoleUpdated, OrgID: change.OrgID, Role: change.Role, PreviousRole: change.CurrentRole})
+		case login.SyncPlanRemove:
+			cmd := &models.RemoveOrgUserCommand{OrgId: change.OrgID, UserId: usr.ID}
+			if err := ls.SQLStore.RemoveOrgUser(ctx, cmd); err != nil {
+				return err
+			}
+			ls.emit(usr, login.SyncEvent{Type: login.SyncEventOrgUserRemoved, OrgID: change.OrgID, PreviousRole: change.CurrentRole})
+		}
+	}
+	return nil
+}
+
+// syncPlanToken hashes the memberships of a user, by org ID, along with the changes planned for them.
+func syncPlanToken(userID int64, memberships map[int64]models.RoleType, changes []login.OrgRoleChange) string {
+	orgIDs := make([]int64, 0, len(memberships))
+	for orgID := range memberships {
+		orgIDs = append(orgIDs, orgID)
+	}
+	sort.Slice(orgIDs, func(i, j int) bool { return orgIDs[i] < orgIDs[j] })
+
+	h := sha256.New()
+	fmt.Fprintf(h, "user:%d\n", userID)
+	for _, orgID := range orgIDs {
+		fmt.Fprintf(h, "member:%d:%s\n", orgID, memberships[orgID])
+	}
+	for _, change := range changes {
+		fmt.Fprintf(h, "change:%d:%s:%s:%s\n", change.OrgID, change.Action, change.CurrentRole, change.Role)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// mapOrgRoles returns the external user with the org roles given by the org mapping provider, if there is one,
+// and the orgs the mappings give it different roles in. The highest of these roles is applied, whatever the order
+// of the mappings. The given external user is not modified.
+func (ls *Implementation) mapOrgRoles(ctx context.Context, extUser *models.ExternalUserInfo) (*models.ExternalUserInfo, []models.OrgMappingConflict, error) {
+	if ls.OrgMappings == nil {
+		return extUser, nil, nil
+	}
+
+	mappings, err := ls.OrgMappings.MappingsFor(ctx, extUser)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get the org mappings of the user: %w", err)
+	}
+
+	mapped := *extUser
+	mapped.OrgRoles = make(map[int64]models.RoleType, len(mappings))
+	roles := map[int64][]models.RoleType{}
+	for _, mapping := range mappings {
+		roles[mapping.OrgID] = append(roles[mapping.OrgID], mapping.Role)
+		if current, ok := mapped.OrgRoles[mapping.OrgID]; ok && current.Includes(mapping.Role) {
+			continue
+		}
+		mapped.OrgRoles[mapping.OrgID] = mapping.Role
+	}
+
+	var conflicts []models.OrgMappingConflict
+	for orgID, orgRoles := range roles {
+		for _, role := range orgRoles[1:] {
+			if role != orgRoles[0] {
+				conflicts = append(conflicts, models.OrgMappingConflict{OrgId: orgID, Roles: orgRoles, AppliedRole: mapped.OrgRoles[orgID]})
+				break
+			}
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].OrgId < conflicts[j].OrgId })
+	return &mapped, conflicts, nil
+}
+
+// constrainOrgRoles returns a copy of the external user with only its role in the given org, and the IDs of the
+// other orgs it has a role in, which are rejected.
+func constrainOrgRoles(extUser *models.ExternalUserInfo, orgID int64) (*models.ExternalUserInfo, []int64) {
+	var rejected []int64
+	constrained := *extUser
+	constrained.OrgRoles = map[int64]models.RoleType{}
+	for id, role := range extUser.OrgRoles {
+		if id != orgID {
+			rejected = append(rejected, id)
+			continue
+		}
+		constrained.OrgRoles[id] = role
+	}
+	sort.Slice(rejected, func(i, j int) bool { return rejected[i] < rejected[j] })
+	return &constrained, rejected
+}
+
+// applyMinOrgRoles returns a copy of the external user whose role in each org with a minimum role is at least that
+// role, including the orgs it has no role in. An external user without any org role is returned as is, since its
+// org roles are not synced at all. When the sync is constrained to an org, the minimum roles of other orgs are left
+// out.
+func applyMinOrgRoles(syncLog log.Logger, extUser *models.ExternalUserInfo, minOrgRoles map[int64]models.RoleType, constrainToOrg int64) *models.ExternalUserInfo {
+	if len(minOrgRoles) == 0 || len(extUser.OrgRoles) == 0 {
+		return extUser
+	}
+
+	raised := *extUser
+	raised.OrgRoles = make(map[int64]models.RoleType, len(extUser.OrgRoles)+len(minOrgRoles))
+	for orgID, role := range extUser.OrgRoles {
+		raised.OrgRoles[orgID] = role
+	}
+	for orgID, minRole := range minOrgRoles {
+		if constrainToOrg != 0 && orgID != constrainToOrg {
+			continue
+		}
+		if role, ok := raised.OrgRoles[orgID]; !ok || !role.Includes(minRole) {
+			syncLog.Debug("Raising the user's organization role to the minimum role of the organization",
+				"org_id", orgID, "role", role, "min_role", minRole)
+			raised.OrgRoles[orgID] = minRole
+		}
+	}
+	return &raised
+}
+
 func (ls *Implementation) createUser(extUser *models.ExternalUserInfo) (*user.User, error) {
 	cmd := user.CreateUserCommand{
 		Login:        extUser.Login,
@@ -211,7 +880,37 @@ func (ls *Implementation) createUser(extUser *models.ExternalUserInfo) (*user.Us
 	return ls.CreateUser(cmd)
 }
 
-func (ls *Implementation) updateUser(ctx context.Context, user *user.User, extUser *models.ExternalUserInfo) error {
+// lastExternalLogin returns the auth info recorded at the previous login of the external user, or nil if there
+// is none.
+func (ls *Implementation) lastExternalLogin(ctx context.Context, extUser *models.ExternalUserInfo) (*models.UserAuth, error) {
+	if extUser.AuthModule == "" || extUser.AuthId == "" {
+		return nil, nil
+	}
+
+	query := &models.GetAuthInfoQuery{AuthModule: extUser.AuthModule, AuthId: extUser.AuthId}
+	if err := ls.AuthInfoService.GetAuthInfo(ctx, query); err != nil {
+		if errors.Is(err, models.ErrUserNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return query.Result, nil
+}
+
+// shouldSyncProfile tells whether the name and email of the user are to be updated from the external user. With
+// ProfileSyncUnlessEdited, the profile counts as edited in Grafana when it was updated after the last login.
+func shouldSyncProfile(mode models.ProfileSyncMode, usr *user.User, lastLogin *models.UserAuth) bool {
+	switch mode {
+	case models.ProfileSyncNever:
+		return false
+	case models.ProfileSyncUnlessEdited:
+		return lastLogin == nil || !usr.Updated.After(lastLogin.Created)
+	default:
+		return true
+	}
+}
+
+func (ls *Implementation) updateUser(ctx context.Context, syncLog log.Logger, user *user.User, extUser *models.ExternalUserInfo, syncProfile bool) error {
 	// sync user info
 	updateCmd := &models.UpdateUserCommand{
 		UserId: user.ID,
@@ -224,13 +923,15 @@ func (ls *Implementation) updateUser(ctx context.Context, user *user.User, extUs
 		needsUpdate = true
 	}
 
-	if extUser.Email != "" && extUser.Email != user.Email {
+	if !syncProfile {
+		syncLog.Debug("Keeping user profile edited in Grafana")
+	} else if extUser.Email != "" && extUser.Email != user.Email {
 		updateCmd.Email = extUser.Email
 		user.Email = extUser.Email
 		needsUpdate = true
 	}
 
-	if extUser.Name != "" && extUser.Name != user.Name {
+	if syncProfile && extUser.Name != "" && extUser.Name != user.Name {
 		updateCmd.Name = extUser.Name
 		user.Name = extUser.Name
 		needsUpdate = true
@@ -240,11 +941,15 @@ func (ls *Implementation) updateUser(ctx context.Context, user *user.User, extUs
 		return nil
 	}
 
-	logger.Debug("Syncing user info", "id", user.ID, "update", updateCmd)
-	return ls.SQLStore.UpdateUser(ctx, updateCmd)
+	syncLog.Debug("Syncing user info", "update", updateCmd)
+	if err := ls.SQLStore.UpdateUser(ctx, updateCmd); err != nil {
+		return err
+	}
+	ls.emit(user, login.SyncEvent{Type: login.SyncEventUserUpdated})
+	return nil
 }
 
-func (ls *Implementation) updateUserAuth(ctx context.Context, user *user.User, extUser *models.ExternalUserInfo) error {
+func (ls *Implementation) updateUserAuth(ctx context.Context, syncLog log.Logger, user *user.User, extUser *models.ExternalUserInfo) error {
 	updateCmd := &models.UpdateAuthInfoCommand{
 		AuthModule: extUser.AuthModule,
 		AuthId:     extUser.AuthId,
@@ -252,45 +957,201 @@ func (ls *Implementation) updateUserAuth(ctx context.Context, user *user.User, e
 		OAuthToken: extUser.OAuthToken,
 	}
 
-	logger.Debug("Updating user_auth info", "user_id", user.ID)
+	syncLog.Debug("Updating user_auth info")
 	return ls.AuthInfoService.UpdateAuthInfo(ctx, updateCmd)
 }
 
-func (ls *Implementation) syncOrgRoles(ctx context.Context, user *user.User, extUser *models.ExternalUserInfo) error {
-	logger.Debug("Syncing organization roles", "id", user.ID, "extOrgRoles", extUser.OrgRoles)
+// resolveOrgFilter returns the IDs of the orgs with the given names or aliases, or nil if no names are given.
+func (ls *Implementation) resolveOrgFilter(ctx context.Context, syncLog log.Logger, names []string) (map[int64]bool, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	orgIds := make(map[int64]bool, len(names))
+	for _, name := range names {
+		org, err := ls.getOrgByName(ctx, syncLog, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve organization %q: %w", name, err)
+		}
+		orgIds[org.Id] = true
+	}
+	return orgIds, nil
+}
+
+// resolveExcludedOrgs returns the IDs of the orgs excluded from the sync. The names of orgs that do not exist are
+// ignored, as the orgs may be created later. Nothing is resolved if there are no removals to check.
+func (ls *Implementation) resolveExcludedOrgs(ctx context.Context, syncLog log.Logger, removals int) (map[int64]bool, error) {
+	if removals == 0 || len(ls.excludedOrgs) == 0 {
+		return nil, nil
+	}
+
+	orgIds := make(map[int64]bool, len(ls.excludedOrgs))
+	for _, org := range ls.excludedOrgs {
+		if id, err := strconv.ParseInt(org, 10, 64); err == nil {
+			orgIds[id] = true
+			continue
+		}
+
+		found, err := ls.getOrgByName(ctx, syncLog, org)
+		if errors.Is(err, models.ErrOrgNotFound) {
+			syncLog.Debug("Ignoring unknown organization excluded from the sync", "org_name", org)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve organization %q: %w", org, err)
+		}
+		orgIds[found.Id] = true
+	}
+	return orgIds, nil
+}
+
+// getOrgByName returns the org with the given name or, if there is none, the org the name is a configured alias of.
+func (ls *Implementation) getOrgByName(ctx context.Context, syncLog log.Logger, name string) (*models.Org, error) {
+	query := &models.GetOrgByNameQuery{Name: name}
+	err := ls.SQLStore.GetOrgByNameHandler(ctx, query)
+	if err == nil {
+		return query.Result, nil
+	}
+	alias, ok := ls.orgNameAliases[name]
+	if !errors.Is(err, models.ErrOrgNotFound) || !ok {
+		return nil, err
+	}
+
+	query = &models.GetOrgByNameQuery{Name: alias}
+	if err := ls.SQLStore.GetOrgByNameHandler(ctx, query); err != nil {
+		return nil, err
+	}
+	syncLog.Debug("Resolved organization by alias", "alias", name, "org_name", alias, "org_id", query.Result.Id)
+	return query.Result, nil
+}
+
+// checkOrgRoles returns an error if the external user has an invalid org role. If skipInvalid is set, the invalid
+// roles are returned instead, and the returned external user has them replaced by fallback, or left out if
+// fallback is empty. The given external user is not modified.
+func checkOrgRoles(syncLog log.Logger, extUser *models.ExternalUserInfo, skipInvalid bool, fallback models.RoleType) (*models.ExternalUserInfo, []models.InvalidOrgRole, error) {
+	var invalid []models.InvalidOrgRole
+	for orgId, orgRole := range extUser.OrgRoles {
+		if !orgRole.IsValid() {
+			invalid = append(invalid, models.InvalidOrgRole{OrgId: orgId, Role: orgRole, AppliedRole: fallback})
+		}
+	}
+	if len(invalid) == 0 {
+		return extUser, nil, nil
+	}
+	sort.Slice(invalid, func(i, j int) bool { return invalid[i].OrgId < invalid[j].OrgId })
+
+	if !skipInvalid {
+		return nil, nil, fmt.Errorf("invalid role %q for organization %d", invalid[0].Role, invalid[0].OrgId)
+	}
+	if fallback != "" && !fallback.IsValid() {
+		return nil, nil, fmt.Errorf("invalid fallback role %q", fallback)
+	}
+
+	syncLog.Warn("Skipping invalid organization roles of external user", "invalid_roles", invalid)
+	checked := *extUser
+	checked.OrgRoles = make(map[int64]models.RoleType, len(extUser.OrgRoles))
+	for orgId, orgRole := range extUser.OrgRoles {
+		if !orgRole.IsValid() {
+			if fallback == "" {
+				continue
+			}
+			orgRole = fallback
+		}
+		checked.OrgRoles[orgId] = orgRole
+	}
+	return &checked, invalid, nil
+}
+
+// syncOrgRoles makes the org memberships of the user match the org roles of the external user.
+// If noDowngrade is set, roles lower than the current ones are not applied but returned,
+// and memberships missing from the external user are kept.
+// If allowedOrgIds is not nil, only the orgs in it are synced. The orgs of the external user that
+// are left out are returned as filtered.
+// The user is never demoted or removed from an org they are the last Admin of, so that the org can still be
+// managed. These orgs are returned as kept admins.
+func (ls *Implementation) syncOrgRoles(ctx context.Context, syncLog log.Logger, user *user.User, extUser *models.ExternalUserInfo, noDowngrade bool, allowedOrgIds map[int64]bool) ([]models.SkippedRoleDowngrade, []int64, []models.KeptLastOrgAdmin, error) {
+	syncLog.Debug("Syncing organization roles", "ext_org_roles", extUser.OrgRoles)
 
 	// don't sync org roles if none is specified
 	if len(extUser.OrgRoles) == 0 {
-		logger.Debug("Not syncing organization roles since external user doesn't have any")
-		return nil
+		syncLog.Debug("Not syncing organization roles since external user doesn't have any")
+		return nil, nil, nil, nil
+	}
+
+	isAllowed := func(orgId int64) bool {
+		return allowedOrgIds == nil || allowedOrgIds[orgId]
+	}
+
+	var filtered []int64
+	orgRoles := make(map[int64]models.RoleType, len(extUser.OrgRoles))
+	for orgId, orgRole := range extUser.OrgRoles {
+		if !isAllowed(orgId) {
+			filtered = append(filtered, orgId)
+			continue
+		}
+		orgRoles[orgId] = orgRole
+	}
+	sort.Slice(filtered, func(i, j int) bool { return filtered[i] < filtered[j] })
+	if len(filtered) > 0 {
+		syncLog.Debug("Skipping organizations left out by the filter", "org_ids", filtered)
 	}
 
 	orgsQuery := &models.GetUserOrgListQuery{UserId: user.ID}
 	if err := ls.SQLStore.GetUserOrgList(ctx, orgsQuery); err != nil {
-		return err
+		return nil, nil, nil, err
 	}
 
 	handledOrgIds := map[int64]bool{}
+	currentRoles := map[int64]models.RoleType{}
 	deleteOrgIds := []int64{}
+	var skipped []models.SkippedRoleDowngrade
+	var keptAdmins []models.KeptLastOrgAdmin
 
 	// update existing org roles
 	for _, org := range orgsQuery.Result {
 		handledOrgIds[org.OrgId] = true
+		currentRoles[org.OrgId] = org.Role
+		if !isAllowed(org.OrgId) {
+			continue
+		}
+
+		extRole := orgRoles[org.OrgId]
+		if !noDowngrade && org.Role == models.ROLE_ADMIN && extRole != models.ROLE_ADMIN {
+			isLast, err := ls.isLastOrgAdmin(ctx, org.OrgId, user.ID)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			if isLast {
+				syncLog.Warn("Keeping the user's organization role", "org_id", org.OrgId, "org_name", org.Name,
+					"skipped_role", extRole, "error", models.ErrLastOrgAdmin)
+				keptAdmins = append(keptAdmins, models.KeptLastOrgAdmin{OrgId: org.OrgId, SkippedRole: extRole})
+				continue
+			}
+		}
 
-		extRole := extUser.OrgRoles[org.OrgId]
 		if extRole == "" {
-			deleteOrgIds = append(deleteOrgIds, org.OrgId)
+			if !noDowngrade {
+				deleteOrgIds = append(deleteOrgIds, org.OrgId)
+			}
 		} else if extRole != org.Role {
+			if noDowngrade && !extRole.Includes(org.Role) {
+				syncLog.Debug("Skipping downgrade of the user's organization role", "org_id", org.OrgId, "org_name", org.Name,
+					"role", org.Role, "skipped_role", extRole)
+				skipped = append(skipped, models.SkippedRoleDowngrade{OrgId: org.OrgId, CurrentRole: org.Role, SkippedRole: extRole})
+				continue
+			}
+
 			// update role
 			cmd := &models.UpdateOrgUserCommand{OrgId: org.OrgId, UserId: user.ID, Role: extRole}
 			if err := ls.SQLStore.UpdateOrgUser(ctx, cmd); err != nil {
-				return err
+				return nil, nil, nil, err
 			}
+			ls.emit(user, login.SyncEvent{Type: login.SyncEventOrgRoleUpdated, OrgID: org.OrgId, Role: extRole, PreviousRole: org.Role})
 		}
 	}
 
 	// add any new org roles
-	for orgId, orgRole := range extUser.OrgRoles {
+	for orgId, orgRole := range orgRoles {
 		if _, exists := handledOrgIds[orgId]; exists {
 			continue
 		}
@@ -298,38 +1159,132 @@ func (ls *Implementation) syncOrgRoles(ctx context.Context, user *user.User, ext
 		// add role
 		cmd := &models.AddOrgUserCommand{UserId: user.ID, Role: orgRole, OrgId: orgId}
 		err := ls.SQLStore.AddOrgUser(ctx, cmd)
-		if err != nil && !errors.Is(err, models.ErrOrgNotFound) {
-			return err
+		if errors.Is(err, models.ErrOrgNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		ls.emit(user, login.SyncEvent{Type: login.SyncEventOrgUserAdded, OrgID: orgId, Role: orgRole})
+
+		if err := ls.applyOrgPreferences(ctx, syncLog, user.ID, orgId, extUser.OrgPreferences[orgId]); err != nil {
+			return nil, nil, nil, err
 		}
+
+		if err := ls.joinDefaultTeam(syncLog, user, orgId); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	excludedOrgIds, err := ls.resolveExcludedOrgs(ctx, syncLog, len(deleteOrgIds))
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
 	// delete any removed org roles
 	for _, orgId := range deleteOrgIds {
-		logger.Debug("Removing user's organization membership as part of syncing with OAuth login",
-			"userId", user.ID, "orgId", orgId)
+		if excludedOrgIds[orgId] {
+			syncLog.Debug("Keeping the user's membership of an organization excluded from the sync", "org_id", orgId)
+			continue
+		}
+
+		syncLog.Debug("Removing user's organization membership as part of syncing with OAuth login", "org_id", orgId)
 		cmd := &models.RemoveOrgUserCommand{OrgId: orgId, UserId: user.ID}
 		if err := ls.SQLStore.RemoveOrgUser(ctx, cmd); err != nil {
 			if errors.Is(err, models.ErrLastOrgAdmin) {
-				logger.Error(err.Error(), "userId", cmd.UserId, "orgId", cmd.OrgId)
+				syncLog.Error("Failed to remove the user's organization membership", "org_id", cmd.OrgId, "error", err)
 				continue
 			}
 
-			return err
+			return nil, nil, nil, err
 		}
+		ls.emit(user, login.SyncEvent{Type: login.SyncEventOrgUserRemoved, OrgID: orgId, PreviousRole: currentRoles[orgId]})
 	}
 
 	// update user's default org if needed
-	if _, ok := extUser.OrgRoles[user.OrgID]; !ok {
-		for orgId := range extUser.OrgRoles {
+	if _, ok := orgRoles[user.OrgID]; !ok && isAllowed(user.OrgID) && len(orgRoles) > 0 {
+		for orgId := range orgRoles {
 			user.OrgID = orgId
 			break
 		}
 
-		return ls.SQLStore.SetUsingOrg(ctx, &models.SetUsingOrgCommand{
+		return skipped, filtered, keptAdmins, ls.SQLStore.SetUsingOrg(ctx, &models.SetUsingOrgCommand{
 			UserId: user.ID,
 			OrgId:  user.OrgID,
 		})
 	}
 
+	return skipped, filtered, keptAdmins, nil
+}
+
+// isLastOrgAdmin returns true if no other user than the given one is an Admin of the org.
+func (ls *Implementation) isLastOrgAdmin(ctx context.Context, orgID, userID int64) (bool, error) {
+	query := &models.GetOrgUsersQuery{
+		OrgId:                    orgID,
+		User:                     &models.SignedInUser{OrgId: orgID},
+		DontEnforceAccessControl: true,
+	}
+	if err := ls.SQLStore.GetOrgUsers(ctx, query); err != nil {
+		return false, err
+	}
+
+	for _, orgUser := range query.Result {
+		if orgUser.UserId != userID && models.RoleType(orgUser.Role) == models.ROLE_ADMIN {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// joinDefaultTeam adds the user to the default team of the org, if it has one, with the default team permission. A
+// user already in the team is left as they are, and a default team that no longer exists is skipped.
+func (ls *Implementation) joinDefaultTeam(syncLog log.Logger, usr *user.User, orgID int64) error {
+	teamID, ok := ls.defaultTeams[orgID]
+	if !ok {
+		return nil
+	}
+
+	err := ls.SQLStore.AddTeamMember(usr.ID, orgID, teamID, true, ls.defaultTeamPermission)
+	if errors.Is(err, models.ErrTeamMemberAlreadyAdded) {
+		return nil
+	}
+	if errors.Is(err, models.ErrTeamNotFound) {
+		syncLog.Warn("The default team of the organization was not found", "org_id", orgID, "team_id", teamID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	ls.emit(usr, login.SyncEvent{Type: login.SyncEventTeamMemberAdded, OrgID: orgID, TeamID: teamID, Permission: ls.defaultTeamPermission})
 	return nil
 }
+
+// applyOrgPreferences sets the preferences of the user in the org to the given ones, leaving out those the user has
+// already set, so that applying them again has no effect.
+func (ls *Implementation) applyOrgPreferences(ctx context.Context, syncLog log.Logger, userID, orgID int64, prefs models.ExternalOrgPreferences) error {
+	if prefs.IsEmpty() || ls.PreferenceService == nil {
+		return nil
+	}
+
+	current, err := ls.PreferenceService.Get(ctx, &pref.GetPreferenceQuery{OrgID: orgID, UserID: userID})
+	if err != nil {
+		return err
+	}
+
+	cmd := &pref.PatchPreferenceCommand{OrgID: orgID, UserID: userID}
+	if prefs.HomeDashboardID != 0 && current.HomeDashboardID == 0 {
+		cmd.HomeDashboardID = &prefs.HomeDashboardID
+	}
+	if prefs.Timezone != "" && current.Timezone == "" {
+		cmd.Timezone = &prefs.Timezone
+	}
+	if prefs.Theme != "" && current.Theme == "" {
+		cmd.Theme = &prefs.Theme
+	}
+	if cmd.HomeDashboardID == nil && cmd.Timezone == nil && cmd.Theme == nil {
+		return nil
+	}
+
+	syncLog.Debug("Applying preferences to user added to organization", "org_id", orgID)
+	return ls.PreferenceService.Patch(ctx, cmd)
+}
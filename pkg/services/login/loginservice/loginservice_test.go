@@ -4,15 +4,24 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/grafana/grafana/pkg/models"
+	ac "github.com/grafana/grafana/pkg/services/accesscontrol"
+	acmock "github.com/grafana/grafana/pkg/services/accesscontrol/mock"
+	"github.com/grafana/grafana/pkg/services/login"
 	"github.com/grafana/grafana/pkg/services/login/logintest"
+	pref "github.com/grafana/grafana/pkg/services/preference"
+	"github.com/grafana/grafana/pkg/services/preference/preftest"
 	"github.com/grafana/grafana/pkg/services/quota"
 	"github.com/grafana/grafana/pkg/services/sqlstore/mockstore"
 	"github.com/grafana/grafana/pkg/services/user"
+	"github.com/grafana/grafana/pkg/setting"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -33,7 +42,7 @@ func Test_syncOrgRoles_doesNotBreakWhenTryingToRemoveLastOrgAdmin(t *testing.T)
 		SQLStore:        store,
 	}
 
-	err := login.syncOrgRoles(context.Background(), &user, &externalUser)
+	_, _, _, err := login.syncOrgRoles(context.Background(), logger, &user, &externalUser, false, nil)
 	require.NoError(t, err)
 }
 
@@ -57,11 +66,493 @@ func Test_syncOrgRoles_whenTryingToRemoveLastOrgLogsError(t *testing.T) {
 		SQLStore:        store,
 	}
 
-	err := login.syncOrgRoles(context.Background(), &user, &externalUser)
+	_, _, _, err := login.syncOrgRoles(context.Background(), logger, &user, &externalUser, false, nil)
 	require.NoError(t, err)
 	assert.Contains(t, buf.String(), models.ErrLastOrgAdmin.Error())
 }
 
+func Test_syncOrgRoles_noDowngrade(t *testing.T) {
+	user := createSimpleUser()
+	externalUser := models.ExternalUserInfo{
+		AuthModule: "ldap",
+		OrgRoles: map[int64]models.RoleType{
+			1:  models.ROLE_EDITOR,
+			10: models.ROLE_EDITOR,
+		},
+	}
+
+	store := &mockstore.SyncStoreMock{
+		SQLStoreMock: &mockstore.SQLStoreMock{
+			ExpectedUserOrgList: createUserOrgDTO(),
+		},
+	}
+
+	login := Implementation{
+		QuotaService:    &quota.QuotaService{},
+		AuthInfoService: &logintest.AuthInfoServiceFake{},
+		SQLStore:        store,
+	}
+
+	skipped, _, _, err := login.syncOrgRoles(context.Background(), logger, &user, &externalUser, true, nil)
+	require.NoError(t, err)
+
+	t.Run("upgrade is applied", func(t *testing.T) {
+		require.Len(t, store.UpdatedOrgUsers, 1)
+		assert.Equal(t, int64(1), store.UpdatedOrgUsers[0].OrgId)
+		assert.Equal(t, models.ROLE_EDITOR, store.UpdatedOrgUsers[0].Role)
+	})
+
+	t.Run("downgrade is skipped", func(t *testing.T) {
+		assert.Equal(t, []models.SkippedRoleDowngrade{
+			{OrgId: 10, CurrentRole: models.ROLE_ADMIN, SkippedRole: models.ROLE_EDITOR},
+		}, skipped)
+	})
+
+	t.Run("memberships are kept", func(t *testing.T) {
+		assert.Empty(t, store.RemovedOrgUsers)
+	})
+}
+
+func Test_syncOrgRoles_orgFilter(t *testing.T) {
+	user := createSimpleUser()
+	externalUser := models.ExternalUserInfo{
+		AuthModule: "ldap",
+		OrgRoles: map[int64]models.RoleType{
+			1:  models.ROLE_EDITOR,
+			10: models.ROLE_EDITOR,
+		},
+	}
+
+	store := &mockstore.SyncStoreMock{
+		SQLStoreMock: &mockstore.SQLStoreMock{
+			ExpectedUserOrgList: createUserOrgDTO(),
+		},
+	}
+
+	login := Implementation{
+		QuotaService:    &quota.QuotaService{},
+		AuthInfoService: &logintest.AuthInfoServiceFake{},
+		SQLStore:        store,
+	}
+
+	allowedOrgIds, err := login.resolveOrgFilter(context.Background(), logger, []string{"Bar"})
+	require.NoError(t, err)
+	_, filtered, _, err := login.syncOrgRoles(context.Background(), logger, &user, &externalUser, false, allowedOrgIds)
+	require.NoError(t, err)
+
+	t.Run("allowed org is synced", func(t *testing.T) {
+		require.Len(t, store.UpdatedOrgUsers, 1)
+		assert.Equal(t, int64(1), store.UpdatedOrgUsers[0].OrgId)
+		assert.Equal(t, models.ROLE_EDITOR, store.UpdatedOrgUsers[0].Role)
+	})
+
+	t.Run("other orgs are filtered", func(t *testing.T) {
+		assert.Equal(t, []int64{10}, filtered)
+		assert.Empty(t, store.RemovedOrgUsers)
+	})
+
+	t.Run("unknown org name is an error", func(t *testing.T) {
+		_, err := login.resolveOrgFilter(context.Background(), logger, []string{"Missing"})
+		require.ErrorIs(t, err, models.ErrOrgNotFound)
+	})
+
+	t.Run("org alias resolves to the aliased org", func(t *testing.T) {
+		aliased := login
+		aliased.orgNameAliases = map[string]string{"Team Bar": "Bar"}
+
+		allowedOrgIds, err := aliased.resolveOrgFilter(context.Background(), logger, []string{"Team Bar"})
+		require.NoError(t, err)
+		assert.Equal(t, map[int64]bool{1: true}, allowedOrgIds)
+	})
+}
+
+func Test_syncOrgRoles_lastOrgAdmin(t *testing.T) {
+	user := createSimpleUser()
+	externalUser := models.ExternalUserInfo{
+		AuthModule: "ldap",
+		OrgRoles: map[int64]models.RoleType{
+			1:  models.ROLE_VIEWER,
+			10: models.ROLE_EDITOR,
+			11: models.ROLE_VIEWER,
+		},
+	}
+
+	sync := func(t *testing.T, orgUsers []*models.OrgUserDTO) (*mockstore.SyncStoreMock, []models.KeptLastOrgAdmin) {
+		t.Helper()
+		store := &mockstore.SyncStoreMock{
+			SQLStoreMock: &mockstore.SQLStoreMock{ExpectedUserOrgList: createUserOrgDTO()},
+			OrgUsers:     orgUsers,
+		}
+		login := Implementation{
+			QuotaService:    &quota.QuotaService{},
+			AuthInfoService: &logintest.AuthInfoServiceFake{},
+			SQLStore:        store,
+		}
+
+		_, _, keptAdmins, err := login.syncOrgRoles(context.Background(), logger, &user, &externalUser, false, nil)
+		require.NoError(t, err)
+		return store, keptAdmins
+	}
+
+	t.Run("the last admin of an org is not demoted", func(t *testing.T) {
+		store, keptAdmins := sync(t, []*models.OrgUserDTO{
+			{OrgId: 10, UserId: user.ID, Role: string(models.ROLE_ADMIN)},
+			{OrgId: 10, UserId: 2, Role: string(models.ROLE_EDITOR)},
+		})
+
+		assert.Equal(t, []models.KeptLastOrgAdmin{{OrgId: 10, SkippedRole: models.ROLE_EDITOR}}, keptAdmins)
+		assert.Empty(t, store.UpdatedOrgUsers)
+	})
+
+	t.Run("an admin is demoted when the org has another admin", func(t *testing.T) {
+		store, keptAdmins := sync(t, []*models.OrgUserDTO{
+			{OrgId: 10, UserId: user.ID, Role: string(models.ROLE_ADMIN)},
+			{OrgId: 10, UserId: 2, Role: string(models.ROLE_ADMIN)},
+		})
+
+		assert.Empty(t, keptAdmins)
+		require.Len(t, store.UpdatedOrgUsers, 1)
+		assert.Equal(t, int64(10), store.UpdatedOrgUsers[0].OrgId)
+		assert.Equal(t, models.ROLE_EDITOR, store.UpdatedOrgUsers[0].Role)
+	})
+}
+
+func Test_syncOrgRoles_excludedOrgs(t *testing.T) {
+	user := createSimpleUser()
+	externalUser := createSimpleExternalUser()
+
+	testCases := []struct {
+		desc     string
+		excluded []string
+	}{
+		{desc: "excluded by ID", excluded: []string{"11"}},
+		{desc: "excluded by name", excluded: []string{"Stuff", "Missing"}},
+		{desc: "excluded by alias", excluded: []string{"Team Stuff"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			store := &mockstore.SyncStoreMock{
+				SQLStoreMock: &mockstore.SQLStoreMock{ExpectedUserOrgList: createUserOrgDTO()},
+				OrgUsers:     []*models.OrgUserDTO{{OrgId: 10, UserId: 2, Role: string(models.ROLE_ADMIN)}},
+			}
+			login := Implementation{
+				QuotaService:    &quota.QuotaService{},
+				AuthInfoService: &logintest.AuthInfoServiceFake{},
+				SQLStore:        store,
+				excludedOrgs:    tc.excluded,
+				orgNameAliases:  map[string]string{"Team Stuff": "Stuff", "Missing": "Nowhere"},
+			}
+
+			_, _, _, err := login.syncOrgRoles(context.Background(), logger, &user, &externalUser, false, nil)
+			require.NoError(t, err)
+
+			require.Len(t, store.RemovedOrgUsers, 1)
+			assert.Equal(t, int64(10), store.RemovedOrgUsers[0].OrgId)
+		})
+	}
+}
+
+func Test_checkOrgRoles(t *testing.T) {
+	externalUser := models.ExternalUserInfo{
+		AuthModule: "ldap",
+		OrgRoles: map[int64]models.RoleType{
+			1:  models.ROLE_EDITOR,
+			10: models.RoleType("Edtor"),
+		},
+	}
+
+	t.Run("an invalid role fails the sync by default", func(t *testing.T) {
+		_, _, err := checkOrgRoles(logger, &externalUser, false, "")
+		require.Error(t, err)
+	})
+
+	t.Run("invalid roles are skipped and reported", func(t *testing.T) {
+		store := &mockstore.SyncStoreMock{
+			SQLStoreMock: &mockstore.SQLStoreMock{
+				ExpectedUserOrgList: createUserOrgDTO(),
+			},
+		}
+		login := Implementation{
+			QuotaService:    &quota.QuotaService{},
+			AuthInfoService: &logintest.AuthInfoServiceFake{},
+			SQLStore:        store,
+		}
+
+		checked, invalid, err := checkOrgRoles(logger, &externalUser, true, "")
+		require.NoError(t, err)
+		assert.Equal(t, []models.InvalidOrgRole{{OrgId: 10, Role: "Edtor"}}, invalid)
+		assert.Equal(t, map[int64]models.RoleType{1: models.ROLE_EDITOR}, checked.OrgRoles)
+		assert.Len(t, externalUser.OrgRoles, 2)
+
+		user := createSimpleUser()
+		_, _, _, err = login.syncOrgRoles(context.Background(), logger, &user, checked, true, nil)
+		require.NoError(t, err)
+		require.Len(t, store.UpdatedOrgUsers, 1)
+		assert.Equal(t, int64(1), store.UpdatedOrgUsers[0].OrgId)
+		assert.Equal(t, models.ROLE_EDITOR, store.UpdatedOrgUsers[0].Role)
+	})
+
+	t.Run("invalid roles are replaced by the fallback", func(t *testing.T) {
+		checked, invalid, err := checkOrgRoles(logger, &externalUser, true, models.ROLE_VIEWER)
+		require.NoError(t, err)
+		assert.Equal(t, []models.InvalidOrgRole{{OrgId: 10, Role: "Edtor", AppliedRole: models.ROLE_VIEWER}}, invalid)
+		assert.Equal(t, map[int64]models.RoleType{1: models.ROLE_EDITOR, 10: models.ROLE_VIEWER}, checked.OrgRoles)
+	})
+}
+
+func Test_syncOrgRoles_orgPreferences(t *testing.T) {
+	user := createSimpleUser()
+	externalUser := models.ExternalUserInfo{
+		AuthModule: "ldap",
+		OrgRoles: map[int64]models.RoleType{
+			1: models.ROLE_VIEWER,
+			2: models.ROLE_EDITOR,
+		},
+		OrgPreferences: map[int64]models.ExternalOrgPreferences{
+			1: {HomeDashboardID: 7},
+			2: {HomeDashboardID: 42, Timezone: "utc"},
+		},
+	}
+
+	t.Run("preferences are applied to a new org member", func(t *testing.T) {
+		prefs := &preferencePatchRecorder{
+			FakePreferenceService: &preftest.FakePreferenceService{ExpectedPreference: &pref.Preference{}},
+		}
+		login := Implementation{
+			QuotaService:      &quota.QuotaService{},
+			AuthInfoService:   &logintest.AuthInfoServiceFake{},
+			SQLStore:          &mockstore.SyncStoreMock{SQLStoreMock: &mockstore.SQLStoreMock{ExpectedUserOrgList: createUserOrgDTO()}},
+			PreferenceService: prefs,
+		}
+
+		_, _, _, err := login.syncOrgRoles(context.Background(), logger, &user, &externalUser, false, nil)
+		require.NoError(t, err)
+
+		homeDashboardID := int64(42)
+		timezone := "utc"
+		assert.Equal(t, []*pref.PatchPreferenceCommand{
+			{OrgID: 2, UserID: user.ID, HomeDashboardID: &homeDashboardID, Timezone: &timezone},
+		}, prefs.patched)
+	})
+
+	t.Run("preferences the user has set are kept", func(t *testing.T) {
+		prefs := &preferencePatchRecorder{
+			FakePreferenceService: &preftest.FakePreferenceService{ExpectedPreference: &pref.Preference{Timezone: "browser"}},
+		}
+		login := Implementation{
+			QuotaService:      &quota.QuotaService{},
+			AuthInfoService:   &logintest.AuthInfoServiceFake{},
+			SQLStore:          &mockstore.SyncStoreMock{SQLStoreMock: &mockstore.SQLStoreMock{ExpectedUserOrgList: createUserOrgDTO()}},
+			PreferenceService: prefs,
+		}
+
+		_, _, _, err := login.syncOrgRoles(context.Background(), logger, &user, &externalUser, false, nil)
+		require.NoError(t, err)
+
+		require.Len(t, prefs.patched, 1)
+		assert.Equal(t, int64(42), *prefs.patched[0].HomeDashboardID)
+		assert.Nil(t, prefs.patched[0].Timezone)
+	})
+}
+
+func Test_syncInheritedTeamPermissions(t *testing.T) {
+	user := createSimpleUser()
+	externalUser := models.ExternalUserInfo{
+		AuthModule: "ldap",
+		OrgRoles: map[int64]models.RoleType{
+			1: models.ROLE_ADMIN,
+		},
+		TeamPermissions: map[int64]models.PermissionType{
+			1: models.PERMISSION_ADMIN,
+		},
+	}
+
+	store := &mockstore.SyncStoreMock{
+		SQLStoreMock: &mockstore.SQLStoreMock{},
+		Teams:        []*models.TeamDTO{{Id: 10, OrgId: 1}, {Id: 11, OrgId: 1}},
+		TeamMembers:  map[int64]map[int64]models.PermissionType{1: {11: 0}},
+	}
+	login := Implementation{
+		QuotaService:    &quota.QuotaService{},
+		AuthInfoService: &logintest.AuthInfoServiceFake{},
+		SQLStore:        store,
+	}
+
+	t.Run("an Admin org role grants Admin on every team of the org", func(t *testing.T) {
+		_, err := login.syncInheritedTeamPermissions(context.Background(), logger, &user, &externalUser, nil, nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, map[int64]map[int64]models.PermissionType{1: {10: models.PERMISSION_ADMIN, 11: models.PERMISSION_ADMIN}}, store.TeamMembers)
+		assert.Equal(t, 1, store.AddedTeamMembers)
+		assert.Equal(t, 1, store.UpdatedTeamMembers)
+	})
+
+	t.Run("syncing again changes nothing", func(t *testing.T) {
+		_, err := login.syncInheritedTeamPermissions(context.Background(), logger, &user, &externalUser, nil, nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, store.AddedTeamMembers)
+		assert.Equal(t, 1, store.UpdatedTeamMembers)
+	})
+
+	t.Run("members with the implied permission or a higher one are not written", func(t *testing.T) {
+		store := &mockstore.SyncStoreMock{
+			SQLStoreMock: &mockstore.SQLStoreMock{},
+			Teams:        []*models.TeamDTO{{Id: 10, OrgId: 1}, {Id: 11, OrgId: 1}},
+			TeamMembers:  map[int64]map[int64]models.PermissionType{1: {10: models.PERMISSION_ADMIN, 11: models.PERMISSION_ADMIN}},
+		}
+		login := Implementation{SQLStore: store}
+		externalUser := models.ExternalUserInfo{
+			AuthModule:      "ldap",
+			OrgRoles:        map[int64]models.RoleType{1: models.ROLE_EDITOR},
+			TeamPermissions: map[int64]models.PermissionType{1: models.PERMISSION_ADMIN},
+		}
+
+		_, err := login.syncInheritedTeamPermissions(context.Background(), logger, &user, &externalUser, nil, nil)
+		require.NoError(t, err)
+
+		externalUser.TeamPermissions = map[int64]models.PermissionType{1: 0}
+		_, err = login.syncInheritedTeamPermissions(context.Background(), logger, &user, &externalUser, nil, nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, 0, store.AddedTeamMembers)
+		assert.Equal(t, 0, store.UpdatedTeamMembers)
+		assert.Equal(t, map[int64]map[int64]models.PermissionType{1: {10: models.PERMISSION_ADMIN, 11: models.PERMISSION_ADMIN}}, store.TeamMembers)
+	})
+
+	t.Run("orgs outside the org filter are skipped", func(t *testing.T) {
+		store := &mockstore.SyncStoreMock{
+			SQLStoreMock: &mockstore.SQLStoreMock{},
+			Teams:        []*models.TeamDTO{{Id: 10, OrgId: 1}},
+		}
+		login := Implementation{SQLStore: store}
+
+		_, err := login.syncInheritedTeamPermissions(context.Background(), logger, &user, &externalUser, map[int64]bool{2: true}, nil)
+		require.NoError(t, err)
+
+		assert.Empty(t, store.TeamMembers)
+	})
+
+	t.Run("an acting user that cannot read the teams of the org skips team assignment", func(t *testing.T) {
+		store := &mockstore.SyncStoreMock{
+			SQLStoreMock: &mockstore.SQLStoreMock{},
+			Teams:        []*models.TeamDTO{{Id: 10, OrgId: 1}},
+		}
+		login := Implementation{SQLStore: store, AccessControl: acmock.New().WithPermissions([]ac.Permission{
+			{Action: ac.ActionOrgUsersRead, Scope: ac.ScopeUsersAll},
+		})}
+		restricted := &models.SignedInUser{Login: "sa-restricted", OrgId: 1}
+
+		skipped, err := login.syncInheritedTeamPermissions(context.Background(), logger, &user, &externalUser, nil, restricted)
+		require.NoError(t, err)
+
+		assert.Equal(t, []int64{1}, skipped)
+		assert.Nil(t, store.TeamsSearchedAs)
+		assert.Empty(t, store.TeamMembers)
+	})
+
+	t.Run("an acting user that can read teams lists them as itself", func(t *testing.T) {
+		store := &mockstore.SyncStoreMock{
+			SQLStoreMock: &mockstore.SQLStoreMock{},
+			Teams:        []*models.TeamDTO{{Id: 10, OrgId: 1}},
+		}
+		login := Implementation{SQLStore: store, AccessControl: acmock.New().WithPermissions([]ac.Permission{
+			{Action: ac.ActionTeamsRead, Scope: "teams:id:10"},
+		})}
+		scoped := &models.SignedInUser{Login: "sa-scoped", OrgId: 1}
+
+		skipped, err := login.syncInheritedTeamPermissions(context.Background(), logger, &user, &externalUser, nil, scoped)
+		require.NoError(t, err)
+
+		assert.Empty(t, skipped)
+		require.NotNil(t, store.TeamsSearchedAs)
+		assert.Equal(t, "sa-scoped", store.TeamsSearchedAs.Login)
+		assert.Equal(t, int64(1), store.TeamsSearchedAs.OrgId)
+		assert.Equal(t, map[int64]map[int64]models.PermissionType{1: {10: models.PERMISSION_ADMIN}}, store.TeamMembers)
+	})
+
+	t.Run("the permissions of the acting user are resolved for another org", func(t *testing.T) {
+		store := &mockstore.SyncStoreMock{
+			SQLStoreMock: &mockstore.SQLStoreMock{ExpectedUserOrgList: []*models.UserOrgDTO{
+				{OrgId: 1, Name: "Main", Role: models.ROLE_VIEWER},
+				{OrgId: 2, Name: "Other", Role: models.ROLE_ADMIN},
+			}},
+			Teams: []*models.TeamDTO{{Id: 20, OrgId: 2}},
+		}
+		accessControl := acmock.New()
+		accessControl.GetUserPermissionsFunc = func(ctx context.Context, user *models.SignedInUser, _ ac.Options) ([]ac.Permission, error) {
+			if user.OrgId == 2 && user.OrgRole == models.ROLE_ADMIN {
+				return []ac.Permission{{Action: ac.ActionTeamsRead, Scope: ac.ScopeTeamsAll}}, nil
+			}
+			return nil, nil
+		}
+		login := Implementation{SQLStore: store, AccessControl: accessControl}
+		acting := &models.SignedInUser{UserId: 5, Login: "admin-of-other", OrgId: 1, OrgRole: models.ROLE_VIEWER}
+		otherOrgUser := models.ExternalUserInfo{TeamPermissions: map[int64]models.PermissionType{2: models.PERMISSION_ADMIN}}
+
+		skipped, err := login.syncInheritedTeamPermissions(context.Background(), logger, &user, &otherOrgUser, nil, acting)
+		require.NoError(t, err)
+
+		assert.Empty(t, skipped)
+		require.NotNil(t, store.TeamsSearchedAs)
+		assert.Equal(t, int64(2), store.TeamsSearchedAs.OrgId)
+		assert.Equal(t, models.ROLE_ADMIN, store.TeamsSearchedAs.OrgRole)
+		assert.Equal(t, map[int64]map[int64]models.PermissionType{2: {20: models.PERMISSION_ADMIN}}, store.TeamMembers)
+	})
+
+	t.Run("without access control the org role of the acting user decides", func(t *testing.T) {
+		store := &mockstore.SyncStoreMock{
+			SQLStoreMock: &mockstore.SQLStoreMock{ExpectedUserOrgList: []*models.UserOrgDTO{
+				{OrgId: 1, Name: "Main", Role: models.ROLE_VIEWER},
+				{OrgId: 2, Name: "Other", Role: models.ROLE_ADMIN},
+			}},
+			Teams: []*models.TeamDTO{{Id: 20}},
+		}
+		login := Implementation{SQLStore: store, AccessControl: acmock.New().WithDisabled()}
+		acting := &models.SignedInUser{UserId: 5, Login: "admin-of-other", OrgId: 1, OrgRole: models.ROLE_VIEWER}
+		bothOrgsUser := models.ExternalUserInfo{TeamPermissions: map[int64]models.PermissionType{
+			1: models.PERMISSION_ADMIN,
+			2: models.PERMISSION_ADMIN,
+		}}
+
+		skipped, err := login.syncInheritedTeamPermissions(context.Background(), logger, &user, &bothOrgsUser, nil, acting)
+		require.NoError(t, err)
+
+		assert.Equal(t, []int64{1}, skipped)
+		require.NotNil(t, store.TeamsSearchedAs)
+		assert.Equal(t, int64(2), store.TeamsSearchedAs.OrgId)
+	})
+
+	t.Run("orgs without a team permission get the configured default", func(t *testing.T) {
+		store := &mockstore.SyncStoreMock{
+			SQLStoreMock: &mockstore.SQLStoreMock{},
+			Teams:        []*models.TeamDTO{{Id: 10, OrgId: 2}},
+		}
+		cfg := setting.NewCfg()
+		cfg.TeamSyncDefaultPermission = "Admin"
+		login, err := ProvideService(cfg, store, nil, nil, nil, nil, nil)
+		require.NoError(t, err)
+		externalUser := models.ExternalUserInfo{
+			AuthModule:                "ldap",
+			OrgRoles:                  map[int64]models.RoleType{2: models.ROLE_EDITOR},
+			DefaultTeamPermissionOrgs: map[int64]bool{2: true},
+		}
+
+		_, err = login.syncInheritedTeamPermissions(context.Background(), logger, &user, &externalUser, nil, nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, map[int64]map[int64]models.PermissionType{2: {10: models.PERMISSION_ADMIN}}, store.TeamMembers)
+	})
+
+	t.Run("an unknown default team permission is rejected", func(t *testing.T) {
+		cfg := setting.NewCfg()
+		cfg.TeamSyncDefaultPermission = "Owner"
+
+		_, err := ProvideService(cfg, &mockstore.SQLStoreMock{}, nil, nil, nil, nil, nil)
+		require.Error(t, err)
+	})
+}
+
 func Test_teamSync(t *testing.T) {
 	authInfoMock := &logintest.AuthInfoServiceFake{}
 	login := Implementation{
@@ -111,6 +602,708 @@ func Test_teamSync(t *testing.T) {
 	})
 }
 
+func Test_UpsertUser_profileSync(t *testing.T) {
+	lastLogin := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	extUser := &models.ExternalUserInfo{
+		AuthModule: "oauth_generic_oauth",
+		AuthId:     "1234",
+		Login:      "test_user",
+		Email:      "new@example.org",
+		Name:       "New Name",
+	}
+
+	upsert := func(t *testing.T, mode models.ProfileSyncMode, updated time.Time) *mockstore.SyncStoreMock {
+		t.Helper()
+		store := &mockstore.SyncStoreMock{SQLStoreMock: &mockstore.SQLStoreMock{}}
+		login := Implementation{
+			QuotaService: &quota.QuotaService{},
+			AuthInfoService: &logintest.AuthInfoServiceFake{
+				ExpectedUser: &user.User{
+					ID:      1,
+					Login:   "test_user",
+					Email:   "old@example.org",
+					Name:    "Old Name",
+					Updated: updated,
+				},
+				ExpectedUserAuth: &models.UserAuth{UserId: 1, Created: lastLogin},
+			},
+			SQLStore: store,
+		}
+
+		err := login.UpsertUser(context.Background(), &models.UpsertUserCommand{ExternalUser: extUser, ProfileSync: mode})
+		require.NoError(t, err)
+		return store
+	}
+
+	t.Run("name and email are applied from the external user", func(t *testing.T) {
+		store := upsert(t, models.ProfileSyncUnlessEdited, lastLogin.Add(-time.Hour))
+		require.Len(t, store.UpdatedUsers, 1)
+		assert.Equal(t, "new@example.org", store.UpdatedUsers[0].Email)
+		assert.Equal(t, "New Name", store.UpdatedUsers[0].Name)
+	})
+
+	t.Run("a profile edited since the last login is kept", func(t *testing.T) {
+		store := upsert(t, models.ProfileSyncUnlessEdited, lastLogin.Add(time.Hour))
+		assert.Empty(t, store.UpdatedUsers)
+	})
+
+	t.Run("a profile edited since the last login is overwritten when forced", func(t *testing.T) {
+		store := upsert(t, models.ProfileSyncAlways, lastLogin.Add(time.Hour))
+		require.Len(t, store.UpdatedUsers, 1)
+		assert.Equal(t, "new@example.org", store.UpdatedUsers[0].Email)
+		assert.Equal(t, "New Name", store.UpdatedUsers[0].Name)
+	})
+
+	t.Run("the profile is never synced when disabled", func(t *testing.T) {
+		store := upsert(t, models.ProfileSyncNever, lastLogin.Add(-time.Hour))
+		assert.Empty(t, store.UpdatedUsers)
+	})
+}
+
+func Test_UpsertUser_memberships(t *testing.T) {
+	store := &mockstore.SyncStoreMock{
+		SQLStoreMock: &mockstore.SQLStoreMock{
+			ExpectedUserOrgList: []*models.UserOrgDTO{
+				{OrgId: 2, Name: "Ops", Role: models.ROLE_EDITOR},
+				{OrgId: 1, Name: "Main", Role: models.ROLE_ADMIN},
+			},
+		},
+		TeamMembers: map[int64]map[int64]models.PermissionType{
+			1: {12: models.PERMISSION_ADMIN, 10: 0},
+			2: {20: 0},
+		},
+	}
+	login := Implementation{
+		QuotaService:    &quota.QuotaService{},
+		AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedUser: &user.User{ID: 1, Login: "test_user"}},
+		SQLStore:        store,
+	}
+	extUser := &models.ExternalUserInfo{
+		AuthModule: "ldap",
+		Login:      "test_user",
+		OrgRoles:   map[int64]models.RoleType{1: models.ROLE_ADMIN, 2: models.ROLE_EDITOR},
+	}
+
+	t.Run("the memberships are not read unless requested", func(t *testing.T) {
+		cmd := &models.UpsertUserCommand{ExternalUser: extUser}
+		require.NoError(t, login.UpsertUser(context.Background(), cmd))
+
+		assert.Nil(t, cmd.Memberships)
+	})
+
+	t.Run("the memberships of both orgs are reported", func(t *testing.T) {
+		cmd := &models.UpsertUserCommand{ExternalUser: extUser, ReportMemberships: true}
+		require.NoError(t, login.UpsertUser(context.Background(), cmd))
+
+		assert.Equal(t, []models.OrgMembership{
+			{
+				OrgId:   1,
+				OrgName: "Main",
+				Role:    models.ROLE_ADMIN,
+				Teams: []models.TeamMembership{
+					{TeamId: 10},
+					{TeamId: 12, Permission: models.PERMISSION_ADMIN},
+				},
+			},
+			{
+				OrgId:   2,
+				OrgName: "Ops",
+				Role:    models.ROLE_EDITOR,
+				Teams:   []models.TeamMembership{{TeamId: 20}},
+			},
+		}, cmd.Memberships)
+	})
+}
+
+func Test_UpsertUser_constrainToOrg(t *testing.T) {
+	isAdmin := true
+	extUser := &models.ExternalUserInfo{
+		AuthModule:     "ldap",
+		Login:          "test_user",
+		IsGrafanaAdmin: &isAdmin,
+		OrgRoles: map[int64]models.RoleType{
+			1:  models.ROLE_EDITOR,
+			10: models.ROLE_EDITOR,
+			12: models.ROLE_VIEWER,
+		},
+	}
+	upsert := func(t *testing.T, cmd *models.UpsertUserCommand) *mockstore.SyncStoreMock {
+		t.Helper()
+		store := &mockstore.SyncStoreMock{
+			SQLStoreMock: &mockstore.SQLStoreMock{ExpectedUserOrgList: createUserOrgDTO()},
+		}
+		login := Implementation{
+			QuotaService:    &quota.QuotaService{},
+			AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedUser: &user.User{ID: 1, Login: "test_user"}},
+			SQLStore:        store,
+		}
+
+		require.NoError(t, login.UpsertUser(context.Background(), cmd))
+		return store
+	}
+
+	t.Run("roles in other orgs are rejected", func(t *testing.T) {
+		cmd := &models.UpsertUserCommand{ExternalUser: extUser, ConstrainToOrg: 1}
+		store := upsert(t, cmd)
+
+		require.Len(t, store.UpdatedOrgUsers, 1)
+		assert.Equal(t, int64(1), store.UpdatedOrgUsers[0].OrgId)
+		assert.Equal(t, models.ROLE_EDITOR, store.UpdatedOrgUsers[0].Role)
+		assert.Empty(t, store.RemovedOrgUsers)
+		assert.Equal(t, []int64{10, 12}, cmd.RejectedOrgIds)
+		assert.Len(t, extUser.OrgRoles, 3)
+	})
+
+	t.Run("the constraint still applies when the org filter allows other orgs", func(t *testing.T) {
+		cmd := &models.UpsertUserCommand{ExternalUser: extUser, ConstrainToOrg: 1, OrgFilter: []string{"Bar", "Foo"}}
+		store := upsert(t, cmd)
+
+		require.Len(t, store.UpdatedOrgUsers, 1)
+		assert.Equal(t, int64(1), store.UpdatedOrgUsers[0].OrgId)
+		assert.Equal(t, []int64{10, 12}, cmd.RejectedOrgIds)
+	})
+
+	t.Run("nothing is rejected without a constraint", func(t *testing.T) {
+		cmd := &models.UpsertUserCommand{ExternalUser: extUser}
+		store := upsert(t, cmd)
+
+		require.Len(t, store.RemovedOrgUsers, 1)
+		assert.Equal(t, int64(11), store.RemovedOrgUsers[0].OrgId)
+		assert.Nil(t, cmd.RejectedOrgIds)
+	})
+}
+
+func Test_OrphanedOrgMappings(t *testing.T) {
+	service := Implementation{
+		SQLStore: &mockstore.SQLStoreMock{
+			// Org 2 was deleted since the mappings were written
+			ExpectedSearchOrgList: []*models.OrgDTO{{Id: 1, Name: "Main"}, {Id: 3, Name: "Ops"}},
+		},
+	}
+
+	t.Run("the mappings into a deleted org are reported", func(t *testing.T) {
+		orphaned, err := service.OrphanedOrgMappings(context.Background(), []login.OrgMapping{
+			{OrgID: 1, Role: models.ROLE_VIEWER},
+			{OrgID: 2, Role: models.ROLE_EDITOR},
+			{OrgID: 3, Role: models.ROLE_ADMIN},
+			{OrgID: 2, Role: models.ROLE_VIEWER},
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, []login.OrgMapping{
+			{OrgID: 2, Role: models.ROLE_EDITOR},
+			{OrgID: 2, Role: models.ROLE_VIEWER},
+		}, orphaned)
+	})
+
+	t.Run("nothing is reported when every org exists", func(t *testing.T) {
+		orphaned, err := service.OrphanedOrgMappings(context.Background(), []login.OrgMapping{{OrgID: 3, Role: models.ROLE_ADMIN}})
+
+		require.NoError(t, err)
+		assert.Empty(t, orphaned)
+	})
+}
+
+func Test_UpsertUser_maxOrgs(t *testing.T) {
+	store := &mockstore.SyncStoreMock{SQLStoreMock: &mockstore.SQLStoreMock{}}
+	authInfo := &logintest.AuthInfoServiceFake{ExpectedUser: &user.User{ID: 1, Login: "test_user"}}
+	svc := Implementation{
+		QuotaService:    &quota.QuotaService{},
+		AuthInfoService: authInfo,
+		SQLStore:        store,
+		OrgMappings: fakeOrgMappingProvider{mappings: []login.OrgMapping{
+			{OrgID: 1, Role: models.ROLE_VIEWER},
+			{OrgID: 2, Role: models.ROLE_VIEWER},
+			{OrgID: 3, Role: models.ROLE_VIEWER},
+		}},
+		maxOrgs: 2,
+	}
+
+	err := svc.UpsertUser(context.Background(), &models.UpsertUserCommand{
+		ExternalUser: &models.ExternalUserInfo{UserId: 1, AuthModule: "oauth_generic_oauth", Login: "test_user"},
+	})
+
+	require.ErrorIs(t, err, login.ErrTooManyOrgs)
+	assert.Contains(t, err.Error(), "mapped into 3 organizations, more than the limit of 2")
+	assert.Zero(t, authInfo.LatestUserID, "the user should not be looked up")
+	assert.Empty(t, store.AddedOrgUsers)
+
+	t.Run("a sync within the limit is applied", func(t *testing.T) {
+		svc.maxOrgs = 3
+
+		err := svc.UpsertUser(context.Background(), &models.UpsertUserCommand{
+			ExternalUser: &models.ExternalUserInfo{UserId: 1, AuthModule: "oauth_generic_oauth", Login: "test_user"},
+		})
+
+		require.NoError(t, err)
+		assert.Len(t, store.AddedOrgUsers, 3)
+	})
+}
+
+func Test_UpsertUser_lastSynced(t *testing.T) {
+	authInfo := &logintest.AuthInfoServiceFake{ExpectedUser: &user.User{ID: 1, Login: "test_user"}}
+	svc := Implementation{
+		QuotaService:    &quota.QuotaService{},
+		AuthInfoService: authInfo,
+		SQLStore:        &mockstore.SQLStoreMock{},
+	}
+
+	t.Run("a successful sync is recorded against the auth module", func(t *testing.T) {
+		err := svc.UpsertUser(context.Background(), &models.UpsertUserCommand{
+			ExternalUser: &models.ExternalUserInfo{UserId: 1, AuthModule: models.AuthModuleLDAP, Login: "test_user"},
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, map[int64]string{1: models.AuthModuleLDAP}, authInfo.LastSynced)
+	})
+
+	t.Run("a failed sync is not recorded", func(t *testing.T) {
+		authInfo.LastSynced = nil
+		svc.SQLStore = &mockstore.SQLStoreMock{ExpectedError: errors.New("database is locked")}
+		isAdmin := true
+
+		err := svc.UpsertUser(context.Background(), &models.UpsertUserCommand{
+			ExternalUser: &models.ExternalUserInfo{
+				UserId: 1, AuthModule: models.AuthModuleLDAP, Login: "test_user", IsGrafanaAdmin: &isAdmin,
+			},
+		})
+
+		require.Error(t, err)
+		assert.Empty(t, authInfo.LastSynced)
+	})
+}
+
+func Test_UpsertUser_defaultTeams(t *testing.T) {
+	store := &mockstore.SyncStoreMock{SQLStoreMock: &mockstore.SQLStoreMock{}}
+	svc := Implementation{
+		QuotaService:    &quota.QuotaService{},
+		AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedUser: &user.User{ID: 1, Login: "test_user"}},
+		SQLStore:        store,
+		defaultTeams:    map[int64]int64{1: 10, 3: 30},
+	}
+
+	t.Run("the default team is joined when the user is added to the org", func(t *testing.T) {
+		err := svc.UpsertUser(context.Background(), &models.UpsertUserCommand{
+			ExternalUser: &models.ExternalUserInfo{
+				UserId: 1, AuthModule: models.AuthModuleLDAP, Login: "test_user",
+				OrgRoles: map[int64]models.RoleType{1: models.ROLE_VIEWER, 2: models.ROLE_EDITOR},
+			},
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, map[int64]models.RoleType{1: models.ROLE_VIEWER, 2: models.ROLE_EDITOR}, store.AddedOrgUsers)
+		assert.Equal(t, map[int64]map[int64]models.PermissionType{1: {10: 0}}, store.TeamMembers)
+	})
+
+	t.Run("a user already in the default team is left as they are", func(t *testing.T) {
+		err := svc.joinDefaultTeam(logger, &user.User{ID: 1}, 1)
+
+		require.NoError(t, err)
+		assert.Equal(t, map[int64]map[int64]models.PermissionType{1: {10: 0}}, store.TeamMembers)
+		assert.Equal(t, 1, store.AddedTeamMembers)
+	})
+}
+
+func Test_PlanMappings(t *testing.T) {
+	service := Implementation{
+		SQLStore: &mockstore.SyncStoreMock{
+			SQLStoreMock: &mockstore.SQLStoreMock{
+				ExpectedUserOrgList: []*models.UserOrgDTO{
+					{OrgId: 1, Name: "Main", Role: models.ROLE_VIEWER},
+					{OrgId: 3, Name: "Ops", Role: models.ROLE_EDITOR},
+				},
+				// Org 4 does not exist
+				ExpectedSearchOrgList: []*models.OrgDTO{{Id: 2, Name: "Dev"}},
+			},
+			Users: []*user.User{{ID: 1, Login: "test", Email: "test@example.org"}},
+		},
+	}
+
+	t.Run("a mapping into a new org is planned as an addition", func(t *testing.T) {
+		plan, err := service.PlanMappings(context.Background(), "test@example.org", []login.OrgMapping{
+			{OrgID: 1, Role: models.ROLE_VIEWER},
+			{OrgID: 2, Role: models.ROLE_EDITOR},
+			{OrgID: 3, Role: models.ROLE_EDITOR},
+			{OrgID: 4, Role: models.ROLE_ADMIN},
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), plan.UserID)
+		assert.Equal(t, login.OrgRoleChange{OrgID: 2, OrgName: "Dev", Action: login.SyncPlanAdd, Role: models.ROLE_EDITOR}, plan.Changes[1])
+		assert.Equal(t, []int64{4}, plan.MissingOrgIDs)
+	})
+
+	t.Run("changed and missing roles are planned as updates and removals", func(t *testing.T) {
+		plan, err := service.PlanMappings(context.Background(), "test@example.org", []login.OrgMapping{
+			{OrgID: 1, Role: models.ROLE_VIEWER},
+			{OrgID: 1, Role: models.ROLE_ADMIN},
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, []login.OrgRoleChange{
+			{OrgID: 1, OrgName: "Main", Action: login.SyncPlanUpdate, CurrentRole: models.ROLE_VIEWER, Role: models.ROLE_ADMIN},
+			{OrgID: 3, OrgName: "Ops", Action: login.SyncPlanRemove, CurrentRole: models.ROLE_EDITOR},
+		}, plan.Changes)
+		assert.Empty(t, plan.MissingOrgIDs)
+	})
+
+	t.Run("mappings matching the current roles change nothing", func(t *testing.T) {
+		for _, mappings := range [][]login.OrgMapping{
+			{{OrgID: 1, Role: models.ROLE_VIEWER}, {OrgID: 3, Role: models.ROLE_EDITOR}},
+			nil,
+		} {
+			plan, err := service.PlanMappings(context.Background(), "test@example.org", mappings)
+
+			require.NoError(t, err)
+			assert.Equal(t, []login.OrgRoleChange{
+				{OrgID: 1, OrgName: "Main", Action: login.SyncPlanKeep, CurrentRole: models.ROLE_VIEWER, Role: models.ROLE_VIEWER},
+				{OrgID: 3, OrgName: "Ops", Action: login.SyncPlanKeep, CurrentRole: models.ROLE_EDITOR, Role: models.ROLE_EDITOR},
+			}, plan.Changes)
+		}
+	})
+}
+
+func Test_PlanRemovals(t *testing.T) {
+	store := &mockstore.SyncStoreMock{
+		SQLStoreMock: &mockstore.SQLStoreMock{
+			ExpectedUserOrgList: []*models.UserOrgDTO{
+				{OrgId: 1, Name: "Main", Role: models.ROLE_VIEWER},
+				{OrgId: 3, Name: "Ops", Role: models.ROLE_EDITOR},
+			},
+		},
+		Users: []*user.User{
+			{ID: 1, Login: "test", Email: "test@example.org"},
+			{ID: 2, Login: "other", Email: "other@example.org"},
+		},
+		TeamMembers: map[int64]map[int64]models.PermissionType{3: {31: 0, 30: 0}},
+	}
+	service := Implementation{SQLStore: store}
+
+	plans, err := service.PlanRemovals(context.Background(), map[string][]login.OrgMapping{
+		"test@example.org":  {{OrgID: 1, Role: models.ROLE_VIEWER}},
+		"other@example.org": {{OrgID: 1, Role: models.ROLE_VIEWER}, {OrgID: 3, Role: models.ROLE_EDITOR}},
+		"new@example.org":   {{OrgID: 1, Role: models.ROLE_VIEWER}},
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []login.UserRemovalPlan{{
+		UserID:    1,
+		UserLogin: "test",
+		Email:     "test@example.org",
+		Removals:  []login.PlannedRemoval{{OrgID: 3, OrgName: "Ops", Role: models.ROLE_EDITOR, TeamIDs: []int64{30, 31}}},
+	}}, plans)
+	assert.Empty(t, store.RemovedOrgUsers)
+}
+
+func Test_PlanRemovals_MixedCaseEmail(t *testing.T) {
+	store := &mockstore.SyncStoreMock{
+		SQLStoreMock: &mockstore.SQLStoreMock{
+			ExpectedUserOrgList: []*models.UserOrgDTO{
+				{OrgId: 1, Name: "Main", Role: models.ROLE_VIEWER},
+				{OrgId: 3, Name: "Ops", Role: models.ROLE_EDITOR},
+			},
+		},
+		Users: []*user.User{{ID: 1, Login: "test", Email: "Test@Example.org"}},
+	}
+	service := Implementation{SQLStore: store}
+
+	plans, err := service.PlanRemovals(context.Background(), map[string][]login.OrgMapping{
+		" TEST@example.ORG": {{OrgID: 1, Role: models.ROLE_VIEWER}},
+	})
+
+	require.NoError(t, err)
+	require.Len(t, plans, 1)
+	assert.Equal(t, int64(1), plans[0].UserID)
+	assert.Equal(t, []login.PlannedRemoval{{OrgID: 3, OrgName: "Ops", Role: models.ROLE_EDITOR}}, plans[0].Removals)
+}
+
+func Test_ApplySync(t *testing.T) {
+	plan := func(t *testing.T) (*mockstore.SyncStoreMock, *login.PlannedSync) {
+		t.Helper()
+		store := &mockstore.SyncStoreMock{
+			SQLStoreMock: &mockstore.SQLStoreMock{
+				ExpectedUserOrgList: []*models.UserOrgDTO{
+					{OrgId: 1, Name: "Main", Role: models.ROLE_VIEWER},
+					{OrgId: 3, Name: "Ops", Role: models.ROLE_EDITOR},
+				},
+				ExpectedSearchOrgList: []*models.OrgDTO{{Id: 2, Name: "Dev"}},
+			},
+			Users: []*user.User{{ID: 1, Login: "test", Email: "test@example.org"}},
+		}
+		service := Implementation{SQLStore: store}
+		planned, err := service.PlanSync(context.Background(), "test@example.org", []login.OrgMapping{
+			{OrgID: 1, Role: models.ROLE_ADMIN},
+			{OrgID: 2, Role: models.ROLE_EDITOR},
+		})
+		require.NoError(t, err)
+		require.NotEmpty(t, planned.Token)
+		return store, planned
+	}
+
+	t.Run("applying a plan after unchanged memberships makes the planned changes", func(t *testing.T) {
+		store, planned := plan(t)
+		emitter := &syncEventRecorder{}
+		service := Implementation{SQLStore: store, SyncEvents: emitter}
+
+		require.NoError(t, service.ApplySync(context.Background(), planned))
+		assert.Equal(t, map[int64]models.RoleType{2: models.ROLE_EDITOR}, store.AddedOrgUsers)
+		require.Len(t, store.UpdatedOrgUsers, 1)
+		assert.Equal(t, int64(1), store.UpdatedOrgUsers[0].OrgId)
+		assert.Equal(t, models.ROLE_ADMIN, store.UpdatedOrgUsers[0].Role)
+		require.Len(t, store.RemovedOrgUsers, 1)
+		assert.Equal(t, int64(3), store.RemovedOrgUsers[0].OrgId)
+		assert.Len(t, emitter.events, 3)
+	})
+
+	t.Run("applying a plan after changed memberships is rejected", func(t *testing.T) {
+		store, planned := plan(t)
+		store.ExpectedUserOrgList = []*models.UserOrgDTO{
+			{OrgId: 1, Name: "Main", Role: models.ROLE_EDITOR},
+			{OrgId: 3, Name: "Ops", Role: models.ROLE_EDITOR},
+		}
+		service := Implementation{SQLStore: store}
+
+		err := service.ApplySync(context.Background(), planned)
+		require.ErrorIs(t, err, login.ErrSyncPlanOutdated)
+		assert.Empty(t, store.AddedOrgUsers)
+		assert.Empty(t, store.UpdatedOrgUsers)
+		assert.Empty(t, store.RemovedOrgUsers)
+	})
+
+	t.Run("applying an altered plan is rejected", func(t *testing.T) {
+		store, planned := plan(t)
+		planned.Changes[0].Role = models.ROLE_VIEWER
+		service := Implementation{SQLStore: store}
+
+		err := service.ApplySync(context.Background(), planned)
+		require.ErrorIs(t, err, login.ErrSyncPlanOutdated)
+		assert.Empty(t, store.UpdatedOrgUsers)
+	})
+}
+
+func Test_UpsertUser_minOrgRoles(t *testing.T) {
+	upsert := func(t *testing.T, orgRoles map[int64]models.RoleType) *mockstore.SyncStoreMock {
+		t.Helper()
+		store := &mockstore.SyncStoreMock{SQLStoreMock: &mockstore.SQLStoreMock{}}
+		login := Implementation{
+			QuotaService:    &quota.QuotaService{},
+			AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedUser: &user.User{ID: 1, Login: "test_user"}},
+			SQLStore:        store,
+			minOrgRoles:     map[int64]models.RoleType{2: models.ROLE_VIEWER, 3: models.ROLE_EDITOR},
+		}
+		extUser := &models.ExternalUserInfo{AuthModule: "ldap", Login: "test_user", OrgRoles: orgRoles}
+
+		require.NoError(t, login.UpsertUser(context.Background(), &models.UpsertUserCommand{ExternalUser: extUser}))
+		return store
+	}
+
+	t.Run("an org without a mapped role gets the minimum role", func(t *testing.T) {
+		store := upsert(t, map[int64]models.RoleType{1: models.ROLE_EDITOR, 3: models.ROLE_ADMIN})
+
+		assert.Equal(t, map[int64]models.RoleType{
+			1: models.ROLE_EDITOR,
+			2: models.ROLE_VIEWER,
+			3: models.ROLE_ADMIN,
+		}, store.AddedOrgUsers)
+	})
+
+	t.Run("a lower mapped role is raised to the minimum role", func(t *testing.T) {
+		store := upsert(t, map[int64]models.RoleType{3: models.ROLE_VIEWER})
+
+		assert.Equal(t, models.ROLE_EDITOR, store.AddedOrgUsers[3])
+	})
+
+	t.Run("users without any org role are left alone", func(t *testing.T) {
+		store := upsert(t, nil)
+
+		assert.Empty(t, store.AddedOrgUsers)
+	})
+}
+
+func Test_UpsertUser_orgMappingProvider(t *testing.T) {
+	extUser := &models.ExternalUserInfo{
+		AuthModule: "oauth_generic_oauth",
+		Login:      "test_user",
+		OrgRoles:   map[int64]models.RoleType{1: models.ROLE_VIEWER},
+	}
+	upsertCmd := func(t *testing.T, provider login.OrgMappingProvider) (*mockstore.SyncStoreMock, *models.UpsertUserCommand) {
+		t.Helper()
+		store := &mockstore.SyncStoreMock{SQLStoreMock: &mockstore.SQLStoreMock{}}
+		login := Implementation{
+			QuotaService:    &quota.QuotaService{},
+			AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedUser: &user.User{ID: 1, Login: "test_user"}},
+			SQLStore:        store,
+			OrgMappings:     provider,
+		}
+
+		cmd := &models.UpsertUserCommand{ExternalUser: extUser}
+		err := login.UpsertUser(context.Background(), cmd)
+		require.NoError(t, err)
+		return store, cmd
+	}
+	upsert := func(t *testing.T, provider login.OrgMappingProvider) *mockstore.SyncStoreMock {
+		t.Helper()
+		store, _ := upsertCmd(t, provider)
+		return store
+	}
+
+	t.Run("the provider replaces the org roles of the external user", func(t *testing.T) {
+		store := upsert(t, fakeOrgMappingProvider{mappings: []login.OrgMapping{
+			{OrgID: 2, Role: models.ROLE_EDITOR},
+			{OrgID: 3, Role: models.ROLE_ADMIN},
+		}})
+
+		assert.Equal(t, map[int64]models.RoleType{2: models.ROLE_EDITOR, 3: models.ROLE_ADMIN}, store.AddedOrgUsers)
+		assert.Equal(t, map[int64]models.RoleType{1: models.ROLE_VIEWER}, extUser.OrgRoles)
+	})
+
+	t.Run("the provider can add to the org roles of the external user", func(t *testing.T) {
+		store := upsert(t, fakeOrgMappingProvider{
+			mappings: []login.OrgMapping{{OrgID: 1, Role: models.ROLE_EDITOR}, {OrgID: 2, Role: models.ROLE_EDITOR}},
+			extend:   true,
+		})
+
+		assert.Equal(t, map[int64]models.RoleType{1: models.ROLE_EDITOR, 2: models.ROLE_EDITOR}, store.AddedOrgUsers)
+	})
+
+	t.Run("the highest role applies when mappings conflict in an org, whatever their order", func(t *testing.T) {
+		for _, mappings := range [][]login.OrgMapping{
+			{{OrgID: 2, Role: models.ROLE_EDITOR}, {OrgID: 2, Role: models.ROLE_ADMIN}, {OrgID: 3, Role: models.ROLE_VIEWER}},
+			{{OrgID: 3, Role: models.ROLE_VIEWER}, {OrgID: 2, Role: models.ROLE_ADMIN}, {OrgID: 2, Role: models.ROLE_EDITOR}},
+		} {
+			store, cmd := upsertCmd(t, fakeOrgMappingProvider{mappings: mappings})
+
+			assert.Equal(t, map[int64]models.RoleType{2: models.ROLE_ADMIN, 3: models.ROLE_VIEWER}, store.AddedOrgUsers)
+			require.Len(t, cmd.OrgMappingConflicts, 1)
+			assert.Equal(t, int64(2), cmd.OrgMappingConflicts[0].OrgId)
+			assert.Equal(t, models.ROLE_ADMIN, cmd.OrgMappingConflicts[0].AppliedRole)
+			assert.ElementsMatch(t, []models.RoleType{models.ROLE_EDITOR, models.ROLE_ADMIN}, cmd.OrgMappingConflicts[0].Roles)
+		}
+	})
+
+	t.Run("repeating the same role in an org is not a conflict", func(t *testing.T) {
+		_, cmd := upsertCmd(t, fakeOrgMappingProvider{mappings: []login.OrgMapping{
+			{OrgID: 2, Role: models.ROLE_EDITOR},
+			{OrgID: 2, Role: models.ROLE_EDITOR},
+		}})
+
+		assert.Empty(t, cmd.OrgMappingConflicts)
+	})
+
+	t.Run("the external user keeps its org roles without a provider", func(t *testing.T) {
+		store := upsert(t, nil)
+
+		assert.Equal(t, map[int64]models.RoleType{1: models.ROLE_VIEWER}, store.AddedOrgUsers)
+	})
+}
+
+func Test_UpsertUser_logKeys(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger.Swap(level.NewFilter(log.NewLogfmtLogger(buf), level.AllowAll()))
+
+	store := &mockstore.SyncStoreMock{SQLStoreMock: &mockstore.SQLStoreMock{}}
+	login := Implementation{
+		QuotaService:    &quota.QuotaService{},
+		AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedUser: &user.User{ID: 1, Login: "test_user"}},
+		SQLStore:        store,
+		OrgMappings: fakeOrgMappingProvider{mappings: []login.OrgMapping{
+			{OrgID: 2, Role: models.ROLE_EDITOR},
+			{OrgID: 2, Role: models.ROLE_ADMIN},
+			{OrgID: 3, Role: models.ROLE_VIEWER},
+		}},
+	}
+	cmd := &models.UpsertUserCommand{
+		ExternalUser: &models.ExternalUserInfo{
+			AuthModule: "oauth_generic_oauth",
+			Login:      "test_user",
+			Email:      "test_user@example.org",
+		},
+		ConstrainToOrg: 2,
+	}
+	require.NoError(t, login.UpsertUser(context.Background(), cmd))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.NotEmpty(t, lines)
+	keyPattern := regexp.MustCompile(`(?:^| )([^ =]+)=`)
+	snakeCase := regexp.MustCompile(`^[a-z]+(_[a-z]+)*$`)
+	for _, line := range lines {
+		keys := map[string]bool{}
+		for _, match := range keyPattern.FindAllStringSubmatch(line, -1) {
+			assert.Regexp(t, snakeCase, match[1], line)
+			keys[match[1]] = true
+		}
+		// Every line of the sync of the user shares the fields identifying it.
+		for _, key := range []string{"user_id", "user_login", "user_email", "auth_module"} {
+			assert.True(t, keys[key], "missing %s in %s", key, line)
+		}
+	}
+	assert.Contains(t, buf.String(), `msg="Organization mappings give the user different roles in the same organization, applying the highest"`)
+	assert.Contains(t, buf.String(), `constrain_to_org=2 org_ids=[3]`)
+}
+
+func Test_UpsertUser_syncEvents(t *testing.T) {
+	store := &mockstore.SyncStoreMock{SQLStoreMock: &mockstore.SQLStoreMock{
+		ExpectedUserOrgList: []*models.UserOrgDTO{
+			{OrgId: 1, Name: "Main", Role: models.ROLE_VIEWER},
+			{OrgId: 3, Name: "Former", Role: models.ROLE_EDITOR},
+		},
+	}}
+	emitter := &syncEventRecorder{}
+	svc := Implementation{
+		QuotaService:    &quota.QuotaService{},
+		AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedUser: &user.User{ID: 1, Login: "test_user", OrgID: 1}},
+		SQLStore:        store,
+		OrgMappings: fakeOrgMappingProvider{mappings: []login.OrgMapping{
+			{OrgID: 1, Role: models.ROLE_EDITOR},
+			{OrgID: 2, Role: models.ROLE_ADMIN},
+		}},
+	}
+	svc.SetSyncEventEmitter(emitter)
+
+	err := svc.UpsertUser(context.Background(), &models.UpsertUserCommand{
+		ExternalUser: &models.ExternalUserInfo{AuthModule: "oauth_generic_oauth", Login: "test_user"},
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []login.SyncEvent{
+		{Type: login.SyncEventOrgRoleUpdated, UserID: 1, UserLogin: "test_user", OrgID: 1, Role: models.ROLE_EDITOR, PreviousRole: models.ROLE_VIEWER},
+		{Type: login.SyncEventOrgUserAdded, UserID: 1, UserLogin: "test_user", OrgID: 2, Role: models.ROLE_ADMIN},
+		{Type: login.SyncEventOrgUserRemoved, UserID: 1, UserLogin: "test_user", OrgID: 3, PreviousRole: models.ROLE_EDITOR},
+	}, emitter.events)
+}
+
+type syncEventRecorder struct {
+	events []login.SyncEvent
+}
+
+func (r *syncEventRecorder) Emit(event login.SyncEvent) {
+	r.events = append(r.events, event)
+}
+
+type fakeOrgMappingProvider struct {
+	mappings []login.OrgMapping
+	// extend adds the mappings to the ones of the default provider.
+	extend bool
+}
+
+func (f fakeOrgMappingProvider) MappingsFor(ctx context.Context, user *models.ExternalUserInfo) ([]login.OrgMapping, error) {
+	if !f.extend {
+		return f.mappings, nil
+	}
+	mappings, err := login.ExternalUserOrgMappings{}.MappingsFor(ctx, user)
+	return append(mappings, f.mappings...), err
+}
+
+type preferencePatchRecorder struct {
+	*preftest.FakePreferenceService
+	patched []*pref.PatchPreferenceCommand
+}
+
+func (r *preferencePatchRecorder) Patch(ctx context.Context, cmd *pref.PatchPreferenceCommand) error {
+	r.patched = append(r.patched, cmd)
+	return nil
+}
+
 func createSimpleUser() user.User {
 	user := user.User{
 		ID: 1,
@@ -19,13 +19,34 @@ func (l *LoginServiceFake) UpsertUser(ctx context.Context, cmd *models.UpsertUse
 func (l *LoginServiceFake) DisableExternalUser(ctx context.Context, username string) error {
 	return nil
 }
-func (l *LoginServiceFake) SetTeamSyncFunc(login.TeamSyncFunc) {}
+func (l *LoginServiceFake) SetTeamSyncFunc(login.TeamSyncFunc)             {}
+func (l *LoginServiceFake) SetOrgMappingProvider(login.OrgMappingProvider) {}
+func (l *LoginServiceFake) SetSyncEventEmitter(login.SyncEventEmitter)     {}
+func (l *LoginServiceFake) OrphanedOrgMappings(ctx context.Context, mappings []login.OrgMapping) ([]login.OrgMapping, error) {
+	return nil, nil
+}
+func (l *LoginServiceFake) PlanMappings(ctx context.Context, email string, mappings []login.OrgMapping) (*login.SyncPlan, error) {
+	return nil, nil
+}
+func (l *LoginServiceFake) PlanRemovals(ctx context.Context, mappings map[string][]login.OrgMapping) ([]login.UserRemovalPlan, error) {
+	return nil, nil
+}
+func (l *LoginServiceFake) PlanSync(ctx context.Context, email string, mappings []login.OrgMapping) (*login.PlannedSync, error) {
+	return nil, nil
+}
+func (l *LoginServiceFake) ApplySync(ctx context.Context, plan *login.PlannedSync) error {
+	return nil
+}
 
 type AuthInfoServiceFake struct {
 	LatestUserID         int64
 	ExpectedUser         *user.User
 	ExpectedExternalUser *models.ExternalUserInfo
+	ExpectedUserAuth     *models.UserAuth
+	ExpectedNotSynced    []*models.UserAuth
 	ExpectedError        error
+	// LastSynced are the auth modules the users were recorded as synced from, keyed by user ID.
+	LastSynced map[int64]string
 }
 
 func (a *AuthInfoServiceFake) LookupAndUpdate(ctx context.Context, query *models.GetUserByAuthInfoQuery) (*user.User, error) {
@@ -35,6 +56,7 @@ func (a *AuthInfoServiceFake) LookupAndUpdate(ctx context.Context, query *models
 
 func (a *AuthInfoServiceFake) GetAuthInfo(ctx context.Context, query *models.GetAuthInfoQuery) error {
 	a.LatestUserID = query.UserId
+	query.Result = a.ExpectedUserAuth
 	return a.ExpectedError
 }
 
@@ -46,6 +68,22 @@ func (a *AuthInfoServiceFake) UpdateAuthInfo(ctx context.Context, cmd *models.Up
 	return a.ExpectedError
 }
 
+func (a *AuthInfoServiceFake) UpdateAuthInfoLastSynced(ctx context.Context, cmd *models.UpdateAuthInfoLastSyncedCommand) error {
+	if a.ExpectedError != nil {
+		return a.ExpectedError
+	}
+	if a.LastSynced == nil {
+		a.LastSynced = map[int64]string{}
+	}
+	a.LastSynced[cmd.UserId] = cmd.AuthModule
+	return nil
+}
+
+func (a *AuthInfoServiceFake) GetAuthInfoNotSyncedSince(ctx context.Context, query *models.GetAuthInfoNotSyncedSinceQuery) error {
+	query.Result = a.ExpectedNotSynced
+	return a.ExpectedError
+}
+
 func (a *AuthInfoServiceFake) GetExternalUserInfoByLogin(ctx context.Context, query *models.GetExternalUserInfoByLoginQuery) error {
 	query.Result = a.ExpectedExternalUser
 	return a.ExpectedError
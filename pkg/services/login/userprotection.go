@@ -17,6 +17,8 @@ type Store interface {
 	SetAuthInfo(ctx context.Context, cmd *models.SetAuthInfoCommand) error
 	UpdateAuthInfo(ctx context.Context, cmd *models.UpdateAuthInfoCommand) error
 	UpdateAuthInfoDate(ctx context.Context, authInfo *models.UserAuth) error
+	UpdateAuthInfoLastSynced(ctx context.Context, cmd *models.UpdateAuthInfoLastSyncedCommand) error
+	GetAuthInfoNotSyncedSince(ctx context.Context, query *models.GetAuthInfoNotSyncedSinceQuery) error
 	DeleteAuthInfo(ctx context.Context, cmd *models.DeleteAuthInfoCommand) error
 	GetUserById(ctx context.Context, id int64) (*user.User, error)
 	GetUserByLogin(ctx context.Context, login string) (*user.User, error)
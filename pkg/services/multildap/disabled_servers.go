@@ -0,0 +1,71 @@
+package multildap
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/grafana/grafana/pkg/services/ldap"
+)
+
+// ErrServerDisabled is the error of the LDAP servers disabled with DisableServer
+var ErrServerDisabled = errors.New("disabled (manual)")
+
+// ErrAllServersDisabled is returned when every configured LDAP server is disabled with DisableServer
+var ErrAllServersDisabled = errors.New("all LDAP servers are disabled")
+
+// disabledServers holds the host:port of the LDAP servers disabled with DisableServer. It is only kept in memory, so
+// that a server under maintenance can be left alone without editing the LDAP configuration.
+var disabledServers = struct {
+	sync.RWMutex
+	servers map[string]bool
+}{servers: map[string]bool{}}
+
+// DisableServer stops the LDAP server with the given host and port from being dialed, until EnableAllServers is
+// called. Its status is reported with ErrServerDisabled, and users are looked up and synced on the other servers.
+func DisableServer(host string, port int) {
+	disabledServers.Lock()
+	defer disabledServers.Unlock()
+	disabledServers.servers[serverAddress(host, port)] = true
+}
+
+// EnableAllServers enables the LDAP servers disabled with DisableServer again.
+func EnableAllServers() {
+	disabledServers.Lock()
+	defer disabledServers.Unlock()
+	disabledServers.servers = map[string]bool{}
+}
+
+// IsServerDisabled tells whether the LDAP server of the given config is disabled with DisableServer.
+func IsServerDisabled(config *ldap.ServerConfig) bool {
+	disabledServers.RLock()
+	defer disabledServers.RUnlock()
+	return disabledServers.servers[serverAddress(config.Host, config.Port)]
+}
+
+func serverAddress(host string, port int) string {
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// disabledStatus is the status reported for a disabled LDAP server, which is not dialed.
+func disabledStatus(config *ldap.ServerConfig) *ServerStatus {
+	return &ServerStatus{Host: config.Host, Port: config.Port, Error: ErrServerDisabled}
+}
+
+// enabledConfigs returns the configs of the servers that are not disabled, or ErrAllServersDisabled if there are
+// none left, so that searching no server at all is not mistaken for the user being missing.
+func enabledConfigs(configs []*ldap.ServerConfig) ([]*ldap.ServerConfig, error) {
+	enabled := make([]*ldap.ServerConfig, 0, len(configs))
+	for _, config := range configs {
+		if IsServerDisabled(config) {
+			logger.Debug("skipping disabled LDAP server", "host", config.Host, "port", config.Port)
+			continue
+		}
+		enabled = append(enabled, config)
+	}
+	if len(enabled) == 0 {
+		return nil, ErrAllServersDisabled
+	}
+	return enabled, nil
+}
@@ -2,10 +2,13 @@ package multildap
 
 import (
 	"errors"
+	"fmt"
+	"sync"
 
 	"github.com/grafana/grafana/pkg/infra/log"
 	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/services/ldap"
+	"github.com/grafana/grafana/pkg/setting"
 )
 
 // logger to log
@@ -32,12 +35,16 @@ var ErrNoLDAPServers = errors.New("no LDAP servers are configured")
 // ErrDidNotFindUser if request for user is unsuccessful
 var ErrDidNotFindUser = errors.New("did not find a user")
 
+// ErrUnknownBaseDN is returned when a base DN is not under the search base DNs of any LDAP server
+var ErrUnknownBaseDN = errors.New("base DN is not under the search base DNs of any LDAP server")
+
 // ServerStatus holds the LDAP server status
 type ServerStatus struct {
-	Host      string
-	Port      int
-	Available bool
-	Error     error
+	Host         string
+	Port         int
+	Available    bool
+	SecurityMode string
+	Error        error
 }
 
 // IMultiLDAP is interface for MultiLDAP
@@ -54,11 +61,34 @@ type IMultiLDAP interface {
 	User(login string) (
 		*models.ExternalUserInfo, ldap.ServerConfig, error,
 	)
+
+	UsersInBaseDN(baseDN string) (
+		[]*models.ExternalUserInfo, ldap.ServerConfig, error,
+	)
+
+	UserAttributes(login string) (
+		map[string][]string, ldap.ServerConfig, error,
+	)
+
+	LookupUser(login string) (
+		*models.ExternalUserInfo, ldap.ServerConfig, []*ServerStatus, error,
+	)
+
+	LookupUserOnAllServers(login string) (
+		[]*UserMatch, []*ServerStatus, error,
+	)
+}
+
+// UserMatch is a user found on an LDAP server, along with the configuration of that server
+type UserMatch struct {
+	User   *models.ExternalUserInfo
+	Config ldap.ServerConfig
 }
 
 // MultiLDAP is basic struct of LDAP authorization
 type MultiLDAP struct {
 	configs []*ldap.ServerConfig
+	pool    *ConnectionPool
 }
 
 // New creates the new LDAP auth
@@ -68,34 +98,96 @@ func New(configs []*ldap.ServerConfig) IMultiLDAP {
 	}
 }
 
+// NewWithPool creates the new LDAP auth, looking up users with the connections of the pool, so that a batch of
+// lookups reuses them instead of dialing the servers for each user.
+func NewWithPool(configs []*ldap.ServerConfig, pool *ConnectionPool) IMultiLDAP {
+	return &MultiLDAP{
+		configs: configs,
+		pool:    pool,
+	}
+}
+
 // Ping dials each of the LDAP servers and returns their status. If the server is unavailable, it also returns the error.
+// Disabled servers are not dialed, and are reported with ErrServerDisabled.
+// Servers are dialed in parallel, with at most setting.LDAPPingConcurrency of them being dialed at the same time.
 func (multiples *MultiLDAP) Ping() ([]*ServerStatus, error) {
 	if len(multiples.configs) == 0 {
 		return nil, ErrNoLDAPServers
 	}
 
-	serverStatuses := []*ServerStatus{}
-	for _, config := range multiples.configs {
-		status := &ServerStatus{}
+	limit := setting.LDAPPingConcurrency
+	if limit < 1 || limit > len(multiples.configs) {
+		limit = len(multiples.configs)
+	}
 
-		status.Host = config.Host
-		status.Port = config.Port
+	serverStatuses := make([]*ServerStatus, len(multiples.configs))
+	sem := make(chan struct{}, limit)
+	wg := sync.WaitGroup{}
+	for index, config := range multiples.configs {
+		if IsServerDisabled(config) {
+			serverStatuses[index] = disabledStatus(config)
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(index int, config *ldap.ServerConfig) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			serverStatuses[index] = ping(config)
+		}(index, config)
+	}
+	wg.Wait()
 
-		server := newLDAP(config)
-		err := server.Dial()
+	return serverStatuses, nil
+}
 
-		if err == nil {
-			status.Available = true
-			serverStatuses = append(serverStatuses, status)
-			server.Close()
-		} else {
+// ping dials a single LDAP server and returns its status. A panic while dialing is reported as the server being unavailable,
+// so that it does not prevent the statuses of the other servers from being returned.
+func ping(config *ldap.ServerConfig) (status *ServerStatus) {
+	status = &ServerStatus{
+		Host: config.Host,
+		Port: config.Port,
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("panic while dialing LDAP server", "host", config.Host, "port", config.Port, "error", r)
 			status.Available = false
-			status.Error = err
-			serverStatuses = append(serverStatuses, status)
+			status.Error = fmt.Errorf("unexpected error while dialing LDAP server: %v", r)
 		}
+	}()
+
+	server := newLDAP(config)
+	if err := server.Dial(); err != nil {
+		status.Error = err
+		return status
 	}
+	status.SecurityMode = server.SecurityMode()
+	server.Close()
 
-	return serverStatuses, nil
+	status.Available = true
+	return status
+}
+
+// TestBind dials the LDAP server of the given config and binds with its bind credentials, as is done before
+// searching for users. The returned status tells whether the server could be dialed, and the returned error
+// whether the bind failed.
+func TestBind(config *ldap.ServerConfig) (*ServerStatus, error) {
+	status := &ServerStatus{
+		Host: config.Host,
+		Port: config.Port,
+	}
+
+	server := newLDAP(config)
+	if err := server.Dial(); err != nil {
+		status.Error = err
+		return status, err
+	}
+	defer server.Close()
+	status.SecurityMode = server.SecurityMode()
+	status.Available = true
+
+	return status, server.Bind()
 }
 
 // Login tries to log in the user in multiples LDAP
@@ -106,14 +198,19 @@ func (multiples *MultiLDAP) Login(query *models.LoginUserQuery) (
 		return nil, ErrNoLDAPServers
 	}
 
-	for index, config := range multiples.configs {
+	configs, err := enabledConfigs(multiples.configs)
+	if err != nil {
+		return nil, err
+	}
+
+	for index, config := range configs {
 		server := newLDAP(config)
 
 		if err := server.Dial(); err != nil {
 			logDialFailure(err, config)
 
 			// Only return an error if it is the last server so we can try next server
-			if index == len(multiples.configs)-1 {
+			if index == len(configs)-1 {
 				return nil, err
 			}
 			continue
@@ -155,15 +252,24 @@ func (multiples *MultiLDAP) User(login string) (
 		return nil, ldap.ServerConfig{}, ErrNoLDAPServers
 	}
 
+	configs, err := enabledConfigs(multiples.configs)
+	if err != nil {
+		return nil, ldap.ServerConfig{}, err
+	}
+
+	if multiples.pool != nil {
+		return multiples.userWithPool(configs, login)
+	}
+
 	search := []string{login}
-	for index, config := range multiples.configs {
+	for index, config := range configs {
 		server := newLDAP(config)
 
 		if err := server.Dial(); err != nil {
 			logDialFailure(err, config)
 
 			// Only return an error if it is the last server so we can try next server
-			if index == len(multiples.configs)-1 {
+			if index == len(configs)-1 {
 				return nil, *config, err
 			}
 			continue
@@ -188,6 +294,262 @@ func (multiples *MultiLDAP) User(login string) (
 	return nil, ldap.ServerConfig{}, ErrDidNotFindUser
 }
 
+// userWithPool is User with the connections of the pool. Like User, it tries the next server if a server cannot be
+// dialed, unless it is the last one.
+func (multiples *MultiLDAP) userWithPool(configs []*ldap.ServerConfig, login string) (
+	*models.ExternalUserInfo,
+	ldap.ServerConfig,
+	error,
+) {
+	for index, config := range configs {
+		user, err := multiples.searchUserWithPool(config, login)
+		if err != nil {
+			var dialErr dialError
+			if errors.As(err, &dialErr) && index < len(configs)-1 {
+				continue
+			}
+			return nil, *config, err
+		}
+
+		if user != nil {
+			return user, *config, nil
+		}
+	}
+
+	return nil, ldap.ServerConfig{}, ErrDidNotFindUser
+}
+
+// UserAttributes finds the user on the LDAP servers in the order they are configured, like User, and returns all the
+// attributes of its entry rather than the mapped ones, alongside the server it was found on.
+func (multiples *MultiLDAP) UserAttributes(login string) (
+	map[string][]string,
+	ldap.ServerConfig,
+	error,
+) {
+	if len(multiples.configs) == 0 {
+		return nil, ldap.ServerConfig{}, ErrNoLDAPServers
+	}
+
+	configs, err := enabledConfigs(multiples.configs)
+	if err != nil {
+		return nil, ldap.ServerConfig{}, err
+	}
+
+	for index, config := range configs {
+		server := newLDAP(config)
+
+		if err := server.Dial(); err != nil {
+			logDialFailure(err, config)
+
+			// Only return an error if it is the last server so we can try next server
+			if index == len(configs)-1 {
+				return nil, *config, err
+			}
+			continue
+		}
+
+		defer server.Close()
+
+		if err := server.Bind(); err != nil {
+			return nil, *config, err
+		}
+
+		attributes, err := server.UserAttributes(login)
+		if err != nil {
+			return nil, *config, err
+		}
+
+		if attributes != nil {
+			return attributes, *config, nil
+		}
+	}
+
+	return nil, ldap.ServerConfig{}, ErrDidNotFindUser
+}
+
+// LookupUser finds a user on the LDAP servers in the order they are configured, like User, but moves on to the next
+// server when one cannot be reached or searched. The statuses of the servers that failed are returned alongside the
+// result, so that a user found despite some servers being down can be told apart from one that was not found anywhere.
+// ErrDidNotFindUser is returned if the user is not on any of the servers that could be searched, and the error of the
+// last server if none of them could be searched. Disabled servers are not searched and are reported as failed.
+func (multiples *MultiLDAP) LookupUser(login string) (
+	*models.ExternalUserInfo,
+	ldap.ServerConfig,
+	[]*ServerStatus,
+	error,
+) {
+	if len(multiples.configs) == 0 {
+		return nil, ldap.ServerConfig{}, nil, ErrNoLDAPServers
+	}
+
+	var failed []*ServerStatus
+	var lastErr error
+	for _, config := range multiples.configs {
+		if IsServerDisabled(config) {
+			failed = append(failed, disabledStatus(config))
+			lastErr = ErrServerDisabled
+			continue
+		}
+
+		user, err := multiples.searchUser(config, login)
+		if err != nil {
+			failed = append(failed, &ServerStatus{Host: config.Host, Port: config.Port, Error: err})
+			lastErr = err
+			continue
+		}
+
+		if user != nil {
+			return user, *config, failed, nil
+		}
+	}
+
+	if len(failed) == len(multiples.configs) {
+		return nil, ldap.ServerConfig{}, failed, lastErr
+	}
+	return nil, ldap.ServerConfig{}, failed, ErrDidNotFindUser
+}
+
+// LookupUserOnAllServers searches every LDAP server for the user, instead of stopping at the first server that has it,
+// so that users with the same login on several servers can be told apart. The matches are returned in the order of the
+// servers, alongside the servers that could not be searched. It returns ErrDidNotFindUser if none of the servers that
+// could be searched has the user, or the last error if none of the servers could be searched. Disabled servers are not
+// searched and are reported as failed.
+func (multiples *MultiLDAP) LookupUserOnAllServers(login string) (
+	[]*UserMatch,
+	[]*ServerStatus,
+	error,
+) {
+	if len(multiples.configs) == 0 {
+		return nil, nil, ErrNoLDAPServers
+	}
+
+	var matches []*UserMatch
+	var failed []*ServerStatus
+	var lastErr error
+	for _, config := range multiples.configs {
+		if IsServerDisabled(config) {
+			failed = append(failed, disabledStatus(config))
+			lastErr = ErrServerDisabled
+			continue
+		}
+
+		user, err := multiples.searchUser(config, login)
+		if err != nil {
+			failed = append(failed, &ServerStatus{Host: config.Host, Port: config.Port, Error: err})
+			lastErr = err
+			continue
+		}
+
+		if user != nil {
+			matches = append(matches, &UserMatch{User: user, Config: *config})
+		}
+	}
+
+	if len(failed) == len(multiples.configs) {
+		return nil, failed, lastErr
+	}
+	if len(matches) == 0 {
+		return nil, failed, ErrDidNotFindUser
+	}
+	return matches, failed, nil
+}
+
+// searchUser searches a single LDAP server for the user. It returns nil if the server does not have the user.
+func (multiples *MultiLDAP) searchUser(config *ldap.ServerConfig, login string) (*models.ExternalUserInfo, error) {
+	if multiples.pool != nil {
+		return multiples.searchUserWithPool(config, login)
+	}
+
+	server := newLDAP(config)
+
+	if err := server.Dial(); err != nil {
+		logDialFailure(err, config)
+		return nil, err
+	}
+
+	defer server.Close()
+
+	if err := server.Bind(); err != nil {
+		return nil, err
+	}
+
+	users, err := server.Users([]string{login})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(users) == 0 {
+		return nil, nil
+	}
+	return users[0], nil
+}
+
+// searchUserWithPool searches a single LDAP server for the user with a connection of the pool. The connection is
+// given back to the pool unless the search failed, as the connection may be broken.
+func (multiples *MultiLDAP) searchUserWithPool(config *ldap.ServerConfig, login string) (*models.ExternalUserInfo, error) {
+	server, err := multiples.pool.get(config)
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := server.Users([]string{login})
+	if err != nil {
+		server.Close()
+		return nil, err
+	}
+	multiples.pool.put(config, server)
+
+	if len(users) == 0 {
+		return nil, nil
+	}
+	return users[0], nil
+}
+
+// UsersInBaseDN finds all the users under the base DN on the first LDAP server whose search base DNs contain it.
+// It returns the users alongside the server they were found on.
+func (multiples *MultiLDAP) UsersInBaseDN(baseDN string) (
+	[]*models.ExternalUserInfo,
+	ldap.ServerConfig,
+	error,
+) {
+	if len(multiples.configs) == 0 {
+		return nil, ldap.ServerConfig{}, ErrNoLDAPServers
+	}
+
+	configs, err := enabledConfigs(multiples.configs)
+	if err != nil {
+		return nil, ldap.ServerConfig{}, err
+	}
+
+	for _, config := range configs {
+		if !ldap.IsInBaseDNs(baseDN, config.SearchBaseDNs) {
+			continue
+		}
+
+		server := newLDAP(config)
+
+		if err := server.Dial(); err != nil {
+			logDialFailure(err, config)
+			return nil, *config, err
+		}
+
+		defer server.Close()
+
+		if err := server.Bind(); err != nil {
+			return nil, *config, err
+		}
+
+		users, err := server.UsersInBaseDN(baseDN)
+		if err != nil {
+			return nil, *config, err
+		}
+
+		return users, *config, nil
+	}
+
+	return nil, ldap.ServerConfig{}, ErrUnknownBaseDN
+}
+
 // Users gets users from multiple LDAP servers
 func (multiples *MultiLDAP) Users(logins []string) (
 	[]*models.ExternalUserInfo,
@@ -199,14 +561,19 @@ func (multiples *MultiLDAP) Users(logins []string) (
 		return nil, ErrNoLDAPServers
 	}
 
-	for index, config := range multiples.configs {
+	configs, err := enabledConfigs(multiples.configs)
+	if err != nil {
+		return nil, err
+	}
+
+	for index, config := range configs {
 		server := newLDAP(config)
 
 		if err := server.Dial(); err != nil {
 			logDialFailure(err, config)
 
 			// Only return an error if it is the last server so we can try next server
-			if index == len(multiples.configs)-1 {
+			if index == len(configs)-1 {
 				return nil, err
 			}
 			continue
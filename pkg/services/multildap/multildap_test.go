@@ -2,10 +2,13 @@ package multildap
 
 import (
 	"errors"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/services/ldap"
+	"github.com/grafana/grafana/pkg/setting"
 
 	"github.com/stretchr/testify/require"
 
@@ -49,6 +52,7 @@ func TestMultiLDAP(t *testing.T) {
 		})
 		t.Run("Should get the LDAP server statuses", func(t *testing.T) {
 			mock := setup()
+			mock.securityModeReturn = ldap.SecurityModeStartTLS
 
 			multi := New([]*ldap.ServerConfig{
 				{Host: "10.0.0.1", Port: 361},
@@ -60,12 +64,111 @@ func TestMultiLDAP(t *testing.T) {
 			require.Equal(t, "10.0.0.1", statuses[0].Host)
 			require.Equal(t, 361, statuses[0].Port)
 			require.True(t, statuses[0].Available)
+			require.Equal(t, ldap.SecurityModeStartTLS, statuses[0].SecurityMode)
 			require.Nil(t, statuses[0].Error)
 			require.Equal(t, 1, mock.closeCalledTimes)
 
 			teardown()
 		})
+		t.Run("Should dial at most the configured number of servers at the same time", func(t *testing.T) {
+			concurrency := setting.LDAPPingConcurrency
+			setting.LDAPPingConcurrency = 2
+
+			tracker := &dialTracker{}
+			newLDAP = func(config *ldap.ServerConfig) ldap.IServer {
+				return &pingLDAP{host: config.Host, tracker: tracker}
+			}
+
+			multi := New([]*ldap.ServerConfig{
+				{Host: "fast", Port: 361},
+				{Host: "slow", Port: 362},
+				{Host: "failing", Port: 363},
+				{Host: "slow", Port: 364},
+				{Host: "panicking", Port: 365},
+				{Host: "fast", Port: 366},
+			})
+
+			statuses, err := multi.Ping()
+
+			require.NoError(t, err)
+			require.LessOrEqual(t, tracker.max, 2)
+			require.Len(t, statuses, 6)
+			for i, status := range statuses {
+				require.Equal(t, 361+i, status.Port)
+			}
+			require.True(t, statuses[0].Available)
+			require.True(t, statuses[1].Available)
+			require.False(t, statuses[2].Available)
+			require.EqualError(t, statuses[2].Error, "connection refused")
+			require.True(t, statuses[3].Available)
+			require.False(t, statuses[4].Available)
+			require.Error(t, statuses[4].Error)
+			require.True(t, statuses[5].Available)
+
+			setting.LDAPPingConcurrency = concurrency
+			teardown()
+		})
+		t.Run("Should not dial a disabled server", func(t *testing.T) {
+			mock := setup()
+			DisableServer("10.0.0.1", 361)
+
+			multi := New([]*ldap.ServerConfig{
+				{Host: "10.0.0.1", Port: 361},
+				{Host: "10.0.0.2", Port: 361},
+			})
+
+			statuses, err := multi.Ping()
+
+			require.NoError(t, err)
+			require.Equal(t, &ServerStatus{Host: "10.0.0.1", Port: 361, Error: ErrServerDisabled}, statuses[0])
+			require.True(t, statuses[1].Available)
+			require.Equal(t, 1, mock.dialCalledTimes)
+
+			EnableAllServers()
+			teardown()
+		})
 	})
+	t.Run("TestBind()", func(t *testing.T) {
+		t.Run("Should return an unavailable status on dial error", func(t *testing.T) {
+			mock := setup()
+			mock.dialErrReturn = errors.New("Dial error")
+
+			status, err := TestBind(&ldap.ServerConfig{Host: "10.0.0.1", Port: 361})
+
+			require.Equal(t, mock.dialErrReturn, err)
+			require.False(t, status.Available)
+			require.Equal(t, 0, mock.bindCalledTimes)
+
+			teardown()
+		})
+		t.Run("Should return the bind error of an available server", func(t *testing.T) {
+			mock := setup()
+			mock.bindErrReturn = ldap.ErrInvalidCredentials
+
+			status, err := TestBind(&ldap.ServerConfig{Host: "10.0.0.1", Port: 361})
+
+			require.Equal(t, ldap.ErrInvalidCredentials, err)
+			require.True(t, status.Available)
+			require.Equal(t, 1, mock.bindCalledTimes)
+			require.Equal(t, 1, mock.closeCalledTimes)
+
+			teardown()
+		})
+		t.Run("Should bind to an available server", func(t *testing.T) {
+			mock := setup()
+			mock.securityModeReturn = ldap.SecurityModeLDAPS
+
+			status, err := TestBind(&ldap.ServerConfig{Host: "10.0.0.1", Port: 361})
+
+			require.NoError(t, err)
+			require.True(t, status.Available)
+			require.Equal(t, ldap.SecurityModeLDAPS, status.SecurityMode)
+			require.Equal(t, 1, mock.bindCalledTimes)
+
+			teardown()
+		})
+	})
+
 	t.Run("Login()", func(t *testing.T) {
 		t.Run("Should return error for absent config list", func(t *testing.T) {
 			setup()
@@ -447,6 +550,403 @@ func TestMultiLDAP(t *testing.T) {
 			teardown()
 		})
 	})
+
+	t.Run("Users() with disabled servers", func(t *testing.T) {
+		t.Run("Should only search the servers that are not disabled", func(t *testing.T) {
+			disabled := &mockLDAP{}
+			up := &mockLDAP{usersFirstReturn: []*models.ExternalUserInfo{{Login: "test"}}}
+			newLDAP = func(config *ldap.ServerConfig) ldap.IServer {
+				if config.Host == "disabled" {
+					return disabled
+				}
+				return up
+			}
+			DisableServer("disabled", 389)
+
+			multi := New([]*ldap.ServerConfig{
+				{Host: "disabled", Port: 389}, {Host: "up", Port: 389},
+			})
+			users, err := multi.Users([]string{"test"})
+
+			require.NoError(t, err)
+			require.Len(t, users, 1)
+			require.Equal(t, 0, disabled.dialCalledTimes)
+
+			EnableAllServers()
+			teardown()
+		})
+
+		t.Run("Should return an error if every server is disabled", func(t *testing.T) {
+			mock := setup()
+			DisableServer("disabled", 389)
+
+			multi := New([]*ldap.ServerConfig{{Host: "disabled", Port: 389}})
+			_, _, err := multi.User("test")
+
+			require.Equal(t, ErrAllServersDisabled, err)
+			require.Equal(t, 0, mock.dialCalledTimes)
+
+			EnableAllServers()
+			teardown()
+		})
+	})
+
+	t.Run("UsersInBaseDN()", func(t *testing.T) {
+		t.Run("Should return error for absent config list", func(t *testing.T) {
+			setup()
+
+			multi := New([]*ldap.ServerConfig{})
+			_, _, err := multi.UsersInBaseDN("ou=people,dc=grafana,dc=org")
+
+			require.Equal(t, ErrNoLDAPServers, err)
+
+			teardown()
+		})
+
+		t.Run("Should return error if no server searches the base DN", func(t *testing.T) {
+			mock := setup()
+
+			multi := New([]*ldap.ServerConfig{
+				{SearchBaseDNs: []string{"dc=example,dc=org"}},
+			})
+			_, _, err := multi.UsersInBaseDN("ou=people,dc=grafana,dc=org")
+
+			require.Equal(t, ErrUnknownBaseDN, err)
+			require.Equal(t, 0, mock.dialCalledTimes)
+
+			teardown()
+		})
+
+		t.Run("Should get the users from the server searching the base DN", func(t *testing.T) {
+			mock := setup()
+
+			mock.usersInBaseDNReturn = []*models.ExternalUserInfo{
+				{Login: "one"},
+				{Login: "two"},
+			}
+
+			multi := New([]*ldap.ServerConfig{
+				{Host: "first", SearchBaseDNs: []string{"dc=example,dc=org"}},
+				{Host: "second", SearchBaseDNs: []string{"dc=grafana,dc=org"}},
+			})
+			users, config, err := multi.UsersInBaseDN("ou=People,dc=grafana,dc=org")
+
+			require.NoError(t, err)
+			require.Equal(t, "second", config.Host)
+			require.Equal(t, []string{"ou=People,dc=grafana,dc=org"}, mock.usersInBaseDNCalledWith)
+			require.Equal(t, 1, mock.dialCalledTimes)
+			require.Equal(t, 1, mock.bindCalledTimes)
+			require.Equal(t, 1, mock.closeCalledTimes)
+			require.Len(t, users, 2)
+
+			teardown()
+		})
+
+		t.Run("Should return a dial error", func(t *testing.T) {
+			mock := setup()
+
+			expectedError := errors.New("Dial error")
+			mock.dialErrReturn = expectedError
+
+			multi := New([]*ldap.ServerConfig{
+				{SearchBaseDNs: []string{"dc=grafana,dc=org"}},
+			})
+			_, _, err := multi.UsersInBaseDN("ou=people,dc=grafana,dc=org")
+
+			require.Equal(t, expectedError, err)
+
+			teardown()
+		})
+	})
+
+	t.Run("UserAttributes()", func(t *testing.T) {
+		t.Run("Should return error for absent config list", func(t *testing.T) {
+			setup()
+
+			multi := New([]*ldap.ServerConfig{})
+			_, _, err := multi.UserAttributes("login")
+
+			require.Equal(t, ErrNoLDAPServers, err)
+
+			teardown()
+		})
+
+		t.Run("Should return the attributes and the server the user was found on", func(t *testing.T) {
+			mock := setup()
+
+			mock.userAttributesReturn = map[string][]string{"sAMAccountName": {"login"}}
+
+			multi := New([]*ldap.ServerConfig{{Host: "first"}})
+			attributes, config, err := multi.UserAttributes("login")
+
+			require.NoError(t, err)
+			require.Equal(t, "first", config.Host)
+			require.Equal(t, map[string][]string{"sAMAccountName": {"login"}}, attributes)
+			require.Equal(t, 1, mock.closeCalledTimes)
+
+			teardown()
+		})
+
+		t.Run("Should return ErrDidNotFindUser if no server has the user", func(t *testing.T) {
+			setup()
+
+			multi := New([]*ldap.ServerConfig{{}, {}})
+			_, _, err := multi.UserAttributes("login")
+
+			require.Equal(t, ErrDidNotFindUser, err)
+
+			teardown()
+		})
+	})
+
+	t.Run("LookupUser()", func(t *testing.T) {
+		setupServers := func(servers map[string]*mockLDAP) {
+			newLDAP = func(config *ldap.ServerConfig) ldap.IServer {
+				return servers[config.Host]
+			}
+		}
+
+		t.Run("Should return the user found despite failing servers", func(t *testing.T) {
+			dialErr := errors.New("Dial error")
+			searchErr := errors.New("Search error")
+			setupServers(map[string]*mockLDAP{
+				"down":    {dialErrReturn: dialErr},
+				"failing": {usersErrReturn: searchErr},
+				"up":      {usersFirstReturn: []*models.ExternalUserInfo{{Login: "test"}}},
+			})
+
+			multi := New([]*ldap.ServerConfig{
+				{Host: "down", Port: 389}, {Host: "failing", Port: 636}, {Host: "up", Port: 389},
+			})
+			user, config, failed, err := multi.LookupUser("test")
+
+			require.NoError(t, err)
+			require.Equal(t, "test", user.Login)
+			require.Equal(t, "up", config.Host)
+			require.Equal(t, []*ServerStatus{
+				{Host: "down", Port: 389, Error: dialErr},
+				{Host: "failing", Port: 636, Error: searchErr},
+			}, failed)
+
+			teardown()
+		})
+
+		t.Run("Should not find a user missing from the servers that could be searched", func(t *testing.T) {
+			setupServers(map[string]*mockLDAP{
+				"down": {dialErrReturn: errors.New("Dial error")},
+				"up":   {},
+			})
+
+			multi := New([]*ldap.ServerConfig{
+				{Host: "down"}, {Host: "up"},
+			})
+			user, _, failed, err := multi.LookupUser("test")
+
+			require.Equal(t, ErrDidNotFindUser, err)
+			require.Nil(t, user)
+			require.Len(t, failed, 1)
+			require.Equal(t, "down", failed[0].Host)
+
+			teardown()
+		})
+
+		t.Run("Should return the last error if no server could be searched", func(t *testing.T) {
+			expected := errors.New("Bind error")
+			setupServers(map[string]*mockLDAP{
+				"down":       {dialErrReturn: errors.New("Dial error")},
+				"unbindable": {bindErrReturn: expected},
+			})
+
+			multi := New([]*ldap.ServerConfig{
+				{Host: "down"}, {Host: "unbindable"},
+			})
+			_, _, failed, err := multi.LookupUser("test")
+
+			require.Equal(t, expected, err)
+			require.Len(t, failed, 2)
+
+			teardown()
+		})
+
+		t.Run("Should skip a disabled server and report it as failed", func(t *testing.T) {
+			disabled := &mockLDAP{usersFirstReturn: []*models.ExternalUserInfo{{Login: "test", Email: "test@disabled"}}}
+			setupServers(map[string]*mockLDAP{
+				"disabled": disabled,
+				"up":       {usersFirstReturn: []*models.ExternalUserInfo{{Login: "test", Email: "test@up"}}},
+			})
+			DisableServer("disabled", 389)
+
+			multi := New([]*ldap.ServerConfig{
+				{Host: "disabled", Port: 389}, {Host: "up", Port: 389},
+			})
+			user, config, failed, err := multi.LookupUser("test")
+
+			require.NoError(t, err)
+			require.Equal(t, "test@up", user.Email)
+			require.Equal(t, "up", config.Host)
+			require.Equal(t, []*ServerStatus{{Host: "disabled", Port: 389, Error: ErrServerDisabled}}, failed)
+			require.Equal(t, 0, disabled.dialCalledTimes)
+
+			EnableAllServers()
+			teardown()
+		})
+	})
+
+	t.Run("LookupUserOnAllServers()", func(t *testing.T) {
+		setupServers := func(servers map[string]*mockLDAP) {
+			newLDAP = func(config *ldap.ServerConfig) ldap.IServer {
+				return servers[config.Host]
+			}
+		}
+
+		t.Run("Should return the user found on every server", func(t *testing.T) {
+			dialErr := errors.New("Dial error")
+			setupServers(map[string]*mockLDAP{
+				"first":  {usersFirstReturn: []*models.ExternalUserInfo{{Login: "test", Email: "test@first"}}},
+				"down":   {dialErrReturn: dialErr},
+				"empty":  {},
+				"second": {usersFirstReturn: []*models.ExternalUserInfo{{Login: "test", Email: "test@second"}}},
+			})
+
+			multi := New([]*ldap.ServerConfig{
+				{Host: "first"}, {Host: "down", Port: 389}, {Host: "empty"}, {Host: "second"},
+			})
+			matches, failed, err := multi.LookupUserOnAllServers("test")
+
+			require.NoError(t, err)
+			require.Len(t, matches, 2)
+			require.Equal(t, "first", matches[0].Config.Host)
+			require.Equal(t, "test@first", matches[0].User.Email)
+			require.Equal(t, "second", matches[1].Config.Host)
+			require.Equal(t, "test@second", matches[1].User.Email)
+			require.Equal(t, []*ServerStatus{{Host: "down", Port: 389, Error: dialErr}}, failed)
+
+			teardown()
+		})
+
+		t.Run("Should not find a user missing from every server", func(t *testing.T) {
+			setupServers(map[string]*mockLDAP{
+				"first":  {},
+				"second": {},
+			})
+
+			multi := New([]*ldap.ServerConfig{
+				{Host: "first"}, {Host: "second"},
+			})
+			matches, failed, err := multi.LookupUserOnAllServers("test")
+
+			require.Equal(t, ErrDidNotFindUser, err)
+			require.Empty(t, matches)
+			require.Empty(t, failed)
+
+			teardown()
+		})
+
+		t.Run("Should return the last error if no server could be searched", func(t *testing.T) {
+			expected := errors.New("Bind error")
+			setupServers(map[string]*mockLDAP{
+				"down":       {dialErrReturn: errors.New("Dial error")},
+				"unbindable": {bindErrReturn: expected},
+			})
+
+			multi := New([]*ldap.ServerConfig{
+				{Host: "down"}, {Host: "unbindable"},
+			})
+			_, failed, err := multi.LookupUserOnAllServers("test")
+
+			require.Equal(t, expected, err)
+			require.Len(t, failed, 2)
+
+			teardown()
+		})
+	})
+
+	t.Run("LookupUser() with a connection pool", func(t *testing.T) {
+		config := &ldap.ServerConfig{Host: "10.0.0.1", Port: 389}
+		user := []*models.ExternalUserInfo{{Login: "test"}}
+
+		t.Run("Should reuse the connection across a batch of lookups", func(t *testing.T) {
+			mock := setup()
+			mock.usersFirstReturn = user
+			mock.usersRestReturn = user
+			pool := NewConnectionPool(1, time.Minute)
+
+			for i := 0; i < 5; i++ {
+				found, _, _, err := NewWithPool([]*ldap.ServerConfig{config}, pool).LookupUser("test")
+				require.NoError(t, err)
+				require.Equal(t, "test", found.Login)
+			}
+
+			require.Equal(t, 1, mock.dialCalledTimes)
+			require.Equal(t, 1, mock.bindCalledTimes)
+			require.Equal(t, 5, mock.usersCalledTimes)
+			require.Equal(t, 0, mock.closeCalledTimes)
+
+			pool.Close()
+			require.Equal(t, 1, mock.closeCalledTimes)
+
+			teardown()
+		})
+
+		t.Run("Should dial again once the idle timeout has passed", func(t *testing.T) {
+			mock := setup()
+			mock.usersFirstReturn = user
+			mock.usersRestReturn = user
+			now := time.Now()
+			pool := NewConnectionPool(1, time.Minute)
+			pool.now = func() time.Time { return now }
+			multi := NewWithPool([]*ldap.ServerConfig{config}, pool)
+
+			_, _, _, err := multi.LookupUser("test")
+			require.NoError(t, err)
+			now = now.Add(2 * time.Minute)
+			_, _, _, err = multi.LookupUser("test")
+			require.NoError(t, err)
+
+			require.Equal(t, 2, mock.dialCalledTimes)
+			require.Equal(t, 1, mock.closeCalledTimes)
+
+			teardown()
+		})
+
+		t.Run("Should reuse the connection across a batch of User() calls", func(t *testing.T) {
+			mock := setup()
+			mock.usersFirstReturn = user
+			mock.usersRestReturn = user
+			pool := NewConnectionPool(1, time.Minute)
+
+			for i := 0; i < 5; i++ {
+				found, server, err := NewWithPool([]*ldap.ServerConfig{config}, pool).User("test")
+				require.NoError(t, err)
+				require.Equal(t, "test", found.Login)
+				require.Equal(t, config.Host, server.Host)
+			}
+
+			require.Equal(t, 1, mock.dialCalledTimes)
+			require.Equal(t, 1, mock.bindCalledTimes)
+			require.Equal(t, 5, mock.usersCalledTimes)
+
+			pool.Close()
+			teardown()
+		})
+
+		t.Run("Should not reuse a connection a search failed on", func(t *testing.T) {
+			mock := setup()
+			mock.usersErrReturn = errors.New("Search error")
+			pool := NewConnectionPool(1, time.Minute)
+			multi := NewWithPool([]*ldap.ServerConfig{config}, pool)
+
+			_, _, _, err := multi.LookupUser("test")
+			require.Error(t, err)
+			_, _, _, err = multi.LookupUser("test")
+			require.Error(t, err)
+
+			require.Equal(t, 2, mock.dialCalledTimes)
+			require.Equal(t, 2, mock.closeCalledTimes)
+
+			teardown()
+		})
+	})
 }
 
 // mockLDAP represents testing struct for ldap testing
@@ -464,9 +964,16 @@ type mockLDAP struct {
 
 	bindErrReturn error
 
+	securityModeReturn string
+
 	usersErrReturn   error
 	usersFirstReturn []*models.ExternalUserInfo
 	usersRestReturn  []*models.ExternalUserInfo
+
+	usersInBaseDNCalledWith []string
+	usersInBaseDNReturn     []*models.ExternalUserInfo
+
+	userAttributesReturn map[string][]string
 }
 
 // Login test fn
@@ -486,6 +993,17 @@ func (mock *mockLDAP) Users([]string) ([]*models.ExternalUserInfo, error) {
 	return mock.usersRestReturn, mock.usersErrReturn
 }
 
+// UsersInBaseDN test fn
+func (mock *mockLDAP) UsersInBaseDN(baseDN string) ([]*models.ExternalUserInfo, error) {
+	mock.usersInBaseDNCalledWith = append(mock.usersInBaseDNCalledWith, baseDN)
+	return mock.usersInBaseDNReturn, nil
+}
+
+// UserAttributes test fn
+func (mock *mockLDAP) UserAttributes(login string) (map[string][]string, error) {
+	return mock.userAttributesReturn, nil
+}
+
 // UserBind test fn
 func (mock *mockLDAP) UserBind(string, string) error {
 	return nil
@@ -507,6 +1025,56 @@ func (mock *mockLDAP) Bind() error {
 	return mock.bindErrReturn
 }
 
+// SecurityMode test fn
+func (mock *mockLDAP) SecurityMode() string {
+	return mock.securityModeReturn
+}
+
+// dialTracker records the highest number of servers being dialed at the same time
+type dialTracker struct {
+	mu      sync.Mutex
+	current int
+	max     int
+}
+
+func (tracker *dialTracker) enter() {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	tracker.current++
+	if tracker.current > tracker.max {
+		tracker.max = tracker.current
+	}
+}
+
+func (tracker *dialTracker) leave() {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	tracker.current--
+}
+
+// pingLDAP is a fake LDAP server whose dial behaviour depends on its host
+type pingLDAP struct {
+	mockLDAP
+	host    string
+	tracker *dialTracker
+}
+
+// Dial test fn
+func (server *pingLDAP) Dial() error {
+	server.tracker.enter()
+	defer server.tracker.leave()
+
+	switch server.host {
+	case "slow":
+		time.Sleep(50 * time.Millisecond)
+	case "failing":
+		return errors.New("connection refused")
+	case "panicking":
+		panic("unexpected dial failure")
+	}
+	return nil
+}
+
 func setup() *mockLDAP {
 	mock := &mockLDAP{}
 
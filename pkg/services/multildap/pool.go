@@ -0,0 +1,138 @@
+package multildap
+
+import (
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana/pkg/services/ldap"
+)
+
+// ConnectionPool keeps the connections to the LDAP servers open between user lookups, already bound with the
+// configured bind credentials, so that a batch of lookups does not dial and bind once per user. At most size idle
+// connections are kept per server, and connections idle for longer than the idle timeout are closed rather than
+// reused, which should be shorter than the idle timeout of the LDAP servers. Connections are kept per server
+// configuration, so the connections of a configuration that was reloaded are not reused.
+type ConnectionPool struct {
+	size        int
+	idleTimeout time.Duration
+	now         func() time.Time
+
+	mutex sync.Mutex
+	idle  map[*ldap.ServerConfig][]idleConnection
+}
+
+// idleConnection is a connection in the pool, along with the time it was given back
+type idleConnection struct {
+	server ldap.IServer
+	since  time.Time
+}
+
+// NewConnectionPool creates a pool keeping at most size idle connections per LDAP server, for up to idleTimeout.
+func NewConnectionPool(size int, idleTimeout time.Duration) *ConnectionPool {
+	return &ConnectionPool{
+		size:        size,
+		idleTimeout: idleTimeout,
+		now:         time.Now,
+		idle:        map[*ldap.ServerConfig][]idleConnection{},
+	}
+}
+
+// get returns an idle connection to the server if there is one, or dials and binds a new one.
+func (p *ConnectionPool) get(config *ldap.ServerConfig) (ldap.IServer, error) {
+	p.mutex.Lock()
+	p.closeExpired()
+	if conns := p.idle[config]; len(conns) > 0 {
+		conn := conns[len(conns)-1]
+		p.idle[config] = conns[:len(conns)-1]
+		p.mutex.Unlock()
+		return conn.server, nil
+	}
+	p.mutex.Unlock()
+
+	server := newLDAP(config)
+	if err := server.Dial(); err != nil {
+		logDialFailure(err, config)
+		return nil, dialError{err: err}
+	}
+	if err := server.Bind(); err != nil {
+		server.Close()
+		return nil, err
+	}
+	return server, nil
+}
+
+// dialError is returned by get when the server could not be dialed, as opposed to bound to, so that callers can try
+// the next server.
+type dialError struct {
+	err error
+}
+
+func (e dialError) Error() string {
+	return e.err.Error()
+}
+
+func (e dialError) Unwrap() error {
+	return e.err
+}
+
+// put gives back a connection to the server, which is closed if the pool already has enough idle connections to it.
+func (p *ConnectionPool) put(config *ldap.ServerConfig, server ldap.IServer) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(p.idle[config]) >= p.size {
+		server.Close()
+		return
+	}
+	p.idle[config] = append(p.idle[config], idleConnection{server: server, since: p.now()})
+}
+
+// closeExpired closes the connections idle for longer than the idle timeout. The mutex must be held.
+func (p *ConnectionPool) closeExpired() {
+	now := p.now()
+	for config, conns := range p.idle {
+		kept := conns[:0]
+		for _, conn := range conns {
+			if now.Sub(conn.since) > p.idleTimeout {
+				conn.server.Close()
+				continue
+			}
+			kept = append(kept, conn)
+		}
+		if len(kept) == 0 {
+			delete(p.idle, config)
+			continue
+		}
+		p.idle[config] = kept
+	}
+}
+
+// Close closes all the idle connections of the pool. The pool can still be used afterwards.
+func (p *ConnectionPool) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for config, conns := range p.idle {
+		for _, conn := range conns {
+			conn.server.Close()
+		}
+		delete(p.idle, config)
+	}
+}
+
+// CloseServer closes the idle connections of the pool to the LDAP server with the given host and port, e.g. once its
+// settings are reloaded. The connections to other servers are kept.
+func (p *ConnectionPool) CloseServer(host string, port int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for config, conns := range p.idle {
+		if config.Host != host || config.Port != port {
+			continue
+		}
+		for _, conn := range conns {
+			conn.server.Close()
+		}
+		delete(p.idle, config)
+	}
+}
@@ -0,0 +1,105 @@
+package multildap
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana/pkg/services/ldap"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+// StatusPoller pings the LDAP servers in the background and keeps their latest statuses, so that the LDAP status
+// can be served without dialing the servers on every request. It is disabled unless the status_poll_interval of
+// the LDAP settings is set.
+type StatusPoller struct {
+	cfg      *setting.Cfg
+	interval time.Duration
+
+	mutex    sync.RWMutex
+	statuses []*ServerStatus
+	asOf     time.Time
+	// generation is increased by Invalidate, so that a poll started before is not kept
+	generation int
+}
+
+func ProvideStatusPoller(cfg *setting.Cfg) *StatusPoller {
+	return &StatusPoller{
+		cfg:      cfg,
+		interval: cfg.LDAPStatusPollInterval,
+	}
+}
+
+func (p *StatusPoller) IsDisabled() bool {
+	return !p.cfg.LDAPEnabled || p.interval <= 0
+}
+
+func (p *StatusPoller) Run(ctx context.Context) error {
+	p.poll()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.poll()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// poll pings the servers of the current LDAP configuration, which is read again each time so that reloading it is
+// taken into account. The previous statuses are kept if the servers cannot be pinged.
+func (p *StatusPoller) poll() {
+	p.mutex.RLock()
+	generation := p.generation
+	p.mutex.RUnlock()
+
+	config, err := GetConfig(p.cfg)
+	if err != nil {
+		logger.Warn("Failed to read the LDAP configuration to poll the server statuses", "error", err)
+		return
+	}
+
+	statuses, err := New(config.Servers).Ping()
+	if err != nil {
+		logger.Warn("Failed to poll the LDAP server statuses", "error", err)
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.generation != generation {
+		return
+	}
+	p.statuses = statuses
+	p.asOf = time.Now()
+}
+
+// Invalidate drops the latest statuses, e.g. once the LDAP configuration is reloaded, so that they are not served
+// for servers that were removed or changed until the next poll. A poll already in progress is not kept either.
+func (p *StatusPoller) Invalidate() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.generation++
+	p.statuses = nil
+	p.asOf = time.Time{}
+}
+
+// Latest returns the latest statuses of the LDAP servers and the time they were polled at, which is zero if the
+// servers have not been polled yet. Servers disabled since they were polled are already reported as disabled.
+func (p *StatusPoller) Latest() ([]*ServerStatus, time.Time) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	statuses := make([]*ServerStatus, 0, len(p.statuses))
+	for _, status := range p.statuses {
+		if IsServerDisabled(&ldap.ServerConfig{Host: status.Host, Port: status.Port}) {
+			status = &ServerStatus{Host: status.Host, Port: status.Port, Error: ErrServerDisabled}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, p.asOf
+}
@@ -46,6 +46,17 @@ func TestCheckRoute(t *testing.T) {
 				return cfg
 			}(),
 		},
+		{
+			name:      "route UIDs missing from a provisioned object should not fail",
+			shouldErr: false,
+			currentConfig: func() definitions.GettableUserConfig {
+				cfg := gettableRoute(t, models.ProvenanceAPI)
+				cfg.AlertmanagerConfig.Route.UID = "root"
+				cfg.AlertmanagerConfig.Route.Routes[0].UID = "child"
+				return cfg
+			}(),
+			newConfig: postableRoute(t, models.ProvenanceAPI),
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
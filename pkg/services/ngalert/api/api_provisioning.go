@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/grafana/grafana/pkg/api/response"
 	"github.com/grafana/grafana/pkg/infra/log"
@@ -39,7 +40,9 @@ type TemplateService interface {
 
 type NotificationPolicyService interface {
 	GetPolicyTree(ctx context.Context, orgID int64) (definitions.Route, error)
-	UpdatePolicyTree(ctx context.Context, orgID int64, tree definitions.Route, p alerting_models.Provenance) error
+	PolicyTreeETag(ctx context.Context, orgID int64) (string, error)
+	GetEffectivePolicyTree(ctx context.Context, orgID int64) (definitions.EffectiveRoute, error)
+	UpdatePolicyTree(ctx context.Context, orgID int64, tree definitions.Route, p alerting_models.Provenance) (bool, error)
 	ResetPolicyTree(ctx context.Context, orgID int64) (definitions.Route, error)
 }
 
@@ -60,6 +63,17 @@ type AlertRuleService interface {
 }
 
 func (srv *ProvisioningSrv) RouteGetPolicyTree(c *models.ReqContext) response.Response {
+	etag, err := srv.policies.PolicyTreeETag(c.Req.Context(), c.OrgId)
+	if errors.Is(err, store.ErrNoAlertmanagerConfiguration) {
+		return ErrResp(http.StatusNotFound, err, "")
+	}
+	if err != nil {
+		return ErrResp(http.StatusInternalServerError, err, "")
+	}
+	if etagMatches(c.Req.Header.Get("If-None-Match"), etag) {
+		return response.Empty(http.StatusNotModified).SetHeader("ETag", etag)
+	}
+
 	policies, err := srv.policies.GetPolicyTree(c.Req.Context(), c.OrgId)
 	if errors.Is(err, store.ErrNoAlertmanagerConfiguration) {
 		return ErrResp(http.StatusNotFound, err, "")
@@ -68,14 +82,42 @@ func (srv *ProvisioningSrv) RouteGetPolicyTree(c *models.ReqContext) response.Re
 		return ErrResp(http.StatusInternalServerError, err, "")
 	}
 
-	return response.JSON(http.StatusOK, policies)
+	return response.JSON(http.StatusOK, policies).SetHeader("ETag", etag)
+}
+
+// etagMatches tells whether the entity tags of an If-None-Match header include the given one. Weak tags match their
+// strong counterpart, as the comparison of If-None-Match is weak.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func (srv *ProvisioningSrv) RouteGetEffectivePolicyTree(c *models.ReqContext) response.Response {
+	tree, err := srv.policies.GetEffectivePolicyTree(c.Req.Context(), c.OrgId)
+	if errors.Is(err, store.ErrNoAlertmanagerConfiguration) {
+		return ErrResp(http.StatusNotFound, err, "")
+	}
+	if err != nil {
+		return ErrResp(http.StatusInternalServerError, err, "")
+	}
+
+	return response.JSON(http.StatusOK, tree)
 }
 
 func (srv *ProvisioningSrv) RoutePutPolicyTree(c *models.ReqContext, tree definitions.Route) response.Response {
-	err := srv.policies.UpdatePolicyTree(c.Req.Context(), c.OrgId, tree, alerting_models.ProvenanceAPI)
+	_, err := srv.policies.UpdatePolicyTree(c.Req.Context(), c.OrgId, tree, alerting_models.ProvenanceAPI)
 	if errors.Is(err, store.ErrNoAlertmanagerConfiguration) {
 		return ErrResp(http.StatusNotFound, err, "")
 	}
+	var routeErr *provisioning.RouteValidationError
+	if errors.As(err, &routeErr) {
+		return response.JSON(http.StatusBadRequest, util.DynMap{"message": err.Error(), "path": routeErr.Path})
+	}
 	if errors.Is(err, provisioning.ErrValidation) {
 		return ErrResp(http.StatusBadRequest, err, "")
 	}
@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/grafana/grafana/pkg/api/response"
 	"github.com/grafana/grafana/pkg/components/simplejson"
 	"github.com/grafana/grafana/pkg/infra/log"
 	gfcore "github.com/grafana/grafana/pkg/models"
@@ -34,6 +35,46 @@ func TestProvisioningApi(t *testing.T) {
 			require.Equal(t, 200, response.Status())
 		})
 
+		t.Run("GET returns the ETag of the tree", func(t *testing.T) {
+			sut := createProvisioningSrvSut(t)
+			rc := createTestRequestCtx()
+
+			resp := sut.RouteGetPolicyTree(&rc)
+
+			require.Equal(t, 200, resp.Status())
+			require.Equal(t, `"some-receiver"`, resp.(*response.NormalResponse).Header().Get("ETag"))
+		})
+
+		t.Run("GET with the ETag of the tree returns 304", func(t *testing.T) {
+			sut := createProvisioningSrvSut(t)
+			rc := createTestRequestCtx()
+			rc.Req.Header = http.Header{"If-None-Match": []string{`"other", W/"some-receiver"`}}
+
+			response := sut.RouteGetPolicyTree(&rc)
+
+			require.Equal(t, 304, response.Status())
+			require.Empty(t, response.Body())
+		})
+
+		t.Run("GET with an outdated ETag returns 200", func(t *testing.T) {
+			sut := createProvisioningSrvSut(t)
+			rc := createTestRequestCtx()
+			rc.Req.Header = http.Header{"If-None-Match": []string{`"other"`}}
+
+			response := sut.RouteGetPolicyTree(&rc)
+
+			require.Equal(t, 200, response.Status())
+		})
+
+		t.Run("successful GET of the effective tree returns 200", func(t *testing.T) {
+			sut := createProvisioningSrvSut(t)
+			rc := createTestRequestCtx()
+
+			response := sut.RouteGetEffectivePolicyTree(&rc)
+
+			require.Equal(t, 200, response.Status())
+		})
+
 		t.Run("successful PUT returns 202", func(t *testing.T) {
 			sut := createProvisioningSrvSut(t)
 			rc := createTestRequestCtx()
@@ -66,6 +107,19 @@ func TestProvisioningApi(t *testing.T) {
 				expBody := `{"error":"invalid object specification: invalid policy tree","message":"invalid object specification: invalid policy tree"}`
 				require.Equal(t, expBody, string(response.Body()))
 			})
+
+			t.Run("PUT returns the path of an invalid route", func(t *testing.T) {
+				sut := createProvisioningSrvSut(t)
+				sut.policies = &fakeRejectingNotificationPolicyService{}
+				rc := createTestRequestCtx()
+				tree := definitions.Route{Routes: []*definitions.Route{{Receiver: "not-existing"}}}
+
+				response := sut.RoutePutPolicyTree(&rc, tree)
+
+				require.Equal(t, 400, response.Status())
+				expBody := `{"message":"invalid object specification: route /routes/0: receiver 'not-existing' does not exist","path":"/routes/0"}`
+				require.JSONEq(t, expBody, string(response.Body()))
+			})
 		})
 
 		t.Run("when org has no AM config", func(t *testing.T) {
@@ -347,13 +401,27 @@ func (f *fakeNotificationPolicyService) GetPolicyTree(ctx context.Context, orgID
 	return result, nil
 }
 
-func (f *fakeNotificationPolicyService) UpdatePolicyTree(ctx context.Context, orgID int64, tree definitions.Route, p models.Provenance) error {
+func (f *fakeNotificationPolicyService) PolicyTreeETag(ctx context.Context, orgID int64) (string, error) {
+	if orgID != 1 {
+		return "", store.ErrNoAlertmanagerConfiguration
+	}
+	return `"` + f.tree.Receiver + `"`, nil
+}
+
+func (f *fakeNotificationPolicyService) GetEffectivePolicyTree(ctx context.Context, orgID int64) (definitions.EffectiveRoute, error) {
 	if orgID != 1 {
-		return store.ErrNoAlertmanagerConfiguration
+		return definitions.EffectiveRoute{}, store.ErrNoAlertmanagerConfiguration
+	}
+	return definitions.EffectiveRoute{Receiver: f.tree.Receiver}, nil
+}
+
+func (f *fakeNotificationPolicyService) UpdatePolicyTree(ctx context.Context, orgID int64, tree definitions.Route, p models.Provenance) (bool, error) {
+	if orgID != 1 {
+		return false, store.ErrNoAlertmanagerConfiguration
 	}
 	f.tree = tree
 	f.prov = p
-	return nil
+	return true, nil
 }
 
 func (f *fakeNotificationPolicyService) ResetPolicyTree(ctx context.Context, orgID int64) (definitions.Route, error) {
@@ -367,8 +435,16 @@ func (f *fakeFailingNotificationPolicyService) GetPolicyTree(ctx context.Context
 	return definitions.Route{}, fmt.Errorf("something went wrong")
 }
 
-func (f *fakeFailingNotificationPolicyService) UpdatePolicyTree(ctx context.Context, orgID int64, tree definitions.Route, p models.Provenance) error {
-	return fmt.Errorf("something went wrong")
+func (f *fakeFailingNotificationPolicyService) PolicyTreeETag(ctx context.Context, orgID int64) (string, error) {
+	return "", fmt.Errorf("something went wrong")
+}
+
+func (f *fakeFailingNotificationPolicyService) GetEffectivePolicyTree(ctx context.Context, orgID int64) (definitions.EffectiveRoute, error) {
+	return definitions.EffectiveRoute{}, fmt.Errorf("something went wrong")
+}
+
+func (f *fakeFailingNotificationPolicyService) UpdatePolicyTree(ctx context.Context, orgID int64, tree definitions.Route, p models.Provenance) (bool, error) {
+	return false, fmt.Errorf("something went wrong")
 }
 
 func (f *fakeFailingNotificationPolicyService) ResetPolicyTree(ctx context.Context, orgID int64) (definitions.Route, error) {
@@ -381,8 +457,19 @@ func (f *fakeRejectingNotificationPolicyService) GetPolicyTree(ctx context.Conte
 	return definitions.Route{}, nil
 }
 
-func (f *fakeRejectingNotificationPolicyService) UpdatePolicyTree(ctx context.Context, orgID int64, tree definitions.Route, p models.Provenance) error {
-	return fmt.Errorf("%w: invalid policy tree", provisioning.ErrValidation)
+func (f *fakeRejectingNotificationPolicyService) PolicyTreeETag(ctx context.Context, orgID int64) (string, error) {
+	return `""`, nil
+}
+
+func (f *fakeRejectingNotificationPolicyService) GetEffectivePolicyTree(ctx context.Context, orgID int64) (definitions.EffectiveRoute, error) {
+	return definitions.EffectiveRoute{}, nil
+}
+
+func (f *fakeRejectingNotificationPolicyService) UpdatePolicyTree(ctx context.Context, orgID int64, tree definitions.Route, p models.Provenance) (bool, error) {
+	if len(tree.Routes) > 0 {
+		return false, &provisioning.RouteValidationError{Path: "/routes/0", Reason: "receiver 'not-existing' does not exist"}
+	}
+	return false, fmt.Errorf("%w: invalid policy tree", provisioning.ErrValidation)
 }
 
 func (f *fakeRejectingNotificationPolicyService) ResetPolicyTree(ctx context.Context, orgID int64) (definitions.Route, error) {
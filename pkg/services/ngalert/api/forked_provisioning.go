@@ -23,6 +23,10 @@ func (f *ForkedProvisioningApi) forkRouteGetPolicyTree(ctx *models.ReqContext) r
 	return f.svc.RouteGetPolicyTree(ctx)
 }
 
+func (f *ForkedProvisioningApi) forkRouteGetEffectivePolicyTree(ctx *models.ReqContext) response.Response {
+	return f.svc.RouteGetEffectivePolicyTree(ctx)
+}
+
 func (f *ForkedProvisioningApi) forkRoutePutPolicyTree(ctx *models.ReqContext, route apimodels.Route) response.Response {
 	return f.svc.RoutePutPolicyTree(ctx, route)
 }
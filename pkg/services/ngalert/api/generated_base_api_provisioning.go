@@ -26,6 +26,7 @@ type ProvisioningApiForkingService interface {
 	RouteGetAlertRule(*models.ReqContext) response.Response
 	RouteGetAlertRuleGroup(*models.ReqContext) response.Response
 	RouteGetContactpoints(*models.ReqContext) response.Response
+	RouteGetEffectivePolicyTree(*models.ReqContext) response.Response
 	RouteGetMuteTiming(*models.ReqContext) response.Response
 	RouteGetMuteTimings(*models.ReqContext) response.Response
 	RouteGetPolicyTree(*models.ReqContext) response.Response
@@ -71,6 +72,9 @@ func (f *ForkedProvisioningApi) RouteGetAlertRuleGroup(ctx *models.ReqContext) r
 func (f *ForkedProvisioningApi) RouteGetContactpoints(ctx *models.ReqContext) response.Response {
 	return f.forkRouteGetContactpoints(ctx)
 }
+func (f *ForkedProvisioningApi) RouteGetEffectivePolicyTree(ctx *models.ReqContext) response.Response {
+	return f.forkRouteGetEffectivePolicyTree(ctx)
+}
 func (f *ForkedProvisioningApi) RouteGetMuteTiming(ctx *models.ReqContext) response.Response {
 	nameParam := web.Params(ctx.Req)[":name"]
 	return f.forkRouteGetMuteTiming(ctx, nameParam)
@@ -233,6 +237,16 @@ func (api *API) RegisterProvisioningApiEndpoints(srv ProvisioningApiForkingServi
 				m,
 			),
 		)
+		group.Get(
+			toMacaronPath("/api/v1/provisioning/policies/effective"),
+			api.authorize(http.MethodGet, "/api/v1/provisioning/policies/effective"),
+			metrics.Instrument(
+				http.MethodGet,
+				"/api/v1/provisioning/policies/effective",
+				srv.RouteGetEffectivePolicyTree,
+				m,
+			),
+		)
 		group.Get(
 			toMacaronPath("/api/v1/provisioning/mute-timings/{name}"),
 			api.authorize(http.MethodGet, "/api/v1/provisioning/mute-timings/{name}"),
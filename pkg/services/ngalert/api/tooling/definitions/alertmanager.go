@@ -690,7 +690,20 @@ type Config struct {
 	Route             *Route                    `yaml:"route,omitempty" json:"route,omitempty"`
 	InhibitRules      []*config.InhibitRule     `yaml:"inhibit_rules,omitempty" json:"inhibit_rules,omitempty"`
 	MuteTimeIntervals []config.MuteTimeInterval `yaml:"mute_time_intervals,omitempty" json:"mute_time_intervals,omitempty"`
-	Templates         []string                  `yaml:"templates" json:"templates"`
+	// TimeIntervals is the spelling newer Alertmanager versions use for MuteTimeIntervals. It is accepted so that
+	// such configurations keep working, and is moved to MuteTimeIntervals by MigrateTimeIntervals.
+	TimeIntervals []config.MuteTimeInterval `yaml:"time_intervals,omitempty" json:"time_intervals,omitempty"`
+	Templates     []string                  `yaml:"templates" json:"templates"`
+}
+
+// MigrateTimeIntervals moves the time intervals defined under TimeIntervals to MuteTimeIntervals, which is where
+// this Alertmanager reads them from. Routes refer to time intervals by name whichever field defines them, so
+// the routing behavior of the configuration does not change.
+func (c *Config) MigrateTimeIntervals() {
+	if len(c.TimeIntervals) > 0 {
+		c.MuteTimeIntervals = append(c.MuteTimeIntervals, c.TimeIntervals...)
+	}
+	c.TimeIntervals = nil
 }
 
 // A Route is a node that contains definitions of how to handle alerts. This is modified
@@ -716,6 +729,21 @@ type Route struct {
 	RepeatInterval *model.Duration `yaml:"repeat_interval,omitempty" json:"repeat_interval,omitempty"`
 
 	Provenance models.Provenance `yaml:"provenance,omitempty" json:"provenance,omitempty"`
+	// Editable tells whether the route can be changed through the API, which is not the case of the routes of a
+	// subtree provisioned from file. It is only set on the routes of a policy tree that is read, and is ignored when
+	// the tree is updated.
+	Editable *bool `yaml:"editable,omitempty" json:"editable,omitempty"`
+	// UID identifies the route within the policy tree. It is stored with the route, so it stays the same when
+	// other routes of the tree are added, removed or reordered, and when the route itself is edited.
+	UID string `yaml:"uid,omitempty" json:"uid,omitempty"`
+
+	// MatcherMacros names sets of matchers that the routes of the tree can use with UseMatcherMacros. They can only
+	// be defined on the root route of a tree that is updated, and are expanded into the routes before the tree is
+	// validated and saved, so they are never stored.
+	MatcherMacros map[string]ObjectMatchers `yaml:"matcher_macros,omitempty" json:"matcher_macros,omitempty"`
+	// UseMatcherMacros lists the matcher macros whose matchers are added to the ObjectMatchers of the route when
+	// the tree is updated.
+	UseMatcherMacros []string `yaml:"use_matcher_macros,omitempty" json:"use_matcher_macros,omitempty"`
 }
 
 // UnmarshalYAML implements the yaml.Unmarshaler interface for Route. This is a copy of alertmanager's upstream except it removes validation on the label key.
@@ -822,14 +850,16 @@ func (c *Config) UnmarshalJSON(b []byte) error {
 	}
 
 	tiNames := make(map[string]struct{})
-	for _, mt := range c.MuteTimeIntervals {
-		if mt.Name == "" {
-			return fmt.Errorf("missing name in mute time interval")
-		}
-		if _, ok := tiNames[mt.Name]; ok {
-			return fmt.Errorf("mute time interval %q is not unique", mt.Name)
+	for _, intervals := range [][]config.MuteTimeInterval{c.MuteTimeIntervals, c.TimeIntervals} {
+		for _, mt := range intervals {
+			if mt.Name == "" {
+				return fmt.Errorf("missing name in mute time interval")
+			}
+			if _, ok := tiNames[mt.Name]; ok {
+				return fmt.Errorf("mute time interval %q is not unique", mt.Name)
+			}
+			tiNames[mt.Name] = struct{}{}
 		}
-		tiNames[mt.Name] = struct{}{}
 	}
 	return checkTimeInterval(c.Route, tiNames)
 }
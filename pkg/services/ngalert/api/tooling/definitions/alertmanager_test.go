@@ -674,6 +674,41 @@ func Test_ConfigUnmashaling(t *testing.T) {
 				}
 			`,
 		},
+		{
+			desc: "routes can refer to time intervals defined under time_intervals",
+			input: `
+				{
+				  "route": {
+					"receiver": "grafana-default-email",
+					"routes": [
+						{
+						  "receiver": "grafana-default-email",
+						  "mute_time_intervals": ["test1", "test2"]
+						}
+					  ]
+				  },
+				  "mute_time_intervals": [{"name": "test1", "time_intervals": [{}]}],
+				  "time_intervals": [{"name": "test2", "time_intervals": [{}]}],
+				  "templates": null,
+				  "receivers": [{"name": "grafana-default-email"}]
+				}
+			`,
+		},
+		{
+			desc: "mute time names defined under both mute_time_intervals and time_intervals should error",
+			err:  errors.New("mute time interval \"test1\" is not unique"),
+			input: `
+				{
+				  "route": {
+					"receiver": "grafana-default-email"
+				  },
+				  "mute_time_intervals": [{"name": "test1", "time_intervals": [{}]}],
+				  "time_intervals": [{"name": "test1", "time_intervals": [{}]}],
+				  "templates": null,
+				  "receivers": [{"name": "grafana-default-email"}]
+				}
+			`,
+		},
 	} {
 		t.Run(tc.desc, func(t *testing.T) {
 			var out Config
@@ -683,6 +718,18 @@ func Test_ConfigUnmashaling(t *testing.T) {
 	}
 }
 
+func Test_ConfigMigrateTimeIntervals(t *testing.T) {
+	cfg := Config{
+		MuteTimeIntervals: []config.MuteTimeInterval{{Name: "test1"}},
+		TimeIntervals:     []config.MuteTimeInterval{{Name: "test2"}},
+	}
+
+	cfg.MigrateTimeIntervals()
+
+	require.Equal(t, []config.MuteTimeInterval{{Name: "test1"}, {Name: "test2"}}, cfg.MuteTimeIntervals)
+	require.Nil(t, cfg.TimeIntervals)
+}
+
 func Test_GettableUserConfigUnmarshaling(t *testing.T) {
 	for _, tc := range []struct {
 		desc, input string
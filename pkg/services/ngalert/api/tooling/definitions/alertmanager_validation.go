@@ -1,6 +1,7 @@
 package definitions
 
 import (
+	"errors"
 	"fmt"
 	"html/template"
 	"regexp"
@@ -45,10 +46,10 @@ func (r *Route) validateChild() error {
 
 	// Routes are a self-referential structure.
 	if r.Routes != nil {
-		for _, child := range r.Routes {
+		for i, child := range r.Routes {
 			err := child.validateChild()
 			if err != nil {
-				return err
+				return childRouteError(i, err)
 			}
 		}
 	}
@@ -101,14 +102,24 @@ func (r *Route) Validate() error {
 	return r.validateChild()
 }
 
+// ValidateReceivers returns an error if a route of the tree delivers to a receiver that does not exist. A child route
+// without a receiver inherits the receiver of its parent, which is checked on the parent.
 func (r *Route) ValidateReceivers(receivers map[string]struct{}) error {
 	if _, exists := receivers[r.Receiver]; !exists {
 		return fmt.Errorf("receiver '%s' does not exist", r.Receiver)
 	}
-	for _, children := range r.Routes {
-		err := children.ValidateReceivers(receivers)
-		if err != nil {
-			return err
+	return r.validateChildReceivers(receivers)
+}
+
+func (r *Route) validateChildReceivers(receivers map[string]struct{}) error {
+	for i, child := range r.Routes {
+		if child.Receiver != "" {
+			if _, exists := receivers[child.Receiver]; !exists {
+				return childRouteError(i, fmt.Errorf("receiver '%s' does not exist", child.Receiver))
+			}
+		}
+		if err := child.validateChildReceivers(receivers); err != nil {
+			return childRouteError(i, err)
 		}
 	}
 	return nil
@@ -120,15 +131,41 @@ func (r *Route) ValidateMuteTimes(muteTimes map[string]struct{}) error {
 			return fmt.Errorf("mute time interval '%s' does not exist", name)
 		}
 	}
-	for _, child := range r.Routes {
+	for i, child := range r.Routes {
 		err := child.ValidateMuteTimes(muteTimes)
 		if err != nil {
-			return err
+			return childRouteError(i, err)
 		}
 	}
 	return nil
 }
 
+// RouteError is an error of a nested route of a tree. Path is a JSON pointer to the route from the root of the tree,
+// such as /routes/0/routes/2.
+type RouteError struct {
+	Path string
+	Err  error
+}
+
+func (e *RouteError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RouteError) Unwrap() error {
+	return e.Err
+}
+
+// childRouteError prefixes the path of an error of the child route at the given index, or of one of its descendants.
+func childRouteError(index int, err error) error {
+	path := ""
+	var routeErr *RouteError
+	if errors.As(err, &routeErr) {
+		path = routeErr.Path
+		err = routeErr.Err
+	}
+	return &RouteError{Path: fmt.Sprintf("/routes/%d%s", index, path), Err: err}
+}
+
 func (mt *MuteTimeInterval) Validate() error {
 	s, err := yaml.Marshal(mt.MuteTimeInterval)
 	if err != nil {
@@ -258,6 +258,59 @@ func TestValidateRoutes(t *testing.T) {
 	})
 }
 
+func TestValidateReceivers(t *testing.T) {
+	receivers := map[string]struct{}{"foo": {}, "bar": {}}
+
+	t.Run("a child without a receiver inherits an existing one", func(t *testing.T) {
+		route := Route{
+			Receiver: "foo",
+			Routes: []*Route{
+				{Routes: []*Route{{Receiver: "bar"}, {}}},
+			},
+		}
+
+		require.NoError(t, route.ValidateReceivers(receivers))
+	})
+
+	t.Run("a child without a receiver inherits a missing one", func(t *testing.T) {
+		route := Route{
+			Receiver: "foo",
+			Routes: []*Route{
+				{Receiver: "missing", Routes: []*Route{{}}},
+			},
+		}
+
+		err := route.ValidateReceivers(receivers)
+
+		var routeErr *RouteError
+		require.ErrorAs(t, err, &routeErr)
+		require.Equal(t, "/routes/0", routeErr.Path)
+		require.EqualError(t, err, "receiver 'missing' does not exist")
+	})
+
+	t.Run("a missing receiver of a nested child", func(t *testing.T) {
+		route := Route{
+			Receiver: "foo",
+			Routes: []*Route{
+				{Routes: []*Route{{}, {Receiver: "missing"}}},
+			},
+		}
+
+		err := route.ValidateReceivers(receivers)
+
+		var routeErr *RouteError
+		require.ErrorAs(t, err, &routeErr)
+		require.Equal(t, "/routes/0/routes/1", routeErr.Path)
+		require.EqualError(t, err, "receiver 'missing' does not exist")
+	})
+
+	t.Run("the root route must have an existing receiver", func(t *testing.T) {
+		route := Route{Routes: []*Route{{Receiver: "foo"}}}
+
+		require.EqualError(t, route.ValidateReceivers(receivers), "receiver '' does not exist")
+	})
+}
+
 func TestValidateMuteTimeInterval(t *testing.T) {
 	type testCase struct {
 		desc   string
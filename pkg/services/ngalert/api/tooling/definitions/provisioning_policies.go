@@ -1,12 +1,24 @@
 package definitions
 
+import "github.com/prometheus/common/model"
+
 // swagger:route GET /api/v1/provisioning/policies provisioning stable RouteGetPolicyTree
 //
-// Get the notification policy tree.
+// Get the notification policy tree. The response has an ETag header, and a request whose If-None-Match header has
+// the ETag of the current tree gets a 304 response without a body.
 //
 //     Responses:
 //       200: Route
 //         description: The currently active notification routing tree
+//       304: description: The tree did not change since the given ETag
+
+// swagger:route GET /api/v1/provisioning/policies/effective provisioning stable RouteGetEffectivePolicyTree
+//
+// Get the notification policy tree with the options each route inherits resolved.
+//
+//     Responses:
+//       200: EffectiveRoute
+//         description: The currently active notification routing tree with inherited options resolved
 
 // swagger:route PUT /api/v1/provisioning/policies provisioning stable RoutePutPolicyTree
 //
@@ -35,3 +47,21 @@ type Policytree struct {
 	// in:body
 	Body Route
 }
+
+// EffectiveRoute is a route of the notification policy tree with the options it inherits from its parents,
+// or the defaults of the Alertmanager, filled in.
+// swagger:model
+type EffectiveRoute struct {
+	Receiver          string         `json:"receiver"`
+	GroupBy           []string       `json:"group_by"`
+	GroupWait         model.Duration `json:"group_wait"`
+	GroupInterval     model.Duration `json:"group_interval"`
+	RepeatInterval    model.Duration `json:"repeat_interval"`
+	ObjectMatchers    ObjectMatchers `json:"object_matchers,omitempty"`
+	MuteTimeIntervals []string       `json:"mute_time_intervals,omitempty"`
+	Continue          bool           `json:"continue,omitempty"`
+	// Inherited lists the options that are not set on the route itself, e.g. "group_by".
+	Inherited []string          `json:"inherited,omitempty"`
+	Routes    []*EffectiveRoute `json:"routes,omitempty"`
+	UID       string            `json:"uid,omitempty"`
+}
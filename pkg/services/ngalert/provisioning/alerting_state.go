@@ -0,0 +1,273 @@
+package provisioning
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/services/ngalert/api/tooling/definitions"
+	"github.com/grafana/grafana/pkg/services/ngalert/models"
+	"github.com/prometheus/alertmanager/config"
+)
+
+// orgAlertingStateVersion is the version of the documents written by ExportOrgAlertingState. Documents of other
+// versions are rejected by ImportOrgAlertingState.
+const orgAlertingStateVersion = 1
+
+// OrgAlertingState is a portable document of the alerting configuration of an org: its policy tree, and the mute
+// timings and receivers the tree refers to. The secure settings of the integrations are left out, as they are
+// encrypted with the secret key of the instance they come from.
+type OrgAlertingState struct {
+	Version     int                                `json:"version"`
+	PolicyTree  *definitions.Route                 `json:"policyTree"`
+	MuteTimings []config.MuteTimeInterval          `json:"muteTimings"`
+	Receivers   []*definitions.PostableApiReceiver `json:"receivers"`
+}
+
+// ExportOrgAlertingState bundles the policy tree of the org, and the mute timings and receivers it refers to, into
+// a document that ImportOrgAlertingState can restore, in the same org or in another one.
+func (nps *NotificationPolicyService) ExportOrgAlertingState(ctx context.Context, orgID int64) ([]byte, error) {
+	revision, err := getLastConfigurationWithLimits(ctx, orgID, nps.amStore, nps.configLimits())
+	if err != nil {
+		return nil, err
+	}
+
+	tree := revision.cfg.AlertmanagerConfig.Config.Route
+	if tree == nil {
+		return nil, fmt.Errorf("no route present in current alertmanager config")
+	}
+	fillDerivedRouteUIDs(tree)
+
+	usedReceivers := map[string]bool{}
+	walkRouteReceivers(tree, "", func(_ *definitions.Route, receiver string) {
+		usedReceivers[receiver] = true
+	})
+	usedMuteTimings := map[string]bool{}
+	walkRoutes(tree, "", func(route *definitions.Route, _ string) {
+		for _, name := range route.MuteTimeIntervals {
+			usedMuteTimings[name] = true
+		}
+	})
+
+	state := OrgAlertingState{
+		Version:     orgAlertingStateVersion,
+		PolicyTree:  tree,
+		MuteTimings: []config.MuteTimeInterval{},
+		Receivers:   []*definitions.PostableApiReceiver{},
+	}
+	for _, mt := range revision.cfg.AlertmanagerConfig.MuteTimeIntervals {
+		if usedMuteTimings[mt.Name] {
+			state.MuteTimings = append(state.MuteTimings, mt)
+		}
+	}
+	for _, receiver := range revision.cfg.AlertmanagerConfig.Receivers {
+		if !usedReceivers[receiver.Name] {
+			continue
+		}
+		for _, integration := range receiver.GrafanaManagedReceivers {
+			integration.SecureSettings = nil
+		}
+		state.Receivers = append(state.Receivers, receiver)
+	}
+
+	return json.Marshal(state)
+}
+
+// ImportOrgAlertingState restores a document written by ExportOrgAlertingState into the org. The policy tree of the
+// org is replaced, and so are the mute timings and receivers of the document that have the same name as existing
+// ones; the others are added. Integrations keep the secure settings of the existing integration with the same UID,
+// if any, and are rejected if they are missing a secure setting they require. The result is validated before
+// anything is saved, and the configuration and the provenance of every imported resource are saved in one
+// transaction, along with the snapshot UndoLastPolicyChange reverts the tree to. Resources and routes provisioned
+// from file can only be replaced by file provisioning.
+func (nps *NotificationPolicyService) ImportOrgAlertingState(ctx context.Context, orgID int64, blob []byte, p models.Provenance) error {
+	var state OrgAlertingState
+	if err := json.Unmarshal(blob, &state); err != nil {
+		return fmt.Errorf("%w: failed to read the alerting state: %s", ErrValidation, err.Error())
+	}
+	if state.Version != orgAlertingStateVersion {
+		return fmt.Errorf("%w: unsupported alerting state version %d", ErrValidation, state.Version)
+	}
+	if state.PolicyTree == nil {
+		return fmt.Errorf("%w: the alerting state has no policy tree", ErrValidation)
+	}
+
+	revision, err := getLastConfigurationWithLimits(ctx, orgID, nps.amStore, nps.configLimits())
+	if err != nil {
+		return err
+	}
+	stored := revision.cfg.AlertmanagerConfig.Config.Route
+	if stored != nil {
+		fillDerivedRouteUIDs(stored)
+	}
+	inheritRouteUIDs(stored, state.PolicyTree)
+
+	provisionables := []models.Provisionable{state.PolicyTree}
+	for _, mt := range state.MuteTimings {
+		muteTiming := definitions.MuteTimeInterval{MuteTimeInterval: mt}
+		if err := muteTiming.Validate(); err != nil {
+			return fmt.Errorf("%w: %s", ErrValidation, err.Error())
+		}
+		provisionables = append(provisionables, &muteTiming)
+	}
+
+	secureSettings := map[string]map[string]string{}
+	for _, receiver := range revision.cfg.AlertmanagerConfig.Receivers {
+		for _, integration := range receiver.GrafanaManagedReceivers {
+			if integration.UID != "" {
+				secureSettings[integration.UID] = integration.SecureSettings
+			}
+		}
+	}
+	imported := map[string]bool{}
+	for _, receiver := range state.Receivers {
+		if imported[receiver.Name] {
+			return fmt.Errorf("%w: receiver %q is defined more than once", ErrValidation, receiver.Name)
+		}
+		imported[receiver.Name] = true
+		for _, integration := range receiver.GrafanaManagedReceivers {
+			if integration.UID != "" && len(integration.SecureSettings) == 0 {
+				integration.SecureSettings = secureSettings[integration.UID]
+			}
+			if err := validateImportedIntegration(integration); err != nil {
+				return fmt.Errorf("%w: integration %q of receiver %q: %s", ErrValidation, integration.Name, receiver.Name, err.Error())
+			}
+			if integration.UID != "" {
+				provisionables = append(provisionables, &definitions.EmbeddedContactPoint{UID: integration.UID})
+			}
+		}
+	}
+
+	revision.cfg.AlertmanagerConfig.MuteTimeIntervals = mergeMuteTimings(revision.cfg.AlertmanagerConfig.MuteTimeIntervals, state.MuteTimings)
+	revision.cfg.AlertmanagerConfig.Receivers = mergeReceivers(revision.cfg.AlertmanagerConfig.Receivers, state.Receivers)
+	if uid := duplicateIntegrationUID(revision.cfg.AlertmanagerConfig.Receivers); uid != "" {
+		return fmt.Errorf("%w: integration UID %q is used more than once", ErrValidation, uid)
+	}
+
+	if err := nps.validatePolicyTree(revision, state.PolicyTree); err != nil {
+		return err
+	}
+	if stored != nil {
+		if err := nps.checkSubtreeProvenance(ctx, orgID, stored, state.PolicyTree, p); err != nil {
+			return err
+		}
+	}
+	if err := nps.checkReplacedProvenance(ctx, orgID, provisionables, p); err != nil {
+		return err
+	}
+	revision.cfg.AlertmanagerConfig.Config.Route = state.PolicyTree
+
+	return nps.saveRevision(ctx, orgID, revision, func(ctx context.Context) error {
+		for _, resource := range provisionables {
+			if err := nps.provenanceStore.SetProvenance(ctx, resource, orgID, p); err != nil {
+				return err
+			}
+		}
+		return nps.saveUndoSnapshot(ctx, orgID, stored, state.PolicyTree)
+	})
+}
+
+// validateImportedIntegration validates the integration like the contact point service validates contact points.
+// Its secure settings are encrypted with the secret key of the instance, so they are not decrypted: a secret the
+// integration has a secure setting for only counts as set.
+func validateImportedIntegration(integration *definitions.PostableGrafanaReceiver) error {
+	if integration.Settings == nil {
+		return fmt.Errorf("settings should not be empty")
+	}
+	raw, err := integration.Settings.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	settings, err := simplejson.NewJson(raw)
+	if err != nil {
+		return err
+	}
+	contactPoint := definitions.EmbeddedContactPoint{
+		UID:      integration.UID,
+		Name:     integration.Name,
+		Type:     integration.Type,
+		Settings: settings,
+	}
+
+	secretKeys, err := contactPoint.SecretKeys()
+	if err != nil {
+		return err
+	}
+	for _, key := range secretKeys {
+		if integration.SecureSettings[key] != "" && settings.Get(key).MustString() == "" {
+			settings.Set(key, definitions.RedactedValue)
+		}
+	}
+	return contactPoint.Valid(func(_ context.Context, _ map[string][]byte, _ string, fallback string) string {
+		return fallback
+	})
+}
+
+// checkReplacedProvenance returns an error if one of the resources is provisioned from file, unless they are
+// replaced by file provisioning.
+func (nps *NotificationPolicyService) checkReplacedProvenance(ctx context.Context, orgID int64, resources []models.Provisionable, p models.Provenance) error {
+	if p == models.ProvenanceFile {
+		return nil
+	}
+	for _, resource := range resources {
+		current, err := nps.provenanceStore.GetProvenance(ctx, resource, orgID)
+		if err != nil {
+			return err
+		}
+		if current == models.ProvenanceFile {
+			return fmt.Errorf("%w: %s %q is provisioned from file and cannot be replaced", ErrValidation, resource.ResourceType(), resource.ResourceID())
+		}
+	}
+	return nil
+}
+
+// mergeMuteTimings replaces the mute timings with the same name as an imported one, and appends the others.
+func mergeMuteTimings(existing, imported []config.MuteTimeInterval) []config.MuteTimeInterval {
+	index := map[string]int{}
+	for i, mt := range existing {
+		index[mt.Name] = i
+	}
+	for _, mt := range imported {
+		if i, ok := index[mt.Name]; ok {
+			existing[i] = mt
+			continue
+		}
+		existing = append(existing, mt)
+	}
+	return existing
+}
+
+// mergeReceivers replaces the receivers with the same name as an imported one, and appends the others.
+func mergeReceivers(existing, imported []*definitions.PostableApiReceiver) []*definitions.PostableApiReceiver {
+	index := map[string]int{}
+	for i, receiver := range existing {
+		index[receiver.Name] = i
+	}
+	for _, receiver := range imported {
+		if i, ok := index[receiver.Name]; ok {
+			existing[i] = receiver
+			continue
+		}
+		existing = append(existing, receiver)
+	}
+	return existing
+}
+
+// duplicateIntegrationUID returns a UID that more than one integration of the receivers has, or an empty string if
+// there is none. Integrations without a UID are ignored.
+func duplicateIntegrationUID(receivers []*definitions.PostableApiReceiver) string {
+	seen := map[string]bool{}
+	for _, receiver := range receivers {
+		for _, integration := range receiver.GrafanaManagedReceivers {
+			if integration.UID == "" {
+				continue
+			}
+			if seen[integration.UID] {
+				return integration.UID
+			}
+			seen[integration.UID] = true
+		}
+	}
+	return ""
+}
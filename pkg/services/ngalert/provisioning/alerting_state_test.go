@@ -0,0 +1,186 @@
+package provisioning
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/services/ngalert/api/tooling/definitions"
+	"github.com/grafana/grafana/pkg/services/ngalert/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrgAlertingState(t *testing.T) {
+	withSecret := func(config string) string {
+		return strings.Replace(config,
+			`"uid": "team-a", "name": "team-a", "type": "email", "settings": {"addresses": "<example@email.com>"}`,
+			`"uid": "team-a", "name": "team-a", "type": "email", "settings": {"addresses": "<example@email.com>"}, "secureSettings": {"password": "ZW5jcnlwdGVk"}`, 1)
+	}
+	exportNestedRoutes := func(t *testing.T) (*NotificationPolicyService, []byte) {
+		t.Helper()
+		sut := createNotificationPolicyServiceSut()
+		sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = withSecret(withEmailIntegrations(configWithNestedRoutes, allNestedRoutesReceivers...))
+		blob, err := sut.ExportOrgAlertingState(context.Background(), 1)
+		require.NoError(t, err)
+		return sut, blob
+	}
+
+	t.Run("exports the tree with the mute timings and receivers it refers to, without secrets", func(t *testing.T) {
+		_, blob := exportNestedRoutes(t)
+
+		var state OrgAlertingState
+		require.NoError(t, json.Unmarshal(blob, &state))
+		require.Equal(t, orgAlertingStateVersion, state.Version)
+		require.Equal(t, "grafana-default-email", state.PolicyTree.Receiver)
+		require.Len(t, state.MuteTimings, 1)
+		require.Equal(t, "always", state.MuteTimings[0].Name)
+		names := make([]string, 0, len(state.Receivers))
+		for _, receiver := range state.Receivers {
+			names = append(names, receiver.Name)
+		}
+		require.ElementsMatch(t, allNestedRoutesReceivers, names)
+		require.NotContains(t, string(blob), "ZW5jcnlwdGVk")
+	})
+
+	t.Run("round-trips into a fresh org", func(t *testing.T) {
+		source, blob := exportNestedRoutes(t)
+		sut := createNotificationPolicyServiceSut()
+
+		err := sut.ImportOrgAlertingState(context.Background(), 1, blob, models.ProvenanceAPI)
+
+		require.NoError(t, err)
+		expected, err := source.GetPolicyTree(context.Background(), 1)
+		require.NoError(t, err)
+		imported, err := sut.GetPolicyTree(context.Background(), 1)
+		require.NoError(t, err)
+		equivalent, err := isEquivalentRoute(&expected, &imported)
+		require.NoError(t, err)
+		require.True(t, equivalent)
+
+		cfg, err := sut.readConfig(context.Background(), 1)
+		require.NoError(t, err)
+		require.Len(t, cfg.AlertmanagerConfig.MuteTimeIntervals, 1)
+		require.Equal(t, "always", cfg.AlertmanagerConfig.MuteTimeIntervals[0].Name)
+		names := []string{}
+		for _, receiver := range cfg.AlertmanagerConfig.Receivers {
+			names = append(names, receiver.Name)
+		}
+		require.ElementsMatch(t, append([]string{"a new receiver"}, allNestedRoutesReceivers...), names)
+
+		provenance, err := sut.provenanceStore.GetProvenance(context.Background(), &definitions.EmbeddedContactPoint{UID: "team-a"}, 1)
+		require.NoError(t, err)
+		require.Equal(t, models.ProvenanceAPI, provenance)
+		provenance, err = sut.provenanceStore.GetProvenance(context.Background(), &imported, 1)
+		require.NoError(t, err)
+		require.Equal(t, models.ProvenanceAPI, provenance)
+	})
+
+	t.Run("keeps the secure settings of existing integrations", func(t *testing.T) {
+		sut, blob := exportNestedRoutes(t)
+
+		err := sut.ImportOrgAlertingState(context.Background(), 1, blob, models.ProvenanceAPI)
+
+		require.NoError(t, err)
+		cfg, err := sut.readConfig(context.Background(), 1)
+		require.NoError(t, err)
+		for _, receiver := range cfg.AlertmanagerConfig.Receivers {
+			if receiver.Name == "team-a" {
+				require.Equal(t, map[string]string{"password": "ZW5jcnlwdGVk"}, receiver.GrafanaManagedReceivers[0].SecureSettings)
+			}
+		}
+	})
+
+	t.Run("rejects integrations missing a secure setting they require", func(t *testing.T) {
+		source := createNotificationPolicyServiceSut()
+		source.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = strings.Replace(withEmailIntegrations(configWithNestedRoutes, allNestedRoutesReceivers...),
+			`"uid": "team-a", "name": "team-a", "type": "email", "settings": {"addresses": "<example@email.com>"}`,
+			`"uid": "team-a", "name": "team-a", "type": "pagerduty", "settings": {}, "secureSettings": {"integrationKey": "ZW5jcnlwdGVk"}`, 1)
+		blob, err := source.ExportOrgAlertingState(context.Background(), 1)
+		require.NoError(t, err)
+		sut := createNotificationPolicyServiceSut()
+
+		err = sut.ImportOrgAlertingState(context.Background(), 1, blob, models.ProvenanceAPI)
+
+		require.ErrorIs(t, err, ErrValidation)
+		require.Nil(t, sut.amStore.(*fakeAMConfigStore).lastSaveCommand)
+
+		err = source.ImportOrgAlertingState(context.Background(), 1, blob, models.ProvenanceAPI)
+		require.NoError(t, err)
+	})
+
+	t.Run("can be undone", func(t *testing.T) {
+		sut, blob := exportNestedRoutes(t)
+		original, err := sut.GetPolicyTree(context.Background(), 1)
+		require.NoError(t, err)
+		var state OrgAlertingState
+		require.NoError(t, json.Unmarshal(blob, &state))
+		state.PolicyTree.Routes = state.PolicyTree.Routes[:1]
+		blob, err = json.Marshal(state)
+		require.NoError(t, err)
+		require.NoError(t, sut.ImportOrgAlertingState(context.Background(), 1, blob, models.ProvenanceAPI))
+
+		_, err = sut.UndoLastPolicyChange(context.Background(), 1, models.ProvenanceAPI)
+
+		require.NoError(t, err)
+		restored, err := sut.GetPolicyTree(context.Background(), 1)
+		require.NoError(t, err)
+		require.Equal(t, original.Routes, restored.Routes)
+	})
+
+	t.Run("cannot change routes provisioned from file", func(t *testing.T) {
+		sut, blob := exportNestedRoutes(t)
+		tree, err := sut.GetPolicyTree(context.Background(), 1)
+		require.NoError(t, err)
+		require.NoError(t, sut.UpdateRoute(context.Background(), 1, tree.Routes[1].UID, *tree.Routes[1], models.ProvenanceFile))
+		sut.amStore.(*fakeAMConfigStore).lastSaveCommand = nil
+		var state OrgAlertingState
+		require.NoError(t, json.Unmarshal(blob, &state))
+		state.PolicyTree.Routes[1].Receiver = "team-a"
+		blob, err = json.Marshal(state)
+		require.NoError(t, err)
+
+		err = sut.ImportOrgAlertingState(context.Background(), 1, blob, models.ProvenanceAPI)
+
+		require.ErrorIs(t, err, ErrValidation)
+		require.Nil(t, sut.amStore.(*fakeAMConfigStore).lastSaveCommand)
+	})
+
+	t.Run("rejects a tree referring to a receiver that does not exist", func(t *testing.T) {
+		_, blob := exportNestedRoutes(t)
+		var state OrgAlertingState
+		require.NoError(t, json.Unmarshal(blob, &state))
+		state.Receivers = state.Receivers[:len(state.Receivers)-1]
+		blob, err := json.Marshal(state)
+		require.NoError(t, err)
+		sut := createNotificationPolicyServiceSut()
+
+		err = sut.ImportOrgAlertingState(context.Background(), 1, blob, models.ProvenanceAPI)
+
+		require.ErrorIs(t, err, ErrValidation)
+		require.Nil(t, sut.amStore.(*fakeAMConfigStore).lastSaveCommand)
+	})
+
+	t.Run("rejects an unsupported version", func(t *testing.T) {
+		sut := createNotificationPolicyServiceSut()
+
+		err := sut.ImportOrgAlertingState(context.Background(), 1, []byte(`{"version": 2, "policyTree": {"receiver": "a new receiver"}}`), models.ProvenanceAPI)
+
+		require.ErrorIs(t, err, ErrValidation)
+		require.Nil(t, sut.amStore.(*fakeAMConfigStore).lastSaveCommand)
+	})
+
+	t.Run("cannot replace resources provisioned from file", func(t *testing.T) {
+		sut, blob := exportNestedRoutes(t)
+		always := definitions.MuteTimeInterval{}
+		always.Name = "always"
+		require.NoError(t, sut.provenanceStore.SetProvenance(context.Background(), &always, 1, models.ProvenanceFile))
+
+		err := sut.ImportOrgAlertingState(context.Background(), 1, blob, models.ProvenanceAPI)
+		require.ErrorIs(t, err, ErrValidation)
+		require.Nil(t, sut.amStore.(*fakeAMConfigStore).lastSaveCommand)
+
+		err = sut.ImportOrgAlertingState(context.Background(), 1, blob, models.ProvenanceFile)
+		require.NoError(t, err)
+	})
+}
@@ -27,7 +27,58 @@ type cfgRevision struct {
 	version          string
 }
 
+// configLimits bounds the stored Alertmanager configuration that is deserialized, so that a corrupted or hostile
+// configuration is rejected before it is unmarshalled. A zero limit is not enforced.
+type configLimits struct {
+	maxSize  int64
+	maxDepth int
+}
+
+// check returns an error if the configuration is larger or more deeply nested than the limits allow. The nesting
+// depth is found with a single scan over the raw bytes, so the check itself does not allocate.
+func (l configLimits) check(config []byte) error {
+	if l.maxSize > 0 && int64(len(config)) > l.maxSize {
+		return fmt.Errorf("alertmanager configuration of %d bytes exceeds the limit of %d bytes", len(config), l.maxSize)
+	}
+	if l.maxDepth <= 0 {
+		return nil
+	}
+	depth := 0
+	inString, escaped := false, false
+	for _, b := range config {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case b == '\\':
+				escaped = true
+			case b == '"':
+				inString = false
+			}
+			continue
+		}
+		switch b {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+			if depth > l.maxDepth {
+				return fmt.Errorf("alertmanager configuration exceeds the maximum nesting depth of %d", l.maxDepth)
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+	return nil
+}
+
 func getLastConfiguration(ctx context.Context, orgID int64, store AMConfigStore) (*cfgRevision, error) {
+	return getLastConfigurationWithLimits(ctx, orgID, store, configLimits{})
+}
+
+// getLastConfigurationWithLimits is like getLastConfiguration, but rejects a stored configuration that exceeds the
+// given limits without deserializing it.
+func getLastConfigurationWithLimits(ctx context.Context, orgID int64, store AMConfigStore, limits configLimits) (*cfgRevision, error) {
 	q := models.GetLatestAlertmanagerConfigurationQuery{
 		OrgID: orgID,
 	}
@@ -39,11 +90,19 @@ func getLastConfiguration(ctx context.Context, orgID int64, store AMConfigStore)
 		return nil, fmt.Errorf("no alertmanager configuration present in this org")
 	}
 
+	raw := []byte(q.Result.AlertmanagerConfiguration)
+	if err := limits.check(raw); err != nil {
+		return nil, err
+	}
+
 	concurrencyToken := q.Result.ConfigurationHash
-	cfg, err := deserializeAlertmanagerConfig([]byte(q.Result.AlertmanagerConfiguration))
+	cfg, err := deserializeAlertmanagerConfig(raw)
 	if err != nil {
 		return nil, err
 	}
+	// Time intervals defined with the spelling of newer Alertmanager versions are resolved like any other,
+	// and are saved under mute_time_intervals the next time the configuration is updated.
+	cfg.AlertmanagerConfig.MigrateTimeIntervals()
 
 	return &cfgRevision{
 		cfg:              cfg,
@@ -0,0 +1,126 @@
+package provisioning
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/grafana/grafana/pkg/services/ngalert/api/tooling/definitions"
+	"github.com/grafana/grafana/pkg/services/ngalert/notifier/channels"
+)
+
+// ValidationProblemKind is the part of the Alertmanager configuration a validation problem is about.
+type ValidationProblemKind string
+
+const (
+	ValidationProblemReceiver   ValidationProblemKind = "receiver"
+	ValidationProblemTemplate   ValidationProblemKind = "template"
+	ValidationProblemMuteTiming ValidationProblemKind = "mute_timing"
+	ValidationProblemRoute      ValidationProblemKind = "route"
+)
+
+// ValidationProblem is a problem ValidateAlertmanagerConfig found. Name is the name of the receiver, template or mute
+// timing the problem is about, or the path of child indexes of the route from the root, which is empty for the root.
+type ValidationProblem struct {
+	Kind    ValidationProblemKind `json:"kind"`
+	Name    string                `json:"name"`
+	Message string                `json:"message"`
+}
+
+// ValidationReport is the result of validating the Alertmanager configuration of an org. Its problems are ordered by
+// kind, receivers first and routes last, and within a kind in the order of the configuration.
+type ValidationReport struct {
+	Problems []ValidationProblem `json:"problems"`
+}
+
+// Valid returns true if the report has no problems.
+func (r *ValidationReport) Valid() bool {
+	return len(r.Problems) == 0
+}
+
+// ValidateAlertmanagerConfig validates the whole stored Alertmanager configuration of the org, and reports all the
+// problems it finds rather than stopping at the first: receivers that are defined more than once or have integrations
+// of unknown types, templates that do not parse, mute timings that are invalid, receivers routed to that reference
+// templates which are not defined, and the errors LintPolicyTree finds in the policy tree. A configuration that
+// cannot be deserialized at all is returned as an error.
+func (nps *NotificationPolicyService) ValidateAlertmanagerConfig(ctx context.Context, orgID int64) (*ValidationReport, error) {
+	revision, err := getLastConfigurationWithLimits(ctx, orgID, nps.amStore, nps.configLimits())
+	if err != nil {
+		return nil, err
+	}
+	cfg := revision.cfg.AlertmanagerConfig
+
+	report := &ValidationReport{Problems: []ValidationProblem{}}
+	add := func(kind ValidationProblemKind, name, format string, args ...interface{}) {
+		report.Problems = append(report.Problems, ValidationProblem{Kind: kind, Name: name, Message: fmt.Sprintf(format, args...)})
+	}
+
+	receivers := map[string]bool{}
+	for _, receiver := range cfg.Receivers {
+		if receiver.Name == "" {
+			add(ValidationProblemReceiver, "", "the receiver has no name")
+		} else if receivers[receiver.Name] {
+			add(ValidationProblemReceiver, receiver.Name, "the receiver is defined more than once")
+		}
+		receivers[receiver.Name] = true
+		for _, integration := range receiver.GrafanaManagedReceivers {
+			if _, ok := channels.Factory(integration.Type); !ok {
+				add(ValidationProblemReceiver, receiver.Name, "the integration %q has the unknown type %q", integration.Name, integration.Type)
+			}
+		}
+	}
+
+	templateNames := make([]string, 0, len(revision.cfg.TemplateFiles))
+	for name := range revision.cfg.TemplateFiles {
+		templateNames = append(templateNames, name)
+	}
+	sort.Strings(templateNames)
+	for _, name := range templateNames {
+		tmpl := definitions.MessageTemplate{Name: name, Template: revision.cfg.TemplateFiles[name]}
+		if err := tmpl.Validate(); err != nil {
+			add(ValidationProblemTemplate, name, "%s", err.Error())
+		}
+	}
+
+	for _, interval := range cfg.MuteTimeIntervals {
+		mt := definitions.MuteTimeInterval{MuteTimeInterval: interval}
+		if err := mt.Validate(); err != nil {
+			add(ValidationProblemMuteTiming, interval.Name, "%s", err.Error())
+		}
+	}
+
+	if cfg.Route == nil {
+		add(ValidationProblemRoute, "", "the configuration has no policy tree")
+		return report, nil
+	}
+	undefined, err := undefinedTemplatesUsed(cfg.Route, cfg.Receivers, revision.cfg.TemplateFiles)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range undefined {
+		add(ValidationProblemReceiver, ref.receiver, "the receiver references the template %q, which is not defined", ref.template)
+	}
+
+	lint, err := nps.lintPolicyTree(revision, cfg.Route)
+	if err != nil {
+		return nil, err
+	}
+	for _, finding := range lint.Findings {
+		if finding.Severity == LintSeverityError {
+			add(ValidationProblemRoute, finding.RoutePath, "%s", finding.Message)
+		}
+	}
+
+	sort.SliceStable(report.Problems, func(i, j int) bool {
+		return validationProblemOrder[report.Problems[i].Kind] < validationProblemOrder[report.Problems[j].Kind]
+	})
+	return report, nil
+}
+
+// validationProblemOrder is the order of the kinds of problems in a ValidationReport.
+var validationProblemOrder = map[ValidationProblemKind]int{
+	ValidationProblemReceiver:   0,
+	ValidationProblemTemplate:   1,
+	ValidationProblemMuteTiming: 2,
+	ValidationProblemRoute:      3,
+}
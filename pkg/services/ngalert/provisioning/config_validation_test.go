@@ -0,0 +1,116 @@
+package provisioning
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateAlertmanagerConfig(t *testing.T) {
+	t.Run("a valid configuration has no problems", func(t *testing.T) {
+		sut := createNotificationPolicyServiceSut()
+		sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithIntegrationTypes
+
+		report, err := sut.ValidateAlertmanagerConfig(context.Background(), 1)
+
+		require.NoError(t, err)
+		require.True(t, report.Valid())
+		require.Empty(t, report.Problems)
+	})
+
+	t.Run("reports all the problems of the configuration", func(t *testing.T) {
+		sut := createNotificationPolicyServiceSut()
+		sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithValidationProblems
+
+		report, err := sut.ValidateAlertmanagerConfig(context.Background(), 1)
+
+		require.NoError(t, err)
+		require.False(t, report.Valid())
+		require.Len(t, report.Problems, 4)
+
+		require.Equal(t, ValidationProblem{
+			Kind:    ValidationProblemReceiver,
+			Name:    "team-a",
+			Message: `the integration "team-a pigeon" has the unknown type "carrier-pigeon"`,
+		}, report.Problems[0])
+		require.Equal(t, ValidationProblem{
+			Kind:    ValidationProblemReceiver,
+			Name:    "team-a",
+			Message: "the receiver is defined more than once",
+		}, report.Problems[1])
+		require.Equal(t, ValidationProblem{
+			Kind:    ValidationProblemReceiver,
+			Name:    "team-a",
+			Message: `the receiver references the template "team.title", which is not defined`,
+		}, report.Problems[2])
+		require.Equal(t, ValidationProblemTemplate, report.Problems[3].Kind)
+		require.Equal(t, "broken", report.Problems[3].Name)
+		require.Contains(t, report.Problems[3].Message, "invalid template")
+	})
+
+	t.Run("reports every mute timing not matching the naming pattern", func(t *testing.T) {
+		sut := createNotificationPolicyServiceSut()
+		sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithWeeklyMuteTimings
+		sut.settings.MuteTimingNamePattern = regexp.MustCompile("^(?:week.+)$")
+
+		report, err := sut.ValidateAlertmanagerConfig(context.Background(), 1)
+
+		require.NoError(t, err)
+		require.True(t, report.Valid())
+
+		sut.settings.MuteTimingNamePattern = regexp.MustCompile("^(?:weekdays)$")
+
+		report, err = sut.ValidateAlertmanagerConfig(context.Background(), 1)
+
+		require.NoError(t, err)
+		require.Equal(t, []ValidationProblem{{
+			Kind:    ValidationProblemRoute,
+			Name:    "1",
+			Message: `mute timing "weekends" does not match the naming pattern "^(?:weekdays)$"`,
+		}}, report.Problems)
+	})
+}
+
+var configWithValidationProblems = `
+{
+	"template_files": {
+		"broken": "{{ define \"broken\" }}{{ .Status"
+	},
+	"alertmanager_config": {
+		"route": {
+			"receiver": "grafana-default-email",
+			"routes": [{
+				"receiver": "team-a",
+				"object_matchers": [["team", "=", "a"]]
+			}]
+		},
+		"receivers": [{
+			"name": "grafana-default-email",
+			"grafana_managed_receiver_configs": [{
+				"uid": "email-uid",
+				"name": "email receiver",
+				"type": "email",
+				"settings": {"addresses": "<example@email.com>"}
+			}]
+		}, {
+			"name": "team-a",
+			"grafana_managed_receiver_configs": [{
+				"uid": "pigeon-uid",
+				"name": "team-a pigeon",
+				"type": "carrier-pigeon",
+				"settings": {"title": "{{ template \"team.title\" . }}"}
+			}]
+		}, {
+			"name": "team-a",
+			"grafana_managed_receiver_configs": [{
+				"uid": "slack-uid",
+				"name": "team-a slack",
+				"type": "slack",
+				"settings": {"recipient": "#team-a"}
+			}]
+		}]
+	}
+}
+`
@@ -343,6 +343,156 @@ func (ecp *ContactPointService) DeleteContactPoint(ctx context.Context, orgID in
 	})
 }
 
+// CanDeleteReceiver tells whether the receiver with the given name can be deleted without breaking the policy tree of
+// the org. If it cannot, the routes that deliver to it are returned, in tree order, so that they can be changed
+// first. Child routes that inherit the receiver are not returned, as they follow the route they inherit it from. If
+// the receiver does not exist, ErrNotFound is returned.
+func (ecp *ContactPointService) CanDeleteReceiver(ctx context.Context, orgID int64, name string) (bool, []RouteRef, error) {
+	revision, err := getLastConfiguration(ctx, orgID, ecp.amStore)
+	if err != nil {
+		return false, nil, err
+	}
+
+	exists := false
+	for _, receiver := range revision.cfg.AlertmanagerConfig.Receivers {
+		if receiver.Name == name {
+			exists = true
+			break
+		}
+	}
+	if !exists {
+		return false, nil, fmt.Errorf("%w: receiver '%s'", ErrNotFound, name)
+	}
+
+	refs := []RouteRef{}
+	tree := revision.cfg.AlertmanagerConfig.Route
+	if tree == nil {
+		return true, refs, nil
+	}
+	fillDerivedRouteUIDs(tree)
+	walkRoutes(tree, "", func(route *apimodels.Route, path string) {
+		if route.Receiver == name {
+			refs = append(refs, RouteRef{RouteUID: route.UID, RoutePath: path, Receiver: name})
+		}
+	})
+	return len(refs) == 0, refs, nil
+}
+
+// FindUnreferencedReceivers returns the names of the receivers that PruneUnreferencedReceivers would remove,
+// without changing the configuration.
+func (ecp *ContactPointService) FindUnreferencedReceivers(ctx context.Context, orgID int64, provenance models.Provenance) ([]string, error) {
+	revision, err := getLastConfiguration(ctx, orgID, ecp.amStore)
+	if err != nil {
+		return nil, err
+	}
+	unreferenced, err := ecp.unreferencedReceivers(ctx, orgID, revision, provenance)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(unreferenced))
+	for _, receiver := range unreferenced {
+		names = append(names, receiver.Name)
+	}
+	return names, nil
+}
+
+// PruneUnreferencedReceivers removes the receivers that are not referenced by any route of the policy tree,
+// and returns their names. Receivers with contact points provisioned with another provenance are kept.
+func (ecp *ContactPointService) PruneUnreferencedReceivers(ctx context.Context, orgID int64, provenance models.Provenance) ([]string, error) {
+	revision, err := getLastConfiguration(ctx, orgID, ecp.amStore)
+	if err != nil {
+		return nil, err
+	}
+	unreferenced, err := ecp.unreferencedReceivers(ctx, orgID, revision, provenance)
+	if err != nil {
+		return nil, err
+	}
+	if len(unreferenced) == 0 {
+		return []string{}, nil
+	}
+
+	removed := make(map[string]struct{}, len(unreferenced))
+	names := make([]string, 0, len(unreferenced))
+	for _, receiver := range unreferenced {
+		removed[receiver.Name] = struct{}{}
+		names = append(names, receiver.Name)
+	}
+	kept := make([]*apimodels.PostableApiReceiver, 0, len(revision.cfg.AlertmanagerConfig.Receivers))
+	remaining := map[string]struct{}{}
+	for _, receiver := range revision.cfg.AlertmanagerConfig.Receivers {
+		if _, ok := removed[receiver.Name]; ok {
+			continue
+		}
+		kept = append(kept, receiver)
+		remaining[receiver.Name] = struct{}{}
+	}
+	if err := revision.cfg.AlertmanagerConfig.Route.ValidateReceivers(remaining); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrValidation, err.Error())
+	}
+	revision.cfg.AlertmanagerConfig.Receivers = kept
+
+	data, err := json.Marshal(revision.cfg)
+	if err != nil {
+		return nil, err
+	}
+	err = ecp.xact.InTransaction(ctx, func(ctx context.Context) error {
+		for _, receiver := range unreferenced {
+			for _, grafanaReceiver := range receiver.GrafanaManagedReceivers {
+				target := &apimodels.EmbeddedContactPoint{
+					UID: grafanaReceiver.UID,
+				}
+				if err := ecp.provenanceStore.DeleteProvenance(ctx, target, orgID); err != nil {
+					return err
+				}
+			}
+		}
+		return ecp.amStore.UpdateAlertmanagerConfiguration(ctx, &models.SaveAlertmanagerConfigurationCmd{
+			AlertmanagerConfiguration: string(data),
+			FetchedConfigurationHash:  revision.concurrencyToken,
+			ConfigurationVersion:      revision.version,
+			Default:                   false,
+			OrgID:                     orgID,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// unreferencedReceivers returns the receivers of the configuration that are not referenced by the policy tree
+// and whose contact points can all be removed with the given provenance.
+func (ecp *ContactPointService) unreferencedReceivers(ctx context.Context, orgID int64, revision *cfgRevision, provenance models.Provenance) ([]*apimodels.PostableApiReceiver, error) {
+	if revision.cfg.AlertmanagerConfig.Route == nil {
+		return nil, fmt.Errorf("no route present in current alertmanager config")
+	}
+	provenances, err := ecp.provenanceStore.GetProvenances(ctx, orgID, (&apimodels.EmbeddedContactPoint{}).ResourceType())
+	if err != nil {
+		return nil, err
+	}
+
+	referenced := referencedReceivers(revision.cfg.AlertmanagerConfig.Route)
+	unreferenced := []*apimodels.PostableApiReceiver{}
+	for _, receiver := range revision.cfg.AlertmanagerConfig.Receivers {
+		if _, ok := referenced[receiver.Name]; ok {
+			continue
+		}
+		removable := true
+		for _, grafanaReceiver := range receiver.GrafanaManagedReceivers {
+			stored := provenances[grafanaReceiver.UID]
+			if stored != provenance && stored != models.ProvenanceNone {
+				ecp.log.Debug("keeping unreferenced receiver provisioned with another provenance", "receiver", receiver.Name, "provenance", stored)
+				removable = false
+				break
+			}
+		}
+		if removable {
+			unreferenced = append(unreferenced, receiver)
+		}
+	}
+	return unreferenced, nil
+}
+
 func isContactPointInUse(name string, routes []*apimodels.Route) bool {
 	if len(routes) == 0 {
 		return false
@@ -219,6 +219,100 @@ func TestContactPointService(t *testing.T) {
 	})
 }
 
+func TestPruneUnreferencedReceivers(t *testing.T) {
+	sqlStore := sqlstore.InitTestDB(t)
+	secretsService := manager.SetupTestService(t, database.ProvideSecretsStore(sqlStore))
+
+	t.Run("dry run finds orphaned receivers without removing them", func(t *testing.T) {
+		sut := createContactPointServiceSut(secretsService)
+
+		names, err := sut.FindUnreferencedReceivers(context.Background(), 1, models.ProvenanceAPI)
+
+		require.NoError(t, err)
+		require.Equal(t, []string{"a new receiver"}, names)
+		require.Nil(t, sut.amStore.(*fakeAMConfigStore).lastSaveCommand)
+	})
+
+	t.Run("orphaned receivers are removed and referenced ones kept", func(t *testing.T) {
+		sut := createContactPointServiceSut(secretsService)
+
+		names, err := sut.PruneUnreferencedReceivers(context.Background(), 1, models.ProvenanceAPI)
+		require.NoError(t, err)
+		require.Equal(t, []string{"a new receiver"}, names)
+
+		revision, err := getLastConfiguration(context.Background(), 1, sut.amStore)
+		require.NoError(t, err)
+		require.Len(t, revision.cfg.AlertmanagerConfig.Receivers, 1)
+		require.Equal(t, "grafana-default-email", revision.cfg.AlertmanagerConfig.Receivers[0].Name)
+	})
+
+	t.Run("prune is a no-op when all receivers are referenced", func(t *testing.T) {
+		sut := createContactPointServiceSut(secretsService)
+		_, err := sut.PruneUnreferencedReceivers(context.Background(), 1, models.ProvenanceAPI)
+		require.NoError(t, err)
+		fake := sut.amStore.(*fakeAMConfigStore)
+		fake.lastSaveCommand = nil
+
+		names, err := sut.PruneUnreferencedReceivers(context.Background(), 1, models.ProvenanceAPI)
+
+		require.NoError(t, err)
+		require.Empty(t, names)
+		require.NotNil(t, names)
+		require.Nil(t, fake.lastSaveCommand)
+	})
+
+	t.Run("orphaned receivers provisioned with another provenance are kept", func(t *testing.T) {
+		sut := createContactPointServiceSut(secretsService)
+		_, err := sut.CreateContactPoint(context.Background(), 1, createTestContactPoint(), models.ProvenanceFile)
+		require.NoError(t, err)
+
+		names, err := sut.PruneUnreferencedReceivers(context.Background(), 1, models.ProvenanceAPI)
+
+		require.NoError(t, err)
+		require.Equal(t, []string{"a new receiver"}, names)
+		cps, err := sut.GetContactPoints(context.Background(), 1)
+		require.NoError(t, err)
+		require.Len(t, cps, 2)
+	})
+}
+
+func TestCanDeleteReceiver(t *testing.T) {
+	sqlStore := sqlstore.InitTestDB(t)
+	secretsService := manager.SetupTestService(t, database.ProvideSecretsStore(sqlStore))
+
+	t.Run("a referenced receiver cannot be deleted", func(t *testing.T) {
+		sut := createContactPointServiceSut(secretsService)
+		sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+
+		ok, refs, err := sut.CanDeleteReceiver(context.Background(), 1, "team-b-critical")
+
+		require.NoError(t, err)
+		require.False(t, ok)
+		require.Len(t, refs, 1)
+		require.Equal(t, "1.0", refs[0].RoutePath)
+		require.NotEmpty(t, refs[0].RouteUID)
+		require.Equal(t, "team-b-critical", refs[0].Receiver)
+	})
+
+	t.Run("an unreferenced receiver can be deleted", func(t *testing.T) {
+		sut := createContactPointServiceSut(secretsService)
+
+		ok, refs, err := sut.CanDeleteReceiver(context.Background(), 1, "a new receiver")
+
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Empty(t, refs)
+	})
+
+	t.Run("fails when the receiver does not exist", func(t *testing.T) {
+		sut := createContactPointServiceSut(secretsService)
+
+		_, _, err := sut.CanDeleteReceiver(context.Background(), 1, "does-not-exist")
+
+		require.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
 func TestContactPointInUse(t *testing.T) {
 	result := isContactPointInUse("test", []*definitions.Route{
 		{
@@ -1,6 +1,38 @@
 package provisioning
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/grafana/grafana/pkg/services/ngalert/api/tooling/definitions"
+)
 
 var ErrValidation = fmt.Errorf("invalid object specification")
 var ErrNotFound = fmt.Errorf("object not found")
+
+// RouteValidationError is a validation error of a route of a policy tree. Path is a JSON pointer to the offending
+// route from the root of the tree, such as /routes/0/routes/2, and is empty when the root route itself is invalid.
+type RouteValidationError struct {
+	Path   string
+	Reason string
+}
+
+func (e *RouteValidationError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%s: %s", ErrValidation.Error(), e.Reason)
+	}
+	return fmt.Sprintf("%s: route %s: %s", ErrValidation.Error(), e.Path, e.Reason)
+}
+
+func (e *RouteValidationError) Unwrap() error {
+	return ErrValidation
+}
+
+// newRouteValidationError converts an error returned by the validation of a route into a RouteValidationError.
+func newRouteValidationError(err error) *RouteValidationError {
+	var routeErr *definitions.RouteError
+	if errors.As(err, &routeErr) {
+		return &RouteValidationError{Path: routeErr.Path, Reason: routeErr.Err.Error()}
+	}
+	return &RouteValidationError{Reason: err.Error()}
+}
@@ -194,6 +194,64 @@ func (svc *MuteTimingService) DeleteMuteTiming(ctx context.Context, name string,
 	})
 }
 
+// RoutesUsingMuteTiming returns the routes of the policy tree of the org that reference the mute timing with the given
+// name, in tree order, to preview which notifications it silences before it is edited or deleted. Mute timings are
+// not inherited, so the child routes of such a route are only returned if they reference it too. If the mute timing
+// does not exist, ErrNotFound is returned.
+func (svc *MuteTimingService) RoutesUsingMuteTiming(ctx context.Context, orgID int64, name string) ([]RouteRef, error) {
+	revision, err := getLastConfiguration(ctx, orgID, svc.config)
+	if err != nil {
+		return nil, err
+	}
+
+	exists := false
+	for _, existing := range revision.cfg.AlertmanagerConfig.MuteTimeIntervals {
+		if existing.Name == name {
+			exists = true
+			break
+		}
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: mute timing '%s'", ErrNotFound, name)
+	}
+
+	refs := []RouteRef{}
+	tree := revision.cfg.AlertmanagerConfig.Route
+	if tree == nil {
+		return refs, nil
+	}
+	fillDerivedRouteUIDs(tree)
+	paths := map[*definitions.Route]string{}
+	walkRoutes(tree, "", func(route *definitions.Route, path string) {
+		paths[route] = path
+	})
+
+	// A receiver stays notified during the window if at least one of the routes delivering to it does not use the
+	// mute timing.
+	notified := map[string]bool{}
+	walkRouteReceivers(tree, "", func(route *definitions.Route, receiver string) {
+		if !routeUsesMuteTiming(route, name) {
+			notified[receiver] = true
+			return
+		}
+		refs = append(refs, RouteRef{RouteUID: route.UID, RoutePath: paths[route], Receiver: receiver})
+	})
+	for i := range refs {
+		refs[i].ReceiverMuted = !notified[refs[i].Receiver]
+	}
+	return refs, nil
+}
+
+// routeUsesMuteTiming tells whether the route itself references the mute timing, regardless of its child routes.
+func routeUsesMuteTiming(route *definitions.Route, name string) bool {
+	for _, mtName := range route.MuteTimeIntervals {
+		if mtName == name {
+			return true
+		}
+	}
+	return false
+}
+
 func isMuteTimeInUse(name string, routes []*definitions.Route) bool {
 	if len(routes) == 0 {
 		return false
@@ -372,6 +372,58 @@ func TestMuteTimingService(t *testing.T) {
 	})
 }
 
+func TestRoutesUsingMuteTiming(t *testing.T) {
+	t.Run("returns the nested routes referencing the mute timing", func(t *testing.T) {
+		sut := createMuteTimingSvcSut()
+		sut.config.(*MockAMConfigStore).EXPECT().
+			GetsConfig(models.AlertConfiguration{
+				AlertmanagerConfiguration: configWithMuteTimingInNestedRoutes,
+			})
+
+		refs, err := sut.RoutesUsingMuteTiming(context.Background(), 1, "maintenance")
+
+		require.NoError(t, err)
+		require.Len(t, refs, 3)
+		require.Equal(t, "0", refs[0].RoutePath)
+		require.NotEmpty(t, refs[0].RouteUID)
+		require.Equal(t, "team-a", refs[0].Receiver)
+		require.True(t, refs[0].ReceiverMuted)
+		// The nested route inherits the receiver of its parent, which is muted by both.
+		require.Equal(t, "0.0", refs[1].RoutePath)
+		require.Equal(t, "team-a", refs[1].Receiver)
+		require.True(t, refs[1].ReceiverMuted)
+		// The parent of this route delivers to the same receiver without the mute timing.
+		require.Equal(t, "1.0", refs[2].RoutePath)
+		require.Equal(t, "team-b", refs[2].Receiver)
+		require.False(t, refs[2].ReceiverMuted)
+	})
+
+	t.Run("returns no routes for an unused mute timing", func(t *testing.T) {
+		sut := createMuteTimingSvcSut()
+		sut.config.(*MockAMConfigStore).EXPECT().
+			GetsConfig(models.AlertConfiguration{
+				AlertmanagerConfiguration: configWithMuteTimings,
+			})
+
+		refs, err := sut.RoutesUsingMuteTiming(context.Background(), 1, "asdf")
+
+		require.NoError(t, err)
+		require.Empty(t, refs)
+	})
+
+	t.Run("fails when the mute timing does not exist", func(t *testing.T) {
+		sut := createMuteTimingSvcSut()
+		sut.config.(*MockAMConfigStore).EXPECT().
+			GetsConfig(models.AlertConfiguration{
+				AlertmanagerConfiguration: configWithMuteTimings,
+			})
+
+		_, err := sut.RoutesUsingMuteTiming(context.Background(), 1, "does-not-exist")
+
+		require.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
 func createMuteTimingSvcSut() *MuteTimingService {
 	return &MuteTimingService{
 		config: &MockAMConfigStore{},
@@ -458,3 +510,40 @@ var configWithMuteTimingsInRoute = `
 	}
 }
 `
+
+var configWithMuteTimingInNestedRoutes = `
+{
+	"alertmanager_config": {
+		"route": {
+			"receiver": "grafana-default-email",
+			"routes": [{
+				"receiver": "team-a",
+				"object_matchers": [["team", "=", "a"]],
+				"mute_time_intervals": ["maintenance"],
+				"routes": [{
+					"object_matchers": [["severity", "=", "critical"]],
+					"mute_time_intervals": ["maintenance"]
+				}]
+			}, {
+				"receiver": "team-b",
+				"object_matchers": [["team", "=", "b"]],
+				"routes": [{
+					"object_matchers": [["severity", "=", "critical"]],
+					"mute_time_intervals": ["maintenance"]
+				}]
+			}]
+		},
+		"mute_time_intervals": [{
+			"name": "maintenance",
+			"time_intervals": [{
+				"weekdays": ["sunday"]
+			}]
+		}],
+		"receivers": [
+			{"name": "grafana-default-email"},
+			{"name": "team-a"},
+			{"name": "team-b"}
+		]
+	}
+}
+`
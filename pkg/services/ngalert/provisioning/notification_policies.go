@@ -1,122 +1,504 @@
 package provisioning
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
 
+	"github.com/grafana/grafana/pkg/infra/kvstore"
 	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/infra/tracing"
 	"github.com/grafana/grafana/pkg/services/ngalert/api/tooling/definitions"
+	"github.com/grafana/grafana/pkg/services/ngalert/eval"
 	"github.com/grafana/grafana/pkg/services/ngalert/models"
+	"github.com/grafana/grafana/pkg/services/ngalert/state"
 	"github.com/grafana/grafana/pkg/setting"
+	"github.com/prometheus/alertmanager/inhibit"
+	"github.com/prometheus/alertmanager/timeinterval"
+	"github.com/prometheus/common/model"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// rerouteNamespace is the namespace of the key-value store the snapshots of rerouted receivers are kept in.
+const rerouteNamespace = "alertmanager.notification-policies.reroutes"
+
+// undoNamespace is the namespace of the key-value store the snapshot of the last policy tree change is kept in, under
+// undoKey.
+const (
+	undoNamespace = "alertmanager.notification-policies.undo"
+	undoKey       = "last-change"
 )
 
 type NotificationPolicyService struct {
 	amStore         AMConfigStore
 	provenanceStore ProvisioningStore
 	xact            TransactionManager
+	kvStore         kvstore.KVStore
+	alertStates     AlertStateReader
+	tracer          tracing.Tracer
 	log             log.Logger
 	settings        setting.UnifiedAlertingSettings
 }
 
-func NewNotificationPolicyService(am AMConfigStore, prov ProvisioningStore,
-	xact TransactionManager, settings setting.UnifiedAlertingSettings, log log.Logger) *NotificationPolicyService {
+func NewNotificationPolicyService(am AMConfigStore, prov ProvisioningStore, xact TransactionManager, kv kvstore.KVStore,
+	alertStates AlertStateReader, tracer tracing.Tracer, settings setting.UnifiedAlertingSettings, log log.Logger) *NotificationPolicyService {
 	return &NotificationPolicyService{
 		amStore:         am,
 		provenanceStore: prov,
 		xact:            xact,
+		kvStore:         kv,
+		alertStates:     alertStates,
+		tracer:          tracer,
 		log:             log,
 		settings:        settings,
 	}
 }
 
+// receiverReroute is the snapshot RerouteReceiver keeps of a rerouted receiver, so that RestoreReceiverRouting can
+// undo it. Routes lists the UIDs of the routes whose receiver was changed to To.
+type receiverReroute struct {
+	To     string   `json:"to"`
+	Routes []string `json:"routes"`
+}
+
+// policyTreeChange is the snapshot of a change of the policy tree that UndoLastPolicyChange reverts. The tree the
+// change applied is kept to tell whether the tree was changed again since.
+type policyTreeChange struct {
+	Previous *definitions.Route `json:"previous"`
+	Applied  *definitions.Route `json:"applied"`
+}
+
+// saveRevision saves the configuration of the revision, and calls save in the same transaction to save what goes
+// along with it, such as provenances. The save fails if the configuration changed since the revision was read.
+func (nps *NotificationPolicyService) saveRevision(ctx context.Context, orgID int64, revision *cfgRevision, save func(ctx context.Context) error) error {
+	serialized, err := serializeAlertmanagerConfig(*revision.cfg)
+	if err != nil {
+		return err
+	}
+	cmd := models.SaveAlertmanagerConfigurationCmd{
+		AlertmanagerConfiguration: string(serialized),
+		ConfigurationVersion:      revision.version,
+		FetchedConfigurationHash:  revision.concurrencyToken,
+		Default:                   false,
+		OrgID:                     orgID,
+	}
+	return nps.xact.InTransaction(ctx, func(ctx context.Context) error {
+		if err := nps.amStore.UpdateAlertmanagerConfiguration(ctx, &cmd); err != nil {
+			return err
+		}
+		return save(ctx)
+	})
+}
+
+// saveUndoSnapshot keeps the change of the policy tree from previous to applied, so that UndoLastPolicyChange can
+// revert it. Nothing is kept if there was no previous tree.
+func (nps *NotificationPolicyService) saveUndoSnapshot(ctx context.Context, orgID int64, previous, applied *definitions.Route) error {
+	if previous == nil {
+		return nil
+	}
+	snapshot, err := json.Marshal(policyTreeChange{Previous: previous, Applied: applied})
+	if err != nil {
+		return err
+	}
+	return nps.kvStore.Set(ctx, orgID, undoNamespace, undoKey, string(snapshot))
+}
+
 func (nps *NotificationPolicyService) GetAMConfigStore() AMConfigStore {
 	return nps.amStore
 }
 
 func (nps *NotificationPolicyService) GetPolicyTree(ctx context.Context, orgID int64) (definitions.Route, error) {
+	ctx, span := nps.startSpan(ctx, "provisioning.GetPolicyTree", orgID)
+	defer span.End()
+
+	deserializeCtx, deserializeSpan := nps.startSpan(ctx, "provisioning.GetPolicyTree.deserialize", orgID)
+	cfg, err := nps.readConfig(deserializeCtx, orgID)
+	endSpan(deserializeSpan, err)
+	if err != nil {
+		return definitions.Route{}, err
+	}
+
+	if cfg.AlertmanagerConfig.Config.Route == nil {
+		return definitions.Route{}, fmt.Errorf("no route present in current alertmanager config")
+	}
+	fillDerivedRouteUIDs(cfg.AlertmanagerConfig.Config.Route)
+
+	provenances, err := nps.provenanceStore.GetProvenances(ctx, orgID, (&definitions.Route{}).ResourceType())
+	if err != nil {
+		return definitions.Route{}, err
+	}
+	locked := map[string]bool{}
+	for id, provenance := range provenances {
+		if route := findRoute(cfg.AlertmanagerConfig.Route, id); route != nil {
+			route.Provenance = provenance
+		}
+		// the provenance of the whole tree is stored under the empty ID
+		if id != "" && provenance == models.ProvenanceFile {
+			locked[id] = true
+		}
+	}
+	markEditableRoutes(cfg.AlertmanagerConfig.Route, locked, false)
+
+	return *cfg.AlertmanagerConfig.Route, nil
+}
+
+// PolicyTreeETag returns an entity tag of the policy tree GetPolicyTree returns for the org, so that clients can tell
+// whether it changed without fetching it. It is derived from the hash of the stored configuration, and from the
+// provenances of the routes, which can change without the configuration.
+func (nps *NotificationPolicyService) PolicyTreeETag(ctx context.Context, orgID int64) (string, error) {
 	q := models.GetLatestAlertmanagerConfigurationQuery{
 		OrgID: orgID,
 	}
-	err := nps.amStore.GetLatestAlertmanagerConfiguration(ctx, &q)
+	if err := nps.amStore.GetLatestAlertmanagerConfiguration(ctx, &q); err != nil {
+		return "", err
+	}
+	if q.Result == nil {
+		return "", fmt.Errorf("no alertmanager configuration present in this org")
+	}
+
+	provenances, err := nps.provenanceStore.GetProvenances(ctx, orgID, (&definitions.Route{}).ResourceType())
 	if err != nil {
-		return definitions.Route{}, err
+		return "", err
+	}
+	ids := make([]string, 0, len(provenances))
+	for id := range provenances {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	h := sha256.New()
+	h.Write([]byte(q.Result.ConfigurationHash))
+	for _, id := range ids {
+		fmt.Fprintf(h, "\x00%s=%s", id, provenances[id])
 	}
+	return `"` + hex.EncodeToString(h.Sum(nil))[:32] + `"`, nil
+}
 
-	cfg, err := deserializeAlertmanagerConfig([]byte(q.Result.AlertmanagerConfiguration))
+// GetEffectivePolicyTree returns the policy tree of the org with the options each route inherits resolved, and the
+// names of those options listed on the route.
+func (nps *NotificationPolicyService) GetEffectivePolicyTree(ctx context.Context, orgID int64) (definitions.EffectiveRoute, error) {
+	revision, err := getLastConfigurationWithLimits(ctx, orgID, nps.amStore, nps.configLimits())
 	if err != nil {
-		return definitions.Route{}, err
+		return definitions.EffectiveRoute{}, err
 	}
 
-	if cfg.AlertmanagerConfig.Config.Route == nil {
-		return definitions.Route{}, fmt.Errorf("no route present in current alertmanager config")
+	if revision.cfg.AlertmanagerConfig.Config.Route == nil {
+		return definitions.EffectiveRoute{}, fmt.Errorf("no route present in current alertmanager config")
 	}
+	fillDerivedRouteUIDs(revision.cfg.AlertmanagerConfig.Config.Route)
+
+	return *effectiveRoute(revision.cfg.AlertmanagerConfig.Config.Route), nil
+}
 
-	provenance, err := nps.provenanceStore.GetProvenance(ctx, cfg.AlertmanagerConfig.Route, orgID)
+// UpdatePolicyTree replaces the policy tree of the org with the given tree. It returns false, without saving the
+// configuration, if the tree routes alerts exactly like the one already stored. The provenance is updated either way.
+// Routes of the tree without a UID take the UID of the stored route at the same position. The matcher macros of the
+// tree are expanded into its routes, and only the expanded tree is validated and saved.
+func (nps *NotificationPolicyService) UpdatePolicyTree(ctx context.Context, orgID int64, tree definitions.Route, p models.Provenance) (bool, error) {
+	ctx, span := nps.startSpan(ctx, "provisioning.UpdatePolicyTree", orgID)
+	defer span.End()
+	span.SetAttributes("provenance", string(p), attribute.String("provenance", string(p)))
+
+	deserializeCtx, deserializeSpan := nps.startSpan(ctx, "provisioning.UpdatePolicyTree.deserialize", orgID)
+	revision, err := getLastConfigurationWithLimits(deserializeCtx, orgID, nps.amStore, nps.configLimits())
+	endSpan(deserializeSpan, err)
 	if err != nil {
-		return definitions.Route{}, err
+		return false, err
+	}
+
+	if revision.cfg.AlertmanagerConfig.Config.Route != nil {
+		fillDerivedRouteUIDs(revision.cfg.AlertmanagerConfig.Config.Route)
+	}
+	inheritRouteUIDs(revision.cfg.AlertmanagerConfig.Config.Route, &tree)
+
+	validateCtx, validateSpan := nps.startSpan(ctx, "provisioning.UpdatePolicyTree.validate", orgID)
+	err = nps.validatePolicyTree(revision, &tree)
+	if err == nil && revision.cfg.AlertmanagerConfig.Config.Route != nil {
+		err = nps.checkSubtreeProvenance(validateCtx, orgID, revision.cfg.AlertmanagerConfig.Config.Route, &tree, p)
+	}
+	endSpan(validateSpan, err)
+	if err != nil {
+		return false, err
 	}
 
-	result := *cfg.AlertmanagerConfig.Route
-	result.Provenance = provenance
+	if revision.cfg.AlertmanagerConfig.Config.Route != nil {
+		unchanged, err := isEquivalentRoute(revision.cfg.AlertmanagerConfig.Config.Route, &tree)
+		if err != nil {
+			return false, err
+		}
+		if unchanged {
+			current, err := nps.provenanceStore.GetProvenance(ctx, &tree, orgID)
+			if err != nil {
+				return false, err
+			}
+			if current != p {
+				if err := nps.provenanceStore.SetProvenance(ctx, &tree, orgID, p); err != nil {
+					return false, err
+				}
+			}
+			return false, nil
+		}
+	}
+
+	previous := revision.cfg.AlertmanagerConfig.Config.Route
+	revision.cfg.AlertmanagerConfig.Config.Route = &tree
+
+	storeCtx, storeSpan := nps.startSpan(ctx, "provisioning.UpdatePolicyTree.store", orgID)
+	err = nps.saveRevision(storeCtx, orgID, revision, func(ctx context.Context) error {
+		if err := nps.provenanceStore.SetProvenance(ctx, &tree, orgID, p); err != nil {
+			return err
+		}
+		return nps.saveUndoSnapshot(ctx, orgID, previous, &tree)
+	})
+	endSpan(storeSpan, err)
+	if err != nil {
+		return false, err
+	}
 
-	return result, nil
+	return true, nil
 }
 
-func (nps *NotificationPolicyService) UpdatePolicyTree(ctx context.Context, orgID int64, tree definitions.Route, p models.Provenance) error {
-	err := tree.Validate()
+// UpdateRoute replaces the route with the given UID, including all of its children, with the given route, and sets
+// the provenance of that subtree. The route keeps its UID. Subtrees provisioned from file can only be changed by file
+// provisioning.
+func (nps *NotificationPolicyService) UpdateRoute(ctx context.Context, orgID int64, routeID string, route definitions.Route, p models.Provenance) error {
+	revision, err := getLastConfigurationWithLimits(ctx, orgID, nps.amStore, nps.configLimits())
 	if err != nil {
-		return fmt.Errorf("%w: %s", ErrValidation, err.Error())
+		return err
+	}
+
+	stored := revision.cfg.AlertmanagerConfig.Config.Route
+	if stored == nil {
+		return fmt.Errorf("no route present in current alertmanager config")
 	}
+	fillDerivedRouteUIDs(stored)
 
-	revision, err := getLastConfiguration(ctx, orgID, nps.amStore)
+	target := findRoute(stored, routeID)
+	if target == nil {
+		return fmt.Errorf("%w: route with ID %q", ErrNotFound, routeID)
+	}
+	route.UID = target.UID
+	inheritRouteUIDs(target, &route)
+
+	tree, err := cloneRoute(stored)
 	if err != nil {
 		return err
 	}
+	tree, ok := replaceRoute(tree, routeID, &route)
+	if !ok {
+		return fmt.Errorf("%w: route with ID %q", ErrNotFound, routeID)
+	}
 
-	receivers, err := nps.receiversToMap(revision.cfg.AlertmanagerConfig.Receivers)
-	err = tree.ValidateReceivers(receivers)
+	err = nps.validatePolicyTree(revision, tree)
 	if err != nil {
-		return fmt.Errorf("%w: %s", ErrValidation, err.Error())
+		return err
 	}
 
-	muteTimes := map[string]struct{}{}
-	for _, mt := range revision.cfg.AlertmanagerConfig.MuteTimeIntervals {
-		muteTimes[mt.Name] = struct{}{}
+	err = nps.checkSubtreeProvenance(ctx, orgID, stored, tree, p)
+	if err != nil {
+		return err
 	}
-	err = tree.ValidateMuteTimes(muteTimes)
+
+	revision.cfg.AlertmanagerConfig.Config.Route = tree
+
+	return nps.saveRevision(ctx, orgID, revision, func(ctx context.Context) error {
+		return nps.provenanceStore.SetProvenance(ctx, routeSubtree{id: routeID}, orgID, p)
+	})
+}
+
+// AddRoute appends the given route, including all of its children, to the children of the route with the given UID,
+// and sets the provenance of the new subtree. The rest of the tree is left untouched. It returns the UID of the new
+// route.
+func (nps *NotificationPolicyService) AddRoute(ctx context.Context, orgID int64, parentRouteID string, route definitions.Route, p models.Provenance) (string, error) {
+	revision, err := getLastConfigurationWithLimits(ctx, orgID, nps.amStore, nps.configLimits())
 	if err != nil {
-		return fmt.Errorf("%w: %s", ErrValidation, err.Error())
+		return "", err
 	}
 
-	revision.cfg.AlertmanagerConfig.Config.Route = &tree
+	stored := revision.cfg.AlertmanagerConfig.Config.Route
+	if stored == nil {
+		return "", fmt.Errorf("no route present in current alertmanager config")
+	}
+	fillDerivedRouteUIDs(stored)
 
-	serialized, err := serializeAlertmanagerConfig(*revision.cfg)
+	tree, err := cloneRoute(stored)
 	if err != nil {
-		return err
+		return "", err
 	}
-	cmd := models.SaveAlertmanagerConfigurationCmd{
-		AlertmanagerConfiguration: string(serialized),
-		ConfigurationVersion:      revision.version,
-		FetchedConfigurationHash:  revision.concurrencyToken,
-		Default:                   false,
-		OrgID:                     orgID,
+	parent := findRoute(tree, parentRouteID)
+	if parent == nil {
+		return "", fmt.Errorf("%w: route with ID %q", ErrNotFound, parentRouteID)
 	}
-	err = nps.xact.InTransaction(ctx, func(ctx context.Context) error {
-		err = nps.amStore.UpdateAlertmanagerConfiguration(ctx, &cmd)
-		if err != nil {
+	inheritRouteUIDs(nil, &route)
+	parent.Routes = append(parent.Routes, &route)
+
+	err = nps.validatePolicyTree(revision, tree)
+	if err != nil {
+		return "", err
+	}
+
+	err = nps.checkSubtreeProvenance(ctx, orgID, stored, tree, p)
+	if err != nil {
+		return "", err
+	}
+
+	revision.cfg.AlertmanagerConfig.Config.Route = tree
+
+	err = nps.saveRevision(ctx, orgID, revision, func(ctx context.Context) error {
+		return nps.provenanceStore.SetProvenance(ctx, routeSubtree{id: route.UID}, orgID, p)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return route.UID, nil
+}
+
+// RerouteReceiver changes every route of the policy tree that uses the receiver from to use the receiver to instead,
+// e.g. to send notifications to a fallback while the integrations of a receiver are down. It returns the number of
+// routes changed. The changed routes are remembered, so that RestoreReceiverRouting can undo the reroute. A receiver
+// can only be rerouted again once it has been restored.
+func (nps *NotificationPolicyService) RerouteReceiver(ctx context.Context, orgID int64, from, to string, p models.Provenance) (int, error) {
+	if from == to {
+		return 0, fmt.Errorf("%w: receiver %q cannot be rerouted to itself", ErrValidation, from)
+	}
+
+	_, exists, err := nps.kvStore.Get(ctx, orgID, rerouteNamespace, from)
+	if err != nil {
+		return 0, err
+	}
+	if exists {
+		return 0, fmt.Errorf("%w: receiver %q is already rerouted", ErrValidation, from)
+	}
+
+	revision, err := getLastConfigurationWithLimits(ctx, orgID, nps.amStore, nps.configLimits())
+	if err != nil {
+		return 0, err
+	}
+
+	stored := revision.cfg.AlertmanagerConfig.Config.Route
+	if stored == nil {
+		return 0, fmt.Errorf("no route present in current alertmanager config")
+	}
+	fillDerivedRouteUIDs(stored)
+
+	tree, err := cloneRoute(stored)
+	if err != nil {
+		return 0, err
+	}
+	reroute := receiverReroute{To: to, Routes: []string{}}
+	walkRoutes(tree, "", func(route *definitions.Route, _ string) {
+		if route.Receiver == from {
+			route.Receiver = to
+			reroute.Routes = append(reroute.Routes, route.UID)
+		}
+	})
+	if len(reroute.Routes) == 0 {
+		return 0, nil
+	}
+
+	err = nps.validatePolicyTree(revision, tree)
+	if err != nil {
+		return 0, err
+	}
+
+	err = nps.checkSubtreeProvenance(ctx, orgID, stored, tree, p)
+	if err != nil {
+		return 0, err
+	}
+
+	snapshot, err := json.Marshal(reroute)
+	if err != nil {
+		return 0, err
+	}
+
+	revision.cfg.AlertmanagerConfig.Config.Route = tree
+
+	err = nps.saveRevision(ctx, orgID, revision, func(ctx context.Context) error {
+		if err := nps.provenanceStore.SetProvenance(ctx, tree, orgID, p); err != nil {
 			return err
 		}
-		err = nps.provenanceStore.SetProvenance(ctx, &tree, orgID, p)
-		if err != nil {
+		return nps.kvStore.Set(ctx, orgID, rerouteNamespace, from, string(snapshot))
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(reroute.Routes), nil
+}
+
+// RestoreReceiverRouting undoes the reroute of the receiver from made by RerouteReceiver. Only the routes that still
+// exist and still use the receiver they were rerouted to are changed back, so later edits of the tree are kept. It
+// returns the number of routes changed.
+func (nps *NotificationPolicyService) RestoreReceiverRouting(ctx context.Context, orgID int64, from string, p models.Provenance) (int, error) {
+	raw, exists, err := nps.kvStore.Get(ctx, orgID, rerouteNamespace, from)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, fmt.Errorf("%w: receiver %q is not rerouted", ErrNotFound, from)
+	}
+	var reroute receiverReroute
+	if err := json.Unmarshal([]byte(raw), &reroute); err != nil {
+		return 0, fmt.Errorf("failed to parse the reroute of receiver %q: %w", from, err)
+	}
+
+	revision, err := getLastConfigurationWithLimits(ctx, orgID, nps.amStore, nps.configLimits())
+	if err != nil {
+		return 0, err
+	}
+
+	stored := revision.cfg.AlertmanagerConfig.Config.Route
+	if stored == nil {
+		return 0, fmt.Errorf("no route present in current alertmanager config")
+	}
+	fillDerivedRouteUIDs(stored)
+
+	tree, err := cloneRoute(stored)
+	if err != nil {
+		return 0, err
+	}
+	restored := 0
+	for _, uid := range reroute.Routes {
+		route := findRoute(tree, uid)
+		if route != nil && route.Receiver == reroute.To {
+			route.Receiver = from
+			restored++
+		}
+	}
+	if restored == 0 {
+		return 0, nps.kvStore.Del(ctx, orgID, rerouteNamespace, from)
+	}
+
+	err = nps.validatePolicyTree(revision, tree)
+	if err != nil {
+		return 0, err
+	}
+
+	err = nps.checkSubtreeProvenance(ctx, orgID, stored, tree, p)
+	if err != nil {
+		return 0, err
+	}
+
+	revision.cfg.AlertmanagerConfig.Config.Route = tree
+
+	err = nps.saveRevision(ctx, orgID, revision, func(ctx context.Context) error {
+		if err := nps.provenanceStore.SetProvenance(ctx, tree, orgID, p); err != nil {
 			return err
 		}
-		return nil
+		return nps.kvStore.Del(ctx, orgID, rerouteNamespace, from)
 	})
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
+	return restored, nil
 }
 
 func (nps *NotificationPolicyService) ResetPolicyTree(ctx context.Context, orgID int64) (definitions.Route, error) {
@@ -127,33 +509,27 @@ func (nps *NotificationPolicyService) ResetPolicyTree(ctx context.Context, orgID
 	}
 	route := defaultCfg.AlertmanagerConfig.Route
 
-	revision, err := getLastConfiguration(ctx, orgID, nps.amStore)
+	revision, err := getLastConfigurationWithLimits(ctx, orgID, nps.amStore, nps.configLimits())
 	if err != nil {
 		return definitions.Route{}, err
 	}
+	previous := revision.cfg.AlertmanagerConfig.Config.Route
 	revision.cfg.AlertmanagerConfig.Config.Route = route
 
-	serialized, err := serializeAlertmanagerConfig(*revision.cfg)
-	if err != nil {
-		return definitions.Route{}, err
-	}
-	cmd := models.SaveAlertmanagerConfigurationCmd{
-		AlertmanagerConfiguration: string(serialized),
-		ConfigurationVersion:      revision.version,
-		FetchedConfigurationHash:  revision.concurrencyToken,
-		Default:                   false,
-		OrgID:                     orgID,
-	}
-	err = nps.xact.InTransaction(ctx, func(ctx context.Context) error {
-		err := nps.amStore.UpdateAlertmanagerConfiguration(ctx, &cmd)
-		if err != nil {
+	err = nps.saveRevision(ctx, orgID, revision, func(ctx context.Context) error {
+		if err := nps.provenanceStore.DeleteProvenance(ctx, route, orgID); err != nil {
 			return err
 		}
-		err = nps.provenanceStore.DeleteProvenance(ctx, route, orgID)
+		provenances, err := nps.provenanceStore.GetProvenances(ctx, orgID, route.ResourceType())
 		if err != nil {
 			return err
 		}
-		return nil
+		for id := range provenances {
+			if err := nps.provenanceStore.DeleteProvenance(ctx, routeSubtree{id: id}, orgID); err != nil {
+				return err
+			}
+		}
+		return nps.saveUndoSnapshot(ctx, orgID, previous, route)
 	})
 	if err != nil {
 		return definitions.Route{}, nil
@@ -162,6 +538,538 @@ func (nps *NotificationPolicyService) ResetPolicyTree(ctx context.Context, orgID
 	return *route, nil
 }
 
+// UndoLastPolicyChange reverts the last change of the policy tree of the org made by UpdatePolicyTree or
+// ResetPolicyTree, and returns the restored tree. Only the last change can be undone, and only once. It cannot be
+// undone if the tree was changed again since, or if the restored tree uses receivers or mute timings that no longer
+// exist.
+func (nps *NotificationPolicyService) UndoLastPolicyChange(ctx context.Context, orgID int64, p models.Provenance) (definitions.Route, error) {
+	raw, exists, err := nps.kvStore.Get(ctx, orgID, undoNamespace, undoKey)
+	if err != nil {
+		return definitions.Route{}, err
+	}
+	if !exists {
+		return definitions.Route{}, fmt.Errorf("%w: there is no policy tree change to undo", ErrNotFound)
+	}
+	var change policyTreeChange
+	if err := json.Unmarshal([]byte(raw), &change); err != nil {
+		return definitions.Route{}, fmt.Errorf("failed to parse the last policy tree change: %w", err)
+	}
+
+	revision, err := getLastConfigurationWithLimits(ctx, orgID, nps.amStore, nps.configLimits())
+	if err != nil {
+		return definitions.Route{}, err
+	}
+
+	stored := revision.cfg.AlertmanagerConfig.Config.Route
+	if stored == nil {
+		return definitions.Route{}, fmt.Errorf("no route present in current alertmanager config")
+	}
+	fillDerivedRouteUIDs(stored)
+
+	unchanged, err := isEquivalentRoute(stored, change.Applied)
+	if err != nil {
+		return definitions.Route{}, err
+	}
+	if !unchanged {
+		return definitions.Route{}, fmt.Errorf("%w: the policy tree was changed again since the last change, which cannot be undone anymore", ErrValidation)
+	}
+
+	tree := change.Previous
+	err = nps.validatePolicyTree(revision, tree)
+	if err != nil {
+		return definitions.Route{}, err
+	}
+
+	err = nps.checkSubtreeProvenance(ctx, orgID, stored, tree, p)
+	if err != nil {
+		return definitions.Route{}, err
+	}
+
+	revision.cfg.AlertmanagerConfig.Config.Route = tree
+
+	err = nps.saveRevision(ctx, orgID, revision, func(ctx context.Context) error {
+		if err := nps.provenanceStore.SetProvenance(ctx, tree, orgID, p); err != nil {
+			return err
+		}
+		return nps.kvStore.Del(ctx, orgID, undoNamespace, undoKey)
+	})
+	if err != nil {
+		return definitions.Route{}, err
+	}
+
+	return *tree, nil
+}
+
+// ResolveReceiversForLabels simulates how an alert with the given labels is routed through the org's policy tree,
+// and returns the receivers it would reach, in routing order. Routes that are currently muted by one of their
+// mute timings do not contribute a receiver.
+func (nps *NotificationPolicyService) ResolveReceiversForLabels(ctx context.Context, orgID int64, labels model.LabelSet) ([]string, error) {
+	revision, err := getLastConfigurationWithLimits(ctx, orgID, nps.amStore, nps.configLimits())
+	if err != nil {
+		return nil, err
+	}
+
+	if revision.cfg.AlertmanagerConfig.Route == nil {
+		return nil, fmt.Errorf("no route present in current alertmanager config")
+	}
+
+	muteTimes := buildMuteTimesMap(revision.cfg.AlertmanagerConfig.MuteTimeIntervals)
+	return resolveReceivers(revision.cfg.AlertmanagerConfig.Route, muteTimes, labels, timeNow()), nil
+}
+
+// SimulateRouting resolves the receivers each of the given sample alerts would reach under the org's policy tree,
+// in the same way as ResolveReceiversForLabels. The result is aligned with the samples.
+func (nps *NotificationPolicyService) SimulateRouting(ctx context.Context, orgID int64, sampleAlerts []model.LabelSet) ([][]string, error) {
+	revision, err := getLastConfigurationWithLimits(ctx, orgID, nps.amStore, nps.configLimits())
+	if err != nil {
+		return nil, err
+	}
+
+	if revision.cfg.AlertmanagerConfig.Route == nil {
+		return nil, fmt.Errorf("no route present in current alertmanager config")
+	}
+
+	muteTimes := buildMuteTimesMap(revision.cfg.AlertmanagerConfig.MuteTimeIntervals)
+	now := timeNow()
+	results := make([][]string, 0, len(sampleAlerts))
+	for _, labels := range sampleAlerts {
+		results = append(results, resolveReceivers(revision.cfg.AlertmanagerConfig.Route, muteTimes, labels, now))
+	}
+	return results, nil
+}
+
+// RoutingSimulation is the routing of an alert among concurrently firing alerts. InhibitedBy lists the indexes of
+// the other alerts that inhibit it, in which case the receivers it is routed to are not notified of it.
+type RoutingSimulation struct {
+	Receivers   []string
+	InhibitedBy []int
+}
+
+// Inhibited returns true if another of the firing alerts inhibits the alert.
+func (s RoutingSimulation) Inhibited() bool {
+	return len(s.InhibitedBy) > 0
+}
+
+// SimulateRoutingWithInhibitions resolves the receivers of the given alerts like SimulateRouting, considering them
+// firing at the same time, and applies the inhibition rules of the org to find which of them the others inhibit.
+// Like in the Alertmanager, an alert that matches both the source and the target matchers of a rule is not inhibited
+// by alerts that also match both. The result is aligned with the alerts.
+func (nps *NotificationPolicyService) SimulateRoutingWithInhibitions(ctx context.Context, orgID int64, firingAlerts []model.LabelSet) ([]RoutingSimulation, error) {
+	revision, err := getLastConfigurationWithLimits(ctx, orgID, nps.amStore, nps.configLimits())
+	if err != nil {
+		return nil, err
+	}
+
+	if revision.cfg.AlertmanagerConfig.Route == nil {
+		return nil, fmt.Errorf("no route present in current alertmanager config")
+	}
+
+	rules := make([]*inhibit.InhibitRule, 0, len(revision.cfg.AlertmanagerConfig.InhibitRules))
+	for _, rule := range revision.cfg.AlertmanagerConfig.InhibitRules {
+		rules = append(rules, inhibit.NewInhibitRule(rule))
+	}
+
+	muteTimes := buildMuteTimesMap(revision.cfg.AlertmanagerConfig.MuteTimeIntervals)
+	now := timeNow()
+	results := make([]RoutingSimulation, 0, len(firingAlerts))
+	for _, labels := range firingAlerts {
+		results = append(results, RoutingSimulation{
+			Receivers:   resolveReceivers(revision.cfg.AlertmanagerConfig.Route, muteTimes, labels, now),
+			InhibitedBy: inhibitingAlerts(rules, firingAlerts, labels),
+		})
+	}
+	return results, nil
+}
+
+// inhibitingAlerts returns the indexes of the firing alerts that inhibit the alert with the given labels through
+// one of the rules, in the same way as the inhibitor of the Alertmanager.
+func inhibitingAlerts(rules []*inhibit.InhibitRule, firingAlerts []model.LabelSet, target model.LabelSet) []int {
+	var sources []int
+	for i, source := range firingAlerts {
+		for _, rule := range rules {
+			if !rule.TargetMatchers.Matches(target) || !rule.SourceMatchers.Matches(source) {
+				continue
+			}
+			if rule.SourceMatchers.Matches(target) && rule.TargetMatchers.Matches(source) {
+				continue
+			}
+			if hasEqualLabels(rule.Equal, source, target) {
+				sources = append(sources, i)
+				break
+			}
+		}
+	}
+	return sources
+}
+
+// hasEqualLabels returns true if both label sets have the same value for each of the given labels.
+func hasEqualLabels(names map[model.LabelName]struct{}, a, b model.LabelSet) bool {
+	for name := range names {
+		if a[name] != b[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// PolicyChangeImpact is a firing alert that a proposed policy tree delivers to other receivers than the current one.
+type PolicyChangeImpact struct {
+	RuleUID      string
+	Labels       model.LabelSet
+	OldReceivers []string
+	NewReceivers []string
+}
+
+// PreviewPolicyTreeImpact resolves the receivers of the currently firing alerts of the org under both the stored
+// policy tree and the given one, without saving anything, and returns the alerts whose receivers would change. The
+// proposed tree is validated like in UpdatePolicyTree. The receivers are compared as sets and returned sorted.
+func (nps *NotificationPolicyService) PreviewPolicyTreeImpact(ctx context.Context, orgID int64, tree definitions.Route) ([]PolicyChangeImpact, error) {
+	revision, err := getLastConfigurationWithLimits(ctx, orgID, nps.amStore, nps.configLimits())
+	if err != nil {
+		return nil, err
+	}
+
+	current := revision.cfg.AlertmanagerConfig.Config.Route
+	if current == nil {
+		return nil, fmt.Errorf("no route present in current alertmanager config")
+	}
+	fillDerivedRouteUIDs(current)
+	inheritRouteUIDs(current, &tree)
+	if err := nps.validatePolicyTree(revision, &tree); err != nil {
+		return nil, err
+	}
+
+	muteTimes := buildMuteTimesMap(revision.cfg.AlertmanagerConfig.MuteTimeIntervals)
+	now := timeNow()
+	impacts := []PolicyChangeImpact{}
+	for _, s := range nps.alertStates.GetAll(orgID) {
+		if s.State != eval.Alerting {
+			continue
+		}
+		labels := alertLabelSet(s)
+
+		oldReceivers := resolveReceivers(current, muteTimes, labels, now)
+		newReceivers := resolveReceivers(&tree, muteTimes, labels, now)
+		sort.Strings(oldReceivers)
+		sort.Strings(newReceivers)
+		if stringSlicesEqual(oldReceivers, newReceivers) {
+			continue
+		}
+		impacts = append(impacts, PolicyChangeImpact{
+			RuleUID:      s.AlertRuleUID,
+			Labels:       labels,
+			OldReceivers: oldReceivers,
+			NewReceivers: newReceivers,
+		})
+	}
+	sort.Slice(impacts, func(i, j int) bool {
+		if impacts[i].RuleUID != impacts[j].RuleUID {
+			return impacts[i].RuleUID < impacts[j].RuleUID
+		}
+		return impacts[i].Labels.String() < impacts[j].Labels.String()
+	})
+	return impacts, nil
+}
+
+// ReceiverLoad counts the currently firing alerts of the org that the stored policy tree routes to each receiver,
+// to show where the notifications are concentrated. Every receiver the tree references is counted, with zero if no
+// firing alert is routed to it. An alert routed to a receiver by several routes is counted once for it, and routes
+// that are currently muted are left out, as in ResolveReceiversForLabels.
+func (nps *NotificationPolicyService) ReceiverLoad(ctx context.Context, orgID int64) (map[string]int, error) {
+	revision, err := getLastConfigurationWithLimits(ctx, orgID, nps.amStore, nps.configLimits())
+	if err != nil {
+		return nil, err
+	}
+
+	tree := revision.cfg.AlertmanagerConfig.Route
+	if tree == nil {
+		return nil, fmt.Errorf("no route present in current alertmanager config")
+	}
+
+	load := map[string]int{}
+	for receiver := range referencedReceivers(tree) {
+		load[receiver] = 0
+	}
+	muteTimes := buildMuteTimesMap(revision.cfg.AlertmanagerConfig.MuteTimeIntervals)
+	now := timeNow()
+	for _, s := range nps.alertStates.GetAll(orgID) {
+		if s.State != eval.Alerting {
+			continue
+		}
+		for _, receiver := range resolveReceivers(tree, muteTimes, alertLabelSet(s), now) {
+			load[receiver]++
+		}
+	}
+	return load, nil
+}
+
+// ReceiverIntegrationTypes returns, for every receiver the stored policy tree references, the sorted integration
+// types configured on it, such as email, slack or webhook. A type configured several times on a receiver is listed
+// once.
+func (nps *NotificationPolicyService) ReceiverIntegrationTypes(ctx context.Context, orgID int64) (map[string][]string, error) {
+	revision, err := getLastConfigurationWithLimits(ctx, orgID, nps.amStore, nps.configLimits())
+	if err != nil {
+		return nil, err
+	}
+
+	tree := revision.cfg.AlertmanagerConfig.Route
+	if tree == nil {
+		return nil, fmt.Errorf("no route present in current alertmanager config")
+	}
+
+	byName := make(map[string]*definitions.PostableApiReceiver, len(revision.cfg.AlertmanagerConfig.Receivers))
+	for _, receiver := range revision.cfg.AlertmanagerConfig.Receivers {
+		byName[receiver.Name] = receiver
+	}
+
+	types := map[string][]string{}
+	for name := range referencedReceivers(tree) {
+		seen := map[string]struct{}{}
+		integrations := []string{}
+		if receiver, ok := byName[name]; ok {
+			for _, integration := range receiver.GrafanaManagedReceivers {
+				if _, ok := seen[integration.Type]; ok {
+					continue
+				}
+				seen[integration.Type] = struct{}{}
+				integrations = append(integrations, integration.Type)
+			}
+		}
+		sort.Strings(integrations)
+		types[name] = integrations
+	}
+	return types, nil
+}
+
+// alertLabelSet returns the labels of the alert instance as a label set the policy tree can route.
+func alertLabelSet(s *state.State) model.LabelSet {
+	labels := make(model.LabelSet, len(s.Labels))
+	for name, value := range s.Labels {
+		labels[model.LabelName(name)] = model.LabelValue(value)
+	}
+	return labels
+}
+
+func stringSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// PolicyTreeWarning is a likely mistake in a policy tree, which does not make the tree invalid. The route is
+// identified by its UID, and by its path of child indexes from the root in case it has no UID yet.
+type PolicyTreeWarning struct {
+	RouteUID  string
+	RoutePath string
+	// Receiver is set instead of RouteUID and RoutePath for warnings about a receiver.
+	Receiver string
+	Message  string
+}
+
+// AnalyzePolicyTree looks for likely mistakes that validation accepts in the given policy tree, using the mute
+// timings of the org, so that they can be reported before or after saving the tree. It warns about routes whose
+// mute timings together cover the whole week, as such routes never send notifications, and about receivers that
+// only such routes deliver to, as they never receive notifications.
+func (nps *NotificationPolicyService) AnalyzePolicyTree(ctx context.Context, orgID int64, tree definitions.Route) ([]PolicyTreeWarning, error) {
+	revision, err := getLastConfigurationWithLimits(ctx, orgID, nps.amStore, nps.configLimits())
+	if err != nil {
+		return nil, err
+	}
+
+	return analyzePolicyTree(&tree, buildMuteTimesMap(revision.cfg.AlertmanagerConfig.MuteTimeIntervals)), nil
+}
+
+// analyzePolicyTree returns the warnings of AnalyzePolicyTree for the given tree and mute timings.
+func analyzePolicyTree(tree *definitions.Route, muteTimes map[string][]timeinterval.TimeInterval) []PolicyTreeWarning {
+	warnings := []PolicyTreeWarning{}
+	mutedRoutes := map[*definitions.Route]bool{}
+	walkRoutes(tree, "", func(route *definitions.Route, path string) {
+		if len(route.MuteTimeIntervals) == 0 {
+			return
+		}
+		var intervals []timeinterval.TimeInterval
+		for _, name := range route.MuteTimeIntervals {
+			intervals = append(intervals, muteTimes[name]...)
+		}
+		if coversWholeWeek(intervals) {
+			mutedRoutes[route] = true
+			warnings = append(warnings, PolicyTreeWarning{
+				RouteUID:  route.UID,
+				RoutePath: path,
+				Message: fmt.Sprintf("the mute timings %s of the route cover the whole week, so it never sends notifications",
+					strings.Join(route.MuteTimeIntervals, ", ")),
+			})
+		}
+	})
+
+	// A receiver is notified if at least one of the routes delivering to it is not muted the whole week.
+	notified := map[string]bool{}
+	walkRouteReceivers(tree, "", func(route *definitions.Route, receiver string) {
+		notified[receiver] = notified[receiver] || !mutedRoutes[route]
+	})
+	var unnotified []string
+	for receiver, ok := range notified {
+		if !ok {
+			unnotified = append(unnotified, receiver)
+		}
+	}
+	sort.Strings(unnotified)
+	for _, receiver := range unnotified {
+		warnings = append(warnings, PolicyTreeWarning{
+			Receiver: receiver,
+			Message:  fmt.Sprintf("the receiver %s is only used by routes muted the whole week, so it never receives notifications", receiver),
+		})
+	}
+	return warnings
+}
+
+// readConfig fetches and deserializes the latest configuration of the org.
+func (nps *NotificationPolicyService) readConfig(ctx context.Context, orgID int64) (*definitions.PostableUserConfig, error) {
+	q := models.GetLatestAlertmanagerConfigurationQuery{
+		OrgID: orgID,
+	}
+	err := nps.amStore.GetLatestAlertmanagerConfiguration(ctx, &q)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := []byte(q.Result.AlertmanagerConfiguration)
+	if err := nps.configLimits().check(raw); err != nil {
+		return nil, err
+	}
+	return deserializeAlertmanagerConfig(raw)
+}
+
+// startSpan starts a span of the policy service with the org as attribute.
+func (nps *NotificationPolicyService) startSpan(ctx context.Context, name string, orgID int64) (context.Context, tracing.Span) {
+	ctx, span := nps.tracer.Start(ctx, name)
+	span.SetAttributes("org_id", orgID, attribute.Int64("org_id", orgID))
+	return ctx, span
+}
+
+// endSpan ends a span, marking it as failed if err is not nil.
+func endSpan(span tracing.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// configLimits returns the limits a stored configuration must stay within to be deserialized.
+func (nps *NotificationPolicyService) configLimits() configLimits {
+	return configLimits{
+		maxSize:  nps.settings.MaxConfigSize,
+		maxDepth: nps.settings.MaxConfigDepth,
+	}
+}
+
+// validatePolicyTree expands the matcher macros of the tree, and validates the result against the receivers and mute
+// timings of the configuration. The Editable flags GetPolicyTree sets are cleared, so that they are not saved.
+func (nps *NotificationPolicyService) validatePolicyTree(revision *cfgRevision, tree *definitions.Route) error {
+	walkRoutes(tree, "", func(route *definitions.Route, _ string) {
+		route.Editable = nil
+	})
+
+	if err := expandMatcherMacros(tree); err != nil {
+		return fmt.Errorf("%w: %s", ErrValidation, err.Error())
+	}
+
+	err := tree.Validate()
+	if err != nil {
+		return newRouteValidationError(err)
+	}
+
+	if uid := duplicateRouteUID(tree); uid != "" {
+		return fmt.Errorf("%w: route UID %q is used more than once", ErrValidation, uid)
+	}
+
+	receivers, err := nps.receiversToMap(revision.cfg.AlertmanagerConfig.Receivers)
+	err = tree.ValidateReceivers(receivers)
+	if err != nil {
+		return newRouteValidationError(err)
+	}
+
+	muteTimes := map[string]struct{}{}
+	for _, mt := range revision.cfg.AlertmanagerConfig.MuteTimeIntervals {
+		muteTimes[mt.Name] = struct{}{}
+	}
+	err = tree.ValidateMuteTimes(muteTimes)
+	if err != nil {
+		return newRouteValidationError(err)
+	}
+
+	if name := nps.nonConformingMuteTiming(tree); name != "" {
+		return fmt.Errorf("%w: mute timing %q does not match the naming pattern %q", ErrValidation, name,
+			nps.settings.MuteTimingNamePattern.String())
+	}
+	return nil
+}
+
+// nonConformingMuteTiming returns the first mute timing referenced by the tree whose name does not match the naming
+// pattern configured for mute timings, or an empty string if there is none or no pattern is configured.
+func (nps *NotificationPolicyService) nonConformingMuteTiming(tree *definitions.Route) string {
+	pattern := nps.settings.MuteTimingNamePattern
+	if pattern == nil {
+		return ""
+	}
+	var offending string
+	walkRoutes(tree, "", func(route *definitions.Route, _ string) {
+		for _, name := range route.MuteTimeIntervals {
+			if offending == "" && !pattern.MatchString(name) {
+				offending = name
+			}
+		}
+	})
+	return offending
+}
+
+// checkSubtreeProvenance returns an error if updating the stored policy tree to the given one changes a subtree that
+// is provisioned from file, unless the update itself comes from file provisioning.
+func (nps *NotificationPolicyService) checkSubtreeProvenance(ctx context.Context, orgID int64, stored, updated *definitions.Route, p models.Provenance) error {
+	if p == models.ProvenanceFile {
+		return nil
+	}
+
+	provenances, err := nps.provenanceStore.GetProvenances(ctx, orgID, stored.ResourceType())
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(provenances))
+	for id, provenance := range provenances {
+		// the provenance of the whole tree is stored under the empty ID
+		if id != "" && provenance == models.ProvenanceFile {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		current := findRoute(stored, id)
+		if current == nil {
+			continue
+		}
+		unchanged := false
+		if next := findRoute(updated, id); next != nil {
+			unchanged, err = isEquivalentRoute(current, next)
+			if err != nil {
+				return err
+			}
+		}
+		if !unchanged {
+			return fmt.Errorf("%w: route %q is provisioned from file and cannot be changed", ErrValidation, id)
+		}
+	}
+	return nil
+}
+
 func (nps *NotificationPolicyService) receiversToMap(records []*definitions.PostableApiReceiver) (map[string]struct{}, error) {
 	receivers := map[string]struct{}{}
 	for _, receiver := range records {
@@ -169,3 +1077,68 @@ func (nps *NotificationPolicyService) receiversToMap(records []*definitions.Post
 	}
 	return receivers, nil
 }
+
+// isEquivalentRoute returns true if both policy trees route alerts in the same way. The order of matchers and group_by
+// labels within a route is not significant, and neither are the provenance and the UID.
+func isEquivalentRoute(a, b *definitions.Route) (bool, error) {
+	left, err := normalizedRoute(a)
+	if err != nil {
+		return false, err
+	}
+	right, err := normalizedRoute(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(left, right), nil
+}
+
+// routeSubtree identifies a subtree of the policy tree by the UID of its root route, so that it can have a
+// provenance of its own. The whole tree is identified by the empty ID, same as the definitions.Route itself.
+type routeSubtree struct {
+	id string
+}
+
+func (s routeSubtree) ResourceType() string {
+	return (&definitions.Route{}).ResourceType()
+}
+
+func (s routeSubtree) ResourceID() string {
+	return s.id
+}
+
+func cloneRoute(r *definitions.Route) (*definitions.Route, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	clone := definitions.Route{}
+	if err := json.Unmarshal(data, &clone); err != nil {
+		return nil, err
+	}
+	return &clone, nil
+}
+
+func normalizedRoute(r *definitions.Route) ([]byte, error) {
+	normalized, err := cloneRoute(r)
+	if err != nil {
+		return nil, err
+	}
+	normalizeRoute(normalized)
+	return json.Marshal(normalized)
+}
+
+func normalizeRoute(r *definitions.Route) {
+	r.Provenance = models.ProvenanceNone
+	r.Editable = nil
+	r.UID = ""
+	sort.Strings(r.GroupByStr)
+	sort.Slice(r.Matchers, func(i, j int) bool {
+		return r.Matchers[i].String() < r.Matchers[j].String()
+	})
+	sort.Slice(r.ObjectMatchers, func(i, j int) bool {
+		return r.ObjectMatchers[i].String() < r.ObjectMatchers[j].String()
+	})
+	for _, child := range r.Routes {
+		normalizeRoute(child)
+	}
+}
@@ -2,13 +2,22 @@ package provisioning
 
 import (
 	"context"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/infra/tracing"
 	"github.com/grafana/grafana/pkg/services/ngalert/api/tooling/definitions"
+	"github.com/grafana/grafana/pkg/services/ngalert/eval"
 	"github.com/grafana/grafana/pkg/services/ngalert/models"
+	"github.com/grafana/grafana/pkg/services/ngalert/state"
 	"github.com/grafana/grafana/pkg/setting"
 	"github.com/prometheus/alertmanager/config"
+	"github.com/prometheus/alertmanager/dispatch"
+	"github.com/prometheus/alertmanager/pkg/labels"
 	"github.com/prometheus/alertmanager/timeinterval"
 	"github.com/prometheus/common/model"
 	"github.com/stretchr/testify/mock"
@@ -59,7 +68,7 @@ func TestNotificationPolicyService(t *testing.T) {
 			MuteTimeIntervals: []string{"not-existing"},
 		})
 
-		err := sut.UpdatePolicyTree(context.Background(), 1, newRoute, models.ProvenanceNone)
+		_, err := sut.UpdatePolicyTree(context.Background(), 1, newRoute, models.ProvenanceNone)
 		require.Error(t, err)
 	})
 
@@ -97,7 +106,7 @@ func TestNotificationPolicyService(t *testing.T) {
 			MuteTimeIntervals: []string{"existing"},
 		})
 
-		err := sut.UpdatePolicyTree(context.Background(), 1, newRoute, models.ProvenanceNone)
+		_, err := sut.UpdatePolicyTree(context.Background(), 1, newRoute, models.ProvenanceNone)
 		require.NoError(t, err)
 	})
 
@@ -106,7 +115,7 @@ func TestNotificationPolicyService(t *testing.T) {
 
 		newRoute := createTestRoutingTree()
 
-		err := sut.UpdatePolicyTree(context.Background(), 1, newRoute, models.ProvenanceNone)
+		_, err := sut.UpdatePolicyTree(context.Background(), 1, newRoute, models.ProvenanceNone)
 		require.NoError(t, err)
 
 		updated, err := sut.GetPolicyTree(context.Background(), 1)
@@ -122,10 +131,201 @@ func TestNotificationPolicyService(t *testing.T) {
 			Receiver: "not-existing",
 		})
 
-		err := sut.UpdatePolicyTree(context.Background(), 1, newRoute, models.ProvenanceNone)
+		_, err := sut.UpdatePolicyTree(context.Background(), 1, newRoute, models.ProvenanceNone)
 		require.Error(t, err)
 	})
 
+	t.Run("validation errors point at the offending route", func(t *testing.T) {
+		updateNestedRoutes := func(t *testing.T, change func(tree *definitions.Route)) *RouteValidationError {
+			t.Helper()
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			change(&tree)
+
+			_, err = sut.UpdatePolicyTree(context.Background(), 1, tree, models.ProvenanceAPI)
+
+			require.ErrorIs(t, err, ErrValidation)
+			var routeErr *RouteValidationError
+			require.ErrorAs(t, err, &routeErr)
+			require.Nil(t, sut.amStore.(*fakeAMConfigStore).lastSaveCommand)
+			return routeErr
+		}
+
+		t.Run("for a receiver that does not exist", func(t *testing.T) {
+			routeErr := updateNestedRoutes(t, func(tree *definitions.Route) {
+				tree.Routes[1].Routes[0].Receiver = "not-existing"
+			})
+
+			require.Equal(t, "/routes/1/routes/0", routeErr.Path)
+			require.Equal(t, "invalid object specification: route /routes/1/routes/0: receiver 'not-existing' does not exist", routeErr.Error())
+		})
+
+		t.Run("for a mute timing that does not exist", func(t *testing.T) {
+			routeErr := updateNestedRoutes(t, func(tree *definitions.Route) {
+				tree.Routes[3].MuteTimeIntervals = []string{"not-existing"}
+			})
+
+			require.Equal(t, "/routes/3", routeErr.Path)
+		})
+
+		t.Run("for an invalid interval", func(t *testing.T) {
+			zero := model.Duration(0)
+			routeErr := updateNestedRoutes(t, func(tree *definitions.Route) {
+				tree.Routes[1].Routes[0].GroupInterval = &zero
+			})
+
+			require.Equal(t, "/routes/1/routes/0", routeErr.Path)
+		})
+
+		t.Run("for the root route", func(t *testing.T) {
+			routeErr := updateNestedRoutes(t, func(tree *definitions.Route) {
+				tree.Receiver = "not-existing"
+			})
+
+			require.Empty(t, routeErr.Path)
+		})
+
+		t.Run("for a child inheriting a receiver that does not exist", func(t *testing.T) {
+			routeErr := updateNestedRoutes(t, func(tree *definitions.Route) {
+				tree.Routes[1].Receiver = "not-existing"
+				tree.Routes[1].Routes[0].Receiver = ""
+			})
+
+			require.Equal(t, "/routes/1", routeErr.Path)
+		})
+	})
+
+	t.Run("mute timing names are checked against the configured naming pattern", func(t *testing.T) {
+		referencing := func(muteTiming string) definitions.Route {
+			return definitions.Route{
+				Receiver: "grafana-default-email",
+				Routes: []*definitions.Route{{
+					Receiver:          "team-a",
+					ObjectMatchers:    definitions.ObjectMatchers{{Type: labels.MatchEqual, Name: "team", Value: "a"}},
+					MuteTimeIntervals: []string{muteTiming},
+				}},
+			}
+		}
+		newSut := func() *NotificationPolicyService {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithTeamMuteTimings
+			sut.settings.MuteTimingNamePattern = regexp.MustCompile("^(?:team-.+)$")
+			return sut
+		}
+
+		t.Run("accepts a compliant name", func(t *testing.T) {
+			sut := newSut()
+
+			_, err := sut.UpdatePolicyTree(context.Background(), 1, referencing("team-a-nights"), models.ProvenanceNone)
+
+			require.NoError(t, err)
+		})
+
+		t.Run("rejects a non-compliant name", func(t *testing.T) {
+			sut := newSut()
+
+			_, err := sut.UpdatePolicyTree(context.Background(), 1, referencing("weekends"), models.ProvenanceNone)
+
+			require.ErrorIs(t, err, ErrValidation)
+			require.Contains(t, err.Error(), `"weekends"`)
+			require.Nil(t, sut.amStore.(*fakeAMConfigStore).lastSaveCommand)
+		})
+	})
+
+	t.Run("a child without a receiver inherits the receiver of its parent", func(t *testing.T) {
+		sut := createNotificationPolicyServiceSut()
+		sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+		tree, err := sut.GetPolicyTree(context.Background(), 1)
+		require.NoError(t, err)
+		tree.Routes[1].Routes[0].Receiver = ""
+
+		_, err = sut.UpdatePolicyTree(context.Background(), 1, tree, models.ProvenanceAPI)
+
+		require.NoError(t, err)
+		saved, err := deserializeAlertmanagerConfig([]byte(sut.amStore.(*fakeAMConfigStore).lastSaveCommand.AlertmanagerConfiguration))
+		require.NoError(t, err)
+		require.Empty(t, saved.AlertmanagerConfig.Route.Routes[1].Routes[0].Receiver)
+	})
+
+	t.Run("matcher macros", func(t *testing.T) {
+		teamMatchers := func(team string) definitions.ObjectMatchers {
+			matcher, err := labels.NewMatcher(labels.MatchEqual, "team", team)
+			require.NoError(t, err)
+			return definitions.ObjectMatchers{matcher}
+		}
+
+		t.Run("are expanded into the routes that use them before saving", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			tree.MatcherMacros = map[string]definitions.ObjectMatchers{"team-d": teamMatchers("d")}
+			tree.Routes[3].ObjectMatchers = nil
+			tree.Routes[3].UseMatcherMacros = []string{"team-d"}
+
+			_, err = sut.UpdatePolicyTree(context.Background(), 1, tree, models.ProvenanceAPI)
+			require.NoError(t, err)
+
+			saved := sut.amStore.(*fakeAMConfigStore).lastSaveCommand
+			require.NotNil(t, saved)
+			require.NotContains(t, saved.AlertmanagerConfiguration, "matcher_macros")
+			require.NotContains(t, saved.AlertmanagerConfiguration, "use_matcher_macros")
+			updated, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			require.Equal(t, teamMatchers("d"), updated.Routes[3].ObjectMatchers)
+			require.Empty(t, updated.MatcherMacros)
+			require.Empty(t, updated.Routes[3].UseMatcherMacros)
+		})
+
+		t.Run("are added to the matchers of the route", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			severity, err := labels.NewMatcher(labels.MatchEqual, "severity", "critical")
+			require.NoError(t, err)
+			tree.MatcherMacros = map[string]definitions.ObjectMatchers{"critical": {severity}}
+			tree.Routes[1].Routes[0].ObjectMatchers = teamMatchers("b")
+			tree.Routes[1].Routes[0].UseMatcherMacros = []string{"critical"}
+
+			_, err = sut.UpdatePolicyTree(context.Background(), 1, tree, models.ProvenanceAPI)
+			require.NoError(t, err)
+
+			updated, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			require.Equal(t, append(teamMatchers("b"), severity), updated.Routes[1].Routes[0].ObjectMatchers)
+		})
+
+		t.Run("must be defined on the root route", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			tree.Routes[1].MatcherMacros = map[string]definitions.ObjectMatchers{"team-c": teamMatchers("c")}
+			tree.Routes[3].UseMatcherMacros = []string{"team-c"}
+
+			_, err = sut.UpdatePolicyTree(context.Background(), 1, tree, models.ProvenanceAPI)
+
+			require.ErrorIs(t, err, ErrValidation)
+			require.Nil(t, sut.amStore.(*fakeAMConfigStore).lastSaveCommand)
+		})
+
+		t.Run("that are not defined are rejected", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			tree.Routes[3].UseMatcherMacros = []string{"team-c"}
+
+			_, err = sut.UpdatePolicyTree(context.Background(), 1, tree, models.ProvenanceAPI)
+
+			require.ErrorIs(t, err, ErrValidation)
+			require.Nil(t, sut.amStore.(*fakeAMConfigStore).lastSaveCommand)
+		})
+	})
+
 	t.Run("existing receiver reference will pass", func(t *testing.T) {
 		sut := createNotificationPolicyServiceSut()
 		sut.amStore = &MockAMConfigStore{}
@@ -160,7 +360,7 @@ func TestNotificationPolicyService(t *testing.T) {
 			Receiver: "existing",
 		})
 
-		err := sut.UpdatePolicyTree(context.Background(), 1, newRoute, models.ProvenanceNone)
+		_, err := sut.UpdatePolicyTree(context.Background(), 1, newRoute, models.ProvenanceNone)
 		require.NoError(t, err)
 	})
 
@@ -177,7 +377,7 @@ func TestNotificationPolicyService(t *testing.T) {
 		sut := createNotificationPolicyServiceSut()
 		newRoute := createTestRoutingTree()
 
-		err := sut.UpdatePolicyTree(context.Background(), 1, newRoute, models.ProvenanceAPI)
+		_, err := sut.UpdatePolicyTree(context.Background(), 1, newRoute, models.ProvenanceAPI)
 		require.NoError(t, err)
 
 		updated, err := sut.GetPolicyTree(context.Background(), 1)
@@ -195,7 +395,7 @@ func TestNotificationPolicyService(t *testing.T) {
 		require.NoError(t, err)
 		expectedConcurrencyToken := q.Result.ConfigurationHash
 
-		err = sut.UpdatePolicyTree(context.Background(), 1, newRoute, models.ProvenanceAPI)
+		_, err = sut.UpdatePolicyTree(context.Background(), 1, newRoute, models.ProvenanceAPI)
 		require.NoError(t, err)
 
 		fake := sut.GetAMConfigStore().(*fakeAMConfigStore)
@@ -203,18 +403,954 @@ func TestNotificationPolicyService(t *testing.T) {
 		require.Equal(t, expectedConcurrencyToken, intercepted.FetchedConfigurationHash)
 	})
 
+	t.Run("service skips saving a tree identical to the stored one", func(t *testing.T) {
+		sut := createNotificationPolicyServiceSut()
+		newRoute := createTestRoutingTree()
+
+		changed, err := sut.UpdatePolicyTree(context.Background(), 1, newRoute, models.ProvenanceNone)
+		require.NoError(t, err)
+		require.True(t, changed)
+
+		fake := sut.GetAMConfigStore().(*fakeAMConfigStore)
+		require.NotNil(t, fake.lastSaveCommand)
+		fake.lastSaveCommand = nil
+
+		changed, err = sut.UpdatePolicyTree(context.Background(), 1, newRoute, models.ProvenanceNone)
+		require.NoError(t, err)
+		require.False(t, changed)
+		require.Nil(t, fake.lastSaveCommand)
+	})
+
+	t.Run("service upgrades provenance of an unchanged tree", func(t *testing.T) {
+		sut := createNotificationPolicyServiceSut()
+		newRoute := createTestRoutingTree()
+
+		_, err := sut.UpdatePolicyTree(context.Background(), 1, newRoute, models.ProvenanceNone)
+		require.NoError(t, err)
+		changed, err := sut.UpdatePolicyTree(context.Background(), 1, newRoute, models.ProvenanceAPI)
+		require.NoError(t, err)
+		require.False(t, changed)
+
+		updated, err := sut.GetPolicyTree(context.Background(), 1)
+		require.NoError(t, err)
+		require.Equal(t, models.ProvenanceAPI, updated.Provenance)
+	})
+
 	t.Run("updating invalid route returns ValidationError", func(t *testing.T) {
 		sut := createNotificationPolicyServiceSut()
 		invalid := createTestRoutingTree()
 		repeat := model.Duration(0)
 		invalid.RepeatInterval = &repeat
 
-		err := sut.UpdatePolicyTree(context.Background(), 1, invalid, models.ProvenanceNone)
+		_, err := sut.UpdatePolicyTree(context.Background(), 1, invalid, models.ProvenanceNone)
 
 		require.Error(t, err)
 		require.ErrorIs(t, err, ErrValidation)
 	})
 
+	t.Run("service rejects a stored config larger than the size limit", func(t *testing.T) {
+		sut := createNotificationPolicyServiceSut()
+		sut.settings.MaxConfigSize = 1024
+		sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = defaultAlertmanagerConfigJSON + strings.Repeat(" ", 2048)
+
+		_, err := sut.GetPolicyTree(context.Background(), 1)
+		require.ErrorContains(t, err, "exceeds the limit of 1024 bytes")
+
+		_, err = sut.UpdatePolicyTree(context.Background(), 1, createTestRoutingTree(), models.ProvenanceNone)
+		require.ErrorContains(t, err, "exceeds the limit of 1024 bytes")
+	})
+
+	t.Run("service rejects a stored config nested deeper than the depth limit", func(t *testing.T) {
+		sut := createNotificationPolicyServiceSut()
+		sut.settings.MaxConfigDepth = 10
+		sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = strings.Repeat("[", 20) + strings.Repeat("]", 20)
+
+		_, err := sut.GetPolicyTree(context.Background(), 1)
+		require.ErrorContains(t, err, "maximum nesting depth of 10")
+
+		_, err = sut.UpdatePolicyTree(context.Background(), 1, createTestRoutingTree(), models.ProvenanceNone)
+		require.ErrorContains(t, err, "maximum nesting depth of 10")
+	})
+
+	t.Run("brackets inside strings do not count towards the depth limit", func(t *testing.T) {
+		limits := configLimits{maxDepth: 2}
+
+		require.NoError(t, limits.check([]byte(`{"a": ["[[[{{{\"]]]"]}`)))
+		require.Error(t, limits.check([]byte(`{"a": [{}]}`)))
+	})
+
+	t.Run("resolving receivers for labels", func(t *testing.T) {
+		t.Run("falls back to root receiver when no route matches", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+
+			receivers, err := sut.ResolveReceiversForLabels(context.Background(), 1, model.LabelSet{"team": "unknown"})
+
+			require.NoError(t, err)
+			require.Equal(t, []string{"grafana-default-email"}, receivers)
+		})
+
+		t.Run("descends into nested routes", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+
+			receivers, err := sut.ResolveReceiversForLabels(context.Background(), 1, model.LabelSet{"team": "b", "severity": "critical"})
+
+			require.NoError(t, err)
+			require.Equal(t, []string{"team-b-critical"}, receivers)
+		})
+
+		t.Run("keeps matching siblings of a continue route", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+
+			receivers, err := sut.ResolveReceiversForLabels(context.Background(), 1, model.LabelSet{"team": "a"})
+
+			require.NoError(t, err)
+			require.Equal(t, []string{"team-a", "team-a-escalation"}, receivers)
+		})
+
+		t.Run("skips routes that are muted", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+
+			receivers, err := sut.ResolveReceiversForLabels(context.Background(), 1, model.LabelSet{"team": "c"})
+
+			require.NoError(t, err)
+			require.Empty(t, receivers)
+		})
+	})
+
+	t.Run("simulate routing", func(t *testing.T) {
+		t.Run("resolves the receivers of each sample alert in order", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+
+			results, err := sut.SimulateRouting(context.Background(), 1, []model.LabelSet{
+				{"team": "b", "severity": "critical"},
+				{"team": "a"},
+				{"team": "b"},
+				{"team": "c"},
+				{"team": "unknown"},
+			})
+
+			require.NoError(t, err)
+			require.Equal(t, [][]string{
+				{"team-b-critical"},
+				{"team-a", "team-a-escalation"},
+				{"team-b"},
+				{},
+				{"grafana-default-email"},
+			}, results)
+		})
+
+		t.Run("returns an empty result for no sample alerts", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+
+			results, err := sut.SimulateRouting(context.Background(), 1, nil)
+
+			require.NoError(t, err)
+			require.Empty(t, results)
+		})
+	})
+
+	t.Run("simulate routing with inhibitions", func(t *testing.T) {
+		configWithInhibitRules := strings.Replace(configWithNestedRoutes, `"mute_time_intervals": [{`, `"inhibit_rules": [{
+			"source_matchers": ["severity=critical"],
+			"target_matchers": ["severity=warning"],
+			"equal": ["team"]
+		}],
+		"mute_time_intervals": [{`, 1)
+
+		t.Run("marks the alerts inhibited by other firing alerts", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithInhibitRules
+
+			results, err := sut.SimulateRoutingWithInhibitions(context.Background(), 1, []model.LabelSet{
+				{"team": "b", "severity": "critical"},
+				{"team": "b", "severity": "warning"},
+				{"team": "a", "severity": "warning"},
+			})
+
+			require.NoError(t, err)
+			require.Equal(t, []RoutingSimulation{
+				{Receivers: []string{"team-b-critical"}},
+				{Receivers: []string{"team-b"}, InhibitedBy: []int{0}},
+				{Receivers: []string{"team-a", "team-a-escalation"}},
+			}, results)
+			require.False(t, results[0].Inhibited())
+			require.True(t, results[1].Inhibited())
+		})
+
+		t.Run("nothing is inhibited without the source alert", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithInhibitRules
+
+			results, err := sut.SimulateRoutingWithInhibitions(context.Background(), 1, []model.LabelSet{
+				{"team": "b", "severity": "warning"},
+			})
+
+			require.NoError(t, err)
+			require.Equal(t, []RoutingSimulation{{Receivers: []string{"team-b"}}}, results)
+		})
+
+		t.Run("an alert matching both sides of a rule is not inhibited by a similar alert", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = strings.Replace(configWithNestedRoutes,
+				`"mute_time_intervals": [{`, `"inhibit_rules": [{
+					"source_matchers": ["team=b"],
+					"target_matchers": ["team=b"]
+				}],
+				"mute_time_intervals": [{`, 1)
+
+			results, err := sut.SimulateRoutingWithInhibitions(context.Background(), 1, []model.LabelSet{
+				{"team": "b", "severity": "critical"},
+				{"team": "b"},
+			})
+
+			require.NoError(t, err)
+			require.False(t, results[0].Inhibited())
+			require.False(t, results[1].Inhibited())
+		})
+	})
+
+	t.Run("subtree provenance", func(t *testing.T) {
+		provisionTeamBFromFile := func(t *testing.T, sut *NotificationPolicyService) {
+			t.Helper()
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			err = sut.UpdateRoute(context.Background(), 1, tree.Routes[1].UID, *tree.Routes[1], models.ProvenanceFile)
+			require.NoError(t, err)
+		}
+
+		t.Run("service reports the provenance of each subtree", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			provisionTeamBFromFile(t, sut)
+
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+
+			require.NoError(t, err)
+			require.Equal(t, models.ProvenanceNone, tree.Provenance)
+			require.Equal(t, models.ProvenanceNone, tree.Routes[0].Provenance)
+			require.Equal(t, models.ProvenanceFile, tree.Routes[1].Provenance)
+		})
+
+		t.Run("service marks the routes of a file provisioned subtree as not editable", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			provisionTeamBFromFile(t, sut)
+
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+
+			require.NoError(t, err)
+			editable := func(route *definitions.Route) bool {
+				require.NotNil(t, route.Editable)
+				return *route.Editable
+			}
+			require.True(t, editable(&tree))
+			require.True(t, editable(tree.Routes[0]))
+			require.False(t, editable(tree.Routes[1]))
+			require.False(t, editable(tree.Routes[1].Routes[0]))
+			require.True(t, editable(tree.Routes[2]))
+			require.True(t, editable(tree.Routes[3]))
+		})
+
+		t.Run("service does not save the editable flags", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			provisionTeamBFromFile(t, sut)
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			require.NotNil(t, tree.Routes[0].Editable)
+			tree.Routes[0].Receiver = "team-c"
+
+			_, err = sut.UpdatePolicyTree(context.Background(), 1, tree, models.ProvenanceAPI)
+
+			require.NoError(t, err)
+			cfg, err := sut.readConfig(context.Background(), 1)
+			require.NoError(t, err)
+			walkRoutes(cfg.AlertmanagerConfig.Route, "", func(route *definitions.Route, _ string) {
+				require.Nil(t, route.Editable)
+			})
+			require.NotContains(t, sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration, `"editable"`)
+		})
+
+		t.Run("API edits inside a file provisioned subtree are rejected", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			provisionTeamBFromFile(t, sut)
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+
+			err = sut.UpdateRoute(context.Background(), 1, tree.Routes[1].Routes[0].UID, definitions.Route{Receiver: "team-b"}, models.ProvenanceAPI)
+			require.ErrorIs(t, err, ErrValidation)
+
+			tree.Routes[1].Receiver = "team-a"
+			_, err = sut.UpdatePolicyTree(context.Background(), 1, tree, models.ProvenanceAPI)
+			require.ErrorIs(t, err, ErrValidation)
+		})
+
+		t.Run("API edits outside of a file provisioned subtree are allowed", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			provisionTeamBFromFile(t, sut)
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+
+			err = sut.UpdateRoute(context.Background(), 1, tree.Routes[0].UID, definitions.Route{Receiver: "team-a-escalation"}, models.ProvenanceAPI)
+			require.NoError(t, err)
+
+			tree, err = sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			require.Equal(t, "team-a-escalation", tree.Routes[0].Receiver)
+			require.Equal(t, models.ProvenanceAPI, tree.Routes[0].Provenance)
+
+			tree.Receiver = "team-c"
+			changed, err := sut.UpdatePolicyTree(context.Background(), 1, tree, models.ProvenanceAPI)
+			require.NoError(t, err)
+			require.True(t, changed)
+		})
+
+		t.Run("file provisioning can change its subtree", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			provisionTeamBFromFile(t, sut)
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+
+			err = sut.UpdateRoute(context.Background(), 1, tree.Routes[1].Routes[0].UID, definitions.Route{Receiver: "team-b"}, models.ProvenanceFile)
+			require.NoError(t, err)
+
+			tree, err = sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			require.Equal(t, "team-b", tree.Routes[1].Routes[0].Receiver)
+		})
+
+		t.Run("updating an unknown route returns ErrNotFound", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+
+			err := sut.UpdateRoute(context.Background(), 1, "unknown", definitions.Route{Receiver: "team-b"}, models.ProvenanceAPI)
+
+			require.ErrorIs(t, err, ErrNotFound)
+		})
+	})
+
+	t.Run("adding routes", func(t *testing.T) {
+		t.Run("adds a route under the root", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+
+			uid, err := sut.AddRoute(context.Background(), 1, tree.UID, definitions.Route{Receiver: "team-c"}, models.ProvenanceAPI)
+			require.NoError(t, err)
+
+			updated, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			require.Len(t, updated.Routes, 5)
+			require.Equal(t, tree.Routes, updated.Routes[:4])
+			require.Equal(t, uid, updated.Routes[4].UID)
+			require.Equal(t, "team-c", updated.Routes[4].Receiver)
+			require.Equal(t, models.ProvenanceAPI, updated.Routes[4].Provenance)
+		})
+
+		t.Run("adds a route under a nested parent", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+
+			uid, err := sut.AddRoute(context.Background(), 1, tree.Routes[1].UID, definitions.Route{Receiver: "team-b"}, models.ProvenanceAPI)
+			require.NoError(t, err)
+
+			updated, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			require.Len(t, updated.Routes, 4)
+			require.Len(t, updated.Routes[1].Routes, 2)
+			require.Equal(t, tree.Routes[1].Routes[0], updated.Routes[1].Routes[0])
+			require.Equal(t, uid, updated.Routes[1].Routes[1].UID)
+			require.Equal(t, "team-b", updated.Routes[1].Routes[1].Receiver)
+		})
+
+		t.Run("rejects a route with an unknown receiver", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+
+			_, err := sut.AddRoute(context.Background(), 1, "", definitions.Route{Receiver: "not-existing"}, models.ProvenanceAPI)
+
+			require.ErrorIs(t, err, ErrValidation)
+			require.Equal(t, configWithNestedRoutes, sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration)
+		})
+
+		t.Run("adding under an unknown parent returns ErrNotFound", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+
+			_, err := sut.AddRoute(context.Background(), 1, "unknown", definitions.Route{Receiver: "team-c"}, models.ProvenanceAPI)
+
+			require.ErrorIs(t, err, ErrNotFound)
+		})
+	})
+
+	t.Run("route UIDs", func(t *testing.T) {
+		routeUIDs := func(tree definitions.Route) []string {
+			var uids []string
+			walkRoutes(&tree, "", func(route *definitions.Route, _ string) {
+				uids = append(uids, route.UID)
+			})
+			return uids
+		}
+
+		t.Run("every route has a distinct UID that is the same on each read", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+
+			first, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			second, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+
+			uids := routeUIDs(first)
+			require.Len(t, uids, 6)
+			seen := map[string]bool{}
+			for _, uid := range uids {
+				require.NotEmpty(t, uid)
+				require.False(t, seen[uid], "duplicate UID %q", uid)
+				seen[uid] = true
+			}
+			require.Equal(t, uids, routeUIDs(second))
+		})
+
+		t.Run("UIDs are stored and survive a round-trip", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			uids := routeUIDs(tree)
+
+			tree.Routes[3].Receiver = "team-a"
+			_, err = sut.UpdatePolicyTree(context.Background(), 1, tree, models.ProvenanceAPI)
+			require.NoError(t, err)
+
+			stored, err := deserializeAlertmanagerConfig([]byte(sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration))
+			require.NoError(t, err)
+			require.Equal(t, uids, routeUIDs(*stored.AlertmanagerConfig.Route))
+			updated, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			require.Equal(t, uids, routeUIDs(updated))
+		})
+
+		t.Run("UIDs do not change when an unrelated route is inserted", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			uids := routeUIDs(tree)
+
+			inserted := &definitions.Route{Receiver: "team-c"}
+			tree.Routes = append([]*definitions.Route{inserted}, tree.Routes...)
+			_, err = sut.UpdatePolicyTree(context.Background(), 1, tree, models.ProvenanceAPI)
+			require.NoError(t, err)
+
+			updated, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			updatedUIDs := routeUIDs(updated)
+			require.Equal(t, uids[0], updatedUIDs[0])
+			require.Equal(t, uids[1:], updatedUIDs[2:])
+			require.NotContains(t, uids, updated.Routes[0].UID)
+		})
+
+		t.Run("a tree saved without UIDs keeps the stored ones", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			uids := routeUIDs(tree)
+
+			walkRoutes(&tree, "", func(route *definitions.Route, _ string) {
+				route.UID = ""
+			})
+			tree.Routes[3].Receiver = "team-a"
+			_, err = sut.UpdatePolicyTree(context.Background(), 1, tree, models.ProvenanceFile)
+			require.NoError(t, err)
+
+			updated, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			require.Equal(t, uids, routeUIDs(updated))
+		})
+
+		t.Run("duplicate UIDs are rejected", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+
+			tree.Routes[3].UID = tree.Routes[0].UID
+			_, err = sut.UpdatePolicyTree(context.Background(), 1, tree, models.ProvenanceAPI)
+
+			require.ErrorIs(t, err, ErrValidation)
+		})
+	})
+
+	t.Run("time intervals", func(t *testing.T) {
+		t.Run("references to mute_time_intervals validate", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+
+			tree.Routes[0].MuteTimeIntervals = []string{"always"}
+			_, err = sut.UpdatePolicyTree(context.Background(), 1, tree, models.ProvenanceAPI)
+
+			require.NoError(t, err)
+		})
+
+		t.Run("references to time_intervals validate and are saved under mute_time_intervals", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithTimeIntervals
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+
+			tree.Routes = append(tree.Routes, &definitions.Route{Receiver: "team-a", MuteTimeIntervals: []string{"weekends"}})
+			_, err = sut.UpdatePolicyTree(context.Background(), 1, tree, models.ProvenanceAPI)
+			require.NoError(t, err)
+
+			saved, err := deserializeAlertmanagerConfig([]byte(sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration))
+			require.NoError(t, err)
+			require.Nil(t, saved.AlertmanagerConfig.TimeIntervals)
+			names := []string{}
+			for _, mt := range saved.AlertmanagerConfig.MuteTimeIntervals {
+				names = append(names, mt.Name)
+			}
+			require.Equal(t, []string{"always", "weekends"}, names)
+		})
+
+		t.Run("references to undefined intervals are rejected", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithTimeIntervals
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+
+			tree.Routes[0].MuteTimeIntervals = []string{"holidays"}
+			_, err = sut.UpdatePolicyTree(context.Background(), 1, tree, models.ProvenanceAPI)
+
+			require.ErrorIs(t, err, ErrValidation)
+			require.Equal(t, configWithTimeIntervals, sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration)
+		})
+	})
+
+	t.Run("effective policy tree", func(t *testing.T) {
+		t.Run("child routes inherit unset options from their parent", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithGroupedRoutes
+
+			tree, err := sut.GetEffectivePolicyTree(context.Background(), 1)
+
+			require.NoError(t, err)
+			require.Equal(t, []string{"alertname", "team"}, tree.GroupBy)
+			require.Equal(t, model.Duration(time.Minute), tree.GroupWait)
+			require.Equal(t, []string{"group_interval", "repeat_interval"}, tree.Inherited)
+
+			child := tree.Routes[0]
+			require.Equal(t, "team-a", child.Receiver)
+			require.Equal(t, []string{"alertname", "team"}, child.GroupBy)
+			require.Equal(t, model.Duration(time.Minute), child.GroupWait)
+			require.Equal(t, []string{"group_by", "group_wait", "group_interval", "repeat_interval"}, child.Inherited)
+
+			grandchild := child.Routes[0]
+			require.Equal(t, "team-a", grandchild.Receiver)
+			require.Equal(t, []string{"..."}, grandchild.GroupBy)
+			require.Equal(t, model.Duration(time.Minute), grandchild.GroupWait)
+			require.Equal(t, []string{"receiver", "group_wait", "group_interval", "repeat_interval"}, grandchild.Inherited)
+		})
+
+		t.Run("root falls back to the Alertmanager defaults", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+
+			tree, err := sut.GetEffectivePolicyTree(context.Background(), 1)
+
+			require.NoError(t, err)
+			require.Equal(t, "grafana-default-email", tree.Receiver)
+			require.Equal(t, []string{"group_wait", "group_interval", "repeat_interval"}, tree.Inherited)
+			require.Equal(t, model.Duration(dispatch.DefaultRouteOpts.GroupWait), tree.GroupWait)
+			require.Equal(t, model.Duration(dispatch.DefaultRouteOpts.GroupInterval), tree.GroupInterval)
+			require.Equal(t, model.Duration(dispatch.DefaultRouteOpts.RepeatInterval), tree.RepeatInterval)
+		})
+	})
+
+	t.Run("rerouting receivers", func(t *testing.T) {
+		t.Run("reroutes the routes of a receiver and restores them", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			tree.Routes[1].Routes[0].Receiver = "team-b"
+			_, err = sut.UpdatePolicyTree(context.Background(), 1, tree, models.ProvenanceAPI)
+			require.NoError(t, err)
+
+			changed, err := sut.RerouteReceiver(context.Background(), 1, "team-b", "team-c", models.ProvenanceAPI)
+			require.NoError(t, err)
+			require.Equal(t, 2, changed)
+
+			rerouted, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			require.Equal(t, "team-a", rerouted.Routes[0].Receiver)
+			require.Equal(t, "team-c", rerouted.Routes[1].Receiver)
+			require.Equal(t, "team-c", rerouted.Routes[1].Routes[0].Receiver)
+
+			restored, err := sut.RestoreReceiverRouting(context.Background(), 1, "team-b", models.ProvenanceAPI)
+			require.NoError(t, err)
+			require.Equal(t, 2, restored)
+
+			updated, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			require.Equal(t, tree.Routes, updated.Routes)
+		})
+
+		t.Run("restoring keeps routes changed since the reroute", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			_, err := sut.RerouteReceiver(context.Background(), 1, "team-a", "team-c", models.ProvenanceAPI)
+			require.NoError(t, err)
+
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			tree.Routes[0].Receiver = "team-b"
+			_, err = sut.UpdatePolicyTree(context.Background(), 1, tree, models.ProvenanceAPI)
+			require.NoError(t, err)
+
+			restored, err := sut.RestoreReceiverRouting(context.Background(), 1, "team-a", models.ProvenanceAPI)
+			require.NoError(t, err)
+			require.Equal(t, 0, restored)
+
+			updated, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			require.Equal(t, "team-b", updated.Routes[0].Receiver)
+
+			_, err = sut.RestoreReceiverRouting(context.Background(), 1, "team-a", models.ProvenanceAPI)
+			require.ErrorIs(t, err, ErrNotFound)
+		})
+
+		t.Run("a receiver cannot be rerouted twice", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			_, err := sut.RerouteReceiver(context.Background(), 1, "team-a", "team-c", models.ProvenanceAPI)
+			require.NoError(t, err)
+
+			_, err = sut.RerouteReceiver(context.Background(), 1, "team-a", "team-b", models.ProvenanceAPI)
+
+			require.ErrorIs(t, err, ErrValidation)
+		})
+
+		t.Run("rerouting to an unknown receiver is rejected", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+
+			_, err := sut.RerouteReceiver(context.Background(), 1, "team-a", "not-existing", models.ProvenanceAPI)
+
+			require.ErrorIs(t, err, ErrValidation)
+			require.Equal(t, configWithNestedRoutes, sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration)
+			_, err = sut.RestoreReceiverRouting(context.Background(), 1, "team-a", models.ProvenanceAPI)
+			require.ErrorIs(t, err, ErrNotFound)
+		})
+
+		t.Run("rerouting an unused receiver changes nothing", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+
+			changed, err := sut.RerouteReceiver(context.Background(), 1, "unused", "team-c", models.ProvenanceAPI)
+
+			require.NoError(t, err)
+			require.Equal(t, 0, changed)
+			require.Equal(t, configWithNestedRoutes, sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration)
+		})
+	})
+
+	t.Run("undoing the last policy change", func(t *testing.T) {
+		t.Run("restores the tree replaced by an update", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			original, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			tree.Routes[0].Receiver = "team-c"
+			tree.Routes = tree.Routes[:2]
+			_, err = sut.UpdatePolicyTree(context.Background(), 1, tree, models.ProvenanceAPI)
+			require.NoError(t, err)
+
+			restored, err := sut.UndoLastPolicyChange(context.Background(), 1, models.ProvenanceAPI)
+
+			require.NoError(t, err)
+			require.Equal(t, original.Routes, restored.Routes)
+			updated, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			require.Equal(t, original.Routes, updated.Routes)
+
+			_, err = sut.UndoLastPolicyChange(context.Background(), 1, models.ProvenanceAPI)
+			require.ErrorIs(t, err, ErrNotFound)
+		})
+
+		t.Run("restores the tree replaced by a reset", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			original, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			_, err = sut.ResetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+
+			_, err = sut.UndoLastPolicyChange(context.Background(), 1, models.ProvenanceAPI)
+
+			require.NoError(t, err)
+			updated, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			require.Equal(t, original.Routes, updated.Routes)
+		})
+
+		t.Run("there is nothing to undo before a change", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+
+			_, err := sut.UndoLastPolicyChange(context.Background(), 1, models.ProvenanceAPI)
+
+			require.ErrorIs(t, err, ErrNotFound)
+		})
+
+		t.Run("a change cannot be undone once the tree was changed again", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			tree.Routes[0].Receiver = "team-c"
+			_, err = sut.UpdatePolicyTree(context.Background(), 1, tree, models.ProvenanceAPI)
+			require.NoError(t, err)
+			_, err = sut.RerouteReceiver(context.Background(), 1, "team-b", "team-a", models.ProvenanceAPI)
+			require.NoError(t, err)
+
+			_, err = sut.UndoLastPolicyChange(context.Background(), 1, models.ProvenanceAPI)
+
+			require.ErrorIs(t, err, ErrValidation)
+		})
+
+		t.Run("a tree using a receiver deleted since cannot be restored", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			tree.Routes = tree.Routes[:3]
+			_, err = sut.UpdatePolicyTree(context.Background(), 1, tree, models.ProvenanceAPI)
+			require.NoError(t, err)
+			cfg, err := deserializeAlertmanagerConfig([]byte(sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration))
+			require.NoError(t, err)
+			receivers := cfg.AlertmanagerConfig.Receivers[:0]
+			for _, receiver := range cfg.AlertmanagerConfig.Receivers {
+				if receiver.Name != "team-c" {
+					receivers = append(receivers, receiver)
+				}
+			}
+			cfg.AlertmanagerConfig.Receivers = receivers
+			serialized, err := serializeAlertmanagerConfig(*cfg)
+			require.NoError(t, err)
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = string(serialized)
+
+			_, err = sut.UndoLastPolicyChange(context.Background(), 1, models.ProvenanceAPI)
+
+			require.ErrorIs(t, err, ErrValidation)
+		})
+	})
+
+	t.Run("analyzing policy trees", func(t *testing.T) {
+		t.Run("warns about a route muted all week", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+
+			warnings, err := sut.AnalyzePolicyTree(context.Background(), 1, tree)
+
+			require.NoError(t, err)
+			require.Len(t, warnings, 2)
+			require.Equal(t, tree.Routes[3].UID, warnings[0].RouteUID)
+			require.Equal(t, "3", warnings[0].RoutePath)
+			require.Contains(t, warnings[0].Message, "always")
+		})
+
+		t.Run("warns about a receiver only used by routes muted all week", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+
+			warnings, err := sut.AnalyzePolicyTree(context.Background(), 1, tree)
+
+			require.NoError(t, err)
+			require.Len(t, warnings, 2)
+			require.Equal(t, "team-c", warnings[1].Receiver)
+			require.Empty(t, warnings[1].RouteUID)
+			require.Empty(t, warnings[1].RoutePath)
+			require.Contains(t, warnings[1].Message, "team-c")
+		})
+
+		t.Run("does not warn about a receiver also used by a route that is not muted", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			tree.Routes[1].Routes[0].Receiver = ""
+			tree.Routes[1].Routes[0].Routes = []*definitions.Route{{Receiver: "team-c"}}
+
+			warnings, err := sut.AnalyzePolicyTree(context.Background(), 1, tree)
+
+			require.NoError(t, err)
+			require.Len(t, warnings, 1)
+			require.Equal(t, "3", warnings[0].RoutePath)
+		})
+
+		t.Run("warns about mute timings that only cover the week together", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithWeeklyMuteTimings
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+
+			warnings, err := sut.AnalyzePolicyTree(context.Background(), 1, tree)
+
+			require.NoError(t, err)
+			require.Len(t, warnings, 1)
+			require.Equal(t, "1", warnings[0].RoutePath)
+		})
+
+		t.Run("a tree without fully muted routes has no warnings", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithWeeklyMuteTimings
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			tree.Routes = tree.Routes[:1]
+
+			warnings, err := sut.AnalyzePolicyTree(context.Background(), 1, tree)
+
+			require.NoError(t, err)
+			require.Empty(t, warnings)
+		})
+	})
+
+	t.Run("previewing the impact of a policy tree", func(t *testing.T) {
+		firing := func(ruleUID string, labels data.Labels) *state.State {
+			return &state.State{OrgID: 1, AlertRuleUID: ruleUID, State: eval.Alerting, Labels: labels}
+		}
+
+		t.Run("reports firing alerts routed to other receivers", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			sut.alertStates = &fakeAlertStateReader{states: []*state.State{
+				firing("rule-a", data.Labels{"team": "a"}),
+				firing("rule-b", data.Labels{"team": "b"}),
+				{OrgID: 1, AlertRuleUID: "rule-normal", State: eval.Normal, Labels: data.Labels{"team": "a"}},
+				{OrgID: 2, AlertRuleUID: "rule-other-org", State: eval.Alerting, Labels: data.Labels{"team": "a"}},
+			}}
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			tree.Routes[0].Receiver = "team-c"
+
+			impacts, err := sut.PreviewPolicyTreeImpact(context.Background(), 1, tree)
+
+			require.NoError(t, err)
+			require.Equal(t, []PolicyChangeImpact{{
+				RuleUID:      "rule-a",
+				Labels:       model.LabelSet{"team": "a"},
+				OldReceivers: []string{"team-a", "team-a-escalation"},
+				NewReceivers: []string{"team-a-escalation", "team-c"},
+			}}, impacts)
+			require.Equal(t, configWithNestedRoutes, sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration)
+		})
+
+		t.Run("an unchanged tree has no impact", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			sut.alertStates = &fakeAlertStateReader{states: []*state.State{firing("rule-a", data.Labels{"team": "a"})}}
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+
+			impacts, err := sut.PreviewPolicyTreeImpact(context.Background(), 1, tree)
+
+			require.NoError(t, err)
+			require.Empty(t, impacts)
+		})
+
+		t.Run("an invalid tree is rejected", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			tree, err := sut.GetPolicyTree(context.Background(), 1)
+			require.NoError(t, err)
+			tree.Routes[0].Receiver = "not-existing"
+
+			_, err = sut.PreviewPolicyTreeImpact(context.Background(), 1, tree)
+
+			require.ErrorIs(t, err, ErrValidation)
+		})
+	})
+
+	t.Run("counting the firing alerts routed to each receiver", func(t *testing.T) {
+		firing := func(ruleUID string, labels data.Labels) *state.State {
+			return &state.State{OrgID: 1, AlertRuleUID: ruleUID, State: eval.Alerting, Labels: labels}
+		}
+		twoReceivers := definitions.Route{
+			Receiver: "grafana-default-email",
+			Routes: []*definitions.Route{{
+				Receiver:       "team-a",
+				ObjectMatchers: definitions.ObjectMatchers{{Type: labels.MatchEqual, Name: "team", Value: "a"}},
+			}},
+		}
+
+		t.Run("counts the alerts of each receiver of the tree", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			_, err := sut.UpdatePolicyTree(context.Background(), 1, twoReceivers, models.ProvenanceNone)
+			require.NoError(t, err)
+			sut.alertStates = &fakeAlertStateReader{states: []*state.State{
+				firing("rule-a", data.Labels{"team": "a", "instance": "1"}),
+				firing("rule-a", data.Labels{"team": "a", "instance": "2"}),
+				firing("rule-b", data.Labels{"team": "b"}),
+				{OrgID: 1, AlertRuleUID: "rule-normal", State: eval.Normal, Labels: data.Labels{"team": "a"}},
+				{OrgID: 2, AlertRuleUID: "rule-other-org", State: eval.Alerting, Labels: data.Labels{"team": "a"}},
+			}}
+
+			load, err := sut.ReceiverLoad(context.Background(), 1)
+
+			require.NoError(t, err)
+			require.Equal(t, map[string]int{"grafana-default-email": 1, "team-a": 2}, load)
+		})
+
+		t.Run("idle receivers of the tree are counted as zero", func(t *testing.T) {
+			sut := createNotificationPolicyServiceSut()
+			sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+			_, err := sut.UpdatePolicyTree(context.Background(), 1, twoReceivers, models.ProvenanceNone)
+			require.NoError(t, err)
+			sut.alertStates = &fakeAlertStateReader{states: []*state.State{firing("rule-b", data.Labels{"team": "b"})}}
+
+			load, err := sut.ReceiverLoad(context.Background(), 1)
+
+			require.NoError(t, err)
+			require.Equal(t, map[string]int{"grafana-default-email": 1, "team-a": 0}, load)
+		})
+	})
+
+	t.Run("listing the integration types of each receiver of the tree", func(t *testing.T) {
+		sut := createNotificationPolicyServiceSut()
+		sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithIntegrationTypes
+
+		types, err := sut.ReceiverIntegrationTypes(context.Background(), 1)
+
+		require.NoError(t, err)
+		require.Equal(t, map[string][]string{
+			"grafana-default-email": {"email"},
+			"team-a":                {"slack", "webhook"},
+			"team-b":                {},
+		}, types)
+	})
+
 	t.Run("deleting route replaces with default", func(t *testing.T) {
 		sut := createNotificationPolicyServiceSut()
 
@@ -227,11 +1363,56 @@ func TestNotificationPolicyService(t *testing.T) {
 	})
 }
 
+func TestPolicyTreeETag(t *testing.T) {
+	t.Run("the ETag is stable while the tree does not change", func(t *testing.T) {
+		sut := createNotificationPolicyServiceSut()
+
+		first, err := sut.PolicyTreeETag(context.Background(), 1)
+		require.NoError(t, err)
+		second, err := sut.PolicyTreeETag(context.Background(), 1)
+		require.NoError(t, err)
+
+		require.Equal(t, first, second)
+		require.Regexp(t, `^"[0-9a-f]{32}"$`, first)
+	})
+
+	t.Run("the ETag changes with the tree", func(t *testing.T) {
+		sut := createNotificationPolicyServiceSut()
+		before, err := sut.PolicyTreeETag(context.Background(), 1)
+		require.NoError(t, err)
+
+		_, err = sut.UpdatePolicyTree(context.Background(), 1, createTestRoutingTree(), models.ProvenanceAPI)
+		require.NoError(t, err)
+		after, err := sut.PolicyTreeETag(context.Background(), 1)
+		require.NoError(t, err)
+
+		require.NotEqual(t, before, after)
+	})
+
+	t.Run("the ETag changes with the provenance of the tree", func(t *testing.T) {
+		sut := createNotificationPolicyServiceSut()
+		tree, err := sut.GetPolicyTree(context.Background(), 1)
+		require.NoError(t, err)
+		before, err := sut.PolicyTreeETag(context.Background(), 1)
+		require.NoError(t, err)
+
+		err = sut.provenanceStore.SetProvenance(context.Background(), &tree, 1, models.ProvenanceFile)
+		require.NoError(t, err)
+		after, err := sut.PolicyTreeETag(context.Background(), 1)
+		require.NoError(t, err)
+
+		require.NotEqual(t, before, after)
+	})
+}
+
 func createNotificationPolicyServiceSut() *NotificationPolicyService {
 	return &NotificationPolicyService{
 		amStore:         newFakeAMConfigStore(),
 		provenanceStore: NewFakeProvisioningStore(),
 		xact:            newNopTransactionManager(),
+		kvStore:         newFakeKVStore(),
+		alertStates:     &fakeAlertStateReader{},
+		tracer:          tracing.InitializeTracerForTest(),
 		log:             log.NewNopLogger(),
 		settings: setting.UnifiedAlertingSettings{
 			DefaultConfiguration: setting.GetAlertmanagerDefaultConfiguration(),
@@ -244,3 +1425,205 @@ func createTestRoutingTree() definitions.Route {
 		Receiver: "a new receiver",
 	}
 }
+
+var configWithNestedRoutes = `
+{
+	"alertmanager_config": {
+		"route": {
+			"receiver": "grafana-default-email",
+			"routes": [{
+				"receiver": "team-a",
+				"object_matchers": [["team", "=", "a"]],
+				"continue": true
+			}, {
+				"receiver": "team-b",
+				"object_matchers": [["team", "=", "b"]],
+				"routes": [{
+					"receiver": "team-b-critical",
+					"object_matchers": [["severity", "=", "critical"]]
+				}]
+			}, {
+				"receiver": "team-a-escalation",
+				"object_matchers": [["team", "=", "a"]]
+			}, {
+				"receiver": "team-c",
+				"object_matchers": [["team", "=", "c"]],
+				"mute_time_intervals": ["always"]
+			}]
+		},
+		"mute_time_intervals": [{
+			"name": "always",
+			"time_intervals": [{}]
+		}],
+		"receivers": [
+			{"name": "grafana-default-email"},
+			{"name": "team-a"},
+			{"name": "team-a-escalation"},
+			{"name": "team-b"},
+			{"name": "team-b-critical"},
+			{"name": "team-c"}
+		]
+	}
+}
+`
+
+var configWithIntegrationTypes = `
+{
+	"alertmanager_config": {
+		"route": {
+			"receiver": "grafana-default-email",
+			"routes": [{
+				"receiver": "team-a",
+				"object_matchers": [["team", "=", "a"]]
+			}, {
+				"receiver": "team-b",
+				"object_matchers": [["team", "=", "b"]]
+			}]
+		},
+		"receivers": [{
+			"name": "grafana-default-email",
+			"grafana_managed_receiver_configs": [{
+				"uid": "email-uid",
+				"name": "email receiver",
+				"type": "email",
+				"settings": {"addresses": "<example@email.com>"}
+			}]
+		}, {
+			"name": "team-a",
+			"grafana_managed_receiver_configs": [{
+				"uid": "webhook-uid",
+				"name": "team-a webhook",
+				"type": "webhook",
+				"settings": {"url": "http://localhost/hook"}
+			}, {
+				"uid": "slack-uid",
+				"name": "team-a slack",
+				"type": "slack",
+				"settings": {"recipient": "#team-a"}
+			}, {
+				"uid": "slack-oncall-uid",
+				"name": "team-a slack oncall",
+				"type": "slack",
+				"settings": {"recipient": "#team-a-oncall"}
+			}]
+		}, {
+			"name": "team-b"
+		}, {
+			"name": "unused",
+			"grafana_managed_receiver_configs": [{
+				"uid": "pagerduty-uid",
+				"name": "unused pagerduty",
+				"type": "pagerduty",
+				"settings": {}
+			}]
+		}]
+	}
+}
+`
+
+var configWithGroupedRoutes = `
+{
+	"alertmanager_config": {
+		"route": {
+			"receiver": "grafana-default-email",
+			"group_by": ["team", "alertname"],
+			"group_wait": "1m",
+			"routes": [{
+				"receiver": "team-a",
+				"object_matchers": [["team", "=", "a"]],
+				"routes": [{
+					"group_by": ["..."],
+					"object_matchers": [["severity", "=", "critical"]]
+				}]
+			}]
+		},
+		"receivers": [
+			{"name": "grafana-default-email"},
+			{"name": "team-a"}
+		]
+	}
+}
+`
+
+var configWithTimeIntervals = `
+{
+	"alertmanager_config": {
+		"route": {
+			"receiver": "grafana-default-email",
+			"routes": [{
+				"receiver": "team-a",
+				"object_matchers": [["team", "=", "a"]],
+				"mute_time_intervals": ["always", "weekends"]
+			}]
+		},
+		"mute_time_intervals": [{
+			"name": "always",
+			"time_intervals": [{}]
+		}],
+		"time_intervals": [{
+			"name": "weekends",
+			"time_intervals": [{}]
+		}],
+		"receivers": [
+			{"name": "grafana-default-email"},
+			{"name": "team-a"}
+		]
+	}
+}
+`
+
+var configWithTeamMuteTimings = `
+{
+	"alertmanager_config": {
+		"route": {
+			"receiver": "grafana-default-email"
+		},
+		"mute_time_intervals": [{
+			"name": "team-a-nights",
+			"time_intervals": [{}]
+		}, {
+			"name": "weekends",
+			"time_intervals": [{}]
+		}],
+		"receivers": [
+			{"name": "grafana-default-email"},
+			{"name": "team-a"}
+		]
+	}
+}
+`
+
+var configWithWeeklyMuteTimings = `
+{
+	"alertmanager_config": {
+		"route": {
+			"receiver": "grafana-default-email",
+			"routes": [{
+				"receiver": "team-a",
+				"object_matchers": [["team", "=", "a"]],
+				"mute_time_intervals": ["weekdays"]
+			}, {
+				"receiver": "team-a",
+				"object_matchers": [["team", "=", "b"]],
+				"mute_time_intervals": ["weekdays", "weekends"]
+			}]
+		},
+		"mute_time_intervals": [{
+			"name": "weekdays",
+			"time_intervals": [{
+				"weekdays": ["monday:friday"],
+				"times": [{"start_time": "00:00", "end_time": "24:00"}]
+			}]
+		}, {
+			"name": "weekends",
+			"time_intervals": [{
+				"weekdays": ["saturday", "sunday"]
+			}]
+		}],
+		"receivers": [
+			{"name": "grafana-default-email"},
+			{"name": "team-a"}
+		]
+	}
+}
+`
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/grafana/grafana/pkg/services/ngalert/models"
+	"github.com/grafana/grafana/pkg/services/ngalert/state"
 	"github.com/grafana/grafana/pkg/services/ngalert/store"
 )
 
@@ -28,6 +29,11 @@ type TransactionManager interface {
 	InTransaction(ctx context.Context, work func(ctx context.Context) error) error
 }
 
+// AlertStateReader represents the ability to read the current state of the alert instances of an org.
+type AlertStateReader interface {
+	GetAll(orgID int64) []*state.State
+}
+
 // RuleStore represents the ability to persist and query alert rules.
 type RuleStore interface {
 	GetAlertRuleByUID(ctx context.Context, query *models.GetAlertRuleByUIDQuery) error
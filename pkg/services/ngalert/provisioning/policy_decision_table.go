@@ -0,0 +1,76 @@
+package provisioning
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/grafana/grafana/pkg/services/ngalert/api/tooling/definitions"
+	"github.com/prometheus/alertmanager/dispatch"
+)
+
+// DecisionRow is one way an alert can be routed through the policy tree. Matchers are the matchers of the route and of
+// all its parents, which an alert must match to be routed along the path, from the root down. Receiver is the receiver
+// the alert is delivered to, and MuteTimeIntervals are the mute timings of the route, which are not inherited. When
+// Continue is true, the alert is also matched against the siblings that follow the route. A row is Fallback when its
+// route has children, and is taken by the alerts that match the route but none of its children.
+type DecisionRow struct {
+	RoutePath         string   `json:"routePath"`
+	RouteUID          string   `json:"routeUid"`
+	Matchers          []string `json:"matchers"`
+	Receiver          string   `json:"receiver"`
+	MuteTimeIntervals []string `json:"muteTimeIntervals"`
+	Continue          bool     `json:"continue"`
+	Fallback          bool     `json:"fallback"`
+}
+
+// GetPolicyDecisionTable flattens the policy tree of the org into a table with one row per leaf route, and one per
+// route with children for the alerts that match none of them. The rows are in the order the Alertmanager evaluates
+// the routes in, so that a route comes after its children, and the receivers are resolved the way the Alertmanager
+// resolves them.
+func (nps *NotificationPolicyService) GetPolicyDecisionTable(ctx context.Context, orgID int64) ([]DecisionRow, error) {
+	revision, err := getLastConfigurationWithLimits(ctx, orgID, nps.amStore, nps.configLimits())
+	if err != nil {
+		return nil, err
+	}
+
+	tree := revision.cfg.AlertmanagerConfig.Config.Route
+	if tree == nil {
+		return nil, fmt.Errorf("no route present in current alertmanager config")
+	}
+	fillDerivedRouteUIDs(tree)
+
+	rows := []DecisionRow{}
+	addDecisionRows(tree, dispatch.NewRoute(tree.AsAMRoute(), nil), "", nil, &rows)
+	return rows, nil
+}
+
+func addDecisionRows(route *definitions.Route, resolved *dispatch.Route, path string, matchers []string, rows *[]DecisionRow) {
+	own := make([]string, 0, len(matchers)+len(resolved.Matchers))
+	own = append(own, matchers...)
+	for _, m := range resolved.Matchers {
+		own = append(own, m.String())
+	}
+
+	for i, child := range route.Routes {
+		childPath := strconv.Itoa(i)
+		if path != "" {
+			childPath = path + "." + childPath
+		}
+		addDecisionRows(child, resolved.Routes[i], childPath, own, rows)
+	}
+
+	muteTimeIntervals := route.MuteTimeIntervals
+	if muteTimeIntervals == nil {
+		muteTimeIntervals = []string{}
+	}
+	*rows = append(*rows, DecisionRow{
+		RoutePath:         path,
+		RouteUID:          route.UID,
+		Matchers:          own,
+		Receiver:          resolved.RouteOpts.Receiver,
+		MuteTimeIntervals: muteTimeIntervals,
+		Continue:          route.Continue,
+		Fallback:          len(route.Routes) > 0,
+	})
+}
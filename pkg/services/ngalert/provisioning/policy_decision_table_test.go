@@ -0,0 +1,91 @@
+package provisioning
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetPolicyDecisionTable(t *testing.T) {
+	t.Run("flattens a nested tree into rows in evaluation order", func(t *testing.T) {
+		sut := createNotificationPolicyServiceSut()
+		sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+
+		rows, err := sut.GetPolicyDecisionTable(context.Background(), 1)
+
+		require.NoError(t, err)
+		for i := range rows {
+			require.NotEmpty(t, rows[i].RouteUID)
+			rows[i].RouteUID = ""
+		}
+		require.Equal(t, []DecisionRow{
+			{
+				RoutePath:         "0",
+				Matchers:          []string{`team="a"`},
+				Receiver:          "team-a",
+				MuteTimeIntervals: []string{},
+				Continue:          true,
+			},
+			{
+				RoutePath:         "1.0",
+				Matchers:          []string{`team="b"`, `severity="critical"`},
+				Receiver:          "team-b-critical",
+				MuteTimeIntervals: []string{},
+			},
+			{
+				RoutePath:         "1",
+				Matchers:          []string{`team="b"`},
+				Receiver:          "team-b",
+				MuteTimeIntervals: []string{},
+				Fallback:          true,
+			},
+			{
+				RoutePath:         "2",
+				Matchers:          []string{`team="a"`},
+				Receiver:          "team-a-escalation",
+				MuteTimeIntervals: []string{},
+			},
+			{
+				RoutePath:         "3",
+				Matchers:          []string{`team="c"`},
+				Receiver:          "team-c",
+				MuteTimeIntervals: []string{"always"},
+			},
+			{
+				RoutePath:         "",
+				Matchers:          []string{},
+				Receiver:          "grafana-default-email",
+				MuteTimeIntervals: []string{},
+				Fallback:          true,
+			},
+		}, rows)
+	})
+
+	t.Run("a child without a receiver delivers to the receiver of its parent", func(t *testing.T) {
+		sut := createNotificationPolicyServiceSut()
+		sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithInheritedReceiver
+
+		rows, err := sut.GetPolicyDecisionTable(context.Background(), 1)
+
+		require.NoError(t, err)
+		require.Len(t, rows, 2)
+		require.Equal(t, "0", rows[0].RoutePath)
+		require.Equal(t, "grafana-default-email", rows[0].Receiver)
+		require.Equal(t, []string{`team="a"`}, rows[0].Matchers)
+	})
+}
+
+var configWithInheritedReceiver = `
+{
+	"alertmanager_config": {
+		"route": {
+			"receiver": "grafana-default-email",
+			"routes": [{
+				"object_matchers": [["team", "=", "a"]]
+			}]
+		},
+		"receivers": [{"name": "grafana-default-email"}]
+	}
+}
+`
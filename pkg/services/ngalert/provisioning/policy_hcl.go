@@ -0,0 +1,152 @@
+package provisioning
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/grafana/grafana/pkg/services/ngalert/api/tooling/definitions"
+	"github.com/prometheus/alertmanager/pkg/labels"
+	"github.com/prometheus/common/model"
+)
+
+// hclResourceName is the name of the grafana_notification_policy resource written by ExportPolicyTreeHCL.
+const hclResourceName = "policy_tree"
+
+// ExportPolicyTreeHCL renders the policy tree of the org as the grafana_notification_policy resource of the
+// Grafana Terraform provider, with its nested routes as policy blocks, so that a tree built in the UI can be moved
+// to Terraform. Contact points and mute timings are referenced by name; they can be replaced with references to the
+// Terraform resources that manage them. The legacy matchers of a route are written as matcher blocks, like its
+// object matchers.
+func (nps *NotificationPolicyService) ExportPolicyTreeHCL(ctx context.Context, orgID int64) ([]byte, error) {
+	revision, err := getLastConfigurationWithLimits(ctx, orgID, nps.amStore, nps.configLimits())
+	if err != nil {
+		return nil, err
+	}
+
+	tree := revision.cfg.AlertmanagerConfig.Config.Route
+	if tree == nil {
+		return nil, fmt.Errorf("no route present in current alertmanager config")
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "resource \"grafana_notification_policy\" %q {\n", hclResourceName)
+	writeHCLRoute(&buf, tree, 1)
+	buf.WriteString("}\n")
+	return buf.Bytes(), nil
+}
+
+// writeHCLRoute writes the attributes and blocks of the route, and its child routes as nested policy blocks.
+func writeHCLRoute(buf *bytes.Buffer, route *definitions.Route, depth int) {
+	indent := strings.Repeat("  ", depth)
+	attr := func(name, value string) {
+		fmt.Fprintf(buf, "%s%s = %s\n", indent, name, value)
+	}
+
+	if route.Receiver != "" {
+		attr("contact_point", hclString(route.Receiver))
+	}
+	// The provider requires the root to group alerts, even by nothing.
+	if len(route.GroupByStr) > 0 || depth == 1 {
+		attr("group_by", hclStringList(route.GroupByStr))
+	}
+	for _, m := range hclMatchers(route) {
+		fmt.Fprintf(buf, "%smatcher {\n", indent)
+		fmt.Fprintf(buf, "%s  label = %s\n", indent, hclString(m.Name))
+		fmt.Fprintf(buf, "%s  match = %s\n", indent, hclString(m.Type.String()))
+		fmt.Fprintf(buf, "%s  value = %s\n", indent, hclString(m.Value))
+		fmt.Fprintf(buf, "%s}\n", indent)
+	}
+	if len(route.MuteTimeIntervals) > 0 {
+		attr("mute_timings", hclStringList(route.MuteTimeIntervals))
+	}
+	if route.Continue {
+		attr("continue", "true")
+	}
+	for _, timing := range []struct {
+		name  string
+		value *model.Duration
+	}{
+		{"group_wait", route.GroupWait},
+		{"group_interval", route.GroupInterval},
+		{"repeat_interval", route.RepeatInterval},
+	} {
+		if timing.value != nil {
+			attr(timing.name, hclString(timing.value.String()))
+		}
+	}
+
+	for _, child := range route.Routes {
+		fmt.Fprintf(buf, "\n%spolicy {\n", indent)
+		writeHCLRoute(buf, child, depth+1)
+		fmt.Fprintf(buf, "%s}\n", indent)
+	}
+}
+
+// hclMatchers returns the matchers of all kinds of the route: the legacy match and match_re maps, sorted by label,
+// then the matchers and the object matchers.
+func hclMatchers(route *definitions.Route) []*labels.Matcher {
+	var matchers []*labels.Matcher
+	names := make([]string, 0, len(route.Match))
+	for name := range route.Match {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		matchers = append(matchers, &labels.Matcher{Type: labels.MatchEqual, Name: name, Value: route.Match[name]})
+	}
+
+	names = make([]string, 0, len(route.MatchRE))
+	for name := range route.MatchRE {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		matchers = append(matchers, &labels.Matcher{Type: labels.MatchRegexp, Name: name, Value: route.MatchRE[name].String()})
+	}
+
+	matchers = append(matchers, route.Matchers...)
+	return append(matchers, route.ObjectMatchers...)
+}
+
+// hclString quotes the string as an HCL string literal. Template sequences are escaped so that the string is taken
+// literally.
+func hclString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '$', '%':
+			// "${" and "%{" start template sequences, which are escaped by doubling the sign.
+			if i+1 < len(s) && s[i+1] == '{' {
+				b.WriteRune(r)
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// hclStringList writes the strings as an HCL list of string literals.
+func hclStringList(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, hclString(value))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
@@ -0,0 +1,82 @@
+package provisioning
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/services/ngalert/api/tooling/definitions"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportPolicyTreeHCL(t *testing.T) {
+	t.Run("renders the tree with its nested routes, timings and mute timings", func(t *testing.T) {
+		sut := createNotificationPolicyServiceSut()
+		sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithGroupedRoutes
+
+		hcl, err := sut.ExportPolicyTreeHCL(context.Background(), 1)
+
+		require.NoError(t, err)
+		require.Equal(t, `resource "grafana_notification_policy" "policy_tree" {
+  contact_point = "grafana-default-email"
+  group_by = ["team", "alertname"]
+  group_wait = "1m"
+
+  policy {
+    contact_point = "team-a"
+    matcher {
+      label = "team"
+      match = "="
+      value = "a"
+    }
+
+    policy {
+      group_by = ["..."]
+      matcher {
+        label = "severity"
+        match = "="
+        value = "critical"
+      }
+    }
+  }
+}
+`, string(hcl))
+	})
+
+	t.Run("renders a policy block for every route of the tree", func(t *testing.T) {
+		sut := createNotificationPolicyServiceSut()
+		sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithNestedRoutes
+		tree, err := sut.GetPolicyTree(context.Background(), 1)
+		require.NoError(t, err)
+
+		hcl, err := sut.ExportPolicyTreeHCL(context.Background(), 1)
+
+		require.NoError(t, err)
+		routes := 0
+		walkRoutes(&tree, "", func(*definitions.Route, string) { routes++ })
+		require.Equal(t, routes-1, strings.Count(string(hcl), "policy {"))
+		require.Equal(t, strings.Count(string(hcl), "{"), strings.Count(string(hcl), "}"))
+		require.Contains(t, string(hcl), `    continue = true`)
+		require.Contains(t, string(hcl), `    mute_timings = ["always"]`)
+		require.Contains(t, string(hcl), "      contact_point = \"team-b-critical\"\n")
+	})
+}
+
+func TestHCLString(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{value: "team-a", expected: `"team-a"`},
+		{value: `say "hi"\n`, expected: `"say \"hi\"\\n"`},
+		{value: "line\nbreak", expected: `"line\nbreak"`},
+		{value: "${var.name} and %{if}", expected: `"$${var.name} and %%{if}"`},
+		{value: "$5 and 50%", expected: `"$5 and 50%"`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.value, func(t *testing.T) {
+			require.Equal(t, tc.expected, hclString(tc.value))
+		})
+	}
+}
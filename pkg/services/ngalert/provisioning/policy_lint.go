@@ -0,0 +1,415 @@
+package provisioning
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana/pkg/services/ngalert/api/tooling/definitions"
+	"github.com/grafana/grafana/pkg/services/ngalert/notifier/channels"
+	"github.com/prometheus/alertmanager/asset"
+)
+
+// maxLintRouteDepth is the number of levels routes can be nested below the root before the linter warns about it.
+const maxLintRouteDepth = 5
+
+// LintSeverity tells whether a finding of the policy tree linter makes the tree invalid.
+type LintSeverity string
+
+const (
+	// LintSeverityError is a finding that makes the policy tree invalid, so it cannot be saved.
+	LintSeverityError LintSeverity = "error"
+	// LintSeverityWarning is a likely mistake that does not make the policy tree invalid.
+	LintSeverityWarning LintSeverity = "warning"
+)
+
+// LintFinding is a problem the policy tree linter found. RoutePath is the path of child indexes of the route from
+// the root, which is empty for the root route and for findings about a receiver.
+type LintFinding struct {
+	Severity  LintSeverity `json:"severity"`
+	RoutePath string       `json:"routePath"`
+	Message   string       `json:"message"`
+}
+
+// LintReport is the result of linting a policy tree. Its findings list the errors before the warnings, and both in
+// the order of the routes in the tree.
+type LintReport struct {
+	Findings []LintFinding `json:"findings"`
+}
+
+// HasErrors returns true if the report has findings of error severity.
+func (r *LintReport) HasErrors() bool {
+	for _, finding := range r.Findings {
+		if finding.Severity == LintSeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// LintPolicyTree reports all the problems of the proposed policy tree without saving it, where UpdatePolicyTree stops
+// at the first one. Errors make the tree invalid:
+//   - missing receivers, mute timings or matcher macros
+//   - mute timings whose names do not match the naming pattern
+//   - invalid matchers and bad intervals
+//
+// Warnings are reported for:
+//   - routes that are never reached, or nested too deep
+//   - child routes whose group_by drops the wildcard or labels of their parent, or switches to the wildcard
+//   - receivers without integrations, which drop their notifications
+//   - integrations referencing undefined templates
+//   - the findings of AnalyzePolicyTree
+func (nps *NotificationPolicyService) LintPolicyTree(ctx context.Context, orgID int64, proposed definitions.Route) (*LintReport, error) {
+	revision, err := getLastConfigurationWithLimits(ctx, orgID, nps.amStore, nps.configLimits())
+	if err != nil {
+		return nil, err
+	}
+	return nps.lintPolicyTree(revision, &proposed)
+}
+
+// lintPolicyTree lints the proposed policy tree against the receivers, mute timings and templates of the revision.
+// The matcher macros of the tree are expanded first, as UpdatePolicyTree does, without changing the proposed tree.
+func (nps *NotificationPolicyService) lintPolicyTree(revision *cfgRevision, proposed *definitions.Route) (*LintReport, error) {
+	receivers, err := nps.receiversToMap(revision.cfg.AlertmanagerConfig.Receivers)
+	if err != nil {
+		return nil, err
+	}
+	muteTimes := buildMuteTimesMap(revision.cfg.AlertmanagerConfig.MuteTimeIntervals)
+
+	report := &LintReport{Findings: []LintFinding{}}
+	add := func(severity LintSeverity, path, format string, args ...interface{}) {
+		report.Findings = append(report.Findings, LintFinding{Severity: severity, RoutePath: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	proposed, err = cloneRoute(proposed)
+	if err != nil {
+		return nil, err
+	}
+	if err := expandMatcherMacros(proposed); err != nil {
+		add(LintSeverityError, "", "%s", err.Error())
+	}
+
+	if uid := duplicateRouteUID(proposed); uid != "" {
+		add(LintSeverityError, "", "route UID %q is used more than once", uid)
+	}
+	walkRoutes(proposed, "", func(route *definitions.Route, path string) {
+		if path == "" {
+			if route.Receiver == "" {
+				add(LintSeverityError, path, "the root route must specify a default receiver")
+			}
+			if hasMatchers(route) {
+				add(LintSeverityError, path, "the root route must not have any matchers")
+			}
+			if len(route.MuteTimeIntervals) > 0 {
+				add(LintSeverityError, path, "the root route must not have any mute timings")
+			}
+		}
+		if _, ok := receivers[route.Receiver]; route.Receiver != "" && !ok {
+			add(LintSeverityError, path, "receiver %q does not exist", route.Receiver)
+		}
+		for _, name := range route.MuteTimeIntervals {
+			if _, ok := muteTimes[name]; !ok {
+				add(LintSeverityError, path, "mute timing %q does not exist", name)
+			}
+			if pattern := nps.settings.MuteTimingNamePattern; pattern != nil && !pattern.MatchString(name) {
+				add(LintSeverityError, path, "mute timing %q does not match the naming pattern %q", name, pattern.String())
+			}
+		}
+		for _, problem := range lintMatchers(route) {
+			add(LintSeverityError, path, "%s", problem)
+		}
+		for _, problem := range lintTimings(route) {
+			add(LintSeverityError, path, "%s", problem)
+		}
+
+		if depth := strings.Count(path, ".") + 1; path != "" && depth == maxLintRouteDepth+1 {
+			add(LintSeverityWarning, path, "the route is nested more than %d levels deep, which makes the tree hard to follow", maxLintRouteDepth)
+		}
+		for i := range route.Routes {
+			for j, sibling := range route.Routes[:i] {
+				if !hasMatchers(sibling) && !sibling.Continue {
+					add(LintSeverityWarning, childRoutePath(path, i),
+						"the route is never reached, because the route %s before it matches all alerts and does not continue", childRoutePath(path, j))
+					break
+				}
+			}
+		}
+	})
+
+	lintGroupByOverrides(proposed, "", nil, func(path, message string) {
+		add(LintSeverityWarning, path, "%s", message)
+	})
+
+	for _, receiver := range emptyReceiversUsed(proposed, revision.cfg.AlertmanagerConfig.Receivers) {
+		add(LintSeverityWarning, "", "the receiver %s has no integrations, so the notifications routed to it are dropped", receiver)
+	}
+	undefined, err := undefinedTemplatesUsed(proposed, revision.cfg.AlertmanagerConfig.Receivers, revision.cfg.TemplateFiles)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range undefined {
+		add(LintSeverityWarning, "", "the receiver %s references the template %q, which is not defined, so its notifications fail", ref.receiver, ref.template)
+	}
+	for _, warning := range analyzePolicyTree(proposed, muteTimes) {
+		add(LintSeverityWarning, warning.RoutePath, "%s", warning.Message)
+	}
+
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		return report.Findings[i].Severity == LintSeverityError && report.Findings[j].Severity != LintSeverityError
+	})
+	return report, nil
+}
+
+// childRoutePath returns the path of the i-th child of the route with the given path.
+func childRoutePath(path string, i int) string {
+	if path == "" {
+		return strconv.Itoa(i)
+	}
+	return path + "." + strconv.Itoa(i)
+}
+
+// emptyReceiversUsed returns the names of the receivers without integrations that routes of the tree deliver to,
+// sorted by name.
+func emptyReceiversUsed(tree *definitions.Route, receivers []*definitions.PostableApiReceiver) []string {
+	empty := map[string]bool{}
+	for _, receiver := range receivers {
+		if receiver.Type() == definitions.EmptyReceiverType {
+			empty[receiver.Name] = true
+		}
+	}
+
+	used := map[string]bool{}
+	walkRouteReceivers(tree, "", func(_ *definitions.Route, receiver string) {
+		if empty[receiver] {
+			used[receiver] = true
+		}
+	})
+	names := make([]string, 0, len(used))
+	for name := range used {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var (
+	templateDefinitionRegexp = regexp.MustCompile(`{{-?\s*(?:define|block)\s+"([^"]+)"`)
+	templateReferenceRegexp  = regexp.MustCompile(`{{-?\s*template\s+"([^"]+)"`)
+)
+
+// templateReference is a template referenced by the integrations of a receiver.
+type templateReference struct {
+	receiver string
+	template string
+}
+
+// undefinedTemplatesUsed returns the templates referenced by the integrations of the receivers that routes of the
+// tree deliver to, which are neither defined by the template files nor built in, sorted by receiver and template.
+func undefinedTemplatesUsed(tree *definitions.Route, receivers []*definitions.PostableApiReceiver, templateFiles map[string]string) ([]templateReference, error) {
+	defined, err := builtinTemplateNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, content := range templateFiles {
+		for _, match := range templateDefinitionRegexp.FindAllStringSubmatch(content, -1) {
+			defined[match[1]] = true
+		}
+	}
+
+	used := map[string]bool{}
+	walkRouteReceivers(tree, "", func(_ *definitions.Route, receiver string) {
+		used[receiver] = true
+	})
+
+	var refs []templateReference
+	for _, receiver := range receivers {
+		if !used[receiver.Name] {
+			continue
+		}
+		seen := map[string]bool{}
+		for _, integration := range receiver.GrafanaManagedReceivers {
+			if integration.Settings == nil {
+				continue
+			}
+			visitSettingsStrings(integration.Settings.Interface(), func(value string) {
+				for _, match := range templateReferenceRegexp.FindAllStringSubmatch(value, -1) {
+					if name := match[1]; !defined[name] && !seen[name] {
+						seen[name] = true
+						refs = append(refs, templateReference{receiver: receiver.Name, template: name})
+					}
+				}
+			})
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].receiver != refs[j].receiver {
+			return refs[i].receiver < refs[j].receiver
+		}
+		return refs[i].template < refs[j].template
+	})
+	return refs, nil
+}
+
+// builtinTemplateNames returns the names of the templates every org can use without defining them, which are the
+// default templates of the Alertmanager and of Grafana.
+func builtinTemplateNames() (map[string]bool, error) {
+	f, err := asset.Assets.Open("/templates/default.tmpl")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the default templates: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	alertmanagerDefaults, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the default templates: %w", err)
+	}
+
+	names := map[string]bool{}
+	for _, content := range []string{string(alertmanagerDefaults), channels.DefaultTemplateString} {
+		for _, match := range templateDefinitionRegexp.FindAllStringSubmatch(content, -1) {
+			names[match[1]] = true
+		}
+	}
+	return names, nil
+}
+
+// visitSettingsStrings calls visit with every string in the settings of an integration, including those nested in
+// objects and lists.
+func visitSettingsStrings(settings interface{}, visit func(string)) {
+	switch v := settings.(type) {
+	case string:
+		visit(v)
+	case map[string]interface{}:
+		for _, value := range v {
+			visitSettingsStrings(value, visit)
+		}
+	case []interface{}:
+		for _, value := range v {
+			visitSettingsStrings(value, visit)
+		}
+	}
+}
+
+// hasMatchers returns true if the route has matchers of any kind, i.e. it does not match all alerts.
+func hasMatchers(route *definitions.Route) bool {
+	return len(route.Match) > 0 || len(route.MatchRE) > 0 || len(route.Matchers) > 0 || len(route.ObjectMatchers) > 0
+}
+
+// lintMatchers returns the problems of the matchers of the route. Label names are not validated, as Grafana accepts
+// any label name, but they must not be empty.
+func lintMatchers(route *definitions.Route) []string {
+	var problems []string
+	for name := range route.Match {
+		if name == "" {
+			problems = append(problems, "match has an empty label name")
+		}
+	}
+	for name := range route.MatchRE {
+		if name == "" {
+			problems = append(problems, "match_re has an empty label name")
+		}
+	}
+	for _, m := range route.Matchers {
+		if m.Name == "" {
+			problems = append(problems, fmt.Sprintf("matcher %s has an empty label name", m))
+		}
+	}
+	for _, m := range route.ObjectMatchers {
+		if m.Name == "" {
+			problems = append(problems, fmt.Sprintf("object matcher %s has an empty label name", m))
+		}
+	}
+	return problems
+}
+
+// lintGroupByOverrides calls warn for every child route whose group_by changes the grouping of its parent in a way
+// that is likely accidental: dropping the wildcard (`...`) or labels the parent grouped by collapses distinct alerts
+// into the same notification, while switching to the wildcard can blow up the number of notifications. Adding labels
+// to those of the parent only splits its groups further, so it is not reported. A route without group_by inherits
+// the one of its parent, and inherited is the group_by of the parent of the given route, nil for the root.
+func lintGroupByOverrides(route *definitions.Route, path string, inherited []string, warn func(path, message string)) {
+	groupBy := inherited
+	if len(route.GroupByStr) > 0 {
+		groupBy = route.GroupByStr
+		if path != "" && len(inherited) > 0 {
+			if message := groupByOverrideProblem(inherited, groupBy); message != "" {
+				warn(path, message)
+			}
+		}
+	}
+	for i, child := range route.Routes {
+		lintGroupByOverrides(child, childRoutePath(path, i), groupBy, warn)
+	}
+}
+
+// groupByOverrideProblem describes how the group_by of a route changes the one of its parent, or returns an empty
+// string if it only adds labels to it.
+func groupByOverrideProblem(parent, child []string) string {
+	parentAll, childAll := groupsByAll(parent), groupsByAll(child)
+	switch {
+	case parentAll && childAll:
+		return ""
+	case parentAll:
+		return fmt.Sprintf("the route groups by %s instead of all labels (`...`) like its parent, so distinct alerts can be grouped in the same notification",
+			strings.Join(child, ", "))
+	case childAll:
+		return fmt.Sprintf("the route groups by all labels (`...`) instead of %s like its parent, so every distinct alert is sent in its own notification",
+			strings.Join(parent, ", "))
+	}
+
+	own := map[string]bool{}
+	for _, label := range child {
+		own[label] = true
+	}
+	var dropped []string
+	for _, label := range parent {
+		if !own[label] {
+			dropped = append(dropped, label)
+		}
+	}
+	if len(dropped) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("the route does not group by %s like its parent, so alerts its parent keeps apart are grouped in the same notification",
+		strings.Join(dropped, ", "))
+}
+
+// groupsByAll returns true if the group_by is the wildcard, which groups by all labels.
+func groupsByAll(groupBy []string) bool {
+	for _, label := range groupBy {
+		if label == "..." {
+			return true
+		}
+	}
+	return false
+}
+
+// lintTimings returns the problems of the grouping and timing options of the route.
+func lintTimings(route *definitions.Route) []string {
+	var problems []string
+	seen := map[string]struct{}{}
+	for _, label := range route.GroupByStr {
+		if label == "..." && len(route.GroupByStr) > 1 {
+			problems = append(problems, "group_by cannot have the wildcard (`...`) and other labels at the same time")
+		}
+		if _, ok := seen[label]; ok {
+			problems = append(problems, fmt.Sprintf("duplicated label %q in group_by", label))
+		}
+		seen[label] = struct{}{}
+	}
+
+	if route.GroupWait != nil && time.Duration(*route.GroupWait) < 0 {
+		problems = append(problems, "group_wait cannot be negative")
+	}
+	if route.GroupInterval != nil && time.Duration(*route.GroupInterval) <= 0 {
+		problems = append(problems, "group_interval must be greater than zero")
+	}
+	if route.RepeatInterval != nil && time.Duration(*route.RepeatInterval) <= 0 {
+		problems = append(problems, "repeat_interval must be greater than zero")
+	}
+	return problems
+}
@@ -0,0 +1,299 @@
+package provisioning
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/services/ngalert/api/tooling/definitions"
+	"github.com/prometheus/alertmanager/pkg/labels"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLintPolicyTree(t *testing.T) {
+	matchTeam := func(team string) definitions.ObjectMatchers {
+		return definitions.ObjectMatchers{{Type: labels.MatchEqual, Name: "team", Value: team}}
+	}
+
+	t.Run("reports errors and warnings together", func(t *testing.T) {
+		sut := createNotificationPolicyServiceSut()
+		sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = withEmailIntegrations(configWithNestedRoutes, allNestedRoutesReceivers...)
+		zero := model.Duration(0)
+		tree := definitions.Route{
+			Receiver: "grafana-default-email",
+			Routes: []*definitions.Route{{
+				Receiver:          "team-a",
+				ObjectMatchers:    matchTeam("a"),
+				MuteTimeIntervals: []string{"missing"},
+				RepeatInterval:    &zero,
+			}, {
+				Receiver:       "team-unknown",
+				ObjectMatchers: matchTeam("b"),
+			}, {
+				Receiver: "team-b",
+			}, {
+				Receiver:          "team-c",
+				ObjectMatchers:    matchTeam("c"),
+				MuteTimeIntervals: []string{"always"},
+			}},
+		}
+
+		report, err := sut.LintPolicyTree(context.Background(), 1, tree)
+
+		require.NoError(t, err)
+		require.True(t, report.HasErrors())
+		require.Len(t, report.Findings, 6)
+		expected := []struct {
+			severity LintSeverity
+			path     string
+		}{
+			{LintSeverityError, "0"},
+			{LintSeverityError, "0"},
+			{LintSeverityError, "1"},
+			{LintSeverityWarning, "3"},
+			{LintSeverityWarning, "3"},
+			{LintSeverityWarning, ""},
+		}
+		for i, e := range expected {
+			require.Equal(t, e.severity, report.Findings[i].Severity, report.Findings[i].Message)
+			require.Equal(t, e.path, report.Findings[i].RoutePath, report.Findings[i].Message)
+		}
+		require.Contains(t, report.Findings[0].Message, "missing")
+		require.Contains(t, report.Findings[1].Message, "repeat_interval")
+		require.Contains(t, report.Findings[2].Message, "team-unknown")
+		require.Contains(t, report.Findings[3].Message, "never reached")
+		require.Contains(t, report.Findings[4].Message, "always")
+		require.Contains(t, report.Findings[5].Message, "team-c")
+	})
+
+	t.Run("warns about deeply nested routes", func(t *testing.T) {
+		sut := createNotificationPolicyServiceSut()
+		sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = withEmailIntegrations(configWithNestedRoutes, allNestedRoutesReceivers...)
+		tree := definitions.Route{Receiver: "grafana-default-email"}
+		parent := &tree
+		for i := 0; i < maxLintRouteDepth+2; i++ {
+			child := &definitions.Route{ObjectMatchers: matchTeam("a")}
+			parent.Routes = []*definitions.Route{child}
+			parent = child
+		}
+
+		report, err := sut.LintPolicyTree(context.Background(), 1, tree)
+
+		require.NoError(t, err)
+		require.False(t, report.HasErrors())
+		require.Len(t, report.Findings, 1)
+		require.Equal(t, LintSeverityWarning, report.Findings[0].Severity)
+		require.Equal(t, "0.0.0.0.0.0", report.Findings[0].RoutePath)
+	})
+
+	t.Run("warns about child routes overriding the group_by of their parent", func(t *testing.T) {
+		sut := createNotificationPolicyServiceSut()
+		sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = withEmailIntegrations(configWithNestedRoutes, allNestedRoutesReceivers...)
+		tree := definitions.Route{
+			Receiver:   "grafana-default-email",
+			GroupByStr: []string{"alertname", "grafana_folder"},
+			Routes: []*definitions.Route{{
+				ObjectMatchers: matchTeam("a"),
+				GroupByStr:     []string{"alertname", "grafana_folder", "instance"},
+			}, {
+				ObjectMatchers: matchTeam("b"),
+				GroupByStr:     []string{"alertname"},
+				Routes: []*definitions.Route{{
+					ObjectMatchers: matchTeam("b-critical"),
+				}},
+			}, {
+				ObjectMatchers: matchTeam("c"),
+				GroupByStr:     []string{"..."},
+				Routes: []*definitions.Route{{
+					ObjectMatchers: matchTeam("c-critical"),
+					GroupByStr:     []string{"cluster"},
+				}},
+			}},
+		}
+
+		report, err := sut.LintPolicyTree(context.Background(), 1, tree)
+
+		require.NoError(t, err)
+		require.False(t, report.HasErrors())
+		require.Equal(t, []LintFinding{{
+			Severity:  LintSeverityWarning,
+			RoutePath: "1",
+			Message:   "the route does not group by grafana_folder like its parent, so alerts its parent keeps apart are grouped in the same notification",
+		}, {
+			Severity:  LintSeverityWarning,
+			RoutePath: "2",
+			Message:   "the route groups by all labels (`...`) instead of alertname, grafana_folder like its parent, so every distinct alert is sent in its own notification",
+		}, {
+			Severity:  LintSeverityWarning,
+			RoutePath: "2.0",
+			Message:   "the route groups by cluster instead of all labels (`...`) like its parent, so distinct alerts can be grouped in the same notification",
+		}}, report.Findings)
+	})
+
+	t.Run("a valid tree without likely mistakes has no findings", func(t *testing.T) {
+		sut := createNotificationPolicyServiceSut()
+		sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = withEmailIntegrations(configWithNestedRoutes, allNestedRoutesReceivers...)
+		tree, err := sut.GetPolicyTree(context.Background(), 1)
+		require.NoError(t, err)
+		tree.Routes = tree.Routes[:3]
+
+		report, err := sut.LintPolicyTree(context.Background(), 1, tree)
+
+		require.NoError(t, err)
+		require.False(t, report.HasErrors())
+		require.Empty(t, report.Findings)
+	})
+
+	t.Run("warns about receivers without integrations", func(t *testing.T) {
+		sut := createNotificationPolicyServiceSut()
+		sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = withEmailIntegrations(configWithNestedRoutes,
+			"grafana-default-email", "team-a", "team-b", "team-c")
+		tree, err := sut.GetPolicyTree(context.Background(), 1)
+		require.NoError(t, err)
+		tree.Routes = tree.Routes[:3]
+
+		report, err := sut.LintPolicyTree(context.Background(), 1, tree)
+
+		require.NoError(t, err)
+		require.False(t, report.HasErrors())
+		require.Len(t, report.Findings, 2)
+		require.Equal(t, LintSeverityWarning, report.Findings[0].Severity)
+		require.Empty(t, report.Findings[0].RoutePath)
+		require.Contains(t, report.Findings[0].Message, "team-a-escalation")
+		require.Contains(t, report.Findings[1].Message, "team-b-critical")
+	})
+
+	t.Run("warns about undefined templates referenced by used receivers", func(t *testing.T) {
+		config := withEmailIntegrations(configWithNestedRoutes, allNestedRoutesReceivers...)
+		config = withEmailSubject(config, "team-a", `{{ template \"team-a.subject\" . }}`)
+		config = withEmailSubject(config, "team-b", `{{ template \"team.subject\" . }}`)
+		config = withEmailSubject(config, "team-b-critical", `{{ template \"default.title\" . }}`)
+		config = withEmailSubject(config, "team-c", `{{ template \"team-c.subject\" . }}`)
+		config = strings.Replace(config, `"alertmanager_config": {`, `"template_files": {
+			"team": "{{ define \"team.subject\" }}{{ .CommonLabels.team }}{{ end }}"
+		},
+		"alertmanager_config": {`, 1)
+
+		sut := createNotificationPolicyServiceSut()
+		sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = config
+		tree, err := sut.GetPolicyTree(context.Background(), 1)
+		require.NoError(t, err)
+		tree.Routes = tree.Routes[:2]
+
+		report, err := sut.LintPolicyTree(context.Background(), 1, tree)
+
+		require.NoError(t, err)
+		require.False(t, report.HasErrors())
+		require.Equal(t, []LintFinding{{
+			Severity: LintSeverityWarning,
+			Message:  `the receiver team-a references the template "team-a.subject", which is not defined, so its notifications fail`,
+		}}, report.Findings)
+	})
+}
+
+func TestLintPolicyTree_MuteTimingNamePattern(t *testing.T) {
+	sut := createNotificationPolicyServiceSut()
+	sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithTeamMuteTimings
+	sut.settings.MuteTimingNamePattern = regexp.MustCompile("^(?:team-.+)$")
+	tree := definitions.Route{
+		Receiver: "grafana-default-email",
+		Routes: []*definitions.Route{{
+			Receiver:          "team-a",
+			ObjectMatchers:    definitions.ObjectMatchers{{Type: labels.MatchEqual, Name: "team", Value: "a"}},
+			MuteTimeIntervals: []string{"weekends"},
+		}, {
+			Receiver:          "team-a",
+			ObjectMatchers:    definitions.ObjectMatchers{{Type: labels.MatchEqual, Name: "team", Value: "b"}},
+			MuteTimeIntervals: []string{"team-a-nights", "weekends"},
+		}},
+	}
+
+	report, err := sut.LintPolicyTree(context.Background(), 1, tree)
+
+	require.NoError(t, err)
+	var errs []LintFinding
+	for _, finding := range report.Findings {
+		if finding.Severity == LintSeverityError {
+			errs = append(errs, finding)
+		}
+	}
+	require.Equal(t, []LintFinding{{
+		Severity:  LintSeverityError,
+		RoutePath: "0",
+		Message:   `mute timing "weekends" does not match the naming pattern "^(?:team-.+)$"`,
+	}, {
+		Severity:  LintSeverityError,
+		RoutePath: "1",
+		Message:   `mute timing "weekends" does not match the naming pattern "^(?:team-.+)$"`,
+	}}, errs)
+}
+
+func TestLintPolicyTree_MatcherMacros(t *testing.T) {
+	sut := createNotificationPolicyServiceSut()
+	sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = withEmailIntegrations(configWithNestedRoutes, allNestedRoutesReceivers...)
+
+	t.Run("routes matching with macros are not catch-all", func(t *testing.T) {
+		tree := definitions.Route{
+			Receiver: "grafana-default-email",
+			MatcherMacros: map[string]definitions.ObjectMatchers{
+				"team-a": {{Type: labels.MatchEqual, Name: "team", Value: "a"}},
+			},
+			Routes: []*definitions.Route{{
+				Receiver:         "team-a",
+				UseMatcherMacros: []string{"team-a"},
+			}, {
+				Receiver:       "team-b",
+				ObjectMatchers: definitions.ObjectMatchers{{Type: labels.MatchEqual, Name: "team", Value: "b"}},
+			}},
+		}
+
+		report, err := sut.LintPolicyTree(context.Background(), 1, tree)
+
+		require.NoError(t, err)
+		require.Empty(t, report.Findings)
+		require.Equal(t, []string{"team-a"}, tree.Routes[0].UseMatcherMacros)
+	})
+
+	t.Run("an undefined macro is an error", func(t *testing.T) {
+		tree := definitions.Route{
+			Receiver: "grafana-default-email",
+			Routes: []*definitions.Route{{
+				Receiver:         "team-a",
+				UseMatcherMacros: []string{"team-a"},
+			}},
+		}
+
+		report, err := sut.LintPolicyTree(context.Background(), 1, tree)
+
+		require.NoError(t, err)
+		require.True(t, report.HasErrors())
+		require.Equal(t, LintFinding{
+			Severity: LintSeverityError,
+			Message:  `the matcher macro "team-a" is not defined`,
+		}, report.Findings[0])
+	})
+}
+
+// allNestedRoutesReceivers are the receivers of configWithNestedRoutes.
+var allNestedRoutesReceivers = []string{"grafana-default-email", "team-a", "team-a-escalation", "team-b", "team-b-critical", "team-c"}
+
+// withEmailIntegrations returns the configuration with an email integration added to each of the given receivers,
+// which must have none.
+func withEmailIntegrations(config string, receivers ...string) string {
+	for _, name := range receivers {
+		config = strings.Replace(config, fmt.Sprintf(`{"name": %q}`, name), fmt.Sprintf(`{"name": %q, "grafana_managed_receiver_configs": [{
+			"uid": %q, "name": %q, "type": "email", "settings": {"addresses": "<example@email.com>"}
+		}]}`, name, name, name), 1)
+	}
+	return config
+}
+
+// withEmailSubject sets the subject of the email integration that withEmailIntegrations added to the receiver.
+func withEmailSubject(config string, receiver string, subject string) string {
+	return strings.Replace(config,
+		fmt.Sprintf(`"uid": %q, "name": %q, "type": "email", "settings": {"addresses": "<example@email.com>"}`, receiver, receiver),
+		fmt.Sprintf(`"uid": %q, "name": %q, "type": "email", "settings": {"addresses": "<example@email.com>", "subject": "%s"}`, receiver, receiver, subject), 1)
+}
@@ -0,0 +1,113 @@
+package provisioning
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana/pkg/services/ngalert/api/tooling/definitions"
+)
+
+// NotificationHistory tells when the receivers of an org last sent notifications.
+type NotificationHistory interface {
+	// LastNotified returns the time each receiver of the org last sent a notification at. Receivers that never sent
+	// one are left out.
+	LastNotified(ctx context.Context, orgID int64) (map[string]time.Time, error)
+}
+
+// ReceiverUsageVerdict tells how safe it is to delete a receiver, given whether the policy tree delivers to it and
+// whether it sent notifications recently.
+type ReceiverUsageVerdict string
+
+const (
+	// ReceiverUsageActive is a receiver the policy tree delivers to, which sent notifications recently.
+	ReceiverUsageActive ReceiverUsageVerdict = "active"
+	// ReceiverUsageNeverNotified is a receiver the policy tree delivers to, which sent no notification recently.
+	// Unless its routes are rarely matched, this may be a routing mistake.
+	ReceiverUsageNeverNotified ReceiverUsageVerdict = "never-notified"
+	// ReceiverUsageUnreferenced is a receiver the policy tree does not deliver to, which sent notifications recently,
+	// for example because it was removed from the tree since.
+	ReceiverUsageUnreferenced ReceiverUsageVerdict = "unreferenced"
+	// ReceiverUsageUnused is a receiver the policy tree does not deliver to, which sent no notification recently.
+	// It is safe to delete.
+	ReceiverUsageUnused ReceiverUsageVerdict = "unused"
+	// ReceiverUsageUnknown is a receiver whose notifications are unknown, because there is no notification history.
+	ReceiverUsageUnknown ReceiverUsageVerdict = "unknown"
+)
+
+// ReceiverUsage is how a receiver of the org is used. RouteUIDs are the UIDs of the routes that deliver to the
+// receiver, including those inheriting it from their parent. LastNotified is the last time the receiver sent a
+// notification at, if it did since the start of the report.
+type ReceiverUsage struct {
+	Receiver     string               `json:"receiver"`
+	RouteUIDs    []string             `json:"routeUids"`
+	LastNotified *time.Time           `json:"lastNotified,omitempty"`
+	Verdict      ReceiverUsageVerdict `json:"verdict"`
+}
+
+// ReceiverUsageReport combines, for each receiver of the org, the routes of the policy tree that deliver to it with
+// the last notification it sent since the given time, to tell which receivers can be deleted. The notifications are
+// read from the given history, which may be nil if there is none, in which case every verdict is unknown. The
+// receivers are sorted by name.
+func (nps *NotificationPolicyService) ReceiverUsageReport(ctx context.Context, orgID int64, history NotificationHistory, since time.Time) ([]ReceiverUsage, error) {
+	revision, err := getLastConfigurationWithLimits(ctx, orgID, nps.amStore, nps.configLimits())
+	if err != nil {
+		return nil, err
+	}
+
+	tree := revision.cfg.AlertmanagerConfig.Config.Route
+	if tree == nil {
+		return nil, fmt.Errorf("no route present in current alertmanager config")
+	}
+	fillDerivedRouteUIDs(tree)
+
+	var lastNotified map[string]time.Time
+	if history != nil {
+		lastNotified, err = history.LastNotified(ctx, orgID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the notification history: %w", err)
+		}
+	}
+
+	routeUIDs := map[string][]string{}
+	walkRouteReceivers(tree, "", func(route *definitions.Route, receiver string) {
+		routeUIDs[receiver] = append(routeUIDs[receiver], route.UID)
+	})
+
+	report := make([]ReceiverUsage, 0, len(revision.cfg.AlertmanagerConfig.Receivers))
+	for _, receiver := range revision.cfg.AlertmanagerConfig.Receivers {
+		usage := ReceiverUsage{
+			Receiver:  receiver.Name,
+			RouteUIDs: routeUIDs[receiver.Name],
+		}
+		if usage.RouteUIDs == nil {
+			usage.RouteUIDs = []string{}
+		}
+		if at, ok := lastNotified[receiver.Name]; ok && !at.Before(since) {
+			usage.LastNotified = &at
+		}
+		usage.Verdict = receiverUsageVerdict(len(usage.RouteUIDs) > 0, usage.LastNotified != nil, history != nil)
+		report = append(report, usage)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Receiver < report[j].Receiver })
+
+	return report, nil
+}
+
+// receiverUsageVerdict returns the verdict for a receiver, given whether the policy tree delivers to it, and whether
+// it sent notifications recently according to the history, if there is one.
+func receiverUsageVerdict(referenced, notified, hasHistory bool) ReceiverUsageVerdict {
+	switch {
+	case !hasHistory:
+		return ReceiverUsageUnknown
+	case referenced && notified:
+		return ReceiverUsageActive
+	case referenced:
+		return ReceiverUsageNeverNotified
+	case notified:
+		return ReceiverUsageUnreferenced
+	default:
+		return ReceiverUsageUnused
+	}
+}
@@ -0,0 +1,101 @@
+package provisioning
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReceiverUsageReport(t *testing.T) {
+	now := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	since := now.Add(-30 * 24 * time.Hour)
+	configWithLegacyReceivers := strings.Replace(configWithNestedRoutes, `{"name": "team-c"}`,
+		`{"name": "team-c"}, {"name": "legacy"}, {"name": "legacy-recent"}`, 1)
+
+	createSut := func() *NotificationPolicyService {
+		sut := createNotificationPolicyServiceSut()
+		sut.amStore.(*fakeAMConfigStore).config.AlertmanagerConfiguration = configWithLegacyReceivers
+		return sut
+	}
+	verdicts := func(report []ReceiverUsage) map[string]ReceiverUsageVerdict {
+		result := map[string]ReceiverUsageVerdict{}
+		for _, usage := range report {
+			result[usage.Receiver] = usage.Verdict
+		}
+		return result
+	}
+
+	t.Run("combines the routes of the tree with the recent notifications", func(t *testing.T) {
+		history := fakeNotificationHistory{lastNotified: map[string]time.Time{
+			"grafana-default-email": now,
+			"team-a":                now.Add(-time.Hour),
+			"team-b":                since.Add(-time.Hour),
+			"legacy-recent":         now.Add(-24 * time.Hour),
+		}}
+
+		report, err := createSut().ReceiverUsageReport(context.Background(), 1, history, since)
+
+		require.NoError(t, err)
+		require.Equal(t, map[string]ReceiverUsageVerdict{
+			"grafana-default-email": ReceiverUsageActive,
+			"legacy":                ReceiverUsageUnused,
+			"legacy-recent":         ReceiverUsageUnreferenced,
+			"team-a":                ReceiverUsageActive,
+			"team-a-escalation":     ReceiverUsageNeverNotified,
+			"team-b":                ReceiverUsageNeverNotified,
+			"team-b-critical":       ReceiverUsageNeverNotified,
+			"team-c":                ReceiverUsageNeverNotified,
+		}, verdicts(report))
+		require.Equal(t, "grafana-default-email", report[0].Receiver)
+		require.Equal(t, now, *report[0].LastNotified)
+		require.Equal(t, "team-b", report[5].Receiver)
+		require.Nil(t, report[5].LastNotified)
+	})
+
+	t.Run("lists the routes delivering to each receiver", func(t *testing.T) {
+		sut := createSut()
+		tree, err := sut.GetPolicyTree(context.Background(), 1)
+		require.NoError(t, err)
+
+		report, err := sut.ReceiverUsageReport(context.Background(), 1, fakeNotificationHistory{}, since)
+
+		require.NoError(t, err)
+		require.Equal(t, "legacy", report[1].Receiver)
+		require.Empty(t, report[1].RouteUIDs)
+		require.Equal(t, "team-b", report[5].Receiver)
+		require.Equal(t, []string{tree.Routes[1].UID}, report[5].RouteUIDs)
+		require.Equal(t, "team-b-critical", report[6].Receiver)
+		require.Equal(t, []string{tree.Routes[1].Routes[0].UID}, report[6].RouteUIDs)
+	})
+
+	t.Run("verdicts are unknown without a notification history", func(t *testing.T) {
+		report, err := createSut().ReceiverUsageReport(context.Background(), 1, nil, since)
+
+		require.NoError(t, err)
+		require.Len(t, report, 8)
+		for _, usage := range report {
+			require.Equal(t, ReceiverUsageUnknown, usage.Verdict, usage.Receiver)
+		}
+	})
+
+	t.Run("fails if the notification history cannot be read", func(t *testing.T) {
+		history := fakeNotificationHistory{err: errors.New("unavailable")}
+
+		_, err := createSut().ReceiverUsageReport(context.Background(), 1, history, since)
+
+		require.ErrorContains(t, err, "unavailable")
+	})
+}
+
+type fakeNotificationHistory struct {
+	lastNotified map[string]time.Time
+	err          error
+}
+
+func (f fakeNotificationHistory) LastNotified(context.Context, int64) (map[string]time.Time, error) {
+	return f.lastNotified, f.err
+}
@@ -0,0 +1,353 @@
+package provisioning
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana/pkg/services/ngalert/api/tooling/definitions"
+	"github.com/grafana/grafana/pkg/util"
+	"github.com/prometheus/alertmanager/config"
+	"github.com/prometheus/alertmanager/dispatch"
+	"github.com/prometheus/alertmanager/timeinterval"
+	"github.com/prometheus/common/model"
+)
+
+var timeNow = time.Now
+
+// matchRoutes returns the routes of the tree that an alert with the given labels is dispatched to,
+// in the same order as the Alertmanager would.
+func matchRoutes(tree *definitions.Route, labels model.LabelSet) []*dispatch.Route {
+	return dispatch.NewRoute(tree.AsAMRoute(), nil).Match(labels)
+}
+
+// buildMuteTimesMap indexes the time intervals of each mute timing by its name.
+func buildMuteTimesMap(intervals []config.MuteTimeInterval) map[string][]timeinterval.TimeInterval {
+	muteTimes := make(map[string][]timeinterval.TimeInterval, len(intervals))
+	for _, mt := range intervals {
+		muteTimes[mt.Name] = mt.TimeIntervals
+	}
+	return muteTimes
+}
+
+// isRouteMuted returns true if any of the mute timings of the route is active at the given time.
+func isRouteMuted(route *dispatch.Route, muteTimes map[string][]timeinterval.TimeInterval, now time.Time) bool {
+	for _, name := range route.RouteOpts.MuteTimeIntervals {
+		for _, ti := range muteTimes[name] {
+			if ti.ContainsTime(now) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+const minutesPerDay = 24 * 60
+
+// coversWholeWeek returns true if the time intervals together contain every minute of the week, so that a route
+// muted by them never notifies. Time intervals restricted to some days of the month, months or years are left out,
+// as they only apply to part of the year.
+func coversWholeWeek(intervals []timeinterval.TimeInterval) bool {
+	var covered [7 * minutesPerDay]bool
+	for _, ti := range intervals {
+		if len(ti.DaysOfMonth) > 0 || len(ti.Months) > 0 || len(ti.Years) > 0 {
+			continue
+		}
+
+		weekdays := ti.Weekdays
+		if len(weekdays) == 0 {
+			weekdays = []timeinterval.WeekdayRange{{InclusiveRange: timeinterval.InclusiveRange{Begin: 0, End: 6}}}
+		}
+		times := ti.Times
+		if len(times) == 0 {
+			times = []timeinterval.TimeRange{{StartMinute: 0, EndMinute: minutesPerDay}}
+		}
+
+		for _, days := range weekdays {
+			for day := days.Begin; day <= days.End && day < 7; day++ {
+				for _, tr := range times {
+					for minute := tr.StartMinute; minute < tr.EndMinute && minute < minutesPerDay; minute++ {
+						covered[day*minutesPerDay+minute] = true
+					}
+				}
+			}
+		}
+	}
+
+	for _, c := range covered {
+		if !c {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveReceivers returns the distinct receivers an alert with the given labels is delivered to at the given time.
+// Matching routes that are currently muted are left out, as the Alertmanager would not notify through them.
+func resolveReceivers(tree *definitions.Route, muteTimes map[string][]timeinterval.TimeInterval, labels model.LabelSet, now time.Time) []string {
+	receivers := []string{}
+	seen := map[string]struct{}{}
+	for _, route := range matchRoutes(tree, labels) {
+		if isRouteMuted(route, muteTimes, now) {
+			continue
+		}
+		if _, ok := seen[route.RouteOpts.Receiver]; ok {
+			continue
+		}
+		seen[route.RouteOpts.Receiver] = struct{}{}
+		receivers = append(receivers, route.RouteOpts.Receiver)
+	}
+	return receivers
+}
+
+// referencedReceivers returns the names of the receivers used by any route of the tree.
+func referencedReceivers(tree *definitions.Route) map[string]struct{} {
+	receivers := map[string]struct{}{}
+	var visit func(route *definitions.Route)
+	visit = func(route *definitions.Route) {
+		if route.Receiver != "" {
+			receivers[route.Receiver] = struct{}{}
+		}
+		for _, child := range route.Routes {
+			visit(child)
+		}
+	}
+	visit(tree)
+	return receivers
+}
+
+// RouteRef is a route of the policy tree referencing a receiver or a mute timing. The route is identified by its UID,
+// and by its path of child indexes from the root.
+type RouteRef struct {
+	RouteUID  string
+	RoutePath string
+	// Receiver is the receiver the route delivers to, which it may inherit from its parent.
+	Receiver string
+	// ReceiverMuted is only set by RoutesUsingMuteTiming, when every route delivering to the receiver references the
+	// mute timing, so that the receiver gets no notifications at all during its window.
+	ReceiverMuted bool
+}
+
+// walkRoutes calls visit for each route of the tree, along with its path of child indexes from the root, e.g. "1.0" for
+// the first child of the second top-level route. The path of the root is empty.
+func walkRoutes(route *definitions.Route, path string, visit func(route *definitions.Route, path string)) {
+	visit(route, path)
+	for i, child := range route.Routes {
+		childPath := strconv.Itoa(i)
+		if path != "" {
+			childPath = path + "." + childPath
+		}
+		walkRoutes(child, childPath, visit)
+	}
+}
+
+// walkRouteReceivers calls visit for each route of the tree, along with the receiver the route delivers to. A route
+// without a receiver delivers to the receiver of its parent.
+func walkRouteReceivers(route *definitions.Route, receiver string, visit func(route *definitions.Route, receiver string)) {
+	if route.Receiver != "" {
+		receiver = route.Receiver
+	}
+	visit(route, receiver)
+	for _, child := range route.Routes {
+		walkRouteReceivers(child, receiver, visit)
+	}
+}
+
+// fillDerivedRouteUIDs gives each route of the tree that has no UID one derived from its position in the tree.
+// Routes get a UID when they are first saved, which is stored with them in the Alertmanager configuration and does not
+// change when other routes of the tree are added, removed, reordered or edited. Only the routes of a configuration
+// saved before routes had UIDs are left without one, and the derived UIDs are persisted the next time the tree is
+// saved.
+func fillDerivedRouteUIDs(tree *definitions.Route) {
+	walkRoutes(tree, "", func(route *definitions.Route, path string) {
+		if route.UID == "" {
+			sum := sha256.Sum256([]byte("route:" + path))
+			route.UID = hex.EncodeToString(sum[:])[:14]
+		}
+	})
+}
+
+// inheritRouteUIDs gives each route of the updated tree that has no UID the UID of the route at the same position in
+// the stored tree, unless the updated tree already uses it. This keeps the UIDs of a tree that is saved without them,
+// e.g. from a provisioning file, unchanged. Any route still left without a UID gets a new one.
+func inheritRouteUIDs(stored, updated *definitions.Route) {
+	used := map[string]bool{}
+	walkRoutes(updated, "", func(route *definitions.Route, _ string) {
+		if route.UID != "" {
+			used[route.UID] = true
+		}
+	})
+
+	var inherit func(stored, updated *definitions.Route)
+	inherit = func(stored, updated *definitions.Route) {
+		if updated.UID == "" {
+			if stored != nil && stored.UID != "" && !used[stored.UID] {
+				updated.UID = stored.UID
+			} else {
+				updated.UID = util.GenerateShortUID()
+			}
+			used[updated.UID] = true
+		}
+		for i, child := range updated.Routes {
+			var storedChild *definitions.Route
+			if stored != nil && i < len(stored.Routes) {
+				storedChild = stored.Routes[i]
+			}
+			inherit(storedChild, child)
+		}
+	}
+	inherit(stored, updated)
+}
+
+// duplicateRouteUID returns a UID that more than one route of the tree uses, or the empty string if there is none.
+func duplicateRouteUID(tree *definitions.Route) string {
+	seen := map[string]bool{}
+	duplicate := ""
+	walkRoutes(tree, "", func(route *definitions.Route, _ string) {
+		if route.UID == "" || duplicate != "" {
+			return
+		}
+		if seen[route.UID] {
+			duplicate = route.UID
+		}
+		seen[route.UID] = true
+	})
+	return duplicate
+}
+
+// findRoute returns the route of the tree with the given UID, or nil if there is none. The empty UID refers to the root.
+func findRoute(tree *definitions.Route, uid string) *definitions.Route {
+	if uid == "" || tree.UID == uid {
+		return tree
+	}
+	for _, child := range tree.Routes {
+		if route := findRoute(child, uid); route != nil {
+			return route
+		}
+	}
+	return nil
+}
+
+// markEditableRoutes sets whether each route of the tree can be changed through the API, which is not the case of the
+// routes of the subtrees provisioned from file, given by the UIDs of their roots, nor of the routes below them.
+func markEditableRoutes(route *definitions.Route, locked map[string]bool, parentLocked bool) {
+	isLocked := parentLocked || locked[route.UID]
+	editable := !isLocked
+	route.Editable = &editable
+	for _, child := range route.Routes {
+		markEditableRoutes(child, locked, isLocked)
+	}
+}
+
+// expandMatcherMacros adds the matchers of the matcher macros defined on the root of the tree to the object matchers
+// of the routes that use them, and then removes the macros from the tree, so that the saved tree is a plain
+// Alertmanager routing tree.
+func expandMatcherMacros(tree *definitions.Route) error {
+	macros := tree.MatcherMacros
+	for name, matchers := range macros {
+		if len(matchers) == 0 {
+			return fmt.Errorf("the matcher macro %q has no matchers", name)
+		}
+	}
+	if len(tree.UseMatcherMacros) > 0 {
+		return fmt.Errorf("the root route cannot use matcher macros, as it must not have any matchers")
+	}
+
+	var err error
+	for _, child := range tree.Routes {
+		walkRoutes(child, "", func(route *definitions.Route, _ string) {
+			if err != nil {
+				return
+			}
+			if len(route.MatcherMacros) > 0 {
+				err = fmt.Errorf("matcher macros can only be defined on the root route")
+				return
+			}
+			for _, name := range route.UseMatcherMacros {
+				matchers, ok := macros[name]
+				if !ok {
+					err = fmt.Errorf("the matcher macro %q is not defined", name)
+					return
+				}
+				route.ObjectMatchers = append(route.ObjectMatchers, matchers...)
+			}
+			route.UseMatcherMacros = nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	tree.MatcherMacros = nil
+	return nil
+}
+
+// replaceRoute replaces the route of the tree with the given UID and returns the resulting tree.
+// It returns false if there is no route with that UID.
+func replaceRoute(tree *definitions.Route, uid string, route *definitions.Route) (*definitions.Route, bool) {
+	if uid == "" || tree.UID == uid {
+		return route, true
+	}
+	for i, child := range tree.Routes {
+		if child.UID == uid {
+			tree.Routes[i] = route
+			return tree, true
+		}
+		if _, ok := replaceRoute(child, uid, route); ok {
+			return tree, true
+		}
+	}
+	return nil, false
+}
+
+// effectiveRoute resolves the options each route of the tree inherits from its parents, or from the defaults of the
+// Alertmanager for the root, in the same way the Alertmanager does when it builds its routing tree.
+func effectiveRoute(tree *definitions.Route) *definitions.EffectiveRoute {
+	return buildEffectiveRoute(tree, dispatch.NewRoute(tree.AsAMRoute(), nil))
+}
+
+func buildEffectiveRoute(route *definitions.Route, resolved *dispatch.Route) *definitions.EffectiveRoute {
+	opts := resolved.RouteOpts
+	result := &definitions.EffectiveRoute{
+		Receiver:          opts.Receiver,
+		GroupWait:         model.Duration(opts.GroupWait),
+		GroupInterval:     model.Duration(opts.GroupInterval),
+		RepeatInterval:    model.Duration(opts.RepeatInterval),
+		ObjectMatchers:    route.ObjectMatchers,
+		MuteTimeIntervals: route.MuteTimeIntervals,
+		Continue:          route.Continue,
+		UID:               route.UID,
+	}
+	if opts.GroupByAll {
+		result.GroupBy = []string{"..."}
+	} else {
+		result.GroupBy = make([]string, 0, len(opts.GroupBy))
+		for label := range opts.GroupBy {
+			result.GroupBy = append(result.GroupBy, string(label))
+		}
+		sort.Strings(result.GroupBy)
+	}
+
+	if route.Receiver == "" {
+		result.Inherited = append(result.Inherited, "receiver")
+	}
+	if route.GroupByStr == nil {
+		result.Inherited = append(result.Inherited, "group_by")
+	}
+	if route.GroupWait == nil {
+		result.Inherited = append(result.Inherited, "group_wait")
+	}
+	if route.GroupInterval == nil {
+		result.Inherited = append(result.Inherited, "group_interval")
+	}
+	if route.RepeatInterval == nil {
+		result.Inherited = append(result.Inherited, "repeat_interval")
+	}
+
+	for i, child := range route.Routes {
+		result.Routes = append(result.Routes, buildEffectiveRoute(child, resolved.Routes[i]))
+	}
+	return result
+}
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/grafana/grafana/pkg/infra/kvstore"
 	"github.com/grafana/grafana/pkg/services/ngalert/models"
+	"github.com/grafana/grafana/pkg/services/ngalert/state"
 	mock "github.com/stretchr/testify/mock"
 )
 
@@ -146,6 +148,65 @@ func (n *NopTransactionManager) InTransaction(ctx context.Context, work func(ctx
 	return work(ctx)
 }
 
+type fakeKVStore struct {
+	store map[int64]map[string]map[string]string
+}
+
+func newFakeKVStore() *fakeKVStore {
+	return &fakeKVStore{
+		store: map[int64]map[string]map[string]string{},
+	}
+}
+
+func (f *fakeKVStore) Get(_ context.Context, orgID int64, namespace string, key string) (string, bool, error) {
+	v, ok := f.store[orgID][namespace][key]
+	return v, ok, nil
+}
+
+func (f *fakeKVStore) Set(_ context.Context, orgID int64, namespace string, key string, value string) error {
+	if _, ok := f.store[orgID]; !ok {
+		f.store[orgID] = map[string]map[string]string{}
+	}
+	if _, ok := f.store[orgID][namespace]; !ok {
+		f.store[orgID][namespace] = map[string]string{}
+	}
+	f.store[orgID][namespace][key] = value
+	return nil
+}
+
+func (f *fakeKVStore) Del(_ context.Context, orgID int64, namespace string, key string) error {
+	delete(f.store[orgID][namespace], key)
+	return nil
+}
+
+func (f *fakeKVStore) Keys(_ context.Context, orgID int64, namespace string, keyPrefix string) ([]kvstore.Key, error) {
+	var keys []kvstore.Key
+	for key := range f.store[orgID][namespace] {
+		if strings.HasPrefix(key, keyPrefix) {
+			keys = append(keys, kvstore.Key{OrgId: orgID, Namespace: namespace, Key: key})
+		}
+	}
+	return keys, nil
+}
+
+func (f *fakeKVStore) GetAll(_ context.Context, orgID int64, namespace string) (map[int64]map[string]string, error) {
+	return map[int64]map[string]string{orgID: f.store[orgID][namespace]}, nil
+}
+
+type fakeAlertStateReader struct {
+	states []*state.State
+}
+
+func (f *fakeAlertStateReader) GetAll(orgID int64) []*state.State {
+	var states []*state.State
+	for _, s := range f.states {
+		if s.OrgID == orgID {
+			states = append(states, s)
+		}
+	}
+	return states
+}
+
 func (m *MockAMConfigStore_Expecter) GetsConfig(ac models.AlertConfiguration) *MockAMConfigStore_Expecter {
 	m.GetLatestAlertmanagerConfiguration(mock.Anything, mock.Anything).
 		Run(func(ctx context.Context, q *models.GetLatestAlertmanagerConfigurationQuery) {
@@ -46,4 +46,8 @@ func addUserAuthMigrations(mg *Migrator) {
 	mg.AddMigration("Add OAuth ID token to user_auth", NewAddColumnMigration(userAuthV1, &Column{
 		Name: "o_auth_id_token", Type: DB_Text, Nullable: true,
 	}))
+
+	mg.AddMigration("Add last synced to user_auth", NewAddColumnMigration(userAuthV1, &Column{
+		Name: "last_synced", Type: DB_DateTime, Nullable: true,
+	}))
 }
@@ -0,0 +1,147 @@
+package mockstore
+
+import (
+	"context"
+	"strings"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/user"
+)
+
+// SyncStoreMock is an SQLStoreMock that keeps users, org members and team members, and records the changes made to
+// them, for the tests of the sync of external users.
+type SyncStoreMock struct {
+	*SQLStoreMock
+
+	// Users are the users found by ID and by email.
+	Users []*user.User
+	// OrgUsers are the members of the orgs GetOrgUsers lists.
+	OrgUsers []*models.OrgUserDTO
+	// Teams are the teams SearchTeams lists.
+	Teams []*models.TeamDTO
+	// TeamMembers is the permission of the user in each team it is a member of, by org ID and team ID.
+	TeamMembers map[int64]map[int64]models.PermissionType
+
+	// AddedOrgUsers is the role the user was added with, by org ID.
+	AddedOrgUsers   map[int64]models.RoleType
+	UpdatedOrgUsers []*models.UpdateOrgUserCommand
+	RemovedOrgUsers []*models.RemoveOrgUserCommand
+	UpdatedUsers    []*models.UpdateUserCommand
+	// AddedTeamMembers and UpdatedTeamMembers count the team memberships added and updated.
+	AddedTeamMembers   int
+	UpdatedTeamMembers int
+	// TeamsSearchedAs is the user the last team search was made as.
+	TeamsSearchedAs *models.SignedInUser
+	// OrgSearches counts the org searches.
+	OrgSearches int
+}
+
+func (m *SyncStoreMock) GetUserById(ctx context.Context, query *models.GetUserByIdQuery) error {
+	for _, u := range m.Users {
+		if u.ID == query.Id {
+			query.Result = u
+			return nil
+		}
+	}
+	return models.ErrUserNotFound
+}
+
+// GetUserByEmail finds the user with the email, ignoring its case if the query does, like the database does.
+func (m *SyncStoreMock) GetUserByEmail(ctx context.Context, query *models.GetUserByEmailQuery) error {
+	for _, u := range m.Users {
+		if u.Email == query.Email || (query.CaseInsensitive && strings.EqualFold(u.Email, query.Email)) {
+			query.Result = u
+			return nil
+		}
+	}
+	return models.ErrUserNotFound
+}
+
+func (m *SyncStoreMock) UpdateUser(ctx context.Context, cmd *models.UpdateUserCommand) error {
+	m.UpdatedUsers = append(m.UpdatedUsers, cmd)
+	return nil
+}
+
+func (m *SyncStoreMock) SearchOrgs(ctx context.Context, query *models.SearchOrgsQuery) error {
+	m.OrgSearches++
+	return m.SQLStoreMock.SearchOrgs(ctx, query)
+}
+
+// GetOrgByNameHandler finds the org by name among the ExpectedUserOrgList.
+func (m *SyncStoreMock) GetOrgByNameHandler(ctx context.Context, query *models.GetOrgByNameQuery) error {
+	for _, org := range m.ExpectedUserOrgList {
+		if org.Name == query.Name {
+			query.Result = &models.Org{Id: org.OrgId, Name: org.Name}
+			return nil
+		}
+	}
+	return models.ErrOrgNotFound
+}
+
+func (m *SyncStoreMock) GetOrgUsers(ctx context.Context, query *models.GetOrgUsersQuery) error {
+	query.Result = []*models.OrgUserDTO{}
+	for _, orgUser := range m.OrgUsers {
+		if orgUser.OrgId == query.OrgId {
+			query.Result = append(query.Result, orgUser)
+		}
+	}
+	return nil
+}
+
+func (m *SyncStoreMock) AddOrgUser(ctx context.Context, cmd *models.AddOrgUserCommand) error {
+	if m.AddedOrgUsers == nil {
+		m.AddedOrgUsers = map[int64]models.RoleType{}
+	}
+	m.AddedOrgUsers[cmd.OrgId] = cmd.Role
+	return nil
+}
+
+func (m *SyncStoreMock) UpdateOrgUser(ctx context.Context, cmd *models.UpdateOrgUserCommand) error {
+	m.UpdatedOrgUsers = append(m.UpdatedOrgUsers, cmd)
+	return nil
+}
+
+func (m *SyncStoreMock) RemoveOrgUser(ctx context.Context, cmd *models.RemoveOrgUserCommand) error {
+	m.RemovedOrgUsers = append(m.RemovedOrgUsers, cmd)
+	return nil
+}
+
+func (m *SyncStoreMock) SearchTeams(ctx context.Context, query *models.SearchTeamsQuery) error {
+	m.TeamsSearchedAs = query.SignedInUser
+	query.Result = models.SearchTeamQueryResult{Teams: m.Teams, TotalCount: int64(len(m.Teams))}
+	return nil
+}
+
+func (m *SyncStoreMock) GetUserTeamMemberships(ctx context.Context, orgID, userID int64, external bool) ([]*models.TeamMemberDTO, error) {
+	var result []*models.TeamMemberDTO
+	for teamID, permission := range m.TeamMembers[orgID] {
+		result = append(result, &models.TeamMemberDTO{OrgId: orgID, TeamId: teamID, UserId: userID, Permission: permission})
+	}
+	return result, nil
+}
+
+// AddTeamMember returns models.ErrTeamMemberAlreadyAdded if the user is already a member of the team.
+func (m *SyncStoreMock) AddTeamMember(userID, orgID, teamID int64, isExternal bool, permission models.PermissionType) error {
+	if _, ok := m.TeamMembers[orgID][teamID]; ok {
+		return models.ErrTeamMemberAlreadyAdded
+	}
+	m.setTeamMember(orgID, teamID, permission)
+	m.AddedTeamMembers++
+	return nil
+}
+
+func (m *SyncStoreMock) UpdateTeamMember(ctx context.Context, cmd *models.UpdateTeamMemberCommand) error {
+	m.setTeamMember(cmd.OrgId, cmd.TeamId, cmd.Permission)
+	m.UpdatedTeamMembers++
+	return nil
+}
+
+func (m *SyncStoreMock) setTeamMember(orgID, teamID int64, permission models.PermissionType) {
+	if m.TeamMembers == nil {
+		m.TeamMembers = map[int64]map[int64]models.PermissionType{}
+	}
+	if m.TeamMembers[orgID] == nil {
+		m.TeamMembers[orgID] = map[int64]models.PermissionType{}
+	}
+	m.TeamMembers[orgID][teamID] = permission
+}
@@ -275,27 +275,44 @@ func (ss *SQLStore) GetUserByEmail(ctx context.Context, query *models.GetUserByE
 			return models.ErrUserNotFound
 		}
 
-		user := &user.User{}
 		where := "email=?"
-		if ss.Cfg.CaseInsensitiveLogin {
+		if ss.Cfg.CaseInsensitiveLogin || query.CaseInsensitive {
 			where = "LOWER(email)=LOWER(?)"
 		}
 
-		has, err := sess.Where(notServiceAccountFilter(ss)).Where(where, query.Email).Get(user)
+		var usr *user.User
+		// With case sensitive logins, emails differing only by their casing may belong to different users, in which
+		// case none of them can be told to be the one looked up
+		if query.CaseInsensitive && !ss.Cfg.CaseInsensitiveLogin {
+			users := make([]user.User, 0)
+			if err := sess.Where(notServiceAccountFilter(ss)).Where(where, query.Email).Find(&users); err != nil {
+				return err
+			}
+			if len(users) == 0 {
+				return models.ErrUserNotFound
+			}
+			if len(users) > 1 {
+				return &ErrCaseInsensitiveLoginConflict{users}
+			}
+			usr = &users[0]
+		} else {
+			usr = &user.User{}
+			has, err := sess.Where(notServiceAccountFilter(ss)).Where(where, query.Email).Get(usr)
 
-		if err != nil {
-			return err
-		} else if !has {
-			return models.ErrUserNotFound
+			if err != nil {
+				return err
+			} else if !has {
+				return models.ErrUserNotFound
+			}
 		}
 
 		if ss.Cfg.CaseInsensitiveLogin {
-			if err := ss.userCaseInsensitiveLoginConflict(ctx, sess, user.Login, user.Email); err != nil {
+			if err := ss.userCaseInsensitiveLoginConflict(ctx, sess, usr.Login, usr.Email); err != nil {
 				return err
 			}
 		}
 
-		query.Result = user
+		query.Result = usr
 
 		return nil
 	})
@@ -147,6 +147,7 @@ var (
 	LDAPSyncCron          string
 	LDAPAllowSignup       bool
 	LDAPActiveSyncEnabled bool
+	LDAPPingConcurrency   int
 
 	// Quota
 	Quota QuotaSettings
@@ -396,8 +397,17 @@ type Cfg struct {
 	FeedbackLinksEnabled                bool
 
 	// LDAP
-	LDAPEnabled     bool
-	LDAPAllowSignup bool
+	LDAPEnabled           bool
+	LDAPAllowSignup       bool
+	LDAPSyncRetryAttempts int
+	LDAPSyncRetryBackoff  time.Duration
+	// LDAPStatusPollInterval is how often the LDAP server statuses are polled in the background. Zero disables
+	// polling, so that the statuses are checked on every request.
+	LDAPStatusPollInterval time.Duration
+	// LDAPConnectionPoolSize is the number of idle connections kept per LDAP server to sync users with, for up to
+	// LDAPConnectionPoolIdleTimeout. Zero disables the pool, so that every sync dials the servers.
+	LDAPConnectionPoolSize        int
+	LDAPConnectionPoolIdleTimeout time.Duration
 
 	Quota QuotaSettings
 
@@ -409,6 +419,24 @@ type Cfg struct {
 	AutoAssignOrgId            int
 	AutoAssignOrgRole          string
 	OAuthSkipOrgRoleUpdateSync bool
+	// TeamSyncDefaultPermission is the team permission, Member or Admin, external users get from
+	// synced team memberships that do not specify one.
+	TeamSyncDefaultPermission string
+	// OAuthKeepEditedProfile stops OAuth logins from overwriting the name and email of users who edited
+	// their profile in Grafana since their last OAuth login.
+	OAuthKeepEditedProfile bool
+	// SyncExcludedOrgs are the IDs or names of the orgs the sync of external users never removes users from.
+	SyncExcludedOrgs []string
+	// OrgNameAliases maps other names orgs are known by to their names, for the orgs the sync of external users
+	// looks up by name. Aliases are only used when no org has the name.
+	OrgNameAliases map[string]string
+	// SyncMinOrgRoles are the lowest roles, keyed by org ID, the sync of external users gives users in these orgs.
+	SyncMinOrgRoles map[int64]string
+	// SyncMaxOrgs is the most orgs a single sync of an external user may map the user into, or 0 for no limit.
+	SyncMaxOrgs int
+	// SyncDefaultTeams are the teams, keyed by org ID, the sync of external users adds users to when it adds them
+	// to the org.
+	SyncDefaultTeams map[int64]int64
 
 	// ExpressionsEnabled specifies whether expressions are enabled.
 	ExpressionsEnabled bool
@@ -1086,6 +1114,12 @@ func (cfg *Cfg) readLDAPConfig() {
 	LDAPActiveSyncEnabled = ldapSec.Key("active_sync_enabled").MustBool(false)
 	LDAPAllowSignup = ldapSec.Key("allow_sign_up").MustBool(true)
 	cfg.LDAPAllowSignup = LDAPAllowSignup
+	LDAPPingConcurrency = ldapSec.Key("ping_concurrency").MustInt(10)
+	cfg.LDAPSyncRetryAttempts = ldapSec.Key("sync_retry_attempts").MustInt(3)
+	cfg.LDAPSyncRetryBackoff = ldapSec.Key("sync_retry_backoff").MustDuration(time.Millisecond * 500)
+	cfg.LDAPStatusPollInterval = ldapSec.Key("status_poll_interval").MustDuration(0)
+	cfg.LDAPConnectionPoolSize = ldapSec.Key("connection_pool_size").MustInt(0)
+	cfg.LDAPConnectionPoolIdleTimeout = ldapSec.Key("connection_pool_idle_timeout").MustDuration(time.Minute)
 }
 
 func (cfg *Cfg) handleAWSConfig() {
@@ -1248,6 +1282,67 @@ func readSecuritySettings(iniFile *ini.File, cfg *Cfg) error {
 	return nil
 }
 
+// parseOrgNameAliases parses a comma-separated list of alias:name pairs. Unlike most lists, it is not split on
+// spaces, since org names often have some.
+func parseOrgNameAliases(value string) (map[string]string, error) {
+	aliases := map[string]string{}
+	for _, entry := range strings.Split(value, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid org_name_aliases entry %q, must be alias:name", strings.TrimSpace(entry))
+		}
+		aliases[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return aliases, nil
+}
+
+// parseSyncMinOrgRoles parses a comma-separated list of orgId:role pairs. The roles are checked by the login service.
+func parseSyncMinOrgRoles(value string) (map[int64]string, error) {
+	roles := map[int64]string{}
+	for _, entry := range strings.Split(value, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid sync_min_org_roles entry %q, must be orgId:role", strings.TrimSpace(entry))
+		}
+		orgID, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sync_min_org_roles entry %q, must be orgId:role", strings.TrimSpace(entry))
+		}
+		roles[orgID] = strings.TrimSpace(parts[1])
+	}
+	return roles, nil
+}
+
+// parseSyncDefaultTeams parses a comma-separated list of orgId:teamId pairs.
+func parseSyncDefaultTeams(value string) (map[int64]int64, error) {
+	teams := map[int64]int64{}
+	for _, entry := range strings.Split(value, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid sync_default_teams entry %q, must be orgId:teamId", strings.TrimSpace(entry))
+		}
+		orgID, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sync_default_teams entry %q, must be orgId:teamId", strings.TrimSpace(entry))
+		}
+		teamID, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sync_default_teams entry %q, must be orgId:teamId", strings.TrimSpace(entry))
+		}
+		teams[orgID] = teamID
+	}
+	return teams, nil
+}
+
 func readAuthSettings(iniFile *ini.File, cfg *Cfg) (err error) {
 	auth := iniFile.Section("auth")
 
@@ -1280,6 +1375,22 @@ func readAuthSettings(iniFile *ini.File, cfg *Cfg) (err error) {
 	cfg.OAuthCookieMaxAge = auth.Key("oauth_state_cookie_max_age").MustInt(600)
 	SignoutRedirectUrl = valueAsString(auth, "signout_redirect_url", "")
 	cfg.OAuthSkipOrgRoleUpdateSync = auth.Key("oauth_skip_org_role_update_sync").MustBool(false)
+	cfg.TeamSyncDefaultPermission = valueAsString(auth, "team_sync_default_permission", "Member")
+	cfg.OAuthKeepEditedProfile = auth.Key("oauth_keep_edited_profile").MustBool(false)
+	cfg.SyncExcludedOrgs = util.SplitString(valueAsString(auth, "sync_excluded_orgs", ""))
+	cfg.OrgNameAliases, err = parseOrgNameAliases(valueAsString(auth, "org_name_aliases", ""))
+	if err != nil {
+		return err
+	}
+	cfg.SyncMinOrgRoles, err = parseSyncMinOrgRoles(valueAsString(auth, "sync_min_org_roles", ""))
+	if err != nil {
+		return err
+	}
+	cfg.SyncMaxOrgs = auth.Key("sync_max_orgs").MustInt(0)
+	cfg.SyncDefaultTeams, err = parseSyncDefaultTeams(valueAsString(auth, "sync_default_teams", ""))
+	if err != nil {
+		return err
+	}
 
 	// SigV4
 	SigV4AuthEnabled = auth.Key("sigv4_auth_enabled").MustBool(false)
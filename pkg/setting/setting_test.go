@@ -744,3 +744,42 @@ func TestAlertingEnabled(t *testing.T) {
 		})
 	}
 }
+
+func TestParseOrgNameAliases(t *testing.T) {
+	t.Run("names and aliases can have spaces", func(t *testing.T) {
+		aliases, err := parseOrgNameAliases("Platform Team:Platform, Ops : Operations Org,")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"Platform Team": "Platform", "Ops": "Operations Org"}, aliases)
+	})
+
+	t.Run("entries without a name are rejected", func(t *testing.T) {
+		_, err := parseOrgNameAliases("Platform Team:Platform, Ops")
+		require.Error(t, err)
+	})
+}
+
+func TestParseSyncDefaultTeams(t *testing.T) {
+	t.Run("teams are keyed by org ID", func(t *testing.T) {
+		teams, err := parseSyncDefaultTeams("1:4, 3 : 7,")
+		require.NoError(t, err)
+		assert.Equal(t, map[int64]int64{1: 4, 3: 7}, teams)
+	})
+
+	t.Run("entries without a team ID are rejected", func(t *testing.T) {
+		_, err := parseSyncDefaultTeams("1:Everyone")
+		require.Error(t, err)
+	})
+}
+
+func TestParseSyncMinOrgRoles(t *testing.T) {
+	t.Run("roles are keyed by org ID", func(t *testing.T) {
+		roles, err := parseSyncMinOrgRoles("1:Viewer, 3 : Editor,")
+		require.NoError(t, err)
+		assert.Equal(t, map[int64]string{1: "Viewer", 3: "Editor"}, roles)
+	})
+
+	t.Run("entries without an org ID are rejected", func(t *testing.T) {
+		_, err := parseSyncMinOrgRoles("Main:Viewer")
+		require.Error(t, err)
+	})
+}
@@ -3,6 +3,7 @@ package setting
 import (
 	"errors"
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -51,6 +52,8 @@ const (
 	screenshotsDefaultCapture               = false
 	screenshotsDefaultMaxConcurrent         = 5
 	screenshotsDefaultUploadImageStorage    = false
+	provisioningDefaultMaxConfigSize        = 10 * 1024 * 1024
+	provisioningDefaultMaxConfigDepth       = 100
 	// SchedulerBaseInterval base interval of the scheduler. Controls how often the scheduler fetches database for new changes as well as schedules evaluation of a rule
 	// changing this value is discouraged because this could cause existing alert definition
 	// with intervals that are not exactly divided by this number not to be evaluated
@@ -80,7 +83,14 @@ type UnifiedAlertingSettings struct {
 	BaseInterval time.Duration
 	// DefaultRuleEvaluationInterval default interval between evaluations of a rule.
 	DefaultRuleEvaluationInterval time.Duration
-	Screenshots                   UnifiedAlertingScreenshotSettings
+	// MaxConfigSize and MaxConfigDepth limit the size in bytes and the nesting depth of a stored Alertmanager
+	// configuration that the provisioning services deserialize. A value of 0 disables the limit.
+	MaxConfigSize  int64
+	MaxConfigDepth int
+	// MuteTimingNamePattern, when set, is matched against the whole name of every mute timing a notification policy
+	// references, and policy trees referencing a mute timing whose name does not match are rejected.
+	MuteTimingNamePattern *regexp.Regexp
+	Screenshots           UnifiedAlertingScreenshotSettings
 }
 
 type UnifiedAlertingScreenshotSettings struct {
@@ -237,6 +247,16 @@ func (cfg *Cfg) ReadUnifiedAlertingSettings(iniFile *ini.File) error {
 	}
 	uaCfg.MaxAttempts = uaMaxAttempts
 
+	uaCfg.MaxConfigSize = ua.Key("max_config_size").MustInt64(provisioningDefaultMaxConfigSize)
+	uaCfg.MaxConfigDepth = ua.Key("max_config_depth").MustInt(provisioningDefaultMaxConfigDepth)
+
+	if pattern := valueAsString(ua, "mute_timing_name_pattern", ""); pattern != "" {
+		uaCfg.MuteTimingNamePattern, err = regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return fmt.Errorf("value of setting 'mute_timing_name_pattern' is not a valid regular expression: %w", err)
+		}
+	}
+
 	uaCfg.BaseInterval = SchedulerBaseInterval
 
 	uaMinInterval, err := gtime.ParseDuration(valueAsString(ua, "min_interval", uaCfg.BaseInterval.String()))